@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const acmAPIVersion = "configmanagement.gke.io/v1"
+
+const (
+	// acmFormatHierarchy writes a hierarchical ACM repository, with
+	// cluster/, namespaces/ and system/ directories.
+	acmFormatHierarchy = "hierarchy"
+	// acmFormatUnstructured writes a repository for ACM's unstructured
+	// mode, which has no required structure: each namespace's resources are
+	// written into a top level directory, and cluster scoped resources into
+	// cluster/.
+	acmFormatUnstructured = "unstructured"
+)
+
+// acmFormat is the format of ACM repository written.
+var acmFormat string
+
+// isACMSystemResource returns true if the given object is an Anthos Config
+// Management resource that configures a hierarchical repository, and so is
+// written to system/.
+func isACMSystemResource(obj *unstructured.Unstructured) bool {
+	if obj.GetAPIVersion() != acmAPIVersion {
+		return false
+	}
+	switch obj.GetKind() {
+	case "Repo", "HierarchyConfig":
+		return true
+	}
+	return false
+}
+
+// namespacesDir returns the directory, relative to the output directory, that
+// contains each namespace's directory.
+func namespacesDir() string {
+	if acmFormat == acmFormatUnstructured {
+		return ""
+	}
+	return "namespaces"
+}
+
+// generateACMResources returns the ACM system resources that are required for
+// the output directory to be a valid ACM repository, but which are not
+// present in the input files.
+func generateACMResources(files map[string][]resource) ([]resource, error) {
+	hasRepo, hasHierarchyConfig := false, false
+	for _, resources := range files {
+		for _, r := range resources {
+			if r.obj.GetAPIVersion() != acmAPIVersion {
+				continue
+			}
+			switch r.obj.GetKind() {
+			case "Repo":
+				hasRepo = true
+			case "HierarchyConfig":
+				hasHierarchyConfig = true
+			}
+		}
+	}
+
+	var generated []resource
+	if !hasRepo {
+		log.Printf("Generating ACM Repo resource as none was found in the input files")
+		repo, err := newGeneratedResource(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": acmAPIVersion,
+			"kind":       "Repo",
+			"metadata": map[string]interface{}{
+				"name": "repo",
+			},
+			"spec": map[string]interface{}{
+				"version": "1.0.0",
+			},
+		}}, false, "repo.yaml")
+		if err != nil {
+			return nil, err
+		}
+		generated = append(generated, repo)
+	}
+	if initACMHierarchyConfig && !hasHierarchyConfig {
+		log.Printf("Generating ACM HierarchyConfig resource as none was found in the input files")
+		hc, err := newGeneratedResource(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": acmAPIVersion,
+			"kind":       "HierarchyConfig",
+			"metadata": map[string]interface{}{
+				"name": "rbac",
+			},
+			"spec": map[string]interface{}{
+				"resources": []interface{}{
+					map[string]interface{}{
+						"group":         "rbac.authorization.k8s.io",
+						"kinds":         []interface{}{"RoleBinding"},
+						"hierarchyMode": "inherit",
+					},
+				},
+			},
+		}}, false, "hierarchyconfig.yaml")
+		if err != nil {
+			return nil, err
+		}
+		generated = append(generated, hc)
+	}
+	return generated, nil
+}
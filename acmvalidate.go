@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// acmValidate enables checking the output against the constraints that ACM
+// places on hierarchical repositories before it is written.
+var acmValidate bool
+
+// validateACMRepo checks the given planned output files against the
+// structural constraints of a hierarchical ACM repository, so that problems
+// are reported before 'nomos vet' or the ACM operator finds them:
+//
+//   - cluster/ only contains cluster scoped resources
+//   - namespaces/ only contains namespaced resources and Namespaces
+//   - each namespace directory contains exactly one Namespace, whose name is
+//     the directory's name, and abstract namespace directories contain none
+//   - system/ contains exactly one Repo, and only ACM system resources
+//
+// Every violation is logged, and an error returned if there are any.
+func validateACMRepo(files []outputFile) error {
+	var problems []string
+	report := func(p string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", p, fmt.Sprintf(format, args...)))
+	}
+
+	nsDirs := make(map[string][]outputFile)
+	repos := 0
+	for _, f := range files {
+		p := filepath.ToSlash(f.path)
+		r := f.resource
+		top := strings.SplitN(p, "/", 2)[0]
+		isNamespace := r.obj.GetKind() == "Namespace" && r.obj.GetAPIVersion() == "v1"
+		switch top {
+		case "cluster":
+			if r.namespaced && !r.obj.IsList() {
+				report(p, "namespaced %s %s must not be in cluster/", r.obj.GetKind(), describeObject(r.obj))
+			}
+		case "namespaces":
+			if !r.namespaced && !isNamespace && !r.obj.IsList() {
+				report(p, "cluster scoped %s %s must not be in namespaces/", r.obj.GetKind(), describeObject(r.obj))
+			}
+			nsDirs[path.Dir(p)] = append(nsDirs[path.Dir(p)], f)
+		case "system":
+			if !isACMSystemResource(r.obj) {
+				report(p, "%s %s must not be in system/, which may only contain ACM Repo and HierarchyConfig resources", r.obj.GetKind(), describeObject(r.obj))
+			}
+			if r.obj.GetKind() == "Repo" {
+				repos++
+			}
+		default:
+			report(p, "%s %s is outside of cluster/, namespaces/ and system/, so is ignored by ACM", r.obj.GetKind(), describeObject(r.obj))
+		}
+	}
+	switch {
+	case repos == 0:
+		report("system", "does not contain a Repo (see --init-acm)")
+	case repos > 1:
+		report("system", "contains %d Repos, but must contain exactly one", repos)
+	}
+
+	for dir, dirFiles := range nsDirs {
+		abstract := dir == "namespaces"
+		for other := range nsDirs {
+			if strings.HasPrefix(other, dir+"/") {
+				abstract = true
+				break
+			}
+		}
+		var namespaces []string
+		for _, f := range dirFiles {
+			if f.resource.obj.GetKind() == "Namespace" && f.resource.obj.GetAPIVersion() == "v1" {
+				namespaces = append(namespaces, f.resource.obj.GetName())
+			}
+		}
+		switch {
+		case abstract && len(namespaces) > 0:
+			report(dir, "is an abstract namespace directory, as it contains other namespace directories, so must not contain a Namespace")
+		case abstract:
+		case len(namespaces) == 0:
+			report(dir, "is a namespace directory, but does not contain a Namespace")
+		case len(namespaces) > 1:
+			report(dir, "contains %d Namespaces (%s), but must contain exactly one", len(namespaces), strings.Join(namespaces, ", "))
+		case namespaces[0] != path.Base(dir):
+			report(dir, "contains Namespace %q, but namespace directories must be named after their Namespace", namespaces[0])
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		log.Printf("ACM validation: %s", p)
+	}
+	return fmt.Errorf("found %d violations of ACM repository constraints", len(problems))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// yamlAliasesExpand causes documents containing YAML anchors, aliases or
+	// merge keys to be re-encoded with all references expanded in place.
+	yamlAliasesExpand = "expand"
+	// yamlAliasesError causes documents containing YAML anchors, aliases or
+	// merge keys to be rejected.
+	yamlAliasesError = "error"
+)
+
+// usesYAMLAliases returns true if the given YAML document declares anchors,
+// references aliases or uses merge keys.
+func usesYAMLAliases(data []byte) (bool, error) {
+	// avoid parsing documents that cannot possibly contain anchors, aliases
+	// or merge keys
+	if !bytes.ContainsAny(data, "&*<") {
+		return false, nil
+	}
+	var n yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &n); err != nil {
+		return false, err
+	}
+	return nodeUsesYAMLAliases(&n), nil
+}
+
+func nodeUsesYAMLAliases(n *yamlv3.Node) bool {
+	if n.Anchor != "" || n.Kind == yamlv3.AliasNode {
+		return true
+	}
+	if n.Kind == yamlv3.MappingNode {
+		for i := 0; i < len(n.Content); i += 2 {
+			if n.Content[i].ShortTag() == "!!merge" {
+				return true
+			}
+		}
+	}
+	for _, c := range n.Content {
+		if nodeUsesYAMLAliases(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// explainYAMLError adds context to YAML decoding errors caused by references
+// to anchors declared in other documents, which commonly occurs when a file
+// containing multiple documents is split.
+func explainYAMLError(err error) error {
+	if strings.Contains(err.Error(), "unknown anchor") {
+		return fmt.Errorf("%v (YAML anchors can only be referenced within the '---' separated document that declares them)", err)
+	}
+	return err
+}
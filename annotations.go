@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultStripAnnotations are annotations injected by tools and controllers
+// that have no place in a declarative config repository, and which are
+// removed from output resources by default.
+var defaultStripAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+	"control-plane.alpha.kubernetes.io/leader",
+	"pv.kubernetes.io/*",
+	"volume.beta.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/selected-node",
+}
+
+// stripAnnotationsTransformer removes annotations matching any of its
+// patterns from resources. A pattern ending in '*' matches all annotations
+// with the preceding prefix.
+type stripAnnotationsTransformer struct {
+	patterns []string
+}
+
+func (t stripAnnotationsTransformer) Transform(r *resource) (bool, error) {
+	annotations := r.obj.GetAnnotations()
+	if len(annotations) == 0 {
+		return false, nil
+	}
+	changed := false
+	for key := range annotations {
+		if t.matches(key) {
+			delete(annotations, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if len(annotations) == 0 {
+		// remove the field entirely, rather than leaving an empty map
+		annotations = nil
+	}
+	r.obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+func (t stripAnnotationsTransformer) matches(key string) bool {
+	for _, p := range t.patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// package v1alpha1 defines version v1alpha1 of the splitter config file,
+// which configures routing rules, transformations and filters declaratively
+// rather than through command line flags.
+package v1alpha1
+
+const (
+	// Group is the API group of the splitter config file.
+	Group = "splitter.config.k8s.io"
+	// Version is the API version implemented by this package.
+	Version = "v1alpha1"
+	// APIVersion is the apiVersion of a v1alpha1 config file.
+	APIVersion = Group + "/" + Version
+	// Kind is the kind of the splitter config file.
+	Kind = "SplitterConfig"
+)
+
+// SplitterConfig is the top level type of a splitter config file, e.g.:
+//
+//	apiVersion: splitter.config.k8s.io/v1alpha1
+//	kind: SplitterConfig
+//	routes:
+//	- name: infra-cluster-roles
+//	  match: object.kind == 'ClusterRole' && object.metadata.labels['team'] == 'infra'
+//	  path: cluster/infra
+//	transformations:
+//	  standardLabels:
+//	    partOf: payments
+//	filters:
+//	  excludeKinds: [secrets]
+type SplitterConfig struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Routes override the directory that matching resources are written
+	// to. Routes are evaluated in order, and the first that matches a
+	// resource is used.
+	Routes []Route `json:"routes,omitempty"`
+
+	// Transformations configures how resources are modified before they are
+	// written.
+	Transformations Transformations `json:"transformations,omitempty"`
+	// Filters configures which resources are written.
+	Filters Filters `json:"filters,omitempty"`
+}
+
+// Route writes the resources matched by an expression to a directory.
+type Route struct {
+	// Name identifies the route in errors and logs.
+	Name string `json:"name"`
+	// Match is a match expression that returns true for the resources the
+	// route applies to. The resource is available as 'object', and
+	// whether it is namespaced as 'namespaced'. Fields that are not set
+	// on a resource do not match.
+	Match string `json:"match"`
+	// Path is a Go template rendering the directory, relative to the
+	// output directory, that matching resources are written to. Available
+	// fields are .Kind, .Group, .Version, .Namespace, .Name, .Labels,
+	// .Annotations, .NamespaceDir (the namespace's directory within
+	// namespaces/) and .Default (the directory the resource would
+	// otherwise be written to).
+	Path string `json:"path"`
+	// Warning, if set, is a Go template with the same fields as Path,
+	// rendering a warning that is reported for each resource the route
+	// matches, e.g. about effects beyond the resource's namespace.
+	Warning string `json:"warning,omitempty"`
+}
+
+// RouteVariables are the variables that route match expressions may
+// reference.
+var RouteVariables = []string{"object", "namespaced"}
+
+// Transformations configures how resources are modified before they are
+// written. Fields that are not set leave the corresponding flag's value
+// unchanged.
+type Transformations struct {
+	// StripAnnotations lists annotations removed from output resources, as
+	// for --strip-annotations. An empty list keeps all annotations.
+	StripAnnotations []string `json:"stripAnnotations,omitempty"`
+	// StandardLabels, if set, sets the recommended app.kubernetes.io labels
+	// on every resource that does not already set them, as for
+	// --standard-labels.
+	StandardLabels *StandardLabels `json:"standardLabels,omitempty"`
+	// InjectConfigHash is equivalent to --inject-config-hash.
+	InjectConfigHash *bool `json:"injectConfigHash,omitempty"`
+	// PruneEmpty is equivalent to --prune-empty.
+	PruneEmpty *bool `json:"pruneEmpty,omitempty"`
+	// StripStatus is equivalent to --strip-status.
+	StripStatus *bool `json:"stripStatus,omitempty"`
+	// NamespaceLabels propagates labels between each Namespace and the
+	// resources within it, e.g. for policies that select on labels set at
+	// the namespace level. Rules are applied in order.
+	NamespaceLabels []NamespaceLabelRule `json:"namespaceLabels,omitempty"`
+}
+
+const (
+	// ToResources copies labels from a Namespace to the resources within
+	// it.
+	ToResources = "ToResources"
+	// ToNamespace copies labels that every resource within a namespace sets
+	// to the same value to the Namespace.
+	ToNamespace = "ToNamespace"
+)
+
+// NamespaceLabelRule propagates labels between a Namespace and the resources
+// within it. Namespaces that are not part of the inputs are left alone.
+type NamespaceLabelRule struct {
+	// Name identifies the rule in errors and logs.
+	Name string `json:"name"`
+	// Labels lists the keys of the labels that are propagated.
+	Labels []string `json:"labels"`
+	// Direction is either ToResources (the default) or ToNamespace.
+	Direction string `json:"direction,omitempty"`
+	// Match, if set, is a match expression that returns true for the
+	// resources within a namespace that the rule applies to, with the same
+	// variables as route match expressions.
+	Match string `json:"match,omitempty"`
+	// Overwrite replaces labels that are already set to a different value.
+	// By default they are left as they are.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// StandardLabels configures the values of the recommended labels. Labels with
+// an empty value are not set, other than managedBy which defaults to
+// manifest-splitter.
+type StandardLabels struct {
+	ManagedBy string `json:"managedBy,omitempty"`
+	PartOf    string `json:"partOf,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+}
+
+// Filters configures which resources are written. Fields that are not set
+// leave the corresponding flag's value unchanged.
+type Filters struct {
+	// IncludeKinds is equivalent to --include-kinds.
+	IncludeKinds []string `json:"includeKinds,omitempty"`
+	// ExcludeKinds is equivalent to --exclude-kinds.
+	ExcludeKinds []string `json:"excludeKinds,omitempty"`
+	// PreferGroups is equivalent to --prefer-group.
+	PreferGroups []string `json:"preferGroups,omitempty"`
+	// SkipOwned is equivalent to --skip-owned.
+	SkipOwned *bool `json:"skipOwned,omitempty"`
+	// SkipSystem is equivalent to --skip-system.
+	SkipSystem *bool `json:"skipSystem,omitempty"`
+}
@@ -0,0 +1,187 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/munnerz/manifest-splitter/expr"
+)
+
+// FieldError is a problem with the value of a single field of a config file.
+type FieldError struct {
+	// Field is the path to the field, e.g. filters.includeKinds[2].
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError lists every problem found when validating a config file,
+// so that they can all be fixed at once.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("%d invalid fields:\n  %s", len(e.Errors), strings.Join(msgs, "\n  "))
+}
+
+// Load reads and validates the config file at path. Unknown fields are an
+// error, so that misspelt fields are not silently ignored.
+func Load(path string) (*SplitterConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SplitterConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("decoding config file %q: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config file %q is invalid: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate returns a *ValidationError listing every invalid field of the
+// config, or nil if it is valid.
+func (c *SplitterConfig) Validate() error {
+	var errs []FieldError
+	report := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case c.APIVersion == APIVersion:
+	case strings.HasPrefix(c.APIVersion, Group+"/"):
+		report("apiVersion", "version %q is not supported, only %q is", c.APIVersion, APIVersion)
+	default:
+		report("apiVersion", "must be %q, got %q", APIVersion, c.APIVersion)
+	}
+	if c.Kind != Kind {
+		report("kind", "must be %q, got %q", Kind, c.Kind)
+	}
+
+	names := make(map[string]bool)
+	for i, r := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		switch {
+		case r.Name == "":
+			report(field+".name", "must be set")
+		case names[r.Name]:
+			report(field+".name", "route %q is already defined", r.Name)
+		}
+		names[r.Name] = true
+		if r.Match == "" {
+			report(field+".match", "must be set")
+		} else if _, err := expr.Compile(r.Match, RouteVariables...); err != nil {
+			report(field+".match", "invalid expression: %v", err)
+		}
+		switch {
+		case r.Path == "":
+			report(field+".path", "must be set")
+		case strings.HasPrefix(r.Path, "/"):
+			report(field+".path", "must be relative to the output directory, got %q", r.Path)
+		default:
+			if _, err := template.New(r.Name).Parse(r.Path); err != nil {
+				report(field+".path", "invalid template: %v", err)
+			}
+		}
+		if r.Warning != "" {
+			if _, err := template.New(r.Name).Parse(r.Warning); err != nil {
+				report(field+".warning", "invalid template: %v", err)
+			}
+		}
+	}
+
+	t := c.Transformations
+	for i, p := range t.StripAnnotations {
+		field := fmt.Sprintf("transformations.stripAnnotations[%d]", i)
+		switch {
+		case p == "" || p == "*":
+			report(field, "must not be empty")
+		case strings.Contains(strings.TrimSuffix(p, "*"), "*"):
+			report(field, "%q may only contain '*' as its last character", p)
+		}
+	}
+	if l := t.StandardLabels; l != nil {
+		for _, v := range []struct{ field, value string }{
+			{"managedBy", l.ManagedBy},
+			{"partOf", l.PartOf},
+			{"instance", l.Instance},
+		} {
+			for _, msg := range validation.IsValidLabelValue(v.value) {
+				report("transformations.standardLabels."+v.field, "%q is not a valid label value: %s", v.value, msg)
+			}
+		}
+	}
+
+	ruleNames := make(map[string]bool)
+	for i, r := range t.NamespaceLabels {
+		field := fmt.Sprintf("transformations.namespaceLabels[%d]", i)
+		switch {
+		case r.Name == "":
+			report(field+".name", "must be set")
+		case ruleNames[r.Name]:
+			report(field+".name", "rule %q is already defined", r.Name)
+		}
+		ruleNames[r.Name] = true
+		if len(r.Labels) == 0 {
+			report(field+".labels", "must not be empty")
+		}
+		for j, key := range r.Labels {
+			for _, msg := range validation.IsQualifiedName(key) {
+				report(fmt.Sprintf("%s.labels[%d]", field, j), "%q is not a valid label key: %s", key, msg)
+			}
+		}
+		switch r.Direction {
+		case "", ToResources, ToNamespace:
+		default:
+			report(field+".direction", "must be %q or %q, got %q", ToResources, ToNamespace, r.Direction)
+		}
+		if r.Match != "" {
+			if _, err := expr.Compile(r.Match, RouteVariables...); err != nil {
+				report(field+".match", "invalid expression: %v", err)
+			}
+		}
+	}
+
+	f := c.Filters
+	for _, list := range []struct {
+		field string
+		names []string
+	}{
+		{"filters.includeKinds", f.IncludeKinds},
+		{"filters.excludeKinds", f.ExcludeKinds},
+	} {
+		for i, name := range list.names {
+			if strings.TrimSpace(name) == "" {
+				report(fmt.Sprintf("%s[%d]", list.field, i), "must not be empty")
+			}
+		}
+	}
+	for i, group := range f.PreferGroups {
+		if group == "core" {
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(group) {
+			report(fmt.Sprintf("filters.preferGroups[%d]", i), "%q is not a valid API group (use 'core' for the core group): %s", group, msg)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
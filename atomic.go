@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// atomicWrites is set by --atomic. When true, output files are first written
+// to temporary files alongside their destination, and only renamed into place
+// once every output has been written and verified, so that a failed run never
+// leaves the output directory partially updated.
+var atomicWrites bool
+
+// staging tracks the temporary files and directories created for the output of
+// an --atomic run.
+var staging = struct {
+	// files maps the destination path of each output file to the temporary
+	// file it has been written to.
+	files map[string]string
+	// dirs lists the directories created, in the order they were created.
+	dirs []string
+	seq  int
+}{files: make(map[string]string)}
+
+// nextStagingSeq returns a sequence number, unique within the run, for
+// naming a temporary file.
+func nextStagingSeq() int {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	staging.seq++
+	return staging.seq
+}
+
+// stageFile creates a new temporary file in the same directory as path, with
+// the given mode (less the umask), and returns its name.
+func stageFile(path string, data []byte, mode os.FileMode) (string, error) {
+	return stageFileFrom(path, bytes.NewReader(data), mode)
+}
+
+// stageFileFrom is stageFile, writing the contents of r.
+func stageFileFrom(path string, r io.Reader, mode os.FileMode) (string, error) {
+	dir, base := filepath.Split(path)
+	for {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d-%d", base, os.Getpid(), nextStagingSeq()))
+		err := outputFS.CreateExclusiveFrom(tmp, r, mode)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		outputMu.Lock()
+		old, ok := staging.files[path]
+		staging.files[path] = tmp
+		outputMu.Unlock()
+		if ok {
+			outputFS.Remove(old)
+		}
+		return tmp, nil
+	}
+}
+
+// stageSymlink creates a new temporary symlink to target in the same
+// directory as path, and returns its name.
+func stageSymlink(path, target string) (string, error) {
+	dir, base := filepath.Split(path)
+	for {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d-%d", base, os.Getpid(), nextStagingSeq()))
+		err := outputFS.Symlink(target, tmp)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		outputMu.Lock()
+		old, ok := staging.files[path]
+		staging.files[path] = tmp
+		outputMu.Unlock()
+		if ok {
+			outputFS.Remove(old)
+		}
+		return tmp, nil
+	}
+}
+
+// stagedPath returns the path that the contents of the given output file can
+// currently be read from.
+func stagedPath(path string) string {
+	if tmp, ok := staging.files[path]; ok {
+		return tmp
+	}
+	return path
+}
+
+// commitStagedOutput renames every staged file into place.
+func commitStagedOutput() error {
+	paths := make([]string, 0, len(staging.files))
+	for path := range staging.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := outputFS.Rename(staging.files[path], path); err != nil {
+			return fmt.Errorf("error moving output file %q into place: %v", path, err)
+		}
+		delete(staging.files, path)
+	}
+	if len(paths) > 0 {
+		log.Printf("Moved %d staged output files into place", len(paths))
+	}
+	staging.dirs = nil
+	return nil
+}
+
+// discardStagedOutput removes all staged files, and any directories created
+// for them, leaving the output directory as it was before the run.
+func discardStagedOutput() {
+	for path, tmp := range staging.files {
+		if err := outputFS.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove staged output file %q: %v", tmp, err)
+		}
+		delete(staging.files, path)
+	}
+	for i := len(staging.dirs) - 1; i >= 0; i-- {
+		// directories that are not empty were not created for this run's
+		// output alone, and are left in place.
+		outputFS.Remove(staging.dirs[i])
+	}
+	staging.dirs = nil
+}
+
+// fatalf discards any staged output, stops any profiling, and records the
+// failure in the metrics file if one is configured, before logging a fatal
+// error.
+func fatalf(format string, args ...interface{}) {
+	discardStagedOutput()
+	stopProfiling()
+	if err := writeMetricsFile(false); err != nil {
+		log.Printf("Error writing metrics file: %v", err)
+	}
+	log.Fatalf(format, args...)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backupDir is the directory that output files are copied into before they
+// are overwritten or deleted, if set.
+var backupDir string
+
+// backupOutputFile copies the existing output file at path into a directory
+// named after the time the run started within --backup-dir, keeping its path
+// relative to the output directory. replacement is the data that the file is
+// about to be overwritten with, or nil if it is about to be deleted; files
+// that already contain the replacement are not backed up.
+func backupOutputFile(path string, replacement []byte) error {
+	if backupDir == "" {
+		return nil
+	}
+	data, err := outputFS.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if replacement != nil && bytes.Equal(data, replacement) {
+		return nil
+	}
+
+	rel, err := filepath.Rel(outputDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// files outside of the output directory, e.g. when splitting
+		// helmfiles into a directory per environment, keep their full
+		// path
+		rel = strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator))
+	}
+	dest := filepath.Join(backupDir, runStarted.Format("20060102-150405"), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	log.Printf("Backing up output file %s to %s", path, dest)
+	return ioutil.WriteFile(dest, data, 0644)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// benchDuration is the minimum time each benchmark is run for.
+const benchDuration = time.Second
+
+// benchResult is the throughput measured by a single benchmark.
+type benchResult struct {
+	name       string
+	iterations int
+	resources  int
+	bytes      int
+	elapsed    time.Duration
+}
+
+// runBench measures the throughput of decoding, encoding and classifying the
+// resources in the given input files, so that performance regressions can be
+// measured and reported. Classification is only measured if --kubeconfig is
+// set.
+func runBench(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: manifest-splitter bench [input files...]")
+	}
+
+	inputs := make(map[string][]byte)
+	size := 0
+	for _, input := range args {
+		data, err := readInput(input)
+		if err != nil {
+			return err
+		}
+		inputs[input] = data
+		size += len(data)
+	}
+
+	var files map[string][]resource
+	decode := benchmark("decode", func() (int, int, error) {
+		files = make(map[string][]resource)
+		for input, data := range inputs {
+			resources, err := decodeResourceManifest(input, bytes.NewReader(data))
+			if err != nil {
+				return 0, 0, err
+			}
+			files[input] = resources
+		}
+		return countResources(files), size, nil
+	})
+	if decode.err != nil {
+		return decode.err
+	}
+	results := []benchResult{decode.benchResult}
+
+	encode := benchmark("encode", func() (int, int, error) {
+		n, b := 0, 0
+		for _, resources := range files {
+			for _, r := range resources {
+				data, err := encoderFor(r.format)(r.obj)
+				if err != nil {
+					return 0, 0, err
+				}
+				n++
+				b += len(data)
+			}
+		}
+		return n, b, nil
+	})
+	if encode.err != nil {
+		return encode.err
+	}
+	results = append(results, encode.benchResult)
+
+	if kubeconfig != "" || scopeFile != "" {
+		inspector, err := newResourceInspector()
+		if err != nil {
+			return err
+		}
+		classify := benchmark("classify", func() (int, int, error) {
+			return countResources(files), 0, populateNamespacedField(inspector, files)
+		})
+		if classify.err != nil {
+			return classify.err
+		}
+		results = append(results, classify.benchResult)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BENCHMARK\tITERATIONS\tTIME/OP\tRESOURCES/S\tMB/S")
+	for _, r := range results {
+		perOp := r.elapsed / time.Duration(r.iterations)
+		secs := r.elapsed.Seconds()
+		mbps := "-"
+		if r.bytes > 0 {
+			mbps = fmt.Sprintf("%.2f", float64(r.bytes*r.iterations)/secs/1e6)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.0f\t%s\n", r.name, r.iterations, perOp, float64(r.resources*r.iterations)/secs, mbps)
+	}
+	return w.Flush()
+}
+
+type benchOutcome struct {
+	benchResult
+	err error
+}
+
+// benchmark runs fn repeatedly for at least benchDuration. fn returns the
+// number of resources and bytes processed by each iteration.
+func benchmark(name string, fn func() (resources, bytes int, err error)) benchOutcome {
+	// discard the log output produced whilst decoding and classifying
+	out := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(out)
+
+	res := benchResult{name: name}
+	start := time.Now()
+	for res.iterations == 0 || time.Since(start) < benchDuration {
+		n, b, err := fn()
+		if err != nil {
+			return benchOutcome{err: fmt.Errorf("%s: %v", name, err)}
+		}
+		res.iterations++
+		res.resources, res.bytes = n, b
+	}
+	res.elapsed = time.Since(start)
+	return benchOutcome{benchResult: res}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// namespaceCapacity is the sum of the compute resources requested by all
+// workloads in a namespace.
+type namespaceCapacity struct {
+	workloads int
+	// perNodeWorkloads is the number of workloads, such as DaemonSets, whose
+	// replica count depends on the number of nodes. These are counted once.
+	perNodeWorkloads int
+
+	// CPU values are stored in millicores, and memory values in bytes.
+	cpuRequests, cpuLimits       int64
+	memoryRequests, memoryLimits int64
+}
+
+func (c *namespaceCapacity) cpuRequestsQuantity() *apiresource.Quantity {
+	return apiresource.NewMilliQuantity(c.cpuRequests, apiresource.DecimalSI)
+}
+
+func (c *namespaceCapacity) cpuLimitsQuantity() *apiresource.Quantity {
+	return apiresource.NewMilliQuantity(c.cpuLimits, apiresource.DecimalSI)
+}
+
+func (c *namespaceCapacity) memoryRequestsQuantity() *apiresource.Quantity {
+	return apiresource.NewQuantity(c.memoryRequests, apiresource.BinarySI)
+}
+
+func (c *namespaceCapacity) memoryLimitsQuantity() *apiresource.Quantity {
+	return apiresource.NewQuantity(c.memoryLimits, apiresource.BinarySI)
+}
+
+// podResources are the effective compute resources of a single pod.
+type podResources struct {
+	cpuRequests, cpuLimits       int64
+	memoryRequests, memoryLimits int64
+}
+
+// capacityByNamespace sums the compute resource requests and limits of every
+// workload in the given files, per namespace, taking into account the number
+// of replicas of each workload. Containers that do not set a request or limit
+// are counted with the default of their namespace's LimitRange in files, or
+// of fallback, if set, in namespaces without one.
+func capacityByNamespace(files map[string][]resource, fallback *podResources) (map[string]*namespaceCapacity, error) {
+	defaults, err := limitRangeDefaults(files)
+	if err != nil {
+		return nil, err
+	}
+	capacity := make(map[string]*namespaceCapacity)
+	for _, r := range sortedResources(files) {
+		spec, ok := podSpec(r.obj)
+		if !ok {
+			continue
+		}
+		d, ok := defaults[r.obj.GetNamespace()]
+		if !ok && fallback != nil {
+			d = *fallback
+		}
+		pod, err := effectivePodResources(spec, d)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s in file %q: %v", r.obj.GetKind(), describeObject(r.obj), r.inputFilename, err)
+		}
+		replicas, perNode := workloadReplicas(r.obj)
+
+		c, ok := capacity[r.obj.GetNamespace()]
+		if !ok {
+			c = &namespaceCapacity{}
+			capacity[r.obj.GetNamespace()] = c
+		}
+		c.workloads++
+		if perNode {
+			c.perNodeWorkloads++
+		}
+		c.cpuRequests += pod.cpuRequests * replicas
+		c.cpuLimits += pod.cpuLimits * replicas
+		c.memoryRequests += pod.memoryRequests * replicas
+		c.memoryLimits += pod.memoryLimits * replicas
+	}
+	return capacity, nil
+}
+
+// limitRangeDefaults returns the default requests and limits set for
+// containers by the LimitRanges in files, by namespace.
+func limitRangeDefaults(files map[string][]resource) (map[string]podResources, error) {
+	defaults := make(map[string]podResources)
+	for _, r := range sortedResources(files) {
+		if r.obj.GetKind() != "LimitRange" || r.obj.GetAPIVersion() != "v1" {
+			continue
+		}
+		d := defaults[r.obj.GetNamespace()]
+		limits, _, _ := unstructured.NestedSlice(r.obj.Object, "spec", "limits")
+		for _, item := range limits {
+			limit, ok := item.(map[string]interface{})
+			if !ok || limit["type"] != "Container" {
+				continue
+			}
+			l, err := parseResources(limit, []string{"defaultRequest"}, []string{"default"})
+			if err != nil {
+				return nil, fmt.Errorf("LimitRange %s in file %q: %v", describeObject(r.obj), r.inputFilename, err)
+			}
+			// the default request of a LimitRange defaults to its
+			// default limit
+			if l.cpuRequests == 0 {
+				l.cpuRequests = l.cpuLimits
+			}
+			if l.memoryRequests == 0 {
+				l.memoryRequests = l.memoryLimits
+			}
+			d.cpuRequests = max64(d.cpuRequests, l.cpuRequests)
+			d.cpuLimits = max64(d.cpuLimits, l.cpuLimits)
+			d.memoryRequests = max64(d.memoryRequests, l.memoryRequests)
+			d.memoryLimits = max64(d.memoryLimits, l.memoryLimits)
+		}
+		defaults[r.obj.GetNamespace()] = d
+	}
+	return defaults, nil
+}
+
+// effectivePodResources computes the resources of a pod in the same way as
+// the Kubernetes scheduler: the larger of the sum of all app containers and
+// the largest init container. Containers that do not set a request or limit
+// are given that of defaults, as they are by a LimitRange.
+func effectivePodResources(spec map[string]interface{}, defaults podResources) (podResources, error) {
+	var containers, init podResources
+	add := func(field string, fn func(c podResources)) error {
+		list, _, _ := unstructured.NestedSlice(spec, field)
+		for _, item := range list {
+			container, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			c, err := containerResources(container, defaults)
+			if err != nil {
+				return err
+			}
+			fn(c)
+		}
+		return nil
+	}
+	if err := add("containers", func(c podResources) {
+		containers.cpuRequests += c.cpuRequests
+		containers.cpuLimits += c.cpuLimits
+		containers.memoryRequests += c.memoryRequests
+		containers.memoryLimits += c.memoryLimits
+	}); err != nil {
+		return podResources{}, err
+	}
+	if err := add("initContainers", func(c podResources) {
+		init.cpuRequests = max64(init.cpuRequests, c.cpuRequests)
+		init.cpuLimits = max64(init.cpuLimits, c.cpuLimits)
+		init.memoryRequests = max64(init.memoryRequests, c.memoryRequests)
+		init.memoryLimits = max64(init.memoryLimits, c.memoryLimits)
+	}); err != nil {
+		return podResources{}, err
+	}
+	return podResources{
+		cpuRequests:    max64(containers.cpuRequests, init.cpuRequests),
+		cpuLimits:      max64(containers.cpuLimits, init.cpuLimits),
+		memoryRequests: max64(containers.memoryRequests, init.memoryRequests),
+		memoryLimits:   max64(containers.memoryLimits, init.memoryLimits),
+	}, nil
+}
+
+func containerResources(container map[string]interface{}, defaults podResources) (podResources, error) {
+	c, err := parseResources(container, []string{"resources", "requests"}, []string{"resources", "limits"})
+	if err != nil {
+		return podResources{}, err
+	}
+	// requests default to limits if only limits are set
+	if c.cpuRequests == 0 {
+		c.cpuRequests = c.cpuLimits
+	}
+	if c.memoryRequests == 0 {
+		c.memoryRequests = c.memoryLimits
+	}
+	// and are then set by the LimitRange of the namespace if still unset
+	if c.cpuRequests == 0 {
+		c.cpuRequests = defaults.cpuRequests
+	}
+	if c.cpuLimits == 0 {
+		c.cpuLimits = defaults.cpuLimits
+	}
+	if c.memoryRequests == 0 {
+		c.memoryRequests = defaults.memoryRequests
+	}
+	if c.memoryLimits == 0 {
+		c.memoryLimits = defaults.memoryLimits
+	}
+	return c, nil
+}
+
+// parseResources parses the CPU and memory quantities of the resource lists at
+// the requests and limits paths within obj.
+func parseResources(obj map[string]interface{}, requests, limits []string) (podResources, error) {
+	quantity := func(fields ...string) (*apiresource.Quantity, error) {
+		v, ok, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+		if !ok {
+			return &apiresource.Quantity{}, nil
+		}
+		q, err := apiresource.ParseQuantity(fmt.Sprintf("%v", v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", strings.Join(fields, "."), err)
+		}
+		return &q, nil
+	}
+	field := func(path []string, name string) []string {
+		return append(append([]string(nil), path...), name)
+	}
+
+	var c podResources
+	for _, f := range []struct {
+		dest   *int64
+		milli  bool
+		fields []string
+	}{
+		{&c.cpuRequests, true, field(requests, "cpu")},
+		{&c.cpuLimits, true, field(limits, "cpu")},
+		{&c.memoryRequests, false, field(requests, "memory")},
+		{&c.memoryLimits, false, field(limits, "memory")},
+	} {
+		q, err := quantity(f.fields...)
+		if err != nil {
+			return podResources{}, err
+		}
+		if f.milli {
+			*f.dest = q.MilliValue()
+		} else {
+			*f.dest = q.Value()
+		}
+	}
+	return c, nil
+}
+
+// workloadReplicas returns the number of pods created by the given workload.
+// perNode is true if the number of pods depends on the number of nodes in the
+// cluster, in which case a single replica is returned.
+func workloadReplicas(obj *unstructured.Unstructured) (replicas int64, perNode bool) {
+	gvk := obj.GroupVersionKind()
+	var path []string
+	switch gvk.Kind {
+	case "DaemonSet":
+		return 1, true
+	case "Deployment", "ReplicaSet", "StatefulSet", "ReplicationController":
+		path = []string{"spec", "replicas"}
+	case "Job":
+		path = []string{"spec", "parallelism"}
+	case "CronJob":
+		path = []string{"spec", "jobTemplate", "spec", "parallelism"}
+	default:
+		return 1, false
+	}
+	replicas, ok, _ := unstructured.NestedInt64(obj.Object, path...)
+	if !ok {
+		return 1, false
+	}
+	return replicas, false
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	certManagerGroup = "cert-manager.io"
+
+	// certManagerIssuerAnnotation and certManagerClusterIssuerAnnotation
+	// request a Certificate for an Ingress or Gateway from the named Issuer
+	// in its namespace, or ClusterIssuer.
+	certManagerIssuerAnnotation        = "cert-manager.io/issuer"
+	certManagerClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// lintIssuerRef checks the issuerRef of a cert-manager Certificate or
+// CertificateRequest.
+func lintIssuerRef(idx *objectIndex, obj *unstructured.Unstructured) {
+	ref, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "issuerRef")
+	if !ok {
+		return
+	}
+	group, kind := ref["group"], ref["kind"]
+	if group == "" {
+		group = certManagerGroup
+	}
+	if kind == "" {
+		kind = "Issuer"
+	}
+	if group != certManagerGroup {
+		// external issuers are not checked
+		return
+	}
+	lintIssuerReference(idx, obj, obj.GetKind()+" "+describeObject(obj)+" issuerRef", kind, ref["name"])
+}
+
+// lintIssuerAnnotations checks the issuer requested by the cert-manager
+// annotations of an Ingress or Gateway.
+func lintIssuerAnnotations(idx *objectIndex, obj *unstructured.Unstructured) {
+	annotations := obj.GetAnnotations()
+	if name, ok := annotations[certManagerIssuerAnnotation]; ok {
+		lintIssuerReference(idx, obj, obj.GetKind()+" "+describeObject(obj)+" annotation "+certManagerIssuerAnnotation, "Issuer", name)
+	}
+	if name, ok := annotations[certManagerClusterIssuerAnnotation]; ok {
+		lintIssuerReference(idx, obj, obj.GetKind()+" "+describeObject(obj)+" annotation "+certManagerClusterIssuerAnnotation, "ClusterIssuer", name)
+	}
+}
+
+// lintIssuerReference checks that the Issuer or ClusterIssuer with the given
+// name, referenced by obj, is defined in the input files, and reports
+// references that cross the boundary between namespaced Issuers and
+// ClusterIssuers. References are only checked if the input files define
+// any issuers, as they are otherwise assumed to be managed elsewhere.
+func lintIssuerReference(idx *objectIndex, obj *unstructured.Unstructured, referrer, kind, name string) {
+	if !idx.hasKind(certManagerGroup, "Issuer") && !idx.hasKind(certManagerGroup, "ClusterIssuer") {
+		return
+	}
+	ns := obj.GetNamespace()
+	switch kind {
+	case "Issuer":
+		if _, ok := idx.get(certManagerGroup, "Issuer", ns, name); ok {
+			return
+		}
+		if _, ok := idx.get(certManagerGroup, "ClusterIssuer", "", name); ok {
+			warnf("%s references Issuer %q, which is not defined in namespace %q, but a ClusterIssuer of that name is; set its kind to ClusterIssuer", referrer, name, ns)
+			return
+		}
+		if others := idx.namespacesWith(certManagerGroup, "Issuer", name); len(others) > 0 {
+			warnf("%s references Issuer %q, which is only defined in other namespaces %v; Issuers can only be used within their own namespace", referrer, name, others)
+			return
+		}
+		warnf("%s references Issuer %s/%s which is not defined in the input files", referrer, ns, name)
+	case "ClusterIssuer":
+		if _, ok := idx.get(certManagerGroup, "ClusterIssuer", "", name); ok {
+			return
+		}
+		if _, ok := idx.get(certManagerGroup, "Issuer", ns, name); ok {
+			warnf("%s references ClusterIssuer %q, which is not defined, but an Issuer of that name is defined in namespace %q; set its kind to Issuer", referrer, name, ns)
+			return
+		}
+		warnf("%s references ClusterIssuer %q which is not defined in the input files", referrer, name)
+	default:
+		warnf("%s has kind %q, which must be Issuer or ClusterIssuer", referrer, kind)
+	}
+}
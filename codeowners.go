@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// codeownersBegin and codeownersEnd mark the lines of a CODEOWNERS file
+	// that are generated. Lines outside of them are kept as they are.
+	codeownersBegin = "# BEGIN manifest-splitter generated owners"
+	codeownersEnd   = "# END manifest-splitter generated owners"
+)
+
+var (
+	// ownersMapFile is the path to an owners map, giving the owners of
+	// namespace directories.
+	ownersMapFile string
+	// ownersLabel is the label on Namespace resources whose value names the
+	// team that owns the namespace.
+	ownersLabel string
+	// ownersLabelPrefix is prepended to the value of ownersLabel to form an
+	// owner, unless the owners map lists owners for the value.
+	ownersLabelPrefix string
+	// codeownersPath is the path, relative to the output directory, of the
+	// CODEOWNERS file written if ownersMapFile or ownersLabel is set.
+	codeownersPath string
+)
+
+// ownersMap is the format of the file given to --owners-map.
+type ownersMap struct {
+	// Default lists the owners of every file not otherwise owned.
+	Default []string `json:"default,omitempty"`
+	// Cluster lists the owners of cluster scoped resources.
+	Cluster []string `json:"cluster,omitempty"`
+	// Namespaces gives the owners of namespaces. The first rule matching a
+	// namespace applies.
+	Namespaces []namespaceOwners `json:"namespaces,omitempty"`
+	// Teams maps values of the --owners-label namespace label to owners.
+	Teams map[string][]string `json:"teams,omitempty"`
+}
+
+type namespaceOwners struct {
+	// Namespace is the name of a namespace, or a glob pattern such as
+	// 'team-a-*'.
+	Namespace string   `json:"namespace"`
+	Owners    []string `json:"owners"`
+}
+
+func loadOwnersMap(filename string) (*ownersMap, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m ownersMap
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode owners map %q: %v", filename, err)
+	}
+	for i, rule := range m.Namespaces {
+		if _, err := path.Match(rule.Namespace, ""); err != nil {
+			return nil, fmt.Errorf("owners map %q: namespaces[%d]: invalid pattern %q: %v", filename, i, rule.Namespace, err)
+		}
+	}
+	return &m, nil
+}
+
+// ownersOf returns the owners of the given namespace: those of the
+// first owners map rule matching it, or otherwise those named by its
+// --owners-label label.
+func (m *ownersMap) ownersOf(ns string, labels map[string]string) []string {
+	for _, rule := range m.Namespaces {
+		if ok, _ := path.Match(rule.Namespace, ns); ok {
+			return rule.Owners
+		}
+	}
+	if ownersLabel == "" {
+		return nil
+	}
+	team, ok := labels[ownersLabel]
+	if !ok || team == "" {
+		return nil
+	}
+	if owners, ok := m.Teams[team]; ok {
+		return owners
+	}
+	return []string{ownersLabelPrefix + team}
+}
+
+// writeCodeowners writes a CODEOWNERS file to dir assigning the owners of each
+// namespace's directory, and of the cluster scoped resources, given by the
+// owners map and the --owners-label label of each Namespace. Resources are
+// written to root, which is dir or a subdirectory of it. If the file already
+// exists, only the lines between the generated markers are replaced.
+func writeCodeowners(dir, root string, outputs map[string][]resource) error {
+	if ownersMapFile == "" && ownersLabel == "" {
+		return nil
+	}
+	m := &ownersMap{}
+	if ownersMapFile != "" {
+		var err error
+		if m, err = loadOwnersMap(ownersMapFile); err != nil {
+			return err
+		}
+	}
+	base, err := filepath.Rel(dir, root)
+	if err != nil {
+		return err
+	}
+
+	// later lines take precedence, so more general owners come first
+	var lines []string
+	if len(m.Default) > 0 {
+		lines = append(lines, codeownersLine("*", m.Default))
+	}
+	if len(m.Cluster) > 0 {
+		lines = append(lines, codeownersLine(codeownersDir(base, "cluster"), m.Cluster))
+	}
+	var namespaceLines []string
+	for ns, resources := range outputs {
+		if ns == "" {
+			continue
+		}
+		var labels map[string]string
+		for _, r := range resources {
+			if r.obj.GetKind() == "Namespace" && r.obj.GetAPIVersion() == "v1" && r.obj.GetName() == ns {
+				labels = r.obj.GetLabels()
+			}
+		}
+		owners := m.ownersOf(ns, labels)
+		if len(owners) == 0 {
+			log.Printf("No owners found for namespace %q, so it is not listed in %s", ns, codeownersPath)
+			continue
+		}
+		namespaceLines = append(namespaceLines, codeownersLine(codeownersDir(base, filepath.Join(namespacesDir(), namespaceDir(ns))), owners))
+	}
+	sort.Strings(namespaceLines)
+	lines = append(lines, namespaceLines...)
+
+	file := filepath.Join(dir, codeownersPath)
+	existing, err := outputFS.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := replaceCodeownersBlock(string(existing), lines)
+	if err != nil {
+		return fmt.Errorf("updating %q: %v", file, err)
+	}
+	if err := mkdirOutput(filepath.Dir(file)); err != nil {
+		return err
+	}
+	log.Printf("Writing owners of %d namespaces to %s", len(namespaceLines), file)
+	return writeOutputFile(file, []byte(data))
+}
+
+// codeownersDir returns the CODEOWNERS pattern matching everything within
+// the given directory, relative to the repository root.
+func codeownersDir(base, dir string) string {
+	return "/" + path.Clean(filepath.ToSlash(filepath.Join(base, dir))) + "/"
+}
+
+func codeownersLine(pattern string, owners []string) string {
+	return pattern + " " + strings.Join(owners, " ")
+}
+
+// replaceCodeownersBlock returns the contents of a CODEOWNERS file with the
+// generated block replaced by the given lines, or appended if there is none.
+func replaceCodeownersBlock(existing string, lines []string) (string, error) {
+	block := codeownersBegin + "\n"
+	for _, line := range lines {
+		block += line + "\n"
+	}
+	block += codeownersEnd + "\n"
+
+	start := strings.Index(existing, codeownersBegin)
+	if start < 0 {
+		if existing != "" && !strings.HasSuffix(existing, "\n") {
+			existing += "\n"
+		}
+		if existing != "" {
+			existing += "\n"
+		}
+		return existing + block, nil
+	}
+	end := strings.Index(existing[start:], codeownersEnd)
+	if end < 0 {
+		return "", fmt.Errorf("%q is not followed by %q", codeownersBegin, codeownersEnd)
+	}
+	end += start + len(codeownersEnd)
+	if end < len(existing) && existing[end] == '\n' {
+		end++
+	}
+	return existing[:start] + block + existing[end:], nil
+}
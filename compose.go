@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// composeServiceLabel is set on all resources converted from a Docker Compose
+// service, and used to select the service's pods.
+const composeServiceLabel = "app.kubernetes.io/name"
+
+// composeFile is the subset of the Docker Compose file format that is
+// converted to Kubernetes resources.
+type composeFile struct {
+	Services map[string]composeService `json:"services"`
+	Volumes  map[string]interface{}    `json:"volumes"`
+}
+
+type composeService struct {
+	Image       string        `json:"image"`
+	Entrypoint  composeString `json:"entrypoint"`
+	Command     composeString `json:"command"`
+	Environment interface{}   `json:"environment"`
+	Ports       []interface{} `json:"ports"`
+	Volumes     []interface{} `json:"volumes"`
+	WorkingDir  string        `json:"working_dir"`
+	EnvFile     interface{}   `json:"env_file"`
+	Deploy      struct {
+		Replicas *int64 `json:"replicas"`
+	} `json:"deploy"`
+}
+
+// composeString is a Compose field that may be given as either a string or a
+// list of strings. Strings are split into words as by a POSIX shell, as
+// Compose does.
+type composeString []string
+
+func (c *composeString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		words, err := splitShellWords(s)
+		if err != nil {
+			return err
+		}
+		*c = words
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+	*c = l
+	return nil
+}
+
+// splitShellWords splits s into words as a POSIX shell does, without
+// expanding variables: words are separated by unquoted whitespace, single
+// quotes preserve everything within them, double quotes preserve everything
+// but backslash escapes of '"', '\\', '$' and '`', and an unquoted backslash
+// escapes the character following it. For example, 'sh -c "echo a b"' is
+// split into 'sh', '-c' and 'echo a b'.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	// inWord is true once a word has been started, so that empty quoted
+	// strings are kept as words
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`\n", c) {
+				word.WriteRune('\\')
+			}
+			if c != '\n' {
+				word.WriteRune(c)
+			}
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	switch {
+	case escaped:
+		return nil, fmt.Errorf("%q ends with an unescaped backslash", s)
+	case quote != 0:
+		return nil, fmt.Errorf("%q has an unterminated %c quote", s, quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// isComposeInput returns true if the given input file is a Docker Compose
+// file, based on its name.
+func isComposeInput(input string) bool {
+	switch filepath.Base(input) {
+	case "docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml":
+		return true
+	}
+	return false
+}
+
+// convertCompose converts a Docker Compose file into a v1 List containing a
+// Deployment for each service, a Service for each service that publishes
+// ports, and a PersistentVolumeClaim for each named volume.
+func convertCompose(input string, data []byte) ([]byte, error) {
+	var compose composeFile
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil, fmt.Errorf("decoding Compose file: %v", err)
+	}
+
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var items []interface{}
+	claims := make(map[string]bool)
+	for _, name := range names {
+		svc := compose.Services[name]
+		if svc.Image == "" {
+			return nil, fmt.Errorf("service %q has no image; services that are only built from source cannot be converted", name)
+		}
+		if svc.EnvFile != nil {
+			warnf("ignoring env_file of Compose service %q in file %q", name, input)
+		}
+
+		labels := map[string]interface{}{composeServiceLabel: name}
+		container := map[string]interface{}{
+			"name":  name,
+			"image": svc.Image,
+		}
+		if len(svc.Entrypoint) > 0 {
+			container["command"] = stringsToInterfaces(svc.Entrypoint)
+		}
+		if len(svc.Command) > 0 {
+			container["args"] = stringsToInterfaces(svc.Command)
+		}
+		if svc.WorkingDir != "" {
+			container["workingDir"] = svc.WorkingDir
+		}
+		if env := composeEnvironment(svc.Environment); len(env) > 0 {
+			container["env"] = env
+		}
+
+		var containerPorts, servicePorts []interface{}
+		for _, p := range svc.Ports {
+			published, target, protocol, err := parseComposePort(p)
+			if err != nil {
+				return nil, fmt.Errorf("service %q: %v", name, err)
+			}
+			containerPorts = append(containerPorts, map[string]interface{}{
+				"containerPort": target,
+				"protocol":      protocol,
+			})
+			servicePorts = append(servicePorts, map[string]interface{}{
+				"name":       fmt.Sprintf("%s-%d", strings.ToLower(protocol), published),
+				"port":       published,
+				"targetPort": target,
+				"protocol":   protocol,
+			})
+		}
+		if len(containerPorts) > 0 {
+			container["ports"] = containerPorts
+		}
+
+		var volumes, mounts []interface{}
+		for _, v := range svc.Volumes {
+			source, target, readOnly := parseComposeVolume(v)
+			if _, named := compose.Volumes[source]; !named {
+				warnf("ignoring volume mounted at %q in Compose service %q in file %q as only named volumes can be converted", target, name, input)
+				continue
+			}
+			volumes = append(volumes, map[string]interface{}{
+				"name": source,
+				"persistentVolumeClaim": map[string]interface{}{
+					"claimName": source,
+				},
+			})
+			mounts = append(mounts, map[string]interface{}{
+				"name":      source,
+				"mountPath": target,
+				"readOnly":  readOnly,
+			})
+			claims[source] = true
+		}
+		if len(mounts) > 0 {
+			container["volumeMounts"] = mounts
+		}
+
+		podSpec := map[string]interface{}{
+			"containers": []interface{}{container},
+		}
+		if len(volumes) > 0 {
+			podSpec["volumes"] = volumes
+		}
+		replicas := int64(1)
+		if svc.Deploy.Replicas != nil {
+			replicas = *svc.Deploy.Replicas
+		}
+		items = append(items, map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   composeMetadata(name, labels),
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec":     podSpec,
+				},
+			},
+		})
+		if len(servicePorts) > 0 {
+			items = append(items, map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   composeMetadata(name, labels),
+				"spec": map[string]interface{}{
+					"selector": labels,
+					"ports":    servicePorts,
+				},
+			})
+		}
+	}
+
+	for _, name := range sortedNames(claims) {
+		items = append(items, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "PersistentVolumeClaim",
+			"metadata":   composeMetadata(name, nil),
+			"spec": map[string]interface{}{
+				"accessModes": []interface{}{"ReadWriteOnce"},
+				"resources": map[string]interface{}{
+					"requests": map[string]interface{}{"storage": "1Gi"},
+				},
+			},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
+
+func composeMetadata(name string, labels map[string]interface{}) map[string]interface{} {
+	meta := map[string]interface{}{
+		"name":      name,
+		"namespace": composeNamespace,
+	}
+	if labels != nil {
+		meta["labels"] = labels
+	}
+	return meta
+}
+
+// composeEnvironment converts a Compose environment, given as either a map or
+// a list of KEY=VALUE strings, into a list of container environment variables.
+func composeEnvironment(environment interface{}) []interface{} {
+	vars := make(map[string]string)
+	switch env := environment.(type) {
+	case map[string]interface{}:
+		for k, v := range env {
+			if v == nil {
+				vars[k] = ""
+			} else {
+				vars[k] = fmt.Sprintf("%v", v)
+			}
+		}
+	case []interface{}:
+		for _, e := range env {
+			parts := strings.SplitN(fmt.Sprintf("%v", e), "=", 2)
+			if len(parts) == 2 {
+				vars[parts[0]] = parts[1]
+			} else {
+				vars[parts[0]] = ""
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []interface{}
+	for _, k := range keys {
+		out = append(out, map[string]interface{}{"name": k, "value": vars[k]})
+	}
+	return out
+}
+
+// parseComposePort parses a Compose port mapping in either the short
+// ("[host:]published:target[/protocol]") or long syntax.
+func parseComposePort(port interface{}) (published, target int64, protocol string, err error) {
+	protocol = "TCP"
+	switch p := port.(type) {
+	case map[string]interface{}:
+		if target, err = composePortNumber(p["target"]); err != nil {
+			return 0, 0, "", err
+		}
+		published = target
+		if p["published"] != nil {
+			if published, err = composePortNumber(p["published"]); err != nil {
+				return 0, 0, "", err
+			}
+		}
+		if proto, ok := p["protocol"].(string); ok {
+			protocol = strings.ToUpper(proto)
+		}
+		return published, target, protocol, nil
+	default:
+		spec := fmt.Sprintf("%v", p)
+		if i := strings.LastIndex(spec, "/"); i >= 0 {
+			protocol = strings.ToUpper(spec[i+1:])
+			spec = spec[:i]
+		}
+		parts := strings.Split(spec, ":")
+		if target, err = composePortNumber(parts[len(parts)-1]); err != nil {
+			return 0, 0, "", err
+		}
+		published = target
+		if len(parts) > 1 {
+			if published, err = composePortNumber(parts[len(parts)-2]); err != nil {
+				return 0, 0, "", err
+			}
+		}
+		return published, target, protocol, nil
+	}
+}
+
+func composePortNumber(v interface{}) (int64, error) {
+	s := fmt.Sprintf("%v", v)
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q; port ranges are not supported", s)
+	}
+	return n, nil
+}
+
+// parseComposeVolume parses a Compose volume in either the short
+// ("source:target[:mode]") or long syntax.
+func parseComposeVolume(volume interface{}) (source, target string, readOnly bool) {
+	switch v := volume.(type) {
+	case map[string]interface{}:
+		source, _ = v["source"].(string)
+		target, _ = v["target"].(string)
+		readOnly, _ = v["read_only"].(bool)
+		return source, target, readOnly
+	default:
+		parts := strings.Split(fmt.Sprintf("%v", v), ":")
+		if len(parts) == 1 {
+			return "", parts[0], false
+		}
+		return parts[0], parts[1], len(parts) > 2 && parts[2] == "ro"
+	}
+}
+
+func stringsToInterfaces(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
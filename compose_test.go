@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "  nginx   -g  daemon ", want: []string{"nginx", "-g", "daemon"}},
+		{in: `sh -c "echo a b"`, want: []string{"sh", "-c", "echo a b"}},
+		{in: `sh -c 'echo "$HOME" a'`, want: []string{"sh", "-c", `echo "$HOME" a`}},
+		{in: `echo "a \"b\" \$c \d"`, want: []string{"echo", `a "b" $c \d`}},
+		{in: `echo a\ b \'c`, want: []string{"echo", "a b", "'c"}},
+		{in: `echo '' ""`, want: []string{"echo", "", ""}},
+		{in: `a"b c"d`, want: []string{"ab cd"}},
+		{in: "a\\\nb", want: []string{"ab"}},
+		{in: `echo "a b`, wantErr: true},
+		{in: `echo 'a b`, wantErr: true},
+		{in: `echo a\`, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := splitShellWords(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("splitShellWords(%q) returned error %v, want error: %t", test.in, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestComposeStringUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want composeString
+	}{
+		{in: `"sh -c \"echo a b\""`, want: composeString{"sh", "-c", "echo a b"}},
+		{in: `["sh", "-c", "echo a b"]`, want: composeString{"sh", "-c", "echo a b"}},
+	}
+	for _, test := range tests {
+		var got composeString
+		if err := json.Unmarshal([]byte(test.in), &got); err != nil {
+			t.Errorf("decoding %s: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("decoding %s = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	flag "github.com/spf13/pflag"
+
+	"github.com/munnerz/manifest-splitter/api/config/v1alpha1"
+)
+
+// configFile is the path to a splitter config file, if set.
+var configFile string
+
+// splitterConfig is the loaded --config file, or nil if none was given.
+var splitterConfig *v1alpha1.SplitterConfig
+
+// loadConfigFile loads --config, if set, compiles its routes along with the
+// default routes, and applies the other settings it contains to their
+// equivalent flags. Flags set explicitly on the command line take precedence
+// over the config file.
+func loadConfigFile() error {
+	if err := validateMeshFlags(); err != nil {
+		return err
+	}
+	if configFile == "" {
+		var err error
+		routes, err = compileRoutes(withDefaultRoutes(nil))
+		return err
+	}
+	cfg, err := v1alpha1.Load(configFile)
+	if err != nil {
+		return err
+	}
+	splitterConfig = cfg
+	if routes, err = compileRoutes(withDefaultRoutes(cfg.Routes)); err != nil {
+		return err
+	}
+
+	setStrings := func(name string, dst *[]string, v []string) {
+		if v != nil && !flag.CommandLine.Changed(name) {
+			*dst = v
+		}
+	}
+	setBool := func(name string, dst *bool, v *bool) {
+		if v != nil && !flag.CommandLine.Changed(name) {
+			*dst = *v
+		}
+	}
+	setString := func(name string, dst *string, v string) {
+		if v != "" && !flag.CommandLine.Changed(name) {
+			*dst = v
+		}
+	}
+
+	t := cfg.Transformations
+	setStrings("strip-annotations", &stripAnnotations, t.StripAnnotations)
+	if l := t.StandardLabels; l != nil {
+		enabled := true
+		setBool("standard-labels", &standardLabels, &enabled)
+		setString("managed-by", &managedBy, l.ManagedBy)
+		setString("part-of", &partOf, l.PartOf)
+		setString("instance", &instance, l.Instance)
+	}
+	setBool("inject-config-hash", &injectConfigHash, t.InjectConfigHash)
+	setBool("prune-empty", &pruneEmpty, t.PruneEmpty)
+	setBool("strip-status", &stripStatus, t.StripStatus)
+	if namespaceLabelRules, err = compileNamespaceLabelRules(t.NamespaceLabels); err != nil {
+		return err
+	}
+
+	f := cfg.Filters
+	setStrings("include-kinds", &includeKinds, f.IncludeKinds)
+	setStrings("exclude-kinds", &excludeKinds, f.ExcludeKinds)
+	setStrings("prefer-group", &preferGroups, f.PreferGroups)
+	setBool("skip-owned", &skipOwned, f.SkipOwned)
+	setBool("skip-system", &skipSystem, f.SkipSystem)
+	return nil
+}
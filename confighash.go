@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// configHashAnnotation is set on the pod template of workloads by
+// --inject-config-hash. Its value changes whenever any ConfigMap or Secret
+// used by the workload changes, causing the workload to be rolled out.
+const configHashAnnotation = "manifest-splitter.io/config-hash"
+
+// configHashKeyFile is set by --config-hash-key-file, the path to a file
+// containing a secret key that config hashes are computed as an HMAC with.
+// Without a key, the data of Secrets does not contribute to config hashes, as
+// a plain hash of it is written to the output, where values of low entropy
+// such as passwords could be recovered by hashing guesses until one matches.
+var configHashKeyFile string
+
+// injectConfigHashes annotates the pod template of each workload with a hash
+// of the contents of the ConfigMaps and Secrets it references. Only objects
+// defined in the input files contribute to the hash, and workloads that
+// reference none of them are not annotated.
+func injectConfigHashes(files map[string][]resource) error {
+	var key []byte
+	if configHashKeyFile != "" {
+		var err error
+		if key, err = ioutil.ReadFile(configHashKeyFile); err != nil {
+			return fmt.Errorf("reading config hash key: %v", err)
+		}
+		if key = bytes.TrimSpace(key); len(key) == 0 {
+			return fmt.Errorf("config hash key file %q is empty", configHashKeyFile)
+		}
+	}
+	idx := newObjectIndex(files)
+	for _, resources := range files {
+		for i := range resources {
+			r := &resources[i]
+			path, ok := podTemplatePaths[r.obj.GroupVersionKind().GroupKind()]
+			if !ok {
+				continue
+			}
+			spec, ok := podSpec(r.obj)
+			if !ok {
+				continue
+			}
+			hash, ok, err := configHash(idx, key, r.obj.GetNamespace(), podSpecReferences(spec))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			annotationsPath := append(append([]string(nil), path...), "metadata", "annotations")
+			annotations, _, _ := unstructured.NestedStringMap(r.obj.Object, annotationsPath...)
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			if annotations[configHashAnnotation] == hash {
+				continue
+			}
+			annotations[configHashAnnotation] = hash
+			if err := unstructured.SetNestedStringMap(r.obj.Object, annotations, annotationsPath...); err != nil {
+				return err
+			}
+			r.markModified()
+		}
+	}
+	return nil
+}
+
+// configHash returns a hash of the data of all ConfigMaps and Secrets in
+// refs that are defined in the index. ok is false if none are. If key is set,
+// the hash is an HMAC with key, and includes the data of Secrets. Otherwise
+// only the names and resourceVersions of Secrets are included.
+func configHash(idx *objectIndex, key []byte, namespace string, refs podReferences) (sum string, ok bool, err error) {
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	add := func(kind string, names []string) error {
+		for _, name := range names {
+			obj, found := idx.get("", kind, namespace, name)
+			if !found {
+				continue
+			}
+			ok = true
+			fields := []interface{}{kind, name, obj.Object["data"], obj.Object["binaryData"], obj.Object["stringData"]}
+			if kind == "Secret" && key == nil {
+				fields = []interface{}{kind, name, obj.GetResourceVersion()}
+			}
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			h.Write(data)
+		}
+		return nil
+	}
+	if err := add("ConfigMap", refs.configMaps); err != nil {
+		return "", false, err
+	}
+	if err := add("Secret", refs.secrets); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), ok, nil
+}
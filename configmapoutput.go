@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+const (
+	configMapOutputScheme = "configmap"
+	secretOutputScheme    = "secret"
+
+	// configMapOutputLabel is set on every ConfigMap or Secret written to the
+	// cluster to the name prefix, so that stale ones can be found and deleted.
+	configMapOutputLabel = "manifest-splitter.io/output"
+	// configMapDirectoryAnnotation records the output directory that a
+	// ConfigMap or Secret holds.
+	configMapDirectoryAnnotation = "manifest-splitter.io/directory"
+	// configMapFilesAnnotation records the filename of each key that differs
+	// from its filename, as a JSON object of key to filename.
+	configMapFilesAnnotation = "manifest-splitter.io/files"
+
+	// maxConfigMapSize is the total size of data that the apiserver accepts
+	// in a single ConfigMap or Secret.
+	maxConfigMapSize = 1024 * 1024
+)
+
+// configMapOutput is the namespace given to --output, if it is a configmap://
+// or secret:// URL. The output is split into memory, and then applied to the
+// cluster given by --kubeconfig.
+var configMapOutput *configMapLocation
+
+type configMapLocation struct {
+	scheme, namespace, prefix string
+}
+
+func (l *configMapLocation) String() string {
+	return fmt.Sprintf("%s://%s/%s", l.scheme, l.namespace, l.prefix)
+}
+
+func (l *configMapLocation) kind() string {
+	if l.scheme == secretOutputScheme {
+		return "Secret"
+	}
+	return "ConfigMap"
+}
+
+// parseConfigMapURL parses a configmap://namespace/prefix or
+// secret://namespace/prefix URL. The prefix defaults to "manifests". ok is
+// false if s is not a ConfigMap or Secret URL.
+func parseConfigMapURL(s string) (loc *configMapLocation, ok bool, err error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != configMapOutputScheme && u.Scheme != secretOutputScheme) {
+		return nil, false, nil
+	}
+	if errs := validation.IsDNS1123Label(u.Host); len(errs) > 0 {
+		return nil, true, fmt.Errorf("%q does not name a valid namespace: %s", s, strings.Join(errs, ", "))
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "manifests"
+	}
+	if errs := validation.IsDNS1123Label(prefix); len(errs) > 0 {
+		return nil, true, fmt.Errorf("%q does not have a valid name prefix: %s", s, strings.Join(errs, ", "))
+	}
+	return &configMapLocation{scheme: u.Scheme, namespace: u.Host, prefix: prefix}, true, nil
+}
+
+var invalidConfigMapNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+var invalidConfigMapKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// configMapName returns the name of the ConfigMap or Secret holding the given
+// output directory.
+func configMapName(prefix, dir string) string {
+	if dir == "." {
+		return prefix
+	}
+	name := invalidConfigMapNameChars.ReplaceAllString(strings.ToLower(dir), "-")
+	name = strings.Trim(prefix+"-"+name, "-")
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		// keep names unique by replacing the tail with a hash of the
+		// directory
+		hash := strings.TrimPrefix(contentHash([]byte(dir)), "sha256:")[:8]
+		name = strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-9], "-") + "-" + hash
+	}
+	return name
+}
+
+// buildConfigMaps returns a ConfigMap or Secret for each directory of the
+// given in-memory output that contains files, keyed by name. Filenames are
+// used as keys, with characters not allowed in keys replaced by '_'.
+func buildConfigMaps(out *outputfs.Mem, loc *configMapLocation) (map[string]*unstructured.Unstructured, error) {
+	type dirData struct {
+		data  map[string]string
+		files map[string]string
+		size  int
+	}
+	dirs := make(map[string]*dirData)
+	var order []string
+	for _, p := range out.Paths() {
+		if fi, err := out.Stat(p); err != nil || fi.IsDir() {
+			continue
+		}
+		data, err := out.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		dir, file := path.Dir(p), path.Base(p)
+		d, ok := dirs[dir]
+		if !ok {
+			d = &dirData{data: make(map[string]string), files: make(map[string]string)}
+			dirs[dir] = d
+			order = append(order, dir)
+		}
+		key := invalidConfigMapKeyChars.ReplaceAllString(file, "_")
+		if _, exists := d.data[key]; exists {
+			return nil, fmt.Errorf("files in %q would share the %s key %q", dir, loc.kind(), key)
+		}
+		if key != file {
+			d.files[key] = file
+		}
+		value := string(data)
+		if loc.scheme == secretOutputScheme {
+			value = base64.StdEncoding.EncodeToString(data)
+		}
+		d.data[key] = value
+		d.size += len(key) + len(data)
+	}
+
+	objs := make(map[string]*unstructured.Unstructured)
+	dirOf := make(map[string]string)
+	for _, dir := range order {
+		d := dirs[dir]
+		name := configMapName(loc.prefix, dir)
+		if other, ok := dirOf[name]; ok {
+			return nil, fmt.Errorf("output directories %q and %q would both be written to %s %q", other, dir, loc.kind(), name)
+		}
+		dirOf[name] = dir
+		if d.size > maxConfigMapSize {
+			return nil, fmt.Errorf("output directory %q is %d bytes, larger than the %d bytes that fit in a %s", dir, d.size, maxConfigMapSize, loc.kind())
+		}
+
+		annotations := map[string]interface{}{configMapDirectoryAnnotation: dir}
+		if len(d.files) > 0 {
+			files, err := json.Marshal(d.files)
+			if err != nil {
+				return nil, err
+			}
+			annotations[configMapFilesAnnotation] = string(files)
+		}
+		data := make(map[string]interface{}, len(d.data))
+		for k, v := range d.data {
+			data[k] = v
+		}
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       loc.kind(),
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": loc.namespace,
+				"labels": map[string]interface{}{
+					"app.kubernetes.io/managed-by": "manifest-splitter",
+					configMapOutputLabel:           loc.prefix,
+				},
+				"annotations": annotations,
+			},
+			"data": data,
+		}}
+		if loc.scheme == secretOutputScheme {
+			obj.Object["type"] = "Opaque"
+		}
+		objs[name] = obj
+	}
+	return objs, nil
+}
+
+// applyConfigMaps writes the given in-memory output to the cluster as one
+// ConfigMap or Secret per output directory, using server-side apply. Any
+// previously written with the same prefix that are no longer part of the
+// output are deleted.
+func applyConfigMaps(out *outputfs.Mem, loc *configMapLocation) error {
+	objs, err := buildConfigMaps(out, loc)
+	if err != nil {
+		return err
+	}
+	restcfg, err := restConfig()
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(restcfg)
+	if err != nil {
+		return err
+	}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: strings.ToLower(loc.kind()) + "s"}
+	resources := client.Resource(gvr).Namespace(loc.namespace)
+	ctx := context.Background()
+
+	names := make([]string, 0, len(objs))
+	for name := range objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	force := true
+	for _, name := range names {
+		data, err := json.Marshal(objs[name].Object)
+		if err != nil {
+			return err
+		}
+		if _, err := resources.Patch(ctx, name, types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: "manifest-splitter", Force: &force}); err != nil {
+			return fmt.Errorf("applying %s %s/%s: %v", loc.kind(), loc.namespace, name, err)
+		}
+	}
+
+	existing, err := resources.List(ctx, metav1.ListOptions{LabelSelector: configMapOutputLabel + "=" + loc.prefix})
+	if err != nil {
+		return fmt.Errorf("listing %ss in %q: %v", loc.kind(), loc.namespace, err)
+	}
+	deleted := 0
+	for _, item := range existing.Items {
+		if _, ok := objs[item.GetName()]; ok {
+			continue
+		}
+		if err := resources.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("deleting stale %s %s/%s: %v", loc.kind(), loc.namespace, item.GetName(), err)
+		}
+		deleted++
+	}
+	log.Printf("Applied %d %ss to namespace %q, deleting %d that are no longer output", len(objs), loc.kind(), loc.namespace, deleted)
+	return nil
+}
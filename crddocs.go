@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// crdDocsDir is the directory, relative to the output directory, that CRD
+// schemas are written to.
+const crdDocsDir = "docs/crds"
+
+var (
+	// crdDocs is set by --crd-docs. When true, the openAPIV3Schema of each
+	// version of each CRD in the inputs is written to crdDocsDir.
+	crdDocs bool
+	// crdDocsMarkdown is set by --crd-docs-markdown. When true, a markdown
+	// description of the fields of each CRD is also written.
+	crdDocsMarkdown bool
+)
+
+// crdSchema is the schema of a single version of a CRD.
+type crdSchema struct {
+	group, kind, version string
+	schema               map[string]interface{}
+}
+
+// crdSchemas returns the schema of each version of the given
+// CustomResourceDefinition that has one. v1beta1 CRDs may declare a single
+// schema for all versions.
+func crdSchemas(obj *unstructured.Unstructured) []crdSchema {
+	group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+	shared, _, _ := unstructured.NestedMap(obj.Object, "spec", "validation", "openAPIV3Schema")
+
+	var schemas []crdSchema
+	add := func(version string, schema map[string]interface{}) {
+		if version != "" && schema != nil {
+			schemas = append(schemas, crdSchema{group: group, kind: kind, version: version, schema: schema})
+		}
+	}
+	versions, _, _ := unstructured.NestedSlice(obj.Object, "spec", "versions")
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := version["name"].(string)
+		schema, _, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if schema == nil {
+			schema = shared
+		}
+		add(name, schema)
+	}
+	if len(versions) == 0 {
+		version, _, _ := unstructured.NestedString(obj.Object, "spec", "version")
+		add(version, shared)
+	}
+	return schemas
+}
+
+// writeCRDDocs writes the schema of each version of each CRD in outputs to
+// crdDocsDir within dir, as <group>/<kind>_<version>.json so that the
+// directory can be used as a schema location by validation tools, and a
+// <group>/<kind>.md file describing its fields if --crd-docs-markdown is set.
+func writeCRDDocs(dir string, outputs map[string][]resource) error {
+	if !crdDocs {
+		return nil
+	}
+	var schemas []crdSchema
+	for _, resources := range outputs {
+		for _, r := range resources {
+			gvk := r.obj.GroupVersionKind()
+			if gvk.Group == "apiextensions.k8s.io" && gvk.Kind == "CustomResourceDefinition" {
+				schemas = append(schemas, crdSchemas(r.obj)...)
+			}
+		}
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		a, b := schemas[i], schemas[j]
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		return a.version < b.version
+	})
+
+	byKind := make(map[string][]crdSchema)
+	var kinds []string
+	for _, s := range schemas {
+		groupDir := filepath.Join(dir, crdDocsDir, s.group)
+		if err := mkdirOutput(groupDir); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(s.schema, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding schema of %s %s: %v", s.kind, s.version, err)
+		}
+		path := filepath.Join(groupDir, fmt.Sprintf("%s_%s.json", strings.ToLower(s.kind), s.version))
+		if err := writeOutputFile(path, append(data, '\n')); err != nil {
+			return err
+		}
+		key := filepath.Join(s.group, strings.ToLower(s.kind))
+		if _, ok := byKind[key]; !ok {
+			kinds = append(kinds, key)
+		}
+		byKind[key] = append(byKind[key], s)
+	}
+	if crdDocsMarkdown {
+		for _, key := range kinds {
+			path := filepath.Join(dir, crdDocsDir, key+".md")
+			if err := writeOutputFile(path, []byte(crdMarkdown(byKind[key]))); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Wrote schemas of %d CRD versions to %s", len(schemas), filepath.Join(dir, crdDocsDir))
+	return nil
+}
+
+// crdMarkdown returns a markdown document describing the fields of each of
+// the given versions of a CRD.
+func crdMarkdown(versions []crdSchema) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\nGroup: `%s`\n", versions[0].kind, versions[0].group)
+	for _, v := range versions {
+		fmt.Fprintf(&b, "\n## %s\n\n", v.version)
+		if desc, _ := v.schema["description"].(string); desc != "" {
+			fmt.Fprintf(&b, "%s\n\n", desc)
+		}
+		b.WriteString("| Field | Type | Required | Description |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		writeSchemaFields(&b, "", v.schema)
+	}
+	return b.String()
+}
+
+// writeSchemaFields writes a table row for each property of the given schema,
+// and recursively of the schemas of its properties and array items.
+func writeSchemaFields(b *strings.Builder, prefix string, schema map[string]interface{}) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	required := make(map[string]bool)
+	if list, ok := schema["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	for _, name := range names {
+		prop, ok := properties[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := prefix + name
+		typ, _ := prop["type"].(string)
+		if typ == "array" {
+			if items, ok := prop["items"].(map[string]interface{}); ok {
+				itemType, _ := items["type"].(string)
+				typ = "[]" + itemType
+			}
+		}
+		desc, _ := prop["description"].(string)
+		desc = strings.Replace(strings.Join(strings.Fields(desc), " "), "|", "\\|", -1)
+		req := ""
+		if required[name] {
+			req = "yes"
+		}
+		fmt.Fprintf(b, "| `%s` | %s | %s | %s |\n", path, typ, req, desc)
+
+		writeSchemaFields(b, path+".", prop)
+		if items, ok := prop["items"].(map[string]interface{}); ok {
+			writeSchemaFields(b, path+"[].", items)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dedupeDir is the directory, relative to the output directory, that
+// resources duplicated across namespaces are written to once, if set.
+var dedupeDir string
+
+// dedupeResources finds namespaced resources that are identical apart from
+// their namespace in more than one namespace, such as baseline RBAC or
+// resources fanned out into every namespace. Each is written once into
+// --dedupe-dir without a namespace, and the file in each namespace's
+// directory is replaced by a symlink to it. It must be called once resources
+// have been routed.
+func dedupeResources(outputs map[string][]resource) error {
+	if dedupeDir == "" {
+		return nil
+	}
+	type member struct {
+		ns  string
+		idx int
+	}
+	groups := make(map[string][]member)
+	for ns, resources := range outputs {
+		if ns == "" {
+			continue
+		}
+		for i, r := range resources {
+			if !r.namespaced || r.obj.IsList() || r.dir != "" || isAllNamespaces(r.obj) {
+				continue
+			}
+			if _, ok, _ := annotatedPath(r.obj); ok {
+				continue
+			}
+			id, err := dedupeIdentity(r)
+			if err != nil {
+				return fmt.Errorf("comparing %s %s: %v", r.obj.GetKind(), describeObject(r.obj), err)
+			}
+			groups[id] = append(groups[id], member{ns: ns, idx: i})
+		}
+	}
+
+	// groups are processed in a stable order so that canonical file names
+	// do not change between runs
+	ids := make([]string, 0, len(groups))
+	for id, members := range groups {
+		if len(members) > 1 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	used := make(map[string]bool)
+	for _, id := range ids {
+		members := groups[id]
+		sort.Slice(members, func(i, j int) bool { return members[i].ns < members[j].ns })
+		first := outputs[members[0].ns][members[0].idx]
+
+		canonical := resource{
+			inputFilename: first.inputFilename,
+			format:        first.format,
+			obj:           first.obj.DeepCopy(),
+			namespaced:    true,
+			dir:           dedupeDir,
+		}
+		canonical.obj.SetNamespace("")
+		canonical.markModified()
+		filename := resourceFilename(canonical)
+		if used[filename] {
+			// a different resource with the same kind and name is
+			// duplicated in other namespaces
+			ext := filepath.Ext(filename)
+			filename = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filename, ext), strings.TrimPrefix(contentHash([]byte(id)), "sha256:")[:8], ext)
+		}
+		used[filename] = true
+		canonical.filename = filename
+		outputs[""] = append(outputs[""], canonical)
+
+		link := filepath.Join(dedupeDir, filename)
+		for _, m := range members {
+			r := &outputs[m.ns][m.idx]
+			r.obj.SetNamespace("")
+			r.markModified()
+			r.link = link
+		}
+		log.Printf("Writing %s %q, which is identical in %d namespaces, once to %s", first.obj.GetKind(), first.obj.GetName(), len(members), filepath.ToSlash(link))
+	}
+	return nil
+}
+
+// dedupeIdentity returns a string identifying the given resource regardless
+// of its namespace.
+func dedupeIdentity(r resource) (string, error) {
+	obj := r.obj.DeepCopy()
+	obj.SetNamespace("")
+	// map keys are sorted when encoding, so the encoding is canonical
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(r.format) + ":" + string(data), nil
+}
+
+// writeOutputSymlink replaces the output file at path with a symlink to
+// target, which is relative to the directory containing path. data is the
+// content of the target, used to record and back up the change.
+func writeOutputSymlink(path, target string, data []byte) error {
+	if existing, err := outputFS.Readlink(path); err == nil && existing == target {
+		return nil
+	}
+	if err := recordChange(path, data); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if err := backupOutputFile(path, data); err != nil {
+		return fmt.Errorf("backing up %q: %v", path, err)
+	}
+	if atomicWrites {
+		_, err := stageSymlink(path, target)
+		return err
+	}
+	if err := outputFS.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return outputFS.Symlink(target, path)
+}
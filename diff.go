@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fieldDiff describes a single field that differs between two objects.
+type fieldDiff struct {
+	// path is the path to the field, e.g. spec.template.spec.containers[0].image
+	path string
+	// a and b are the values of the field in each object.
+	a, b interface{}
+	// aMissing and bMissing are true if the field is not set in the
+	// respective object.
+	aMissing, bMissing bool
+}
+
+func (d fieldDiff) String() string {
+	format := func(v interface{}, missing bool) string {
+		if missing {
+			return "<unset>"
+		}
+		return fmt.Sprintf("%v", v)
+	}
+	return fmt.Sprintf("%s: %s != %s", d.path, format(d.a, d.aMissing), format(d.b, d.bMissing))
+}
+
+// diffObjects returns the list of fields that differ between a and b, sorted
+// by path.
+func diffObjects(a, b map[string]interface{}) []fieldDiff {
+	var diffs []fieldDiff
+	diffValues("", a, b, &diffs)
+	return diffs
+}
+
+func diffValues(path string, a, b interface{}, diffs *[]fieldDiff) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]struct{})
+		for k := range av {
+			keys[k] = struct{}{}
+		}
+		for k := range bv {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			aval, aok := av[k]
+			bval, bok := bv[k]
+			fieldPath := joinFieldPath(path, k)
+			if !aok || !bok {
+				*diffs = append(*diffs, fieldDiff{path: fieldPath, a: aval, b: bval, aMissing: !aok, bMissing: !bok})
+				continue
+			}
+			diffValues(fieldPath, aval, bval, diffs)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(av):
+				*diffs = append(*diffs, fieldDiff{path: itemPath, b: bv[i], aMissing: true})
+			case i >= len(bv):
+				*diffs = append(*diffs, fieldDiff{path: itemPath, a: av[i], bMissing: true})
+			default:
+				diffValues(itemPath, av[i], bv[i], diffs)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, fieldDiff{path: path, a: a, b: b})
+	}
+}
+
+// joinFieldPath appends the given map key to a field path, using bracket
+// notation for keys that would otherwise be ambiguous (e.g. annotation keys).
+func joinFieldPath(path, key string) string {
+	if strings.ContainsAny(key, `./[]"`) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// runDiff implements the 'diff' subcommand, which decodes two sets of
+// manifests and prints the resources added, removed and changed between them,
+// with the fields that changed. Each set is an input file or a directory of
+// manifests. It returns an error if the sets differ, so that it can be used as
+// a check.
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: manifest-splitter diff <old file or directory> <new file or directory>")
+	}
+	a, err := readDiffObjects(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readDiffObjects(args[1])
+	if err != nil {
+		return err
+	}
+	changes := printObjectDiff(os.Stdout, a, b)
+	if changes > 0 {
+		return fmt.Errorf("%d resources differ between %q and %q", changes, args[0], args[1])
+	}
+	return nil
+}
+
+// readDiffObjects decodes the given input file, or all manifests within the
+// given directory, and returns the objects they contain by key.
+func readDiffObjects(input string) (map[objectKey]*unstructured.Unstructured, error) {
+	inputs := []string{input}
+	if fi, err := os.Stat(input); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		paths, err := layoutFiles(input)
+		if err != nil {
+			return nil, err
+		}
+		inputs = inputs[:0]
+		for _, path := range paths {
+			inputs = append(inputs, filepath.Join(input, path))
+		}
+	}
+	files, err := readInputs(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make(map[objectKey]*unstructured.Unstructured)
+	add := func(filename string, obj *unstructured.Unstructured) error {
+		key := objectKey{group: obj.GroupVersionKind().Group, kind: obj.GetKind(), namespace: obj.GetNamespace(), name: obj.GetName()}
+		if _, ok := objs[key]; ok {
+			return fmt.Errorf("%s %s is defined more than once in %q", obj.GetKind(), describeObject(obj), input)
+		}
+		objs[key] = obj
+		return nil
+	}
+	for filename, resources := range files {
+		for _, r := range resources {
+			if !r.obj.IsList() {
+				if err := add(filename, r.obj); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			list, err := r.obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("decoding list in %q: %v", filename, err)
+			}
+			for i := range list.Items {
+				if err := add(filename, &list.Items[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return objs, nil
+}
+
+// printObjectDiff writes the objects added, removed and changed from a to b to
+// w, sorted by key, and returns the number of objects that differ.
+func printObjectDiff(w io.Writer, a, b map[objectKey]*unstructured.Unstructured) int {
+	keys := make([]objectKey, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.namespace != kj.namespace {
+			return ki.namespace < kj.namespace
+		}
+		if ki.group != kj.group {
+			return ki.group < kj.group
+		}
+		if ki.kind != kj.kind {
+			return ki.kind < kj.kind
+		}
+		return ki.name < kj.name
+	})
+
+	format := func(v interface{}, missing bool) string {
+		if missing {
+			return "<unset>"
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+	changes := 0
+	for _, key := range keys {
+		desc := key.kind
+		if key.group != "" {
+			desc += "." + key.group
+		}
+		desc += " " + key.name
+		if key.namespace != "" {
+			desc += " in namespace " + key.namespace
+		}
+		aobj, aok := a[key]
+		bobj, bok := b[key]
+		switch {
+		case !aok:
+			fmt.Fprintf(w, "+ %s\n", desc)
+		case !bok:
+			fmt.Fprintf(w, "- %s\n", desc)
+		default:
+			diffs := diffObjects(aobj.Object, bobj.Object)
+			if len(diffs) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "~ %s\n", desc)
+			for _, d := range diffs {
+				fmt.Fprintf(w, "    %s: %s -> %s\n", d.path, format(d.a, d.aMissing), format(d.b, d.bMissing))
+			}
+		}
+		changes++
+	}
+	return changes
+}
@@ -1,22 +1,43 @@
 package discovery
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	kdiscov "k8s.io/client-go/discovery"
-	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/restmapper"
 )
 
 // APIServerResourceInspector implements ResourceInspector using the Kubernetes
 // discovery API.
 // It relies on a Kubernetes apiserver that has discovery information for all
 // inputted resource types.
+//
+// Discovery information is fetched once, using the aggregated discovery API
+// if the apiserver supports it. API groups that cannot be discovered, e.g.
+// because the APIService backing them is unavailable, are reported through
+// Warnf rather than failing discovery of every other group, and only cause
+// errors for resources within those groups.
 type APIServerResourceInspector struct {
-	mapper *restmapper.DeferredDiscoveryRESTMapper
+	discovery *kdiscov.DiscoveryClient
+
+	// Warnf, if set, is called with a warning for each API group that could
+	// not be discovered.
+	Warnf func(format string, args ...interface{})
+
+	once sync.Once
+	// static holds the resources discovered, and failed the error for each
+	// API group that could not be discovered.
+	static  *StaticResourceInspector
+	failed  map[string]error
+	loadErr error
 }
 
 func NewAPIServerResourceInspector(cfg *rest.Config) (*APIServerResourceInspector, error) {
@@ -24,21 +45,304 @@ func NewAPIServerResourceInspector(cfg *rest.Config) (*APIServerResourceInspecto
 	if err != nil {
 		return nil, err
 	}
+	return &APIServerResourceInspector{discovery: cl}, nil
+}
+
+const (
+	// discoveryAttempts is the number of times discovery is attempted
+	// before failing, waiting discoveryBackoff between the first attempts
+	// and doubling each time.
+	discoveryAttempts = 5
+	discoveryBackoff  = 500 * time.Millisecond
+)
+
+// load fetches discovery information from the apiserver the first time it is
+// called, retrying with backoff if the apiserver cannot be reached.
+func (a *APIServerResourceInspector) load() error {
+	a.once.Do(func() {
+		var resources []ScopeResource
+		var err error
+		backoff := discoveryBackoff
+		for attempt := 1; ; attempt++ {
+			if resources, a.failed, err = a.discover(); err == nil || attempt == discoveryAttempts {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err != nil {
+			a.loadErr = fmt.Errorf("could not list API resources after %d attempts: %w", discoveryAttempts, err)
+			return
+		}
+		groups := make([]string, 0, len(a.failed))
+		for group := range a.failed {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			if a.Warnf != nil {
+				a.Warnf("discovery failed for API group %q, so its resources cannot be split: %v", group, a.failed[group])
+			}
+		}
+		// a kind may be served by more than one resource in the same
+		// version
+		seen := make(map[schema.GroupVersionKind]bool)
+		unique := resources[:0]
+		for _, r := range resources {
+			if !seen[r.groupVersionKind()] {
+				seen[r.groupVersionKind()] = true
+				unique = append(unique, r)
+			}
+		}
+		a.static, a.loadErr = NewStaticResourceInspector(unique)
+	})
+	return a.loadErr
+}
 
-	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(cl))
+// discover returns all resource types served by the apiserver, in order of
+// group and preferred version, along with the error for each API group that
+// could not be discovered.
+func (a *APIServerResourceInspector) discover() ([]ScopeResource, map[string]error, error) {
+	resources, failed, ok, err := a.discoverAggregated()
+	if ok || err != nil {
+		return resources, failed, err
+	}
 
-	return &APIServerResourceInspector{
-		mapper: mapper,
-	}, nil
+	_, lists, err := a.discovery.ServerGroupsAndResources()
+	failed = make(map[string]error)
+	if groupErr, isGroupErr := err.(*kdiscov.ErrGroupDiscoveryFailed); isGroupErr {
+		for gv, gvErr := range groupErr.Groups {
+			failed[gv.Group] = gvErr
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") {
+				// subresources cannot be referred to directly
+				continue
+			}
+			resources = append(resources, ScopeResource{
+				Group:        gv.Group,
+				Version:      gv.Version,
+				Kind:         r.Kind,
+				Namespaced:   r.Namespaced,
+				Name:         r.Name,
+				SingularName: r.SingularName,
+				ShortNames:   r.ShortNames,
+				Categories:   r.Categories,
+			})
+		}
+	}
+	return resources, failed, nil
+}
+
+// aggregatedDiscoveryAccept requests the aggregated discovery document, which
+// lists the resources of every group and version in a single response,
+// falling back to the legacy APIGroupList if it is not supported.
+const aggregatedDiscoveryAccept = "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList," +
+	"application/json;g=apidiscovery.k8s.io;v=v2beta1;as=APIGroupDiscoveryList," +
+	"application/json"
+
+// apiGroupDiscoveryList is the subset of the aggregated discovery document
+// (apidiscovery.k8s.io APIGroupDiscoveryList) that is used.
+type apiGroupDiscoveryList struct {
+	Kind  string `json:"kind"`
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		// Versions are in order of preference.
+		Versions []struct {
+			Version   string `json:"version"`
+			Freshness string `json:"freshness"`
+			Resources []struct {
+				Resource     string `json:"resource"`
+				ResponseKind struct {
+					Kind string `json:"kind"`
+				} `json:"responseKind"`
+				Scope            string   `json:"scope"`
+				SingularResource string   `json:"singularResource"`
+				ShortNames       []string `json:"shortNames"`
+				Categories       []string `json:"categories"`
+			} `json:"resources"`
+		} `json:"versions"`
+	} `json:"items"`
+}
+
+// discoverAggregated fetches discovery information using the aggregated
+// discovery API. ok is false if the apiserver does not support it. Versions
+// marked stale, because their APIService is unavailable, are reported as
+// failed.
+func (a *APIServerResourceInspector) discoverAggregated() (resources []ScopeResource, failed map[string]error, ok bool, err error) {
+	failed = make(map[string]error)
+	for _, path := range []string{"/api", "/apis"} {
+		data, err := a.discovery.RESTClient().Get().AbsPath(path).SetHeader("Accept", aggregatedDiscoveryAccept).DoRaw(context.Background())
+		if err != nil {
+			return nil, nil, false, err
+		}
+		var list apiGroupDiscoveryList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, nil, false, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		if list.Kind != "APIGroupDiscoveryList" {
+			return nil, nil, false, nil
+		}
+		for _, group := range list.Items {
+			for _, version := range group.Versions {
+				if version.Freshness == "Stale" {
+					failed[group.Metadata.Name] = fmt.Errorf("discovery information for %s is stale", schema.GroupVersion{Group: group.Metadata.Name, Version: version.Version})
+					continue
+				}
+				for _, r := range version.Resources {
+					resources = append(resources, ScopeResource{
+						Group:        group.Metadata.Name,
+						Version:      version.Version,
+						Kind:         r.ResponseKind.Kind,
+						Namespaced:   r.Scope == "Namespaced",
+						Name:         r.Resource,
+						SingularName: r.SingularResource,
+						ShortNames:   r.ShortNames,
+						Categories:   r.Categories,
+					})
+				}
+			}
+		}
+	}
+	return resources, failed, true, nil
+}
+
+// groupError returns an error for a lookup of a resource type that was not
+// discovered, noting if discovery failed for its group.
+func (a *APIServerResourceInspector) groupError(group, resource string) error {
+	if err, ok := a.failed[group]; ok {
+		return fmt.Errorf("could not find REST mapping for resource %s, as discovery failed for its API group: %w", resource, err)
+	}
+	return fmt.Errorf("could not find REST mapping for resource %s: no matches for kind in the apiserver", resource)
 }
 
 func (a *APIServerResourceInspector) IsNamespaced(gvk schema.GroupVersionKind) (bool, error) {
-	mapping, err := a.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		return false, fmt.Errorf("could not find REST mapping for resource %v: %w", gvk.String(), err)
+	if err := a.load(); err != nil {
+		return false, err
+	}
+	if _, ok := a.static.scopes[gvk]; !ok {
+		return false, a.groupError(gvk.Group, gvk.String())
 	}
+	return a.static.IsNamespaced(gvk)
+}
 
-	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+func (a *APIServerResourceInspector) ServedVersions(gk schema.GroupKind) ([]string, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	versions, err := a.static.ServedVersions(gk)
+	if len(versions) == 0 {
+		if _, ok := a.failed[gk.Group]; ok {
+			// the kind may be served, so a lack of versions cannot be
+			// reported as skew
+			return nil, a.groupError(gk.Group, gk.String())
+		}
+	}
+	return versions, err
+}
+
+func (a *APIServerResourceInspector) ResolveKinds(name string) ([]schema.GroupKind, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a.static.ResolveKinds(name)
+}
+
+// Scopes returns every resource type served by the apiserver, with its scope
+// and names, in the format of a scope file. Versions of each GroupKind are
+// listed in the apiserver's order of preference. If discovery fails for some
+// API groups, e.g. because an aggregated API is unavailable, the resources
+// of all other groups are returned along with an error.
+func (a *APIServerResourceInspector) Scopes() ([]ScopeResource, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	// sorting is stable so that versions remain in order of preference
+	resources := append([]ScopeResource(nil), a.static.resources...)
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Kind < b.Kind
+	})
+	if len(a.failed) > 0 {
+		groups := make([]string, 0, len(a.failed))
+		for group := range a.failed {
+			groups = append(groups, fmt.Sprintf("%q: %v", group, a.failed[group]))
+		}
+		sort.Strings(groups)
+		return resources, fmt.Errorf("discovery failed for API groups %s", strings.Join(groups, ", "))
+	}
+	return resources, nil
+}
+
+// resolveKinds returns the GroupKinds of the given resources matched by name,
+// using the same rules as kubectl: name may be a kind, the plural or singular
+// resource name or a short name, optionally followed by '.<group>', or else a
+// category such as 'all'. Matching is case insensitive.
+// An AmbiguousKindError is returned if a name that is not qualified by group
+// matches kinds in more than one group.
+func resolveKinds(name string, resources []metav1.APIResource) ([]schema.GroupKind, error) {
+	name = strings.ToLower(name)
+	resource, group, qualified := name, "", false
+	if i := strings.Index(name, "."); i >= 0 {
+		resource, group, qualified = name[:i], name[i+1:], true
+	}
+
+	seen := make(map[schema.GroupKind]bool)
+	var kinds []schema.GroupKind
+	add := func(r metav1.APIResource) {
+		gk := schema.GroupKind{Group: r.Group, Kind: r.Kind}
+		if !seen[gk] {
+			seen[gk] = true
+			kinds = append(kinds, gk)
+		}
+	}
+
+	for _, r := range resources {
+		if qualified && r.Group != group {
+			continue
+		}
+		if resource == strings.ToLower(r.Kind) || resource == r.Name || resource == r.SingularName {
+			add(r)
+			continue
+		}
+		for _, short := range r.ShortNames {
+			if resource == short {
+				add(r)
+				break
+			}
+		}
+	}
+	if len(kinds) > 1 && !qualified {
+		return nil, &AmbiguousKindError{Name: name, Kinds: kinds}
+	}
+	if len(kinds) > 0 || qualified {
+		return kinds, nil
+	}
+
+	for _, r := range resources {
+		for _, category := range r.Categories {
+			if name == category {
+				add(r)
+				break
+			}
+		}
+	}
+	return kinds, nil
 }
 
 var _ ResourceInspector = &APIServerResourceInspector{}
+var _ VersionInspector = &APIServerResourceInspector{}
+var _ KindResolver = &APIServerResourceInspector{}
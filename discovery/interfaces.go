@@ -1,6 +1,9 @@
 package discovery
 
 import (
+	"fmt"
+	"strings"
+
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -9,3 +12,42 @@ type ResourceInspector interface {
 	// namespace-scoped object.
 	IsNamespaced(schema.GroupVersionKind) (bool, error)
 }
+
+// VersionInspector is optionally implemented by a ResourceInspector that can
+// report which versions of a resource type are served by the target cluster.
+type VersionInspector interface {
+	// ServedVersions returns the versions of the given GroupKind served by
+	// the target cluster, most preferred first. It returns an empty list if
+	// the GroupKind is not served at all.
+	ServedVersions(schema.GroupKind) ([]string, error)
+}
+
+// KindResolver is optionally implemented by a ResourceInspector that can
+// resolve the names used to refer to resource types on the command line.
+type KindResolver interface {
+	// ResolveKinds returns the GroupKinds matched by the given name, which
+	// may be a kind, a plural, singular or short resource name, optionally
+	// qualified by group (e.g. 'deployments.apps'), or a category (e.g.
+	// 'all'), as accepted by kubectl.
+	ResolveKinds(name string) ([]schema.GroupKind, error)
+}
+
+// AmbiguousKindError is returned by ResolveKinds when a name that is not
+// qualified by group matches kinds in more than one group, e.g. 'certificate'
+// when more than one API group defines a Certificate kind.
+type AmbiguousKindError struct {
+	Name  string
+	Kinds []schema.GroupKind
+}
+
+func (e *AmbiguousKindError) Error() string {
+	names := make([]string, len(e.Kinds))
+	example := ""
+	for i, gk := range e.Kinds {
+		names[i] = gk.String()
+		if example == "" && gk.Group != "" {
+			example = e.Name + "." + gk.Group
+		}
+	}
+	return fmt.Sprintf("%q is ambiguous as it matches kinds in more than one group (%s), and must be qualified with a group, e.g. %q", e.Name, strings.Join(names, ", "), example)
+}
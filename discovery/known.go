@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KnownResources lists the resource types of widely used projects, whose
+// scope is fixed, so that their resources can be split even if the apiserver
+// does not serve them or the scope file does not list them, e.g. when the
+// project's CRDs are installed by the same manifests that use them.
+var KnownResources = []ScopeResource{
+	{Group: "cert-manager.io", Version: "v1", Kind: "Certificate", Namespaced: true, Name: "certificates", SingularName: "certificate", ShortNames: []string{"cert", "certs"}, Categories: []string{"cert-manager"}},
+	{Group: "cert-manager.io", Version: "v1", Kind: "CertificateRequest", Namespaced: true, Name: "certificaterequests", SingularName: "certificaterequest", ShortNames: []string{"cr", "crs"}, Categories: []string{"cert-manager"}},
+	{Group: "cert-manager.io", Version: "v1", Kind: "Issuer", Namespaced: true, Name: "issuers", SingularName: "issuer", Categories: []string{"cert-manager"}},
+	{Group: "cert-manager.io", Version: "v1", Kind: "ClusterIssuer", Namespaced: false, Name: "clusterissuers", SingularName: "clusterissuer", Categories: []string{"cert-manager"}},
+	{Group: "acme.cert-manager.io", Version: "v1", Kind: "Order", Namespaced: true, Name: "orders", SingularName: "order", Categories: []string{"cert-manager", "cert-manager-acme"}},
+	{Group: "acme.cert-manager.io", Version: "v1", Kind: "Challenge", Namespaced: true, Name: "challenges", SingularName: "challenge", Categories: []string{"cert-manager", "cert-manager-acme"}},
+}
+
+// KnownScope returns whether resources of the given GroupKind are namespaced,
+// if it is one of the KnownResources. The scope of a kind does not change
+// between versions, so the version is not compared.
+func KnownScope(gk schema.GroupKind) (namespaced bool, ok bool) {
+	for _, r := range KnownResources {
+		if r.Group == gk.Group && r.Kind == gk.Kind {
+			return r.Namespaced, true
+		}
+	}
+	return false, false
+}
+
+// builtinGroups lists the API groups built in to Kubernetes, in the order of
+// the priority that the apiserver gives them in discovery, and so that
+// kubectl gives them when a resource type matches kinds in more than one
+// group, e.g. 'events' matches both Event and Event.events.k8s.io.
+var builtinGroups = []string{
+	"",
+	"extensions",
+	"apps",
+	"events.k8s.io",
+	"authentication.k8s.io",
+	"authorization.k8s.io",
+	"autoscaling",
+	"batch",
+	"certificates.k8s.io",
+	"networking.k8s.io",
+	"policy",
+	"rbac.authorization.k8s.io",
+	"storage.k8s.io",
+	"apiextensions.k8s.io",
+	"admissionregistration.k8s.io",
+	"scheduling.k8s.io",
+	"coordination.k8s.io",
+	"node.k8s.io",
+	"discovery.k8s.io",
+	"flowcontrol.apiserver.k8s.io",
+}
+
+// PreferredBuiltinKind returns the kind in the built in API group with the
+// highest priority that any of the given kinds belong to. It returns false if
+// none of them belong to a built in group, in which case the kinds are
+// genuinely ambiguous.
+func PreferredBuiltinKind(kinds []schema.GroupKind) (schema.GroupKind, bool) {
+	for _, group := range builtinGroups {
+		for _, gk := range kinds {
+			if gk.Group == group {
+				return gk, true
+			}
+		}
+	}
+	return schema.GroupKind{}, false
+}
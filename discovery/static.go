@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// ScopeFile is the format of a scope file, which lists resource types and
+// whether each is namespaced, for use without access to an apiserver.
+type ScopeFile struct {
+	Resources []ScopeResource `json:"resources"`
+}
+
+// ScopeResource describes the scope of a single GroupVersionKind, and
+// optionally the names it can be referred to by on the command line.
+type ScopeResource struct {
+	Group      string `json:"group,omitempty"`
+	Version    string `json:"version"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+
+	// Name is the plural resource name, e.g. 'deployments'.
+	Name         string   `json:"name,omitempty"`
+	SingularName string   `json:"singularName,omitempty"`
+	ShortNames   []string `json:"shortNames,omitempty"`
+	Categories   []string `json:"categories,omitempty"`
+}
+
+func (r ScopeResource) groupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// StaticResourceInspector implements ResourceInspector using a fixed list of
+// resource types, e.g. in tests or when no apiserver is available.
+type StaticResourceInspector struct {
+	resources []ScopeResource
+	scopes    map[schema.GroupVersionKind]bool
+}
+
+// NewStaticResourceInspector returns a StaticResourceInspector for the given
+// resource types. Versions of a GroupKind are preferred in the order given.
+func NewStaticResourceInspector(resources []ScopeResource) (*StaticResourceInspector, error) {
+	s := &StaticResourceInspector{
+		resources: resources,
+		scopes:    make(map[schema.GroupVersionKind]bool, len(resources)),
+	}
+	for i, r := range resources {
+		if r.Version == "" || r.Kind == "" {
+			return nil, fmt.Errorf("resources[%d]: version and kind must be set", i)
+		}
+		gvk := r.groupVersionKind()
+		if _, ok := s.scopes[gvk]; ok {
+			return nil, fmt.Errorf("resources[%d]: %v is listed more than once", i, gvk)
+		}
+		s.scopes[gvk] = r.Namespaced
+	}
+	return s, nil
+}
+
+// NewStaticResourceInspectorFromScopes returns a StaticResourceInspector for
+// the given map of GroupVersionKind to whether it is namespaced.
+func NewStaticResourceInspectorFromScopes(scopes map[schema.GroupVersionKind]bool) *StaticResourceInspector {
+	resources := make([]ScopeResource, 0, len(scopes))
+	for gvk, namespaced := range scopes {
+		resources = append(resources, ScopeResource{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Namespaced: namespaced})
+	}
+	// map iteration order is random, so versions are preferred in a stable
+	// (if arbitrary) order
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Version < b.Version
+	})
+	s, _ := NewStaticResourceInspector(resources)
+	return s
+}
+
+// LoadScopeFile reads a YAML scope file and returns a StaticResourceInspector
+// for the resource types it lists.
+func LoadScopeFile(path string) (*StaticResourceInspector, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f ScopeFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode scope file %q: %v", path, err)
+	}
+	s, err := NewStaticResourceInspector(f.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope file %q: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *StaticResourceInspector) IsNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	namespaced, ok := s.scopes[gvk]
+	if !ok {
+		return false, fmt.Errorf("resource %v is not listed in the scope file", gvk.String())
+	}
+	return namespaced, nil
+}
+
+func (s *StaticResourceInspector) ServedVersions(gk schema.GroupKind) ([]string, error) {
+	var versions []string
+	for _, r := range s.resources {
+		if r.Group == gk.Group && r.Kind == gk.Kind {
+			versions = append(versions, r.Version)
+		}
+	}
+	return versions, nil
+}
+
+func (s *StaticResourceInspector) ResolveKinds(name string) ([]schema.GroupKind, error) {
+	resources := make([]metav1.APIResource, len(s.resources))
+	for i, r := range s.resources {
+		resources[i] = metav1.APIResource{
+			Name:         r.Name,
+			SingularName: r.SingularName,
+			Namespaced:   r.Namespaced,
+			Group:        r.Group,
+			Version:      r.Version,
+			Kind:         r.Kind,
+			ShortNames:   r.ShortNames,
+			Categories:   r.Categories,
+		}
+	}
+	return resolveKinds(name, resources)
+}
+
+var _ ResourceInspector = &StaticResourceInspector{}
+var _ VersionInspector = &StaticResourceInspector{}
+var _ KindResolver = &StaticResourceInspector{}
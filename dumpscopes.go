@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+// runDumpScopes implements the 'dump-scopes' subcommand, which writes the
+// scope of every resource type served by the --kubeconfig cluster to a scope
+// file, so that later runs can use --scope-file without cluster access. The
+// file is written to stdout if no path is given.
+func runDumpScopes(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: manifest-splitter dump-scopes [scope file]")
+	}
+	restcfg, err := restConfig()
+	if err != nil {
+		return err
+	}
+	inspector, err := discovery.NewAPIServerResourceInspector(restcfg)
+	if err != nil {
+		return fmt.Errorf("failed to construct APIServer backed resource inspector: %v", err)
+	}
+	resources, err := inspector.Scopes()
+	if resources == nil && err != nil {
+		return err
+	}
+	if err != nil {
+		log.Printf("Warning: the scope file will be incomplete: %v", err)
+	}
+
+	data, err := yaml.Marshal(discovery.ScopeFile{Resources: resources})
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(args[0], data, 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote the scopes of %d resource types to %q", len(resources), args[0])
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const eventsOutputJSON = "json"
+
+// Types of event emitted when --events-output is set.
+const (
+	eventDecoded    = "decoded"
+	eventClassified = "classified"
+	eventWritten    = "written"
+	eventSkipped    = "skipped"
+	eventWarning    = "warning"
+)
+
+// event describes a single action performed during a run. Events are written
+// as JSON, one per line, so that other tools can follow the progress of a run.
+type event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Input      string    `json:"input,omitempty"`
+	APIVersion string    `json:"apiVersion,omitempty"`
+	Kind       string    `json:"kind,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Namespaced *bool     `json:"namespaced,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// events is the destination for events, or nil if --events-output is not set.
+var events *json.Encoder
+
+// setEventsOutput configures events to be written to w.
+func setEventsOutput(w io.Writer) {
+	events = json.NewEncoder(w)
+}
+
+// eventCounts counts the events emitted of each type, whether or not an
+// events output is configured.
+var eventCounts = make(map[string]int)
+
+// eventsMu guards eventCounts and events, as events are emitted by the
+// goroutines writing output files, as well as warnings emitted by them.
+var eventsMu sync.Mutex
+
+// emitEvent writes e to the events output, if one is configured. It is safe
+// for concurrent use.
+func emitEvent(e event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventCounts[e.Type]++
+	if events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if err := events.Encode(e); err != nil {
+		log.Printf("Failed to write event: %v", err)
+	}
+}
+
+// objectEvent returns an event of the given type describing obj.
+func objectEvent(typ, input string, obj *unstructured.Unstructured) event {
+	return event{
+		Type:       typ,
+		Input:      input,
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// explodeAnnotation may be set to "true" on a workload to explode it even if
+// it has a single container, or "false" to never explode it.
+const explodeAnnotation = "manifest-splitter.io/explode"
+
+// explodeWorkloads is set by --explode-workloads. When true, workloads with
+// more than one container are written as a kustomization in their own
+// directory, with a strategic merge patch file per container, so that changes
+// to a single container of a workload with many sidecars are easy to review.
+var explodeWorkloads bool
+
+// explodedDirs is the set of directories, relative to the directory that
+// resources are written to, each containing the kustomization of an exploded
+// workload.
+var explodedDirs = make(map[string]bool)
+
+// explodedContainerFields are the fields of a pod spec holding containers
+// that are moved into patch files. Ephemeral containers are left in place,
+// as they cannot be set when a workload is created.
+var explodedContainerFields = []string{"initContainers", "containers"}
+
+// explodeWorkloadResources replaces each workload in outputs with more than
+// one container by a copy with only the name of each container, a patch per
+// container holding the rest of its spec, and a kustomization applying the
+// patches to the copy, all in a directory named after the workload's file.
+func explodeWorkloadResources(outputs map[string][]resource) error {
+	for ns, resources := range outputs {
+		var out []resource
+		for _, r := range resources {
+			if !shouldExplode(r) {
+				out = append(out, r)
+				continue
+			}
+			exploded, err := explodeWorkload(r, ns)
+			if err != nil {
+				return fmt.Errorf("exploding %s %s: %v", r.obj.GetKind(), describeObject(r.obj), err)
+			}
+			out = append(out, exploded...)
+		}
+		outputs[ns] = out
+	}
+	return nil
+}
+
+// shouldExplode returns true if the given resource is a workload that is
+// exploded, according to its number of containers and explodeAnnotation.
+func shouldExplode(r resource) bool {
+	if r.obj.IsList() || r.link != "" {
+		return false
+	}
+	spec, ok := podSpec(r.obj)
+	if !ok {
+		return false
+	}
+	containers := 0
+	for _, field := range explodedContainerFields {
+		list, _, _ := unstructured.NestedSlice(spec, field)
+		for _, c := range list {
+			if container, ok := c.(map[string]interface{}); !ok || container["name"] == nil {
+				// containers are patched by name, so one without a name
+				// cannot be moved into a patch
+				return false
+			}
+			containers++
+		}
+	}
+	switch r.obj.GetAnnotations()[explodeAnnotation] {
+	case "true":
+		return containers > 0
+	case "false":
+		return false
+	}
+	return containers > 1
+}
+
+// podSpecPath returns the path of the pod spec within the given Pod or
+// workload resource.
+func podSpecPath(obj *unstructured.Unstructured) []string {
+	if isPod(obj) {
+		return []string{"spec"}
+	}
+	path := podTemplatePaths[obj.GroupVersionKind().GroupKind()]
+	return append(append([]string(nil), path...), "spec")
+}
+
+// explodeWorkload returns the resources that the given workload is exploded
+// into.
+func explodeWorkload(r resource, ns string) ([]resource, error) {
+	dir := filepath.Join(resourceDir(r, ns), strings.TrimSuffix(resourceFilename(r), "."+string(r.format)))
+	specPath := podSpecPath(r.obj)
+	base := r.obj.DeepCopy()
+	// the directory requested by the path annotation is that of the
+	// kustomization, and it would otherwise override the directory of the
+	// workload itself
+	if annotations := base.GetAnnotations(); annotations[pathAnnotation] != "" {
+		delete(annotations, pathAnnotation)
+		base.SetAnnotations(annotations)
+	}
+	baseFilename := strings.TrimSuffix(resourceFilename(r), "."+string(r.format)) + ".yaml"
+
+	var patches []resource
+	var patchFiles []interface{}
+	for _, field := range explodedContainerFields {
+		fieldPath := append(append([]string(nil), specPath...), field)
+		list, ok, err := unstructured.NestedSlice(base.Object, fieldPath...)
+		if err != nil || !ok {
+			continue
+		}
+		names := make([]interface{}, len(list))
+		for i, c := range list {
+			container := c.(map[string]interface{})
+			names[i] = map[string]interface{}{"name": container["name"]}
+
+			patch := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			patch.SetAPIVersion(r.obj.GetAPIVersion())
+			patch.SetKind(r.obj.GetKind())
+			patch.SetName(r.obj.GetName())
+			if r.obj.GetNamespace() != "" {
+				patch.SetNamespace(r.obj.GetNamespace())
+			}
+			if err := unstructured.SetNestedSlice(patch.Object, []interface{}{container}, fieldPath...); err != nil {
+				return nil, err
+			}
+			filename := fmt.Sprintf("%s-%s.yaml", strings.ToLower(strings.TrimSuffix(field, "s")), container["name"])
+			p, err := newGeneratedResource(patch, r.namespaced, filename)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, p)
+			patchFiles = append(patchFiles, map[string]interface{}{"path": filename})
+		}
+		if err := unstructured.SetNestedSlice(base.Object, names, fieldPath...); err != nil {
+			return nil, err
+		}
+	}
+
+	k := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  []interface{}{baseFilename},
+		"patches":    patchFiles,
+	}}
+	exploded := []resource{{}, {}}
+	var err error
+	if exploded[0], err = newGeneratedResource(k, r.namespaced, kustomizationFilename); err != nil {
+		return nil, err
+	}
+	if exploded[1], err = newGeneratedResource(base, r.namespaced, baseFilename); err != nil {
+		return nil, err
+	}
+	exploded = append(exploded, patches...)
+	for i := range exploded {
+		exploded[i].inputFilename = r.inputFilename
+		exploded[i].idx = r.idx
+		exploded[i].dir = dir
+	}
+	log.Printf("Exploding %s %s into %d containers in %s", r.obj.GetKind(), describeObject(r.obj), len(patches), dir)
+	explodedDirs[dir] = true
+	return exploded, nil
+}
+
+// kustomizeResourcePath returns the path that the written file at path is
+// included in a kustomization by: the directory of the exploded workload it
+// is part of, if any, or otherwise path itself.
+func kustomizeResourcePath(path string) string {
+	if dir := filepath.Dir(path); explodedDirs[dir] {
+		return dir
+	}
+	return path
+}
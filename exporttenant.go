@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const rbacGroup = "rbac.authorization.k8s.io"
+
+// runExportTenant implements the 'export-tenant' subcommand, which writes a
+// gzipped tarball of the files of a config directory that belong to the
+// namespaces owned by a team, according to --owners-map and --owners-label,
+// along with the cluster scoped resources that they depend on. The tarball is
+// written to stdout if no path is given.
+func runExportTenant(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: manifest-splitter export-tenant <team> <config directory> [tarball]")
+	}
+	team, root := args[0], args[1]
+	if ownersMapFile == "" && ownersLabel == "" {
+		return fmt.Errorf("--owners-map or --owners-label must be set to determine the namespaces owned by %q", team)
+	}
+	m := &ownersMap{}
+	if ownersMapFile != "" {
+		var err error
+		if m, err = loadOwnersMap(ownersMapFile); err != nil {
+			return err
+		}
+	}
+
+	paths, err := layoutFiles(root)
+	if err != nil {
+		return err
+	}
+	files := make(map[string][]*unstructured.Unstructured)
+	var links []string
+	for _, path := range paths {
+		info, err := os.Lstat(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, path)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		resources, err := decodeResourceManifest(path, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode %q: %v", path, err)
+		}
+		for _, r := range resources {
+			files[path] = append(files[path], r.obj)
+		}
+	}
+
+	owned := tenantNamespaces(files, m, team)
+	if len(owned) == 0 {
+		return fmt.Errorf("no namespaces in %q are owned by %q", root, team)
+	}
+	selected := tenantObjects(files, linkedNamespaces(files, links), owned)
+
+	var export []string
+	for _, path := range paths {
+		objs := files[path]
+		n := 0
+		for _, obj := range objs {
+			if selected[obj] {
+				n++
+			}
+		}
+		switch {
+		case n == 0:
+		case n < len(objs):
+			warnf("%q is not exported, as it also contains resources that %q does not own", path, team)
+		default:
+			export = append(export, path)
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(args) == 3 {
+		f, err := os.Create(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeTarball(out, root, export); err != nil {
+		return err
+	}
+	log.Printf("Exported %d files of namespaces %v owned by %q", len(export), sortedNames(owned), team)
+	return nil
+}
+
+// tenantNamespaces returns the namespaces of the objects in files that are
+// owned by team: those whose --owners-label label is team, or whose owners
+// in the owners map include team.
+func tenantNamespaces(files map[string][]*unstructured.Unstructured, m *ownersMap, team string) map[string]bool {
+	labels := make(map[string]map[string]string)
+	for _, objs := range files {
+		for _, obj := range objs {
+			if obj.GetKind() == "Namespace" && obj.GetAPIVersion() == "v1" {
+				labels[obj.GetName()] = obj.GetLabels()
+			} else if ns := obj.GetNamespace(); ns != "" {
+				if _, ok := labels[ns]; !ok {
+					labels[ns] = nil
+				}
+			}
+		}
+	}
+	owned := make(map[string]bool)
+	for ns, l := range labels {
+		if ownersLabel != "" && l[ownersLabel] == team {
+			owned[ns] = true
+			continue
+		}
+		for _, owner := range m.ownersOf(ns, l) {
+			if owner == team {
+				owned[ns] = true
+			}
+		}
+	}
+	return owned
+}
+
+// linkedNamespaces returns the namespace of each of the given files that is
+// a symlink to a resource written once by --dedupe-dir, which does not set
+// metadata.namespace. The namespace is that of the nearest directory
+// containing the file whose other files hold the resources of exactly one
+// namespace.
+func linkedNamespaces(files map[string][]*unstructured.Unstructured, links []string) map[string]string {
+	// dirs maps each directory containing namespaced resources, and its
+	// parents, to their namespace, or to "" if they contain more than one
+	dirs := make(map[string]string)
+	for path, objs := range files {
+		for _, obj := range objs {
+			ns := obj.GetNamespace()
+			if obj.GetKind() == "Namespace" && obj.GetAPIVersion() == "v1" {
+				ns = obj.GetName()
+			}
+			if ns == "" {
+				continue
+			}
+			for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+				if existing, ok := dirs[dir]; ok && existing != ns {
+					ns = ""
+				}
+				dirs[dir] = ns
+			}
+		}
+	}
+
+	namespaces := make(map[string]string)
+	for _, path := range links {
+		for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+			if ns := dirs[dir]; ns != "" {
+				namespaces[path] = ns
+				break
+			}
+		}
+		if namespaces[path] == "" {
+			warnf("the namespace of %q, a symlink created by --dedupe-dir, could not be determined from its directory, so it is treated as cluster scoped", path)
+		}
+	}
+	return namespaces
+}
+
+// tenantObjects returns the objects in files that are within, or are, the
+// given namespaces, along with the cluster scoped objects that they depend
+// on: the ClusterRoles bound within them, ClusterRoleBindings binding their
+// ServiceAccounts, and the CRDs of their custom resources. Objects without a
+// namespace in the files given by linked are within the namespace it maps
+// the file to.
+func tenantObjects(files map[string][]*unstructured.Unstructured, linked map[string]string, namespaces map[string]bool) map[*unstructured.Unstructured]bool {
+	selected := make(map[*unstructured.Unstructured]bool)
+	clusterRoles := make(map[string]bool)
+	kinds := make(map[string]bool)
+	var cluster []*unstructured.Unstructured
+	for path, objs := range files {
+		for _, obj := range objs {
+			ns := obj.GetNamespace()
+			if obj.GetKind() == "Namespace" && obj.GetAPIVersion() == "v1" {
+				ns = obj.GetName()
+			}
+			if ns == "" {
+				ns = linked[path]
+			}
+			if ns == "" {
+				cluster = append(cluster, obj)
+				continue
+			}
+			if !namespaces[ns] {
+				continue
+			}
+			selected[obj] = true
+			gvk := obj.GroupVersionKind()
+			kinds[gvk.Group+"/"+gvk.Kind] = true
+			if gvk.Group == rbacGroup && gvk.Kind == "RoleBinding" {
+				if kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); kind == "ClusterRole" {
+					name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+					clusterRoles[name] = true
+				}
+			}
+		}
+	}
+
+	for _, obj := range cluster {
+		gvk := obj.GroupVersionKind()
+		if gvk.Group != rbacGroup || gvk.Kind != "ClusterRoleBinding" {
+			continue
+		}
+		bound := false
+		forEachMap(obj.Object, []string{"subjects"}, func(subject map[string]interface{}) {
+			ns, _ := subject["namespace"].(string)
+			bound = bound || (subject["kind"] == "ServiceAccount" && namespaces[ns])
+		})
+		if bound {
+			selected[obj] = true
+			name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+			clusterRoles[name] = true
+		}
+	}
+	for _, obj := range cluster {
+		gvk := obj.GroupVersionKind()
+		switch {
+		case gvk.Group == rbacGroup && gvk.Kind == "ClusterRole":
+			selected[obj] = selected[obj] || clusterRoles[obj.GetName()]
+		case gvk.Group == "apiextensions.k8s.io" && gvk.Kind == "CustomResourceDefinition":
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			selected[obj] = kinds[group+"/"+kind]
+		}
+	}
+	return selected
+}
+
+// writeTarball writes a gzipped tarball of the given files within root to w.
+func writeTarball(w io.Writer, root string, paths []string) error {
+	sort.Strings(paths)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, path := range paths {
+		// symlinks to identical resources may point outside of the
+		// exported files, so the contents of every file are written
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name: filepath.ToSlash(path),
+			Mode: 0644,
+			Size: int64(len(data)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
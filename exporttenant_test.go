@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestTenantObjectsWithDedupeDir(t *testing.T) {
+	defer func(w []string) { warnings = w }(warnings)
+	object := func(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+		return testResource(apiVersion, kind, namespace, name, namespace != "").obj
+	}
+	binding := object(rbacGroup+"/v1", "RoleBinding", "", "view")
+	binding.Object["roleRef"] = map[string]interface{}{"kind": "ClusterRole", "name": "view"}
+	files := map[string][]*unstructured.Unstructured{
+		"namespaces/team-a/Namespace-team-a.yaml": {object("v1", "Namespace", "", "team-a")},
+		"namespaces/team-a/RoleBinding-view.yaml": {binding},
+		"namespaces/team-b/ConfigMap-config.yaml": {object("v1", "ConfigMap", "team-b", "config")},
+		"namespaces/team-b/RoleBinding-view.yaml": {binding.DeepCopy()},
+		"namespaces/orphan/RoleBinding-view.yaml": {binding.DeepCopy()},
+		"shared/RoleBinding-view.yaml":            {binding.DeepCopy()},
+		"cluster/ClusterRole-view.yaml":           {object(rbacGroup+"/v1", "ClusterRole", "", "view")},
+		"cluster/ClusterRole-edit.yaml":           {object(rbacGroup+"/v1", "ClusterRole", "", "edit")},
+	}
+	links := []string{
+		"namespaces/orphan/RoleBinding-view.yaml",
+		"namespaces/team-a/RoleBinding-view.yaml",
+		"namespaces/team-b/RoleBinding-view.yaml",
+	}
+
+	warnings = nil
+	linked := linkedNamespaces(files, links)
+	wantLinked := map[string]string{
+		"namespaces/team-a/RoleBinding-view.yaml": "team-a",
+		"namespaces/team-b/RoleBinding-view.yaml": "team-b",
+	}
+	if !reflect.DeepEqual(linked, wantLinked) {
+		t.Errorf("got namespaces %v, want %v", linked, wantLinked)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("got warnings %q, want one for the link in a directory of no namespace", warnings)
+	}
+
+	selected := tenantObjects(files, linked, map[string]bool{"team-a": true})
+	var got []string
+	for path, objs := range files {
+		if selected[objs[0]] {
+			got = append(got, path)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"cluster/ClusterRole-view.yaml",
+		"namespaces/team-a/Namespace-team-a.yaml",
+		"namespaces/team-a/RoleBinding-view.yaml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
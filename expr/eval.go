@@ -0,0 +1,586 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// NoSuchKeyError is returned when an expression selects a field or map key
+// that is not set.
+type NoSuchKeyError struct {
+	Key string
+}
+
+func (e *NoSuchKeyError) Error() string {
+	return fmt.Sprintf("no such key: %s", e.Key)
+}
+
+// activation holds the values of variables during evaluation. Comprehensions
+// add an activation for their variable whose parent is the enclosing one.
+type activation struct {
+	name   string
+	value  interface{}
+	vars   map[string]interface{}
+	parent *activation
+}
+
+func (a *activation) lookup(name string) (interface{}, bool) {
+	for ; a != nil; a = a.parent {
+		if a.vars != nil {
+			if v, ok := a.vars[name]; ok {
+				return v, true
+			}
+		} else if a.name == name {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+type node interface {
+	eval(a *activation) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(*activation) (interface{}, error) { return n.value, nil }
+
+type identNode struct {
+	name string
+	pos  int
+}
+
+func (n *identNode) eval(a *activation) (interface{}, error) {
+	v, ok := a.lookup(n.name)
+	if !ok {
+		return nil, fmt.Errorf("column %d: no value for %q", n.pos, n.name)
+	}
+	return normalize(v), nil
+}
+
+type selectNode struct {
+	operand node
+	field   string
+	pos     int
+}
+
+func (n *selectNode) eval(a *activation) (interface{}, error) {
+	v, ok, err := n.lookup(a)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &NoSuchKeyError{Key: n.field}
+	}
+	return v, nil
+}
+
+// lookup returns the selected field, and whether it is set.
+func (n *selectNode) lookup(a *activation) (interface{}, bool, error) {
+	x, err := n.operand.eval(a)
+	if err != nil {
+		return nil, false, err
+	}
+	m, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("column %d: cannot select field %q of %s", n.pos, n.field, typeName(x))
+	}
+	v, ok := m[n.field]
+	return normalize(v), ok, nil
+}
+
+type hasNode struct{ sel *selectNode }
+
+func (n *hasNode) eval(a *activation) (interface{}, error) {
+	_, ok, err := n.sel.lookup(a)
+	return ok, err
+}
+
+type indexNode struct {
+	operand, index node
+	pos            int
+}
+
+func (n *indexNode) eval(a *activation) (interface{}, error) {
+	x, err := n.operand.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.index.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]interface{}:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("column %d: map keys must be strings, got %s", n.pos, typeName(i))
+		}
+		v, ok := x[k]
+		if !ok {
+			return nil, &NoSuchKeyError{Key: k}
+		}
+		return normalize(v), nil
+	case []interface{}:
+		idx, ok := i.(int64)
+		if !ok {
+			return nil, fmt.Errorf("column %d: list indexes must be integers, got %s", n.pos, typeName(i))
+		}
+		if idx < 0 || idx >= int64(len(x)) {
+			return nil, fmt.Errorf("column %d: index %d out of range for list of size %d", n.pos, idx, len(x))
+		}
+		return normalize(x[idx]), nil
+	}
+	return nil, fmt.Errorf("column %d: cannot index %s", n.pos, typeName(x))
+}
+
+type unaryNode struct {
+	op  string
+	x   node
+	pos int
+}
+
+func (n *unaryNode) eval(a *activation) (interface{}, error) {
+	x, err := n.x.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+	case int64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+	return nil, fmt.Errorf("column %d: operator %q cannot be applied to %s", n.pos, n.op, typeName(x))
+}
+
+// logicalNode implements '&&' and '||'. As in CEL, an error on one side is
+// ignored if the other side determines the result, so the order of the
+// operands does not matter.
+type logicalNode struct {
+	and  bool
+	l, r node
+}
+
+func (n *logicalNode) eval(a *activation) (interface{}, error) {
+	l, lerr := evalBool(n.l, a)
+	if lerr == nil && l != n.and {
+		return l, nil
+	}
+	r, rerr := evalBool(n.r, a)
+	if rerr == nil && r != n.and {
+		return r, nil
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	if rerr != nil {
+		return nil, rerr
+	}
+	return n.and, nil
+}
+
+func evalBool(n node, a *activation) (bool, error) {
+	v, err := n.eval(a)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a bool, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+type condNode struct{ cond, t, f node }
+
+func (n *condNode) eval(a *activation) (interface{}, error) {
+	c, err := evalBool(n.cond, a)
+	if err != nil {
+		return nil, err
+	}
+	if c {
+		return n.t.eval(a)
+	}
+	return n.f.eval(a)
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+	pos  int
+}
+
+func (n *binaryNode) eval(a *activation) (interface{}, error) {
+	l, err := n.l.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []interface{}:
+			for _, e := range r {
+				if equal(l, normalize(e)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			k, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = r[k]
+			return ok, nil
+		}
+	case "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+":
+		switch l := l.(type) {
+		case string:
+			if r, ok := r.(string); ok {
+				return l + r, nil
+			}
+		case []interface{}:
+			if r, ok := r.([]interface{}); ok {
+				return append(append([]interface{}(nil), l...), r...), nil
+			}
+		}
+		return arithmetic(n, l, r)
+	case "-", "*", "/", "%":
+		return arithmetic(n, l, r)
+	}
+	return nil, fmt.Errorf("column %d: operator %q cannot be applied to %s and %s", n.pos, n.op, typeName(l), typeName(r))
+}
+
+func arithmetic(n *binaryNode, l, r interface{}) (interface{}, error) {
+	if li, ok := l.(int64); ok {
+		if ri, ok := r.(int64); ok {
+			switch n.op {
+			case "+":
+				return li + ri, nil
+			case "-":
+				return li - ri, nil
+			case "*":
+				return li * ri, nil
+			}
+			if ri == 0 {
+				return nil, fmt.Errorf("column %d: division by zero", n.pos)
+			}
+			if n.op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if lok && rok {
+		switch n.op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		case "/":
+			return lf / rf, nil
+		}
+	}
+	return nil, fmt.Errorf("column %d: operator %q cannot be applied to %s and %s", n.pos, n.op, typeName(l), typeName(r))
+}
+
+type listNode struct{ elems []node }
+
+func (n *listNode) eval(a *activation) (interface{}, error) {
+	l := make([]interface{}, len(n.elems))
+	for i, e := range n.elems {
+		v, err := e.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = v
+	}
+	return l, nil
+}
+
+type mapNode struct{ keys, values []node }
+
+func (n *mapNode) eval(a *activation) (interface{}, error) {
+	m := make(map[string]interface{}, len(n.keys))
+	for i := range n.keys {
+		k, err := n.keys[i].eval(a)
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys must be strings, got %s", typeName(k))
+		}
+		if m[ks], err = n.values[i].eval(a); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// comprehensionNode implements the exists() and all() macros over the
+// elements of a list, or the keys of a map.
+type comprehensionNode struct {
+	target   node
+	variable string
+	pred     node
+	all      bool
+	pos      int
+}
+
+func (n *comprehensionNode) eval(a *activation) (interface{}, error) {
+	x, err := n.target.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	var elems []interface{}
+	switch x := x.(type) {
+	case []interface{}:
+		elems = x
+	case map[string]interface{}:
+		for k := range x {
+			elems = append(elems, k)
+		}
+	default:
+		return nil, fmt.Errorf("column %d: cannot iterate over %s", n.pos, typeName(x))
+	}
+	var firstErr error
+	for _, e := range elems {
+		b, err := evalBool(n.pred, &activation{name: n.variable, value: normalize(e), parent: a})
+		switch {
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
+			}
+		case b != n.all:
+			// exists() is true once any element matches, and all()
+			// is false once any element does not
+			return b, nil
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return n.all, nil
+}
+
+type callNode struct {
+	fn string
+	// target is the receiver of a method call, or nil for a function
+	target node
+	args   []node
+	pos    int
+}
+
+func (n *callNode) eval(a *activation) (interface{}, error) {
+	var args []interface{}
+	if n.target != nil {
+		t, err := n.target.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t)
+	}
+	for _, arg := range n.args {
+		v, err := arg.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	f := functions[n.fn]
+	if n.target != nil {
+		f = methods[n.fn]
+	}
+	v, err := f(args)
+	if err != nil {
+		return nil, fmt.Errorf("column %d: %s(): %v", n.pos, n.fn, err)
+	}
+	return v, nil
+}
+
+// functions are the global functions that may be called in expressions.
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"size": size,
+}
+
+// methods are the functions that may be called on a value; the value is
+// passed as the first argument.
+var methods = map[string]func(args []interface{}) (interface{}, error){
+	"size": size,
+	"startsWith": stringMethod(func(s, arg string) (interface{}, error) {
+		return strings.HasPrefix(s, arg), nil
+	}),
+	"endsWith": stringMethod(func(s, arg string) (interface{}, error) {
+		return strings.HasSuffix(s, arg), nil
+	}),
+	"contains": stringMethod(func(s, arg string) (interface{}, error) {
+		return strings.Contains(s, arg), nil
+	}),
+	"matches": stringMethod(func(s, arg string) (interface{}, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	}),
+	"lowerAscii": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected no arguments")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot be called on %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	},
+}
+
+func size(args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	switch x := args[0].(type) {
+	case string:
+		return int64(len([]rune(x))), nil
+	case []interface{}:
+		return int64(len(x)), nil
+	case map[string]interface{}:
+		return int64(len(x)), nil
+	}
+	return nil, fmt.Errorf("cannot be applied to %s", typeName(args[0]))
+}
+
+// stringMethod returns a method of a string that takes a single string
+// argument.
+func stringMethod(f func(s, arg string) (interface{}, error)) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 1 argument, got %d", len(args)-1)
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot be called on %s", typeName(args[0]))
+		}
+		arg, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string argument, got %s", typeName(args[1]))
+		}
+		return f(s, arg)
+	}
+}
+
+// normalize converts the numeric types found in decoded resources to the
+// int64 and float64 used by expressions.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return v
+}
+
+// equal compares values as CEL does, treating ints and floats with the same
+// numeric value as equal.
+func equal(l, r interface{}) bool {
+	if c, ok := compare(l, r); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+// compare orders two numbers or two strings, returning false if they cannot
+// be ordered.
+func compare(l, r interface{}) (int, bool) {
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(ls, rs), true
+	}
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,71 @@
+// Package expr implements the small expression language used to match
+// resources. Its syntax is modelled on the Common Expression Language (CEL),
+// but it is not an implementation of CEL.
+//
+// Supported are literals (strings, ints, doubles, bools, null, lists and
+// maps), field selection and indexing, the logical, relational, arithmetic
+// and 'in' operators, the ternary operator, has(), size(), the string methods
+// startsWith(), endsWith(), contains(), matches() and lowerAscii(), and the
+// exists() and all() macros. Values are dynamically typed.
+package expr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Program is a compiled expression.
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses the given expression. variables lists the names of the
+// variables that the expression may reference.
+func Compile(source string, variables ...string) (*Program, error) {
+	toks, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, scope: append([]string(nil), variables...)}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("expected end of expression")
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression with the given variable values.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.root.eval(&activation{vars: vars})
+}
+
+// Matches evaluates an expression that must return a bool. An expression that
+// selects a field or key that is not set does not match, rather than
+// returning an error, so that e.g. "object.metadata.labels['team'] == 'a'"
+// can be used with resources that have no labels. This also applies to
+// negated comparisons, so "object.metadata.labels['team'] != 'a'" does not
+// match resources without the label either.
+func (p *Program) Matches(vars map[string]interface{}) (bool, error) {
+	v, err := p.Eval(vars)
+	var noSuchKey *NoSuchKeyError
+	if errors.As(err, &noSuchKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression must evaluate to a bool, got %s", typeName(v))
+	}
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testObject is the value of the 'object' variable in tests, a Namespace with
+// a label and no annotations.
+func testObject() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]interface{}{
+			"name":   "team-a-prod",
+			"labels": map[string]interface{}{"team": "a"},
+		},
+		"spec": map[string]interface{}{
+			"finalizers": []interface{}{"kubernetes", "example.com/cleanup"},
+		},
+		"replicas": int64(3),
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    interface{}
+		wantErr bool
+	}{
+		// precedence
+		{name: "multiplication before addition", expr: "1 + 2 * 3", want: int64(7)},
+		{name: "parentheses", expr: "(1 + 2) * 3", want: int64(9)},
+		{name: "left associative subtraction", expr: "10 - 4 - 3", want: int64(3)},
+		{name: "left associative division", expr: "64 / 4 / 2", want: int64(8)},
+		{name: "remainder with multiplication", expr: "7 % 4 * 2", want: int64(6)},
+		{name: "unary minus", expr: "-2 * 3", want: int64(-6)},
+		{name: "arithmetic before relations", expr: "1 + 1 == 2", want: true},
+		{name: "relations before equality", expr: "1 < 2 == true", want: true},
+		{name: "and before or", expr: "true || false && false", want: true},
+		{name: "and before or, reversed", expr: "false && false || true", want: true},
+		{name: "not binds tightest", expr: "!false && false", want: false},
+		{name: "ternary is lowest", expr: "1 < 2 ? 'a' + 'b' : 'c'", want: "ab"},
+		{name: "nested ternary", expr: "false ? 1 : true ? 2 : 3", want: int64(2)},
+		{name: "in after arithmetic", expr: "1 + 1 in [2, 3]", want: true},
+		{name: "doubles", expr: "1.5 * 2.0", want: 3.0},
+		{name: "mixed int and double", expr: "1 + 1.5", wantErr: true},
+		{name: "division by zero", expr: "1 / 0", wantErr: true},
+
+		// selection
+		{name: "select", expr: "object.metadata.name", want: "team-a-prod"},
+		{name: "index", expr: "object.metadata.labels['team']", want: "a"},
+		{name: "list index", expr: "object.spec.finalizers[1]", want: "example.com/cleanup"},
+		{name: "missing field", expr: "object.metadata.annotations", wantErr: true},
+		{name: "select of scalar", expr: "object.metadata.name.first", wantErr: true},
+
+		// in
+		{name: "in list", expr: "'kubernetes' in object.spec.finalizers", want: true},
+		{name: "not in list", expr: "'other' in object.spec.finalizers", want: false},
+		{name: "in map", expr: "'team' in object.metadata.labels", want: true},
+		{name: "not in map", expr: "'env' in object.metadata.labels", want: false},
+		{name: "int in map", expr: "1 in object.metadata.labels", want: false},
+		{name: "in scalar", expr: "'a' in 'abc'", wantErr: true},
+
+		// has()
+		{name: "has set field", expr: "has(object.metadata.labels)", want: true},
+		{name: "has unset field", expr: "has(object.metadata.annotations)", want: false},
+		{name: "has of unset parent", expr: "has(object.status.phase)", wantErr: true},
+
+		// exists() and all()
+		{name: "exists", expr: "object.spec.finalizers.exists(f, f.startsWith('example.com/'))", want: true},
+		{name: "exists none", expr: "object.spec.finalizers.exists(f, f == 'other')", want: false},
+		{name: "exists over map keys", expr: "object.metadata.labels.exists(k, k == 'team')", want: true},
+		{name: "exists empty", expr: "[].exists(x, x == 1)", want: false},
+		{name: "all", expr: "object.spec.finalizers.all(f, size(f) > 3)", want: true},
+		{name: "all false", expr: "object.spec.finalizers.all(f, f.contains('.'))", want: false},
+		{name: "all empty", expr: "[].all(x, x == 1)", want: true},
+		{name: "exists absorbs errors once true", expr: "[1, 'a'].exists(x, x > 0)", want: true},
+		{name: "all absorbs errors once false", expr: "[1, 'a'].all(x, x > 1)", want: false},
+		{name: "exists returns error otherwise", expr: "[1, 'a'].exists(x, x > 1)", wantErr: true},
+		{name: "all returns error otherwise", expr: "[1, 'a'].all(x, x > 0)", wantErr: true},
+		{name: "comprehension variable scope", expr: "[[1, 2], [3]].exists(x, x.all(y, y > 2))", want: true},
+
+		// error absorption by && and ||
+		{name: "and absorbs error on left", expr: "object.missing == 1 && false", want: false},
+		{name: "and absorbs error on right", expr: "false && object.missing == 1", want: false},
+		{name: "and returns error", expr: "object.missing == 1 && true", wantErr: true},
+		{name: "and returns error on right", expr: "true && object.missing == 1", wantErr: true},
+		{name: "or absorbs error on left", expr: "object.missing == 1 || true", want: true},
+		{name: "or absorbs error on right", expr: "true || object.missing == 1", want: true},
+		{name: "or returns error", expr: "object.missing == 1 || false", wantErr: true},
+		{name: "and of non-bool", expr: "1 && false", want: false},
+		{name: "or of non-bool", expr: "1 || false", wantErr: true},
+		{name: "ternary does not absorb errors", expr: "object.missing ? true : false", wantErr: true},
+
+		// functions
+		{name: "size of string", expr: "size('abc')", want: int64(3)},
+		{name: "size method of list", expr: "object.spec.finalizers.size()", want: int64(2)},
+		{name: "matches", expr: "object.metadata.name.matches('^team-[a-z]+-prod$')", want: true},
+		{name: "invalid regexp", expr: "'a'.matches('(')", wantErr: true},
+		{name: "lowerAscii", expr: "'ABC'.lowerAscii() == 'abc'", want: true},
+		{name: "int fields", expr: "object.replicas >= 3", want: true},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.expr, "object")
+		if err != nil {
+			t.Errorf("%s: compiling %q: %v", test.name, test.expr, err)
+			continue
+		}
+		got, err := p.Eval(map[string]interface{}{"object": testObject()})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: evaluating %q returned error %v, want error: %t", test.name, test.expr, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %q = %#v, want %#v", test.name, test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1 +",
+		"(1",
+		"'unterminated",
+		"undeclared == 1",
+		"object.metadata.name ==",
+		"has(object)",
+		"unknown(1)",
+		"object.exists(1, true)",
+		"1 2",
+	}
+	for _, expr := range tests {
+		if _, err := Compile(expr, "object"); err == nil {
+			t.Errorf("compiling %q succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "true", expr: "object.metadata.labels['team'] == 'a'", want: true},
+		{name: "false", expr: "object.metadata.labels['team'] == 'b'", want: false},
+		{name: "unset key", expr: "object.metadata.labels['env'] == 'prod'", want: false},
+		{name: "unset field", expr: "object.metadata.annotations['a'] == 'b'", want: false},
+		// an unset key does not match, even when negated, so the key must
+		// be checked for explicitly to match objects without it
+		{name: "negated unset key", expr: "object.metadata.labels['env'] != 'prod'", want: false},
+		{name: "negated unset key checked", expr: "!('env' in object.metadata.labels) || object.metadata.labels['env'] != 'prod'", want: true},
+		{name: "not of unset key", expr: "!(object.metadata.labels['env'] == 'prod')", want: false},
+		{name: "unset key absorbed", expr: "object.metadata.labels['env'] == 'prod' || object.metadata.name.startsWith('team-')", want: true},
+		{name: "non-bool", expr: "object.metadata.name", wantErr: true},
+		{name: "other errors", expr: "object.metadata.name > 1", wantErr: true},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.expr, "object")
+		if err != nil {
+			t.Errorf("%s: compiling %q: %v", test.name, test.expr, err)
+			continue
+		}
+		got, err := p.Matches(map[string]interface{}{"object": testObject()})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: matching %q returned error %v, want error: %t", test.name, test.expr, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: %q matches = %t, want %t", test.name, test.expr, got, test.want)
+		}
+	}
+}
+
+func TestNoSuchKeyError(t *testing.T) {
+	p, err := Compile("object.metadata.labels['env']", "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Eval(map[string]interface{}{"object": testObject()})
+	var noSuchKey *NoSuchKeyError
+	if !errors.As(err, &noSuchKey) || noSuchKey.Key != "env" {
+		t.Errorf("got error %v, want a NoSuchKeyError for %q", err, "env")
+	}
+}
@@ -0,0 +1,500 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token kinds produced by the lexer
+const (
+	tokEOF = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind int
+	text string
+	// value is the decoded value of a literal token
+	value interface{}
+	// pos is the 1-based column of the token within the expression
+	pos int
+}
+
+// operators and punctuation, longest first so that e.g. '<=' is preferred to
+// '<'
+var punctuation = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]", "{", "}"}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], pos: start + 1})
+		case unicode.IsDigit(rune(c)):
+			start := i
+			kind := tokInt
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.' || src[i] == 'e' || src[i] == 'E') {
+				if src[i] == '.' {
+					if i+1 >= len(src) || !unicode.IsDigit(rune(src[i+1])) {
+						break
+					}
+					kind = tokFloat
+				}
+				if src[i] == 'e' || src[i] == 'E' {
+					kind = tokFloat
+					if i+1 < len(src) && (src[i+1] == '+' || src[i+1] == '-') {
+						i++
+					}
+				}
+				i++
+			}
+			text := src[start:i]
+			tok := token{kind: kind, text: text, pos: start + 1}
+			var err error
+			if kind == tokInt {
+				tok.value, err = strconv.ParseInt(text, 10, 64)
+			} else {
+				tok.value, err = strconv.ParseFloat(text, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid number %q", start+1, text)
+			}
+			toks = append(toks, tok)
+		case c == '\'' || c == '"':
+			start := i
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: %v", start+1, err)
+			}
+			i += n
+			toks = append(toks, token{kind: tokString, text: src[start:i], value: s, pos: start + 1})
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(src[i:], p) {
+					toks = append(toks, token{kind: tokPunct, text: p, pos: i + 1})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("column %d: unexpected character %q", i+1, c)
+			}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src) + 1}), nil
+}
+
+// lexString decodes the quoted string at the start of s, returning its value
+// and the number of bytes consumed.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '\'', '"':
+				b.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("unsupported escape sequence \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// parser is a recursive descent parser following CEL's operator precedence.
+type parser struct {
+	toks []token
+	i    int
+	// scope lists the variables that may be referenced, including the
+	// variables of enclosing comprehensions
+	scope []string
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// backup un-reads the given token, which was returned by next.
+func (p *parser) backup(t token) {
+	if t.kind != tokEOF {
+		p.i--
+	}
+}
+
+// accept consumes the next token if it is the given punctuation.
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == punct {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.accept(punct) {
+		return p.errorf("expected %q", punct)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := "end of expression"
+	if t.kind != tokEOF {
+		found = fmt.Sprintf("%q", t.text)
+	}
+	return fmt.Errorf("column %d: %s, found %s", t.pos, fmt.Sprintf(format, args...), found)
+}
+
+func (p *parser) parseExpr() (node, error) {
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return c, nil
+	}
+	t, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond: c, t: t, f: f}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{and: false, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{and: true, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseRelation() (node, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		switch {
+		case t.kind == tokPunct && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+			op = t.text
+		case t.kind == tokIdent && t.text == "in":
+			op = "in"
+		default:
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: op, l: l, r: r, pos: t.pos}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !(t.kind == tokPunct && (t.text == "+" || t.text == "-")) {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: t.text, l: l, r: r, pos: t.pos}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !(t.kind == tokPunct && (t.text == "*" || t.text == "/" || t.text == "%")) {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: t.text, l: l, r: r, pos: t.pos}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t.kind == tokPunct && (t.text == "!" || t.text == "-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, x: x, pos: t.pos}, nil
+	}
+	return p.parseMember()
+}
+
+func (p *parser) parseMember() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				p.backup(name)
+				return nil, p.errorf("expected a field or method name")
+			}
+			if !p.accept("(") {
+				x = &selectNode{operand: x, field: name.text, pos: name.pos}
+				continue
+			}
+			if x, err = p.parseMethod(x, name); err != nil {
+				return nil, err
+			}
+		case p.accept("["):
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{operand: x, index: index, pos: t.pos}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// parseMethod parses the arguments of a method call on target, once the
+// opening parenthesis has been consumed.
+func (p *parser) parseMethod(target node, name token) (node, error) {
+	switch name.text {
+	case "exists", "all":
+		v := p.next()
+		if v.kind != tokIdent {
+			p.backup(v)
+			return nil, p.errorf("expected a variable name as the first argument of %s()", name.text)
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		p.scope = append(p.scope, v.text)
+		pred, err := p.parseExpr()
+		p.scope = p.scope[:len(p.scope)-1]
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &comprehensionNode{target: target, variable: v.text, pred: pred, all: name.text == "all", pos: name.pos}, nil
+	}
+	if _, ok := methods[name.text]; !ok {
+		return nil, fmt.Errorf("column %d: unknown method %q", name.pos, name.text)
+	}
+	args, err := p.parseArgs(")")
+	if err != nil {
+		return nil, err
+	}
+	return &callNode{fn: name.text, target: target, args: args, pos: name.pos}, nil
+}
+
+// parseArgs parses a comma separated list of expressions up to and including
+// the given closing punctuation.
+func (p *parser) parseArgs(closing string) ([]node, error) {
+	var args []node
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(closing) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt, tokFloat, tokString:
+		return &literalNode{value: t.value}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseFunction(t)
+		}
+		for _, v := range p.scope {
+			if v == t.text {
+				return &identNode{name: t.text, pos: t.pos}, nil
+			}
+		}
+		return nil, fmt.Errorf("column %d: undeclared reference to %q (expected one of: %s)", t.pos, t.text, strings.Join(p.scope, ", "))
+	case tokPunct:
+		switch t.text {
+		case "(":
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			elems, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{elems: elems}, nil
+		case "{":
+			m := &mapNode{}
+			if p.accept("}") {
+				return m, nil
+			}
+			for {
+				k, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				v, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				m.keys, m.values = append(m.keys, k), append(m.values, v)
+				if p.accept("}") {
+					return m, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	p.backup(t)
+	return nil, p.errorf("expected an expression")
+}
+
+// parseFunction parses a global function call, once the opening parenthesis
+// has been consumed.
+func (p *parser) parseFunction(name token) (node, error) {
+	if name.text == "has" {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		sel, ok := arg.(*selectNode)
+		if !ok {
+			return nil, fmt.Errorf("column %d: the argument of has() must be a field selection, e.g. has(object.spec)", name.pos)
+		}
+		return &hasNode{sel: sel}, nil
+	}
+	if _, ok := functions[name.text]; !ok {
+		return nil, fmt.Errorf("column %d: unknown function %q", name.pos, name.text)
+	}
+	args, err := p.parseArgs(")")
+	if err != nil {
+		return nil, err
+	}
+	return &callNode{fn: name.text, args: args, pos: name.pos}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// fanOutAnnotation may be set to "true" on a namespaced input resource to
+// replicate it into each of the namespaces given by --fan-out-namespaces.
+const fanOutAnnotation = "manifest-splitter.io/fan-out"
+
+// loadFanOutNamespaces returns the namespaces given by --fan-out-namespaces,
+// along with those listed, one per line, in --fan-out-namespaces-file.
+func loadFanOutNamespaces() ([]string, error) {
+	namespaces := append([]string(nil), fanOutNamespaces...)
+	if fanOutNamespacesFile == "" {
+		return namespaces, nil
+	}
+	f, err := os.Open(fanOutNamespacesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		namespaces = append(namespaces, line)
+	}
+	return namespaces, scanner.Err()
+}
+
+// fanOutResources replaces each namespaced resource annotated with
+// fanOutAnnotation by a copy in each of the given namespaces, with its
+// metadata.namespace rewritten.
+func fanOutResources(files map[string][]resource, namespaces []string) error {
+	for input, resources := range files {
+		var out []resource
+		for _, r := range resources {
+			if r.obj.IsList() || r.obj.GetAnnotations()[fanOutAnnotation] != "true" {
+				out = append(out, r)
+				continue
+			}
+			if !r.namespaced {
+				return fmt.Errorf("in input file %q: %s %q is cluster scoped, but is annotated with %s", input, r.obj.GetKind(), r.obj.GetName(), fanOutAnnotation)
+			}
+			log.Printf("Replicating %s %q into %d namespaces", r.obj.GetKind(), r.obj.GetName(), len(namespaces))
+			for _, ns := range namespaces {
+				obj := r.obj.DeepCopy()
+				obj.SetNamespace(ns)
+				annotations := obj.GetAnnotations()
+				delete(annotations, fanOutAnnotation)
+				if len(annotations) == 0 {
+					annotations = nil
+				}
+				obj.SetAnnotations(annotations)
+
+				c := r
+				c.obj = obj
+				c.markModified()
+				out = append(out, c)
+			}
+		}
+		files[input] = out
+	}
+	return nil
+}
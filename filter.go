@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+// ignoreAnnotation may be set to "true" on an input resource to exclude it
+// from the output entirely.
+const ignoreAnnotation = "manifest-splitter.io/ignore"
+
+// systemKinds are kinds of resource that are created and managed by the
+// cluster itself, which commonly appear in exports from a cluster but should
+// never be committed to a config repository. They are skipped when
+// --skip-system is set.
+var systemKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "Event"}:                          true,
+	{Group: "events.k8s.io", Kind: "Event"}:             true,
+	{Group: "", Kind: "Node"}:                           true,
+	{Group: "", Kind: "ComponentStatus"}:                true,
+	{Group: "discovery.k8s.io", Kind: "EndpointSlice"}:  true,
+	{Group: "coordination.k8s.io", Kind: "Lease"}:       true,
+	{Group: "apps", Kind: "ControllerRevision"}:         true,
+	{Group: "storage.k8s.io", Kind: "CSINode"}:          true,
+	{Group: "storage.k8s.io", Kind: "VolumeAttachment"}: true,
+	{Group: "metrics.k8s.io", Kind: "NodeMetrics"}:      true,
+	{Group: "metrics.k8s.io", Kind: "PodMetrics"}:       true,
+}
+
+// includedKinds and excludedKinds are the kinds matched by --include-kinds and
+// --exclude-kinds, as resolved by resolveKindFilters. includedKinds is nil if
+// resources of all kinds are included.
+var includedKinds, excludedKinds map[schema.GroupKind]bool
+
+// resolveKindFilters resolves the types given by --include-kinds and
+// --exclude-kinds to the kinds they match, using discovery information from
+// the given inspector.
+func resolveKindFilters(inspector discovery.ResourceInspector) error {
+	var err error
+	if len(includeKinds) > 0 {
+		if includedKinds, err = resolveKindNames(inspector, includeKinds); err != nil {
+			return err
+		}
+	}
+	if len(excludeKinds) > 0 {
+		if excludedKinds, err = resolveKindNames(inspector, excludeKinds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveKindNames returns the set of kinds matched by the given names. Names
+// that do not match any resource type served by the cluster, such as custom
+// resources whose CRD is not installed yet, are matched against kinds as
+// given, e.g. 'Certificate.cert-manager.io'. Names that match kinds in more
+// than one group resolve to the group preferred by --prefer-group, and
+// otherwise to the built in group kubectl would choose, e.g. 'events' to the
+// core Event kind rather than Event.events.k8s.io.
+func resolveKindNames(inspector discovery.ResourceInspector, names []string) (map[schema.GroupKind]bool, error) {
+	resolver, canResolve := inspector.(discovery.KindResolver)
+	kinds := make(map[schema.GroupKind]bool)
+	for _, name := range names {
+		var matched []schema.GroupKind
+		if canResolve {
+			var err error
+			if matched, err = resolver.ResolveKinds(name); err != nil {
+				var ambiguous *discovery.AmbiguousKindError
+				if !errors.As(err, &ambiguous) {
+					return nil, err
+				}
+				gk, ok := preferredKind(ambiguous.Kinds)
+				if !ok {
+					gk, ok = discovery.PreferredBuiltinKind(ambiguous.Kinds)
+				}
+				if !ok {
+					return nil, fmt.Errorf("%v, or a preferred group must be given with --prefer-group", err)
+				}
+				matched = []schema.GroupKind{gk}
+			}
+			if len(matched) == 0 {
+				warnf("%q does not match any resource type served by the cluster, so is matched against kinds exactly", name)
+			}
+		}
+		if len(matched) == 0 {
+			matched = []schema.GroupKind{schema.ParseGroupKind(name)}
+		}
+		for _, gk := range matched {
+			kinds[gk] = true
+		}
+	}
+	return kinds, nil
+}
+
+// preferredKind returns the kind in the first group given by --prefer-group
+// that any of the given kinds belong to.
+func preferredKind(kinds []schema.GroupKind) (schema.GroupKind, bool) {
+	for _, group := range preferGroups {
+		if group == "core" {
+			group = ""
+		}
+		for _, gk := range kinds {
+			if gk.Group == group {
+				return gk, true
+			}
+		}
+	}
+	return schema.GroupKind{}, false
+}
+
+// skipResource returns true if the given decoded object should be excluded
+// from the output.
+func skipResource(input string, obj *unstructured.Unstructured) bool {
+	if obj.GetAnnotations()[ignoreAnnotation] == "true" {
+		return skip(input, obj, "annotated with "+ignoreAnnotation)
+	}
+	gk := obj.GroupVersionKind().GroupKind()
+	if includedKinds != nil && !includedKinds[gk] {
+		return skip(input, obj, "not of a kind given by --include-kinds")
+	}
+	if excludedKinds[gk] {
+		return skip(input, obj, "of a kind given by --exclude-kinds")
+	}
+	if skipSystem && systemKinds[gk] {
+		return skip(input, obj, "a system resource that should not be committed (see --skip-system)")
+	}
+	if acmFormat == acmFormatUnstructured && isACMSystemResource(obj) {
+		return skip(input, obj, "only used by hierarchical ACM repositories (see --acm-format)")
+	}
+	if skipOwned {
+		if refs, _, _ := unstructured.NestedSlice(obj.Object, "metadata", "ownerReferences"); len(refs) > 0 {
+			return skip(input, obj, "owned by another resource")
+		}
+	}
+	return false
+}
+
+func skip(input string, obj *unstructured.Unstructured, reason string) bool {
+	log.Printf("Skipping %s %q in file %q as it is %s", obj.GetKind(), obj.GetName(), input, reason)
+	e := objectEvent(eventSkipped, input, obj)
+	e.Message = reason
+	emitEvent(e)
+	return true
+}
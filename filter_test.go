@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+func TestResolveKindNames(t *testing.T) {
+	defer func(groups []string) { preferGroups = groups }(preferGroups)
+	inspector, err := discovery.NewStaticResourceInspector([]discovery.ScopeResource{
+		{Version: "v1", Kind: "Event", Namespaced: true, Name: "events", SingularName: "event", ShortNames: []string{"ev"}},
+		{Group: "events.k8s.io", Version: "v1", Kind: "Event", Namespaced: true, Name: "events", SingularName: "event", ShortNames: []string{"ev"}},
+		{Group: "example.com", Version: "v1", Kind: "Certificate", Namespaced: true, Name: "certificates", SingularName: "certificate"},
+		{Group: "example.org", Version: "v1", Kind: "Certificate", Namespaced: true, Name: "certificates", SingularName: "certificate"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		prefer  []string
+		want    []schema.GroupKind
+		wantErr string
+	}{
+		{name: "events", want: []schema.GroupKind{{Kind: "Event"}}},
+		{name: "ev", want: []schema.GroupKind{{Kind: "Event"}}},
+		{name: "events", prefer: []string{"events.k8s.io"}, want: []schema.GroupKind{{Group: "events.k8s.io", Kind: "Event"}}},
+		{name: "events.events.k8s.io", want: []schema.GroupKind{{Group: "events.k8s.io", Kind: "Event"}}},
+		{name: "certificates", wantErr: "ambiguous"},
+		{name: "certificates", prefer: []string{"example.org"}, want: []schema.GroupKind{{Group: "example.org", Kind: "Certificate"}}},
+	}
+	for _, test := range tests {
+		preferGroups = test.prefer
+		kinds, err := resolveKindNames(inspector, []string{test.name})
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := make(map[schema.GroupKind]bool)
+		for _, gk := range test.want {
+			want[gk] = true
+		}
+		if !reflect.DeepEqual(kinds, want) {
+			t.Errorf("%s (prefer %q): got %v, want %v", test.name, test.prefer, kinds, want)
+		}
+	}
+}
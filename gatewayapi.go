@@ -0,0 +1,157 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const gatewayAPIGroup = "gateway.networking.k8s.io"
+
+// gatewayRouteKinds are the Gateway API route kinds, which attach to Gateways
+// by parentRefs and forward to backends by backendRefs.
+var gatewayRouteKinds = map[string]bool{
+	"HTTPRoute": true,
+	"GRPCRoute": true,
+	"TLSRoute":  true,
+	"TCPRoute":  true,
+	"UDPRoute":  true,
+}
+
+// gatewayRef is a reference from a Gateway API resource to another object,
+// with the defaults of the referring field applied.
+type gatewayRef struct {
+	group, kind, namespace, name, sectionName string
+}
+
+// parseGatewayRef parses a parentRef, backendRef or certificateRef, using the
+// given default group and kind, and the namespace of the referring object.
+func parseGatewayRef(ref map[string]interface{}, group, kind, namespace string) gatewayRef {
+	r := gatewayRef{group: group, kind: kind, namespace: namespace}
+	if g, ok := ref["group"].(string); ok {
+		r.group = g
+	}
+	if k, ok := ref["kind"].(string); ok && k != "" {
+		r.kind = k
+	}
+	if ns, ok := ref["namespace"].(string); ok && ns != "" {
+		r.namespace = ns
+	}
+	r.name, _ = ref["name"].(string)
+	r.sectionName, _ = ref["sectionName"].(string)
+	return r
+}
+
+// lintGatewayRoute checks that a route is allowed to attach to the Gateways
+// it references in other namespaces, and that a ReferenceGrant in the input
+// files permits each reference to a backend in another namespace.
+func lintGatewayRoute(idx *objectIndex, obj *unstructured.Unstructured) {
+	ns := obj.GetNamespace()
+	routeKind := obj.GetKind()
+	forEachMap(obj.Object, []string{"spec", "parentRefs"}, func(m map[string]interface{}) {
+		ref := parseGatewayRef(m, gatewayAPIGroup, "Gateway", ns)
+		if ref.group != gatewayAPIGroup || ref.kind != "Gateway" {
+			return
+		}
+		gateway, ok := idx.get(gatewayAPIGroup, "Gateway", ref.namespace, ref.name)
+		switch {
+		case !ok && idx.namespaces[ref.namespace]:
+			warnf("%s %s references Gateway %s/%s which is not defined in the input files", routeKind, describeObject(obj), ref.namespace, ref.name)
+		case ok && ref.namespace != ns && !gatewayAllowsRoute(idx, gateway, ref.sectionName, routeKind, ns):
+			warnf("%s %s references Gateway %s/%s in another namespace, but none of its listeners allow routes from namespace %q", routeKind, describeObject(obj), ref.namespace, ref.name, ns)
+		}
+	})
+
+	forEachMap(obj.Object, []string{"spec", "rules"}, func(rule map[string]interface{}) {
+		forEachMap(rule, []string{"backendRefs"}, func(m map[string]interface{}) {
+			ref := parseGatewayRef(m, "", "Service", ns)
+			if ref.namespace != ns && !referenceGranted(idx, gatewayAPIGroup, routeKind, ns, ref) {
+				warnf("%s %s references %s %s/%s in another namespace, but no ReferenceGrant in the input files allows it", routeKind, describeObject(obj), ref.kind, ref.namespace, ref.name)
+			}
+		})
+	})
+}
+
+// lintGateway checks that a ReferenceGrant in the input files permits each
+// reference from the Gateway's listeners to a certificate in another
+// namespace.
+func lintGateway(idx *objectIndex, obj *unstructured.Unstructured) {
+	ns := obj.GetNamespace()
+	forEachMap(obj.Object, []string{"spec", "listeners"}, func(listener map[string]interface{}) {
+		forEachMap(listener, []string{"tls", "certificateRefs"}, func(m map[string]interface{}) {
+			ref := parseGatewayRef(m, "", "Secret", ns)
+			if ref.namespace != ns && !referenceGranted(idx, gatewayAPIGroup, "Gateway", ns, ref) {
+				warnf("Gateway %s listener %q references %s %s/%s in another namespace, but no ReferenceGrant in the input files allows it", describeObject(obj), listener["name"], ref.kind, ref.namespace, ref.name)
+			}
+		})
+	})
+}
+
+// gatewayAllowsRoute returns true if a listener of the given Gateway, or the
+// listener named by sectionName if set, allows routes of the given kind from
+// the given namespace to attach to it. Namespace selectors that cannot be
+// evaluated from the input files are assumed to allow the route.
+func gatewayAllowsRoute(idx *objectIndex, gateway *unstructured.Unstructured, sectionName, routeKind, routeNamespace string) bool {
+	allowed := false
+	forEachMap(gateway.Object, []string{"spec", "listeners"}, func(listener map[string]interface{}) {
+		if allowed || (sectionName != "" && listener["name"] != sectionName) {
+			return
+		}
+		if kinds, ok, _ := unstructured.NestedSlice(listener, "allowedRoutes", "kinds"); ok && len(kinds) > 0 {
+			kindAllowed := false
+			for _, k := range kinds {
+				if kind, ok := k.(map[string]interface{}); ok && kind["kind"] == routeKind {
+					kindAllowed = true
+				}
+			}
+			if !kindAllowed {
+				return
+			}
+		}
+		from, _, _ := unstructured.NestedString(listener, "allowedRoutes", "namespaces", "from")
+		switch from {
+		case "All":
+			allowed = true
+		case "Selector":
+			selector, _, _ := unstructured.NestedMap(listener, "allowedRoutes", "namespaces", "selector")
+			if _, ok := selector["matchExpressions"]; ok {
+				allowed = true
+				return
+			}
+			namespace, ok := idx.get("", "Namespace", "", routeNamespace)
+			if !ok {
+				allowed = true
+				return
+			}
+			matchLabels, _, _ := unstructured.NestedStringMap(selector, "matchLabels")
+			allowed = selectorMatches(matchLabels, namespace.GetLabels())
+		default:
+			// routes are only allowed from the Gateway's own namespace by
+			// default
+			allowed = routeNamespace == gateway.GetNamespace()
+		}
+	})
+	return allowed
+}
+
+// referenceGranted returns true if a ReferenceGrant in the namespace of the
+// referenced object allows references to it from objects of the given group
+// and kind in the given namespace.
+func referenceGranted(idx *objectIndex, fromGroup, fromKind, fromNamespace string, to gatewayRef) bool {
+	for key, grant := range idx.objects {
+		if key.group != gatewayAPIGroup || key.kind != "ReferenceGrant" || key.namespace != to.namespace {
+			continue
+		}
+		fromOK, toOK := false, false
+		forEachMap(grant.Object, []string{"spec", "from"}, func(from map[string]interface{}) {
+			fromOK = fromOK || (from["group"] == fromGroup && from["kind"] == fromKind && from["namespace"] == fromNamespace)
+		})
+		forEachMap(grant.Object, []string{"spec", "to"}, func(t map[string]interface{}) {
+			group, _ := t["group"].(string)
+			name, _ := t["name"].(string)
+			toOK = toOK || (group == to.group && t["kind"] == to.kind && (name == "" || name == to.name))
+		})
+		if fromOK && toOK {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newGeneratedResource returns a resource for an object generated by
+// manifest-splitter rather than read from an input file.
+// If filename is non-empty, it overrides the name of the output file.
+func newGeneratedResource(obj *unstructured.Unstructured, namespaced bool, filename string) (resource, error) {
+	data, err := EncodeYAML(obj)
+	if err != nil {
+		return resource{}, fmt.Errorf("encoding generated %s %q: %v", obj.GetKind(), obj.GetName(), err)
+	}
+	return resource{
+		data:       data,
+		format:     yamlFormat,
+		obj:        obj,
+		namespaced: namespaced,
+		filename:   filename,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// gitMessageTemplate is the parsed --git-message-template.
+var gitMessageTemplate *template.Template
+
+// gitMessageData is passed to --git-message-template when rendering the
+// message of a commit.
+type gitMessageData struct {
+	// Inputs are the input files given on the command line.
+	Inputs []string
+	// Files is the number of output files written.
+	Files int
+	// Namespaces are the namespaces that output was written for.
+	Namespaces []string
+	Time       time.Time
+}
+
+// runGit runs git with the given arguments within dir, returning its
+// trimmed standard output.
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// checkoutGitBranch switches the git repository containing dir to the given
+// branch, creating it from the current HEAD if it does not exist.
+func checkoutGitBranch(dir, branch string) error {
+	if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+		log.Printf("Creating git branch %q", branch)
+		_, err := runGit(dir, "checkout", "-b", branch)
+		return err
+	}
+	log.Printf("Checking out git branch %q", branch)
+	_, err := runGit(dir, "checkout", branch)
+	return err
+}
+
+// commitGitChanges stages all changes within dir and commits them, using
+// --git-message-template to render the commit message. It returns the commit
+// message, or an empty string if nothing changed and no commit was created.
+func commitGitChanges(dir string, data gitMessageData) (string, error) {
+	if _, err := runGit(dir, "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	if _, err := runGit(dir, "diff", "--cached", "--quiet", "--", "."); err == nil {
+		log.Printf("No changes to commit in %q", dir)
+		return "", nil
+	}
+
+	var msg bytes.Buffer
+	if err := gitMessageTemplate.Execute(&msg, data); err != nil {
+		return "", fmt.Errorf("rendering commit message: %v", err)
+	}
+	if _, err := runGit(dir, "commit", "--quiet", "--message", msg.String(), "--", "."); err != nil {
+		return "", err
+	}
+	rev, err := runGit(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Committed changes to %q as %s", dir, rev)
+	return msg.String(), nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/munnerz/manifest-splitter/pkg/splittertest"
+)
+
+// TestGolden runs the splitter against each case in testdata/. The splitter
+// is built from this package unless $MANIFEST_SPLITTER is set.
+func TestGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping golden tests in short mode")
+	}
+	if os.Getenv("MANIFEST_SPLITTER") == "" {
+		dir, err := ioutil.TempDir("", "manifest-splitter-bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		bin := filepath.Join(dir, "manifest-splitter")
+		if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+			t.Fatalf("building manifest-splitter: %v\n%s", err, out)
+		}
+		os.Setenv("MANIFEST_SPLITTER", bin)
+		defer os.Unsetenv("MANIFEST_SPLITTER")
+	}
+	splittertest.RunDir(t, "testdata")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+// helmfileBinary is the name of the helmfile executable used to render
+// helmfile.yaml inputs.
+const helmfileBinary = "helmfile"
+
+// isHelmfileInput returns true if the given input file is a Helmfile.
+func isHelmfileInput(input string) bool {
+	switch filepath.Base(input) {
+	case "helmfile.yaml", "helmfile.yaml.gotmpl":
+		return true
+	}
+	return false
+}
+
+// helmfileEnvironments returns the environments declared by the given
+// Helmfile, or those set by --helmfile-environments.
+func helmfileEnvironments(input string) ([]string, error) {
+	if len(helmfileEnvs) > 0 {
+		return helmfileEnvs, nil
+	}
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	// only the first document of a Helmfile declares environments
+	if i := bytes.Index(data, []byte("\n---")); i >= 0 {
+		data = data[:i]
+	}
+	var helmfile struct {
+		Environments map[string]interface{} `json:"environments"`
+	}
+	if err := yaml.Unmarshal(data, &helmfile); err != nil {
+		return nil, fmt.Errorf("reading environments from %q (set --helmfile-environments if it is templated): %v", input, err)
+	}
+	if len(helmfile.Environments) == 0 {
+		return []string{"default"}, nil
+	}
+	envs := make([]string, 0, len(helmfile.Environments))
+	for env := range helmfile.Environments {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	return envs, nil
+}
+
+// renderHelmfile renders every release in the given Helmfile for the given
+// environment using 'helmfile template'.
+func renderHelmfile(input, env string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(helmfileBinary, "--file", input, "--environment", env, "template")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, fmt.Errorf("%s must be installed to render Helmfile inputs: %v", helmfileBinary, err)
+		}
+		return nil, fmt.Errorf("rendering environment %q: %v: %s", env, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// splitHelmfiles renders the releases of each Helmfile for every environment
+// it declares, and splits the output of each environment, along with the
+// resources of all other inputs, into its own subtree of the output
+// directory, e.g. <output>/production/.
+// It returns the combined outputs of all environments.
+func splitHelmfiles(inspector discovery.ResourceInspector, helmfiles, inputs []string) (map[string][]resource, []outputFile, error) {
+	byEnv := make(map[string][]string)
+	for _, helmfile := range helmfiles {
+		envs, err := helmfileEnvironments(helmfile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, env := range envs {
+			byEnv[env] = append(byEnv[env], helmfile)
+		}
+	}
+	envs := make([]string, 0, len(byEnv))
+	for env := range byEnv {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	outputs := make(map[string][]resource)
+	var written []outputFile
+	for _, env := range envs {
+		log.Printf("Splitting Helmfile environment %q", env)
+		// inputs are re-read for each environment, as resources are
+		// modified in place whilst being split.
+		files, err := readInputs(inputs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, helmfile := range byEnv[env] {
+			data, err := renderHelmfile(helmfile, env)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to render input file %q: %v", helmfile, err)
+			}
+			resources, err := decodeResourceManifest(helmfile, bytes.NewReader(data))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode rendered input file %q: %v", helmfile, err)
+			}
+			log.Printf("Found %d resources in environment %q of file %q", len(resources), env, helmfile)
+			files[helmfile] = resources
+		}
+
+		envOutputs, envWritten, err := splitResources(inspector, files, filepath.Join(outputDir, env))
+		if err != nil {
+			return nil, nil, fmt.Errorf("in environment %q: %v", env, err)
+		}
+		for ns, resources := range envOutputs {
+			outputs[ns] = append(outputs[ns], resources...)
+		}
+		for _, f := range envWritten {
+			f.path = filepath.Join(env, f.path)
+			written = append(written, f)
+		}
+	}
+	return outputs, written, nil
+}
+
+// partitionHelmfiles separates Helmfile inputs from all other inputs.
+func partitionHelmfiles(inputs []string) (helmfiles, others []string) {
+	for _, input := range inputs {
+		if isHelmfileInput(input) {
+			helmfiles = append(helmfiles, input)
+		} else {
+			others = append(others, input)
+		}
+	}
+	return helmfiles, others
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	hncGroup = "hnc.x-k8s.io"
+	// hncTreeLabelSuffix is the suffix of labels applied by the Hierarchical
+	// Namespace Controller to namespaces, recording the depth of each ancestor.
+	hncTreeLabelSuffix = ".tree." + hncGroup + "/depth"
+)
+
+// namespaceParents maps namespace names to the name of their parent namespace
+// as declared using the Hierarchical Namespace Controller. It is only
+// populated if --hnc is set.
+var namespaceParents map[string]string
+
+// discoverNamespaceHierarchy builds a map of namespace to parent namespace from
+// the SubnamespaceAnchor, HierarchyConfiguration and Namespace resources in the
+// given files.
+func discoverNamespaceHierarchy(files map[string][]resource) (map[string]string, error) {
+	parents := make(map[string]string)
+	setParent := func(child, parent, source string) error {
+		if existing, ok := parents[child]; ok && existing != parent {
+			return fmt.Errorf("conflicting parents %q and %q declared for namespace %q (%s)", existing, parent, child, source)
+		}
+		parents[child] = parent
+		return nil
+	}
+
+	for _, resources := range files {
+		for _, r := range resources {
+			gvk := r.obj.GroupVersionKind()
+			switch {
+			case gvk.Group == hncGroup && gvk.Kind == "SubnamespaceAnchor":
+				if err := setParent(r.obj.GetName(), r.obj.GetNamespace(), "SubnamespaceAnchor in file "+r.inputFilename); err != nil {
+					return nil, err
+				}
+			case gvk.Group == hncGroup && gvk.Kind == "HierarchyConfiguration":
+				parent, _, _ := unstructured.NestedString(r.obj.Object, "spec", "parent")
+				if parent != "" {
+					if err := setParent(r.obj.GetNamespace(), parent, "HierarchyConfiguration in file "+r.inputFilename); err != nil {
+						return nil, err
+					}
+				}
+			case gvk.Group == "" && gvk.Kind == "Namespace":
+				for key, value := range r.obj.GetLabels() {
+					if value == "1" && strings.HasSuffix(key, hncTreeLabelSuffix) {
+						parent := strings.TrimSuffix(key, hncTreeLabelSuffix)
+						if err := setParent(r.obj.GetName(), parent, "tree label in file "+r.inputFilename); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// ensure the hierarchy contains no cycles
+	for ns := range parents {
+		seen := map[string]bool{ns: true}
+		for p, ok := parents[ns]; ok; p, ok = parents[p] {
+			if seen[p] {
+				return nil, fmt.Errorf("namespace hierarchy for %q contains a cycle", ns)
+			}
+			seen[p] = true
+		}
+	}
+	return parents, nil
+}
+
+// namespacePath returns the path of the directory for the given namespace,
+// relative to the namespaces/ directory. If a namespace hierarchy has been
+// discovered, the directory is nested beneath the directories of each of its
+// ancestors.
+func namespacePath(ns string) string {
+	path := []string{ns}
+	for p, ok := namespaceParents[ns]; ok; p, ok = namespaceParents[p] {
+		path = append([]string{p}, path...)
+	}
+	return filepath.Join(path...)
+}
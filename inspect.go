@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// analyses maps the names of analyses that can be run using the 'inspect'
+// subcommand to their implementation.
+var analyses = map[string]func(files map[string][]resource, w io.Writer) error{
+	"capacity": inspectCapacity,
+	"orphans":  inspectOrphans,
+	"security": inspectSecurity,
+	"services": inspectServices,
+}
+
+// runInspect implements the 'inspect' subcommand, which runs an analysis
+// against the given input files and prints a report to stdout.
+// No output directory is written, and no cluster access is required.
+func runInspect(args []string) error {
+	var names []string
+	for name := range analyses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return fmt.Errorf("usage: manifest-splitter inspect <%s> [input files...]", strings.Join(names, "|"))
+	}
+
+	analysis, ok := analyses[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown analysis %q, must be one of: %s", args[0], strings.Join(names, ", "))
+	}
+	files, err := readInputs(args[1:])
+	if err != nil {
+		return err
+	}
+	return analysis(files, os.Stdout)
+}
+
+// inspectOrphans reports ConfigMaps, Secrets and ServiceAccounts in the input
+// files that are not referenced by any other resource in the input files.
+func inspectOrphans(files map[string][]resource, w io.Writer) error {
+	referenced := make(map[objectKey]bool)
+	ref := func(kind, namespace, name string) {
+		referenced[objectKey{kind: kind, namespace: namespace, name: name}] = true
+	}
+
+	for _, resources := range files {
+		for _, r := range resources {
+			obj := r.obj
+			ns := obj.GetNamespace()
+			if spec, ok := podSpec(obj); ok {
+				refs := podSpecReferences(spec)
+				ref("ServiceAccount", ns, refs.serviceAccount)
+				for _, name := range refs.configMaps {
+					ref("ConfigMap", ns, name)
+				}
+				for _, name := range refs.secrets {
+					ref("Secret", ns, name)
+				}
+			}
+
+			gvk := obj.GroupVersionKind()
+			switch {
+			case gvk.Group == "" && gvk.Kind == "ServiceAccount":
+				for _, field := range []string{"secrets", "imagePullSecrets"} {
+					forEachMap(obj.Object, []string{field}, func(m map[string]interface{}) {
+						name, _ := m["name"].(string)
+						ref("Secret", ns, name)
+					})
+				}
+			case gvk.Group == "" && gvk.Kind == "Secret":
+				// service account token secrets are referenced by the
+				// service account they are created for
+				if sa := obj.GetAnnotations()["kubernetes.io/service-account.name"]; sa != "" {
+					ref("Secret", ns, obj.GetName())
+					ref("ServiceAccount", ns, sa)
+				}
+			case gvk.Group == "rbac.authorization.k8s.io" && (gvk.Kind == "RoleBinding" || gvk.Kind == "ClusterRoleBinding"):
+				forEachMap(obj.Object, []string{"subjects"}, func(subject map[string]interface{}) {
+					if subject["kind"] != "ServiceAccount" {
+						return
+					}
+					name, _ := subject["name"].(string)
+					subjectNS, _ := subject["namespace"].(string)
+					ref("ServiceAccount", subjectNS, name)
+				})
+			case (gvk.Group == "networking.k8s.io" || gvk.Group == "extensions") && gvk.Kind == "Ingress":
+				forEachMap(obj.Object, []string{"spec", "tls"}, func(tls map[string]interface{}) {
+					name, _ := tls["secretName"].(string)
+					ref("Secret", ns, name)
+				})
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAMESPACE\tNAME\tFILE")
+	found := 0
+	for _, r := range sortedResources(files) {
+		obj := r.obj
+		gvk := obj.GroupVersionKind()
+		if gvk.Group != "" {
+			continue
+		}
+		switch gvk.Kind {
+		case "ConfigMap", "Secret", "ServiceAccount":
+		default:
+			continue
+		}
+		if gvk.Kind == "ServiceAccount" && obj.GetName() == "default" {
+			continue
+		}
+		if referenced[objectKey{kind: gvk.Kind, namespace: obj.GetNamespace(), name: obj.GetName()}] {
+			continue
+		}
+		found++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", gvk.Kind, obj.GetNamespace(), obj.GetName(), r.inputFilename)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nFound %d unreferenced resources\n", found)
+	return nil
+}
+
+// podTemplateLabels returns the labels that pods created by the given Pod or
+// workload resource have.
+func podTemplateLabels(obj *unstructured.Unstructured) (map[string]string, bool) {
+	if isPod(obj) {
+		return obj.GetLabels(), true
+	}
+	path, ok := podTemplatePaths[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		return nil, false
+	}
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, append(append([]string(nil), path...), "metadata", "labels")...)
+	return labels, true
+}
+
+// inspectServices reports, per namespace, each Service with its selector, the
+// workloads whose pods it selects and the ports it exposes. Services whose
+// selector matches no workload, and named target ports that no selected
+// container declares, are flagged.
+func inspectServices(files map[string][]resource, w io.Writer) error {
+	type workload struct {
+		obj    *unstructured.Unstructured
+		labels map[string]string
+		ports  map[string]bool
+	}
+	var workloads []workload
+	var services []*unstructured.Unstructured
+	for _, r := range sortedResources(files) {
+		gvk := r.obj.GroupVersionKind()
+		if gvk.Group == "" && gvk.Kind == "Service" {
+			services = append(services, r.obj)
+			continue
+		}
+		labels, ok := podTemplateLabels(r.obj)
+		spec, hasSpec := podSpec(r.obj)
+		if !ok || !hasSpec {
+			continue
+		}
+		ports := make(map[string]bool)
+		for _, c := range podContainers(spec) {
+			forEachMap(c, []string{"ports"}, func(port map[string]interface{}) {
+				if name, _ := port["name"].(string); name != "" {
+					ports[name] = true
+				}
+			})
+		}
+		workloads = append(workloads, workload{obj: r.obj, labels: labels, ports: ports})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tSERVICE\tTYPE\tSELECTOR\tPORTS\tWORKLOADS")
+	var problems []string
+	unmatched := 0
+	for _, svc := range services {
+		ns, name := svc.GetNamespace(), svc.GetName()
+		typ, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+		if typ == "" {
+			typ = "ClusterIP"
+		}
+		selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+
+		var matched []workload
+		if len(selector) > 0 {
+			for _, wl := range workloads {
+				if wl.obj.GetNamespace() == ns && selectorMatches(selector, wl.labels) {
+					matched = append(matched, wl)
+				}
+			}
+		}
+		var matchedNames []string
+		for _, wl := range matched {
+			matchedNames = append(matchedNames, fmt.Sprintf("%s/%s", wl.obj.GetKind(), wl.obj.GetName()))
+		}
+		matchedDesc := strings.Join(matchedNames, ",")
+		switch {
+		case len(selector) == 0:
+			// endpoints are managed separately, e.g. for ExternalName
+			// services or manually created Endpoints
+			matchedDesc = "-"
+		case len(matched) == 0:
+			matchedDesc = "NONE"
+			unmatched++
+			problems = append(problems, fmt.Sprintf("Service %s/%s: selector %s matches no workloads", ns, name, formatSelector(selector)))
+		}
+
+		var ports []string
+		forEachMap(svc.Object, []string{"spec", "ports"}, func(port map[string]interface{}) {
+			desc := fmt.Sprint(port["port"])
+			if portName, _ := port["name"].(string); portName != "" {
+				desc = portName + ":" + desc
+			}
+			if target, ok := port["targetPort"]; ok {
+				desc += "->" + fmt.Sprint(target)
+				if targetName, isName := target.(string); isName && len(matched) > 0 {
+					declared := false
+					for _, wl := range matched {
+						declared = declared || wl.ports[targetName]
+					}
+					if !declared {
+						problems = append(problems, fmt.Sprintf("Service %s/%s: target port %q is not declared by any selected container", ns, name, targetName))
+					}
+				}
+			}
+			protocol, _ := port["protocol"].(string)
+			if protocol == "" {
+				protocol = "TCP"
+			}
+			ports = append(ports, desc+"/"+protocol)
+		})
+		portsDesc := strings.Join(ports, ",")
+		if portsDesc == "" {
+			portsDesc = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ns, name, typ, formatSelector(selector), portsDesc, matchedDesc)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		fmt.Fprintln(w)
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+	}
+	fmt.Fprintf(w, "\nFound %d Services, %d with selectors matching no workloads\n", len(services), unmatched)
+	return nil
+}
+
+// selectorMatches returns true if labels contains every label in selector.
+func selectorMatches(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSelector formats a map of labels as a comma separated list of
+// key=value pairs, sorted by key.
+func formatSelector(selector map[string]string) string {
+	if len(selector) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(selector))
+	for k, v := range selector {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// sortedResources returns every non-list resource in the given files, sorted
+// by namespace, kind and name.
+func sortedResources(files map[string][]resource) []resource {
+	var all []resource
+	for _, resources := range files {
+		for _, r := range resources {
+			if !r.obj.IsList() {
+				all = append(all, r)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].obj, all[j].obj
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		if a.GetKind() != b.GetKind() {
+			return a.GetKind() < b.GetKind()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return all
+}
+
+// securityFinding is a single security relevant observation about a workload.
+type securityFinding struct {
+	workload  *unstructured.Unstructured
+	container string
+	category  string
+	detail    string
+}
+
+const (
+	securityPrivileged    = "privileged"
+	securityRoot          = "root"
+	securityHostNamespace = "host-namespace"
+	securityNoLimits      = "no-limits"
+)
+
+// inspectSecurity reports, per namespace, the workloads that run privileged
+// containers, run as root, share host namespaces or do not set resource
+// limits.
+func inspectSecurity(files map[string][]resource, w io.Writer) error {
+	var findings []securityFinding
+	workloads := make(map[string]int)
+	for _, r := range sortedResources(files) {
+		spec, ok := podSpec(r.obj)
+		if !ok {
+			continue
+		}
+		workloads[r.obj.GetNamespace()]++
+		findings = append(findings, podSecurityFindings(r.obj, spec)...)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tKIND\tNAME\tCONTAINER\tFINDING\tDETAIL")
+	counts := make(map[string]map[string]int)
+	for _, f := range findings {
+		ns := f.workload.GetNamespace()
+		if counts[ns] == nil {
+			counts[ns] = make(map[string]int)
+		}
+		counts[ns][f.category]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ns, f.workload.GetKind(), f.workload.GetName(), f.container, f.category, f.detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	namespaces := make([]string, 0, len(workloads))
+	for ns := range workloads {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAMESPACE\tWORKLOADS\t%s\t%s\t%s\t%s\n", strings.ToUpper(securityPrivileged), strings.ToUpper(securityRoot), strings.ToUpper(securityHostNamespace), strings.ToUpper(securityNoLimits))
+	for _, ns := range namespaces {
+		c := counts[ns]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", ns, workloads[ns], c[securityPrivileged], c[securityRoot], c[securityHostNamespace], c[securityNoLimits])
+	}
+	return tw.Flush()
+}
+
+// podSecurityFindings returns the security findings for the given workload
+// and its pod spec.
+func podSecurityFindings(obj *unstructured.Unstructured, spec map[string]interface{}) []securityFinding {
+	var findings []securityFinding
+	add := func(container, category, detail string) {
+		findings = append(findings, securityFinding{workload: obj, container: container, category: category, detail: detail})
+	}
+
+	for _, field := range []string{"hostNetwork", "hostPID", "hostIPC"} {
+		if enabled, _, _ := unstructured.NestedBool(spec, field); enabled {
+			add("", securityHostNamespace, field)
+		}
+	}
+
+	podRunAsUser, podRunAsUserSet, _ := unstructured.NestedInt64(spec, "securityContext", "runAsUser")
+	podRunAsNonRoot, _, _ := unstructured.NestedBool(spec, "securityContext", "runAsNonRoot")
+	for _, c := range podContainers(spec) {
+		name, _ := c["name"].(string)
+		if privileged, _, _ := unstructured.NestedBool(c, "securityContext", "privileged"); privileged {
+			add(name, securityPrivileged, "securityContext.privileged is true")
+		}
+
+		runAsUser, runAsUserSet, _ := unstructured.NestedInt64(c, "securityContext", "runAsUser")
+		if !runAsUserSet {
+			runAsUser, runAsUserSet = podRunAsUser, podRunAsUserSet
+		}
+		runAsNonRoot, runAsNonRootSet, _ := unstructured.NestedBool(c, "securityContext", "runAsNonRoot")
+		if !runAsNonRootSet {
+			runAsNonRoot = podRunAsNonRoot
+		}
+		switch {
+		case runAsUserSet && runAsUser == 0:
+			add(name, securityRoot, "runAsUser is 0")
+		case !runAsUserSet && !runAsNonRoot:
+			add(name, securityRoot, "runAsNonRoot is not set, so the image's default user is used")
+		}
+
+		var missing []string
+		for _, resource := range []string{"cpu", "memory"} {
+			if _, ok, _ := unstructured.NestedFieldNoCopy(c, "resources", "limits", resource); !ok {
+				missing = append(missing, resource)
+			}
+		}
+		if len(missing) > 0 {
+			add(name, securityNoLimits, "no "+strings.Join(missing, " or ")+" limit")
+		}
+	}
+	return findings
+}
+
+// inspectCapacity reports the sum of the CPU and memory requests and limits
+// of all workloads in each namespace, taking replica counts and the defaults
+// of LimitRanges into account.
+func inspectCapacity(files map[string][]resource, w io.Writer) error {
+	capacity, err := capacityByNamespace(files, nil)
+	if err != nil {
+		return err
+	}
+	namespaces := make([]string, 0, len(capacity))
+	for ns := range capacity {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	perNode := 0
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tWORKLOADS\tCPU REQUESTS\tCPU LIMITS\tMEMORY REQUESTS\tMEMORY LIMITS")
+	for _, ns := range namespaces {
+		c := capacity[ns]
+		perNode += c.perNodeWorkloads
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", ns, c.workloads, c.cpuRequestsQuantity(), c.cpuLimitsQuantity(), c.memoryRequestsQuantity(), c.memoryLimitsQuantity())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if perNode > 0 {
+		fmt.Fprintf(w, "\nNote: %d workloads (e.g. DaemonSets) run one replica per node and have been counted once\n", perNode)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journalFilename is the name of the file, within the output directory, that
+// records the output files written so far by a run, so that it can be resumed
+// with --resume if it fails. It is deleted once the run completes.
+const journalFilename = ".manifest-splitter-journal"
+
+// resume is whether output files recorded in the journal of a previous,
+// failed run with the same arguments and inputs are skipped.
+var resume bool
+
+// journal records output files as they are written.
+var journal struct {
+	path string
+	file *os.File
+	// completed maps each output file written by the run being resumed to
+	// its content hash.
+	completed map[string]string
+}
+
+// journalEntry is a single line of the journal. The first line records the
+// run, and each following line an output file.
+type journalEntry struct {
+	Run  string `json:"run,omitempty"`
+	Path string `json:"path,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// startJournal begins recording output files written to dir. If --resume is
+// set and the journal left by a failed run has the same arguments and inputs,
+// the files it records are loaded so that they are not written again. The
+// journal is not kept for dry runs, atomic writes or remote output, where
+// nothing is written in place as the run progresses.
+func startJournal(dir string, inputs []string) error {
+	if dryRun || atomicWrites || objectStoreOutput != nil || configMapOutput != nil {
+		return nil
+	}
+	run, err := journalRunID(inputs)
+	if err != nil {
+		return err
+	}
+	journal.path = filepath.Join(dir, journalFilename)
+
+	if resume {
+		completed, previousRun, err := readJournal(journal.path)
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("No journal of a failed run was found in %q, so all output files are written", dir)
+		case err != nil:
+			return fmt.Errorf("reading journal: %v", err)
+		case previousRun != run:
+			log.Printf("The journal in %q is from a run with different arguments or inputs, so all output files are written", dir)
+		default:
+			log.Printf("Resuming a failed run that wrote %d output files", len(completed))
+			journal.completed = completed
+			journal.file, err = os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0)
+			return err
+		}
+	}
+
+	// the output directory is otherwise only created once the first output
+	// file is written
+	if err := mkdirOutput(dir); err != nil {
+		return fmt.Errorf("creating output directory: %v", err)
+	}
+	if journal.file, err = os.Create(journal.path); err != nil {
+		return fmt.Errorf("creating journal: %v", err)
+	}
+	return writeJournalEntry(journalEntry{Run: run})
+}
+
+// journalRunID identifies a run by its arguments and the contents of its
+// input files.
+func journalRunID(inputs []string) (string, error) {
+	h := sha256.New()
+	for _, arg := range os.Args[1:] {
+		if arg == "--resume" || strings.HasPrefix(arg, "--resume=") {
+			continue
+		}
+		fmt.Fprintf(h, "%q\n", arg)
+	}
+	for _, input := range inputs {
+		if isKustomizeInput(input) {
+			fmt.Fprintf(h, "%s %s\n", input, kustomizeDigests[input])
+			continue
+		}
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s %s\n", input, hex.EncodeToString(sum[:]))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readJournal returns the output files recorded in the journal at path, and
+// the run that wrote them. A partially written final line is ignored.
+func readJournal(path string) (map[string]string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	completed := make(map[string]string)
+	var run string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if e.Run != "" {
+			run = e.Run
+		} else if e.Path != "" {
+			completed[e.Path] = e.Hash
+		}
+	}
+	return completed, run, scanner.Err()
+}
+
+func writeJournalEntry(e journalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if _, err := journal.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	return nil
+}
+
+// journalOutput records that the output file at path has been written with
+// content of the given hash.
+func journalOutput(path, hash string) error {
+	if journal.file == nil {
+		return nil
+	}
+	return writeJournalEntry(journalEntry{Path: filepath.ToSlash(path), Hash: hash})
+}
+
+// resumedOutput returns true if the output file at path was written with the
+// same content by the run being resumed, and still exists.
+func resumedOutput(path, hash string) bool {
+	if journal.completed[filepath.ToSlash(path)] != hash {
+		return false
+	}
+	_, err := outputFS.Lstat(path)
+	return err == nil
+}
+
+// finishJournal deletes the journal once the run has completed.
+func finishJournal() error {
+	if journal.file == nil {
+		return nil
+	}
+	journal.file.Close()
+	journal.file = nil
+	return os.Remove(journal.path)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// jsonnetBinary is the name of the jsonnet executable used to evaluate
+// .jsonnet and .libsonnet input files.
+const jsonnetBinary = "jsonnet"
+
+// isJsonnetInput returns true if the given input file should be evaluated as
+// Jsonnet.
+func isJsonnetInput(input string) bool {
+	switch filepath.Ext(input) {
+	case ".jsonnet", ".libsonnet":
+		return true
+	}
+	return false
+}
+
+// evaluateJsonnet evaluates the given Jsonnet file using the jsonnet binary,
+// returning a JSON document containing the Kubernetes objects it produces.
+//
+// The result of the evaluation may be a single object, an array of objects,
+// or an object whose values are Kubernetes objects (as commonly produced by
+// kubecfg and similar tools). Arrays and objects of objects are converted to
+// a v1 List.
+func evaluateJsonnet(input string) ([]byte, error) {
+	var args []string
+	for _, path := range jsonnetPaths {
+		args = append(args, "--jpath", path)
+	}
+	for _, v := range jsonnetExtStrs {
+		args = append(args, "--ext-str", v)
+	}
+	for _, v := range jsonnetExtCodes {
+		args = append(args, "--ext-code", v)
+	}
+	args = append(args, input)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(jsonnetBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, fmt.Errorf("%s must be installed to evaluate Jsonnet inputs: %v", jsonnetBinary, err)
+		}
+		return nil, fmt.Errorf("evaluating Jsonnet: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("decoding Jsonnet output: %v", err)
+	}
+	var items []interface{}
+	switch v := result.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		if _, ok := v["kind"]; ok {
+			return stdout.Bytes(), nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			items = append(items, v[k])
+		}
+	default:
+		return nil, fmt.Errorf("Jsonnet output must be an object or array, got %T", result)
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
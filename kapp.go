@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	kappAPIVersion            = "kapp.k14s.io/v1alpha1"
+	kappChangeGroupAnnotation = "kapp.k14s.io/change-group"
+	kappChangeRuleAnnotation  = "kapp.k14s.io/change-rule"
+	// kappConfigFilename is the name of the kapp Config file written into
+	// the root of the output directory with --layout=kapp.
+	kappConfigFilename = "app.yml"
+)
+
+// kappChangeGroups are the change groups resources are assigned to with
+// --layout=kapp, in the order they are applied. Each group is applied only
+// once the previous group has been.
+var kappChangeGroups = []string{
+	"manifest-splitter.io/crds",
+	"manifest-splitter.io/namespaces",
+	"manifest-splitter.io/cluster",
+	"manifest-splitter.io/config",
+	"manifest-splitter.io/workloads",
+	"manifest-splitter.io/other",
+}
+
+// kappConfigKinds are namespaced kinds that workloads commonly depend on, and
+// so are applied before them.
+var kappConfigKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "ConfigMap"}:                            true,
+	{Group: "", Kind: "Secret"}:                               true,
+	{Group: "", Kind: "ServiceAccount"}:                       true,
+	{Group: "", Kind: "PersistentVolumeClaim"}:                true,
+	{Group: "", Kind: "LimitRange"}:                           true,
+	{Group: "", Kind: "ResourceQuota"}:                        true,
+	{Group: "rbac.authorization.k8s.io", Kind: "Role"}:        true,
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}: true,
+	{Group: "networking.k8s.io", Kind: "NetworkPolicy"}:       true,
+}
+
+// kappChangeGroup returns the index within kappChangeGroups of the group that
+// the given resource belongs to.
+func kappChangeGroup(r *resource) int {
+	gk := r.obj.GroupVersionKind().GroupKind()
+	switch {
+	case gk == schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:
+		return 0
+	case gk == schema.GroupKind{Group: "", Kind: "Namespace"}:
+		return 1
+	case !r.namespaced:
+		return 2
+	case kappConfigKinds[gk]:
+		return 3
+	case isPod(r.obj) || podTemplatePaths[gk] != nil:
+		return 4
+	}
+	return 5
+}
+
+// kappTransformer annotates resources with kapp change groups and rules, so
+// that kapp applies them in dependency order.
+type kappTransformer struct{}
+
+func (kappTransformer) Transform(r *resource) (bool, error) {
+	if isKappConfig(r.obj) {
+		return false, nil
+	}
+	group := kappChangeGroup(r)
+	annotations := r.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	changed := false
+	set := func(key, value string) {
+		if _, ok := annotations[key]; ok {
+			// never override change groups and rules set on the input
+			return
+		}
+		annotations[key] = value
+		changed = true
+	}
+	set(kappChangeGroupAnnotation, kappChangeGroups[group])
+	if group > 0 {
+		set(kappChangeRuleAnnotation, fmt.Sprintf("upsert after upserting %s", kappChangeGroups[group-1]))
+	}
+	if changed {
+		r.obj.SetAnnotations(annotations)
+	}
+	return changed, nil
+}
+
+// isKappConfig returns true if the given object is a kapp Config.
+func isKappConfig(obj *unstructured.Unstructured) bool {
+	return obj.GetAPIVersion() == kappAPIVersion && obj.GetKind() == "Config"
+}
+
+// generateKappConfig returns a kapp Config to be written to app.yml in the
+// root of the output directory, if the inputs do not already contain one.
+func generateKappConfig(files map[string][]resource) ([]resource, error) {
+	for _, resources := range files {
+		for _, r := range resources {
+			if isKappConfig(r.obj) {
+				return nil, nil
+			}
+		}
+	}
+	log.Printf("Generating kapp Config as none was found in the input files")
+	config, err := newGeneratedResource(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": kappAPIVersion,
+		"kind":       "Config",
+		// change rule annotations require kapp v0.29.0 or later
+		"minimumRequiredVersion": "0.29.0",
+	}}, false, kappConfigFilename)
+	if err != nil {
+		return nil, err
+	}
+	return []resource{config}, nil
+}
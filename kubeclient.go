@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var (
+	// kubeQPS and kubeBurst limit the rate of requests made to the
+	// apiserver. client-go's defaults are used if they are zero.
+	kubeQPS   float32
+	kubeBurst int
+	// kubeRequestTimeout is the timeout of each request made to the
+	// apiserver, or zero for no timeout.
+	kubeRequestTimeout time.Duration
+
+	// impersonateUser, impersonateUID and impersonateGroups are the
+	// identity that requests are made as, if set.
+	impersonateUser   string
+	impersonateUID    string
+	impersonateGroups []string
+
+	// kubeToken and kubeTokenFile are a bearer token used to authenticate
+	// instead of the credentials in the kubeconfig.
+	kubeToken     string
+	kubeTokenFile string
+
+	// execCommand, execArgs and execAPIVersion configure a client-go
+	// credential plugin used to authenticate instead of the credentials in
+	// the kubeconfig.
+	execCommand    string
+	execArgs       []string
+	execAPIVersion string
+)
+
+// restConfig returns the client configuration for the cluster given by
+// --kubeconfig, with the client flags applied.
+func restConfig() (*rest.Config, error) {
+	restcfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes REST client config: %v", err)
+	}
+	if kubeQPS > 0 {
+		restcfg.QPS = kubeQPS
+	}
+	if kubeBurst > 0 {
+		restcfg.Burst = kubeBurst
+	}
+	if kubeRequestTimeout > 0 {
+		restcfg.Timeout = kubeRequestTimeout
+	}
+
+	switch {
+	case kubeToken != "" || kubeTokenFile != "":
+		clearCredentials(restcfg)
+		restcfg.BearerToken, restcfg.BearerTokenFile = kubeToken, kubeTokenFile
+	case execCommand != "":
+		clearCredentials(restcfg)
+		restcfg.ExecProvider = &clientcmdapi.ExecConfig{
+			Command:         execCommand,
+			Args:            execArgs,
+			APIVersion:      execAPIVersion,
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+	}
+
+	if err := applyNetworkConfig(restcfg); err != nil {
+		return nil, err
+	}
+	if impersonateUser != "" || impersonateUID != "" || len(impersonateGroups) > 0 {
+		restcfg.Impersonate = rest.ImpersonationConfig{
+			UserName: impersonateUser,
+			UID:      impersonateUID,
+			Groups:   impersonateGroups,
+		}
+	}
+	return restcfg, nil
+}
+
+// clearCredentials removes the credentials read from the kubeconfig, other
+// than client certificates, so that those given by flags are used instead.
+func clearCredentials(restcfg *rest.Config) {
+	restcfg.BearerToken, restcfg.BearerTokenFile = "", ""
+	restcfg.Username, restcfg.Password = "", ""
+	restcfg.AuthProvider = nil
+	restcfg.ExecProvider = nil
+}
+
+// validateAuthFlags checks that at most one way of authenticating is given by
+// flags.
+func validateAuthFlags() error {
+	if kubeToken != "" && kubeTokenFile != "" {
+		return fmt.Errorf("only one of --token and --token-file may be set")
+	}
+	if execCommand != "" && (kubeToken != "" || kubeTokenFile != "") {
+		return fmt.Errorf("--exec-command cannot be used with --token or --token-file")
+	}
+	if execCommand == "" && len(execArgs) > 0 {
+		return fmt.Errorf("--exec-arg requires --exec-command")
+	}
+	if len(impersonateGroups) > 0 && impersonateUser == "" {
+		return fmt.Errorf("--as-group requires --as, as the apiserver does not allow impersonating groups without a user")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const kustomizationFilename = "kustomization.yaml"
+
+// kustomization is the subset of the kustomize Kustomization type that
+// manifest-splitter generates.
+type kustomization struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Resources  []string `json:"resources,omitempty"`
+}
+
+func newKustomization(resources []string) kustomization {
+	return kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	}
+}
+
+// writeKustomizeEnvironments writes a kustomization.yaml into the 'base'
+// directory within outputDir which references every written file, as well as
+// an overlay directory for each configured environment that uses the base.
+// Existing overlay kustomizations are left untouched so that environment
+// specific patches are not lost when re-running.
+func writeKustomizeEnvironments(outputDir string, written []outputFile) error {
+	seen := make(map[string]bool)
+	var resources []string
+	for _, f := range written {
+		path := filepath.ToSlash(kustomizeResourcePath(f.path))
+		if !seen[path] {
+			seen[path] = true
+			resources = append(resources, path)
+		}
+	}
+	sort.Strings(resources)
+
+	base := filepath.Join(outputDir, "base", kustomizationFilename)
+	log.Printf("Writing kustomize base to: %s", base)
+	if err := writeKustomization(base, newKustomization(resources)); err != nil {
+		return err
+	}
+
+	for _, env := range environments {
+		overlay := filepath.Join(outputDir, "overlays", env, kustomizationFilename)
+		if _, err := outputFS.Stat(overlay); err == nil {
+			log.Printf("Overlay for environment %q already exists, skipping: %s", env, overlay)
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		log.Printf("Writing overlay for environment %q to: %s", env, overlay)
+		if err := mkdirOutput(filepath.Dir(overlay)); err != nil {
+			return fmt.Errorf("error creating overlay directory: %v", err)
+		}
+		if err := writeKustomization(overlay, newKustomization([]string{"../../base"})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKustomization(path string, k kustomization) error {
+	data, err := EncodeYAML(k)
+	if err != nil {
+		return err
+	}
+	if err := writeOutputFile(path, data); err != nil {
+		return fmt.Errorf("error writing kustomization %q: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// kustomizeInputScheme prefixes inputs that are kustomization targets,
+	// built with kustomize rather than read from a file.
+	kustomizeInputScheme = "kustomize://"
+	// kustomizeBinary is the name of the kustomize executable used to build
+	// kustomize:// inputs. If it is not installed, 'kubectl kustomize' is
+	// used instead.
+	kustomizeBinary = "kustomize"
+)
+
+// kustomizeBuildFlags are passed to each 'kustomize build' of a kustomize://
+// input, e.g. --enable-helm.
+var kustomizeBuildFlags []string
+
+// kustomizeDigests records the digest of the output built for each
+// kustomize:// input, as there is no input file to record the digest of.
+var kustomizeDigests = make(map[string]string)
+
+// isKustomizeInput returns true if the given input is a kustomize:// target,
+// e.g. kustomize://github.com/org/repo//path?ref=v1.2 or
+// kustomize://./overlays/prod.
+func isKustomizeInput(input string) bool {
+	return strings.HasPrefix(input, kustomizeInputScheme)
+}
+
+// buildKustomizeInput builds the kustomization target of the given
+// kustomize:// input, which may be a local directory or any remote URL
+// accepted by kustomize, returning the resources it produces.
+func buildKustomizeInput(input string) ([]byte, error) {
+	target := strings.TrimPrefix(input, kustomizeInputScheme)
+	if target == "" {
+		return nil, fmt.Errorf("no kustomization target given")
+	}
+	args := append(append([]string{"build"}, kustomizeBuildFlags...), target)
+
+	log.Printf("Building kustomization %q", target)
+	data, err := runKustomize(kustomizeBinary, args)
+	if _, ok := err.(*exec.Error); ok {
+		args[0] = "kustomize"
+		if data, err = runKustomize("kubectl", args); err != nil {
+			if _, ok := err.(*exec.Error); ok {
+				return nil, fmt.Errorf("%s or kubectl must be installed to build kustomize:// inputs: %v", kustomizeBinary, err)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	kustomizeDigests[input] = contentHash(data)
+	return data, nil
+}
+
+// runKustomize runs the given kustomize binary, returning its output.
+// Failures to run the binary at all are returned as an *exec.Error.
+func runKustomize(binary string, args []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("building kustomization: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+// Recommended labels set on every resource when --standard-labels is set.
+// See https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	partOfLabel    = "app.kubernetes.io/part-of"
+	instanceLabel  = "app.kubernetes.io/instance"
+)
+
+// standardLabelsTransformer sets the recommended labels on resources. Labels
+// with an empty value are not set, and labels already set on a resource are
+// left as they are.
+type standardLabelsTransformer struct {
+	managedBy, partOf, instance string
+}
+
+func (t standardLabelsTransformer) Transform(r *resource) (bool, error) {
+	labels := r.obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	changed := false
+	for key, value := range map[string]string{
+		managedByLabel: t.managedBy,
+		partOfLabel:    t.partOf,
+		instanceLabel:  t.instance,
+	} {
+		if _, ok := labels[key]; ok || value == "" {
+			continue
+		}
+		labels[key] = value
+		changed = true
+	}
+	if changed {
+		r.obj.SetLabels(labels)
+	}
+	return changed, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// objectKey identifies a single object by group, kind, namespace and name.
+type objectKey struct {
+	group, kind, namespace, name string
+}
+
+// objectIndex is an index of all objects in the input files, used to check
+// whether objects referenced by other objects are present.
+type objectIndex struct {
+	objects map[objectKey]*unstructured.Unstructured
+	// namespaces is the set of namespaces that objects are declared in
+	namespaces map[string]bool
+}
+
+func newObjectIndex(files map[string][]resource) *objectIndex {
+	idx := &objectIndex{
+		objects:    make(map[objectKey]*unstructured.Unstructured),
+		namespaces: make(map[string]bool),
+	}
+	for _, resources := range files {
+		for _, r := range resources {
+			if r.obj.IsList() {
+				continue
+			}
+			gvk := r.obj.GroupVersionKind()
+			obj := r.obj
+			if r.partial && lintedGroups[gvk.Group] {
+				full, err := r.fullObject()
+				if err != nil {
+					warnf("Not linting %s %s: %v", gvk.Kind, describeObject(r.obj), err)
+					continue
+				}
+				obj = full
+			}
+			idx.objects[objectKey{group: gvk.Group, kind: gvk.Kind, namespace: r.obj.GetNamespace(), name: r.obj.GetName()}] = obj
+			if ns := r.obj.GetNamespace(); ns != "" {
+				idx.namespaces[ns] = true
+			}
+		}
+	}
+	return idx
+}
+
+func (i *objectIndex) get(group, kind, namespace, name string) (*unstructured.Unstructured, bool) {
+	obj, ok := i.objects[objectKey{group: group, kind: kind, namespace: namespace, name: name}]
+	return obj, ok
+}
+
+// namespacesWith returns the sorted list of namespaces that contain an object
+// with the given group, kind and name.
+func (i *objectIndex) namespacesWith(group, kind, name string) []string {
+	var namespaces []string
+	for key := range i.objects {
+		if key.group == group && key.kind == kind && key.name == name && key.namespace != "" {
+			namespaces = append(namespaces, key.namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// hasKind returns true if the index contains any object with the given group
+// and kind.
+func (i *objectIndex) hasKind(group, kind string) bool {
+	for key := range i.objects {
+		if key.group == group && key.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedObjects returns all objects in the index in a stable order.
+func (i *objectIndex) sortedObjects() []*unstructured.Unstructured {
+	keys := make([]objectKey, 0, len(i.objects))
+	for key := range i.objects {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		ka, kb := keys[a], keys[b]
+		if ka.group != kb.group {
+			return ka.group < kb.group
+		}
+		if ka.kind != kb.kind {
+			return ka.kind < kb.kind
+		}
+		if ka.namespace != kb.namespace {
+			return ka.namespace < kb.namespace
+		}
+		return ka.name < kb.name
+	})
+	objs := make([]*unstructured.Unstructured, len(keys))
+	for n, key := range keys {
+		objs[n] = i.objects[key]
+	}
+	return objs
+}
+
+// lintedGroups are the groups of the resources whose fields other than their
+// header are read by lintResources. Other streamed resources are indexed by
+// their header alone.
+var lintedGroups = map[string]bool{
+	"rbac.authorization.k8s.io":    true,
+	"networking.k8s.io":            true,
+	"extensions":                   true,
+	certManagerGroup:               true,
+	"admissionregistration.k8s.io": true,
+	"apiregistration.k8s.io":       true,
+	gatewayAPIGroup:                true,
+}
+
+// lintResources checks the input resources for common mistakes in references
+// between resources, emitting a warning for each problem found.
+func lintResources(files map[string][]resource) {
+	idx := newObjectIndex(files)
+	for _, obj := range idx.sortedObjects() {
+		gvk := obj.GroupVersionKind()
+		switch {
+		case gvk.Group == "rbac.authorization.k8s.io" && (gvk.Kind == "RoleBinding" || gvk.Kind == "ClusterRoleBinding"):
+			lintBinding(idx, obj)
+		case (gvk.Group == "networking.k8s.io" || gvk.Group == "extensions") && gvk.Kind == "Ingress":
+			lintIngress(idx, obj)
+			lintIssuerAnnotations(idx, obj)
+		case gvk.Group == certManagerGroup && (gvk.Kind == "Certificate" || gvk.Kind == "CertificateRequest"):
+			lintIssuerRef(idx, obj)
+		case gvk.Group == "admissionregistration.k8s.io" && (gvk.Kind == "ValidatingWebhookConfiguration" || gvk.Kind == "MutatingWebhookConfiguration"):
+			lintWebhookConfiguration(idx, obj)
+		case gvk.Group == "apiregistration.k8s.io" && gvk.Kind == "APIService":
+			lintAPIService(idx, obj)
+		case gvk.Group == gatewayAPIGroup && gatewayRouteKinds[gvk.Kind]:
+			lintGatewayRoute(idx, obj)
+		case gvk.Group == gatewayAPIGroup && gvk.Kind == "Gateway":
+			lintGateway(idx, obj)
+			lintIssuerAnnotations(idx, obj)
+		}
+	}
+}
+
+func lintBinding(idx *objectIndex, obj *unstructured.Unstructured) {
+	ns := obj.GetNamespace()
+	subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+	for _, s := range subjects {
+		subject, ok := s.(map[string]interface{})
+		if !ok || subject["kind"] != "ServiceAccount" {
+			continue
+		}
+		name, _ := subject["name"].(string)
+		subjectNS, _ := subject["namespace"].(string)
+		switch {
+		case subjectNS == "":
+			warnf("%s %s references ServiceAccount %q without specifying its namespace", obj.GetKind(), describeObject(obj), name)
+			continue
+		case ns != "" && subjectNS != ns:
+			warnf("%s %s grants access to ServiceAccount %s/%s in another namespace", obj.GetKind(), describeObject(obj), subjectNS, name)
+		}
+		if name != "default" && idx.namespaces[subjectNS] {
+			if _, ok := idx.get("", "ServiceAccount", subjectNS, name); !ok {
+				warnf("%s %s references ServiceAccount %s/%s which is not defined in the input files", obj.GetKind(), describeObject(obj), subjectNS, name)
+			}
+		}
+	}
+
+	// a RoleBinding may only reference a Role in its own namespace
+	roleKind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind")
+	roleName, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+	if roleKind == "Role" && ns != "" {
+		if _, ok := idx.get("rbac.authorization.k8s.io", "Role", ns, roleName); !ok {
+			if others := idx.namespacesWith("rbac.authorization.k8s.io", "Role", roleName); len(others) > 0 {
+				warnf("%s %s references Role %q which is only defined in other namespaces %v", obj.GetKind(), describeObject(obj), roleName, others)
+			}
+		}
+	}
+}
+
+func lintIngress(idx *objectIndex, obj *unstructured.Unstructured) {
+	ns := obj.GetNamespace()
+	for _, name := range ingressServiceNames(obj) {
+		if _, ok := idx.get("", "Service", ns, name); !ok {
+			warnf("Ingress %s references Service %q which is not defined in the input files for namespace %q", describeObject(obj), name, ns)
+		}
+	}
+}
+
+// lintWebhookConfiguration checks that the Services called by each webhook
+// are defined in the input files, as a webhook whose Service is missing will
+// cause matching API requests to fail (or be silently allowed).
+func lintWebhookConfiguration(idx *objectIndex, obj *unstructured.Unstructured) {
+	webhooks, _, _ := unstructured.NestedSlice(obj.Object, "webhooks")
+	for _, w := range webhooks {
+		webhook, ok := w.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		service, ok, _ := unstructured.NestedMap(webhook, "clientConfig", "service")
+		if !ok {
+			// webhooks called by URL are not checked
+			continue
+		}
+		name, _ := webhook["name"].(string)
+		lintServiceReference(idx, fmt.Sprintf("%s %s webhook %q", obj.GetKind(), describeObject(obj), name), service)
+	}
+}
+
+// lintAPIService checks that the Service backing an aggregated API is
+// defined in the input files, as a missing Service makes the API, and API
+// discovery for the whole cluster, fail.
+func lintAPIService(idx *objectIndex, obj *unstructured.Unstructured) {
+	service, ok, _ := unstructured.NestedMap(obj.Object, "spec", "service")
+	if !ok {
+		// APIServices without a Service are served by the apiserver itself
+		return
+	}
+	lintServiceReference(idx, fmt.Sprintf("APIService %s", describeObject(obj)), service)
+}
+
+// lintServiceReference checks that the Service referenced by a webhook or
+// APIService, and its namespace, are defined in the input files.
+func lintServiceReference(idx *objectIndex, referrer string, service map[string]interface{}) {
+	ns, _ := service["namespace"].(string)
+	name, _ := service["name"].(string)
+	if _, ok := idx.get("", "Namespace", "", ns); !ok && !idx.namespaces[ns] {
+		warnf("%s references Service %s/%s in namespace %q, which is not defined in the input files", referrer, ns, name, ns)
+		return
+	}
+	if _, ok := idx.get("", "Service", ns, name); !ok {
+		warnf("%s references Service %s/%s which is not defined in the input files", referrer, ns, name)
+	}
+}
+
+// ingressServiceNames returns the names of all Services referenced by the
+// backends of the given Ingress, supporting both the v1 and v1beta1 schemas.
+func ingressServiceNames(obj *unstructured.Unstructured) []string {
+	seen := make(map[string]bool)
+	var names []string
+	addBackend := func(backend map[string]interface{}) {
+		name, _, _ := unstructured.NestedString(backend, "service", "name")
+		if name == "" {
+			name, _, _ = unstructured.NestedString(backend, "serviceName")
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, field := range []string{"defaultBackend", "backend"} {
+		if backend, ok, _ := unstructured.NestedMap(obj.Object, "spec", field); ok {
+			addBackend(backend)
+		}
+	}
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		paths, _, _ := unstructured.NestedSlice(rule, "http", "paths")
+		for _, p := range paths {
+			path, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if backend, ok := path["backend"].(map[string]interface{}); ok {
+				addBackend(backend)
+			}
+		}
+	}
+	return names
+}
+
+// describeObject returns a human readable 'namespace/name' reference to the
+// given object, or just its name if it is cluster scoped.
+func describeObject(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runLintLayout implements the 'lint-layout' subcommand, which checks that
+// an existing config directory, e.g. one that has been edited by hand, is laid
+// out as the splitter would write it using the current flags. Each misplaced
+// file is reported, and an error is returned if any are found.
+func runLintLayout(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: manifest-splitter lint-layout <config directory>")
+	}
+	root := args[0]
+	paths, err := layoutFiles(root)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	report := func(path, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", filepath.ToSlash(path), fmt.Sprintf(format, args...)))
+	}
+
+	files := make(map[string][]resource)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		resources, err := decodeResourceManifest(path, bytes.NewReader(data))
+		if err != nil {
+			report(path, "failed to decode: %v", err)
+			continue
+		}
+		if len(resources) > 1 {
+			report(path, "contains %d resources, but each resource should be in its own file", len(resources))
+		}
+		files[path] = resources
+	}
+
+	inspector, err := newResourceInspector()
+	if err != nil {
+		return err
+	}
+	if err := populateNamespacedField(inspector, files); err != nil {
+		return fmt.Errorf("discovering whether resources are namespaced: %v", err)
+	}
+	for path, resources := range files {
+		for i := range resources {
+			r := &resources[i]
+			switch {
+			case r.obj.IsList():
+				if err := validateResourceList(r); err != nil {
+					report(path, "%v", err)
+				}
+			case isAllNamespaces(r.obj):
+				if !r.namespaced {
+					report(path, "%s %q is cluster scoped, but is annotated with %s", r.obj.GetKind(), r.obj.GetName(), allNamespacesAnnotation)
+				}
+			case r.namespaced && r.obj.GetNamespace() == "":
+				report(path, "%s %q is namespaced, but does not set metadata.namespace", r.obj.GetKind(), r.obj.GetName())
+			case !r.namespaced && r.obj.GetNamespace() != "":
+				report(path, "%s %q is cluster scoped, but sets metadata.namespace", r.obj.GetKind(), r.obj.GetName())
+				r.obj.SetNamespace("")
+			}
+		}
+	}
+
+	if hnc {
+		parents, err := discoverNamespaceHierarchy(files)
+		if err != nil {
+			return fmt.Errorf("discovering namespace hierarchy: %v", err)
+		}
+		namespaceParents = parents
+	}
+	detectAmbiguousKinds(files)
+	outputs := groupOutputs(files)
+	dirs, err := renderNamespaceDirs(outputs)
+	if err != nil {
+		return fmt.Errorf("computing namespace directories: %v", err)
+	}
+	namespaceDirs = dirs
+	if err := routeResources(outputs); err != nil {
+		return err
+	}
+	for ns, resources := range outputs {
+		for _, r := range resources {
+			if ns == "" && r.namespaced && !r.obj.IsList() && !isAllNamespaces(r.obj) {
+				// already reported as missing its namespace
+				continue
+			}
+			expected := filepath.Join(resourceDir(r, ns), resourceFilename(r))
+			if !samePath(expected, r.inputFilename) {
+				report(r.inputFilename, "%s %s should be located at %s", r.obj.GetKind(), describeObject(r.obj), filepath.ToSlash(expected))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%d files are laid out correctly\n", len(paths))
+		return nil
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return fmt.Errorf("found %d layout problems in %d files", len(problems), len(paths))
+}
+
+// layoutFiles returns the paths, relative to root, of all manifest files in
+// the given config directory. Hidden files and directories, such as .git and
+// the state file, and kustomization and provenance files are ignored.
+func layoutFiles(root string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if path != root && strings.HasPrefix(name, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if rel, err := filepath.Rel(root, path); err == nil && filepath.ToSlash(rel) == crdDocsDir {
+				// CRD schemas written by --crd-docs are not manifests
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(name) {
+		case "kustomization.yaml", "kustomization.yml", "kustomization", provenanceFilename:
+			return nil
+		}
+		switch filepath.Ext(name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	return paths, err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,31 +11,216 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	flag "github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
-	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/yaml"
 
+	"github.com/munnerz/manifest-splitter/api/config/v1alpha1"
 	"github.com/munnerz/manifest-splitter/discovery"
+	"github.com/munnerz/manifest-splitter/outputfs"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
 
 var (
 	kubeconfig  string
+	scopeFile   string
 	outputDir   string
 	expandLists bool
 
+	layout        string
+	clusterLayout string
+	environments  []string
+
+	verifyRoundTrip bool
+	pruneEmpty      bool
+
+	normalizeFileWhitespace bool
+	yamlAliases             string
+	onInvalid               string
+
+	initACM                 bool
+	initACMHierarchyConfig  bool
+	hnc                     bool
+	lint                    bool
+	namespaceDirTmpl        string
+	generateQuotas          bool
+	generateNetpol          bool
+	showProgress            bool
+	eventsOutput            string
+	renderEngine            string
+	valuesFiles             []string
+	setValues               []string
+	jsonnetPaths            []string
+	jsonnetExtStrs          []string
+	jsonnetExtCodes         []string
+	composeNamespace        string
+	gitCommit               bool
+	gitMessageTmpl          string
+	gitBranch               string
+	gitRemote               string
+	pullRequestProvider     string
+	pullRequestBase         string
+	pullRequestAPIURL       string
+	onlyFrom                []string
+	changedSince            string
+	useState                bool
+	cpuProfile              string
+	memProfile              string
+	traceOutput             string
+	stripAnnotations        []string
+	skipOwned               bool
+	skipSystem              bool
+	includeKinds            []string
+	excludeKinds            []string
+	preferGroups            []string
+	force                   bool
+	keepGoing               bool
+	createOutputDir         bool
+	helmfileEnvs            []string
+	ytt                     bool
+	yttDataValues           []string
+	forbidCluster           bool
+	allNamespacesAnnotation string
+	fanOutNamespaces        []string
+	fanOutNamespacesFile    string
+	injectConfigHash        bool
+	standardLabels          bool
+	managedBy               string
+	partOf                  string
+	instance                string
+
 	scheme = runtime.NewScheme()
 )
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a KUBECONFIG file used to lookup discovery information")
-	flag.StringVar(&outputDir, "output", "config/", "Path to a directory where output files will be written")
+	flag.Float32Var(&kubeQPS, "qps", 0, "Maximum queries per second made to the apiserver. Defaults to client-go's default of 5 if zero")
+	flag.IntVar(&kubeBurst, "burst", 0, "Maximum burst of queries made to the apiserver above --qps. Defaults to client-go's default of 10 if zero")
+	flag.DurationVar(&kubeRequestTimeout, "request-timeout", 0, "Timeout of each request made to the apiserver, e.g. 30s. Zero means no timeout")
+	flag.StringVar(&impersonateUser, "as", "", "Username to impersonate for requests to the apiserver")
+	flag.StringVar(&impersonateUID, "as-uid", "", "UID to impersonate for requests to the apiserver")
+	flag.StringArrayVar(&impersonateGroups, "as-group", nil, "Group to impersonate for requests to the apiserver. May be given more than once")
+	flag.StringVar(&kubeToken, "token", "", "Bearer token used to authenticate to the apiserver, instead of the credentials in the kubeconfig")
+	flag.StringVar(&kubeTokenFile, "token-file", "", "Path to a file containing a bearer token used to authenticate to the apiserver, re-read as it changes, e.g. a projected service account token")
+	flag.StringVar(&execCommand, "exec-command", "", "Command run as a client-go credential plugin to authenticate to the apiserver, instead of the credentials in the kubeconfig")
+	flag.StringArrayVar(&execArgs, "exec-arg", nil, "Argument passed to --exec-command. May be given more than once")
+	flag.StringVar(&execAPIVersion, "exec-api-version", "client.authentication.k8s.io/v1beta1", "API version of the ExecCredential exchanged with --exec-command")
+	flag.StringVar(&proxyURL, "proxy-url", "", "URL of a proxy that requests to the apiserver, object stores and pull request APIs are made through. Defaults to $HTTPS_PROXY or $HTTP_PROXY, excluding hosts in $NO_PROXY")
+	flag.StringVar(&caBundle, "ca-bundle", "", "Path to a file of PEM encoded CA certificates trusted for requests to the apiserver, object stores and pull request APIs, in addition to the system roots, or to the kubeconfig's CA if it has one")
+	flag.StringVar(&scopeFile, "scope-file", "", "Path to a YAML file listing resource types and whether each is namespaced, used instead of discovery information from a cluster")
+	flag.StringVar(&configFile, "config", "", "Path to a "+v1alpha1.Kind+" config file configuring transformations and filters. Flags set on the command line take precedence over the config file")
+	flag.StringArrayVar(&kustomizeBuildFlags, "kustomize-build-flag", nil, "Flag passed to 'kustomize build' when building kustomize:// inputs, e.g. --enable-helm. May be given more than once")
+	flag.StringVar(&outputDir, "output", "config/", "Path to a directory where output files will be written, an s3://bucket/prefix or gs://bucket/prefix URL that output files are uploaded to, or a configmap://namespace/prefix or secret://namespace/prefix URL of ConfigMaps or Secrets written to the --kubeconfig cluster")
+	flag.BoolVar(&keepGoing, "keep-going", false, "If true, input files that cannot be read or decoded are skipped, and the remaining inputs are still split. The skipped files are reported, and the command fails, once complete")
+	flag.BoolVar(&dryRun, "dry-run", false, "If true, no changes are made to the output directory. Combine with --report-html to review the changes that would be made")
+	flag.StringVar(&reportHTML, "report-html", "", "If set, a standalone HTML report of the changes made to the output directory, warnings and statistics is written to this file")
+	flag.StringVar(&backupDir, "backup-dir", "", "If set, output files are copied into a timestamped directory within this directory before they are overwritten or deleted")
+	flag.BoolVar(&force, "force", false, "If true, output is written even if --output is the filesystem root, the home directory or the root of a git repository")
+	flag.BoolVar(&createOutputDir, "create-output-dir", true, "If false, the --output directory must already exist")
 	flag.BoolVar(&expandLists, "expand-lists", true, "if true, List-like resources will be expanded into multiple YAML files")
+	flag.StringSliceVar(&environments, "environments", nil, "If set, output is written as a kustomize base with an overlay directory for each of the named environments (e.g. dev,stage,prod)")
+	flag.BoolVar(&verifyRoundTrip, "verify-roundtrip", false, "If true, every written output file is re-read and compared to the resource it was generated from, failing if any data was lost or changed")
+	flag.BoolVar(&pruneEmpty, "prune-empty", false, "If true, null values, empty strings and empty maps and lists that serve no purpose are removed from output resources")
+	flag.BoolVar(&normalizeFileWhitespace, "normalize-whitespace", false, "If true, output files are written with LF line endings, no leading '---' separator and exactly one trailing newline")
+	flag.BoolVar(&preserveScalarTypes, "preserve-scalar-types", true, "If true, fields of re-encoded resources whose type does not match their schema, such as an annotation value of 'on' read as a YAML 1.1 boolean, or a containerPort of \"8080\", are written with the type of their schema. Schemas are taken from CRDs in the inputs and --openapi-schema")
+	flag.StringArrayVar(&openAPISchemaFiles, "openapi-schema", nil, "Path to an OpenAPI document describing built-in resource types, e.g. the output of 'kubectl get --raw /openapi/v2', used by --preserve-scalar-types. May be given more than once")
+	flag.IntVar(&yamlIndent, "yaml-indent", 2, "Number of spaces each level of YAML output is indented by")
+	flag.IntVar(&yamlFlowLists, "yaml-flow-lists", 0, "If greater than zero, lists of at most this many scalars are written in flow style, e.g. '[a, b]', rather than one item per line")
+	flag.StringVar(&yamlQuote, "yaml-quote", yamlQuoteRequired, "Which strings in YAML output are quoted. One of 'required' (strings that would otherwise be read as another type, including YAML 1.1 booleans such as 'on' and 'off'), 'ambiguous' (also strings that look like versions, such as '1.2.3') or 'all' (every string value)")
+	flag.StringVar(&yamlQuoteChar, "yaml-quote-char", yamlQuoteDouble, "The quotes used for quoted strings in YAML output. One of 'double' or 'single'")
+	flag.StringVar(&yamlAliases, "yaml-aliases", yamlAliasesExpand, "How YAML anchors, aliases and merge keys in input documents are handled. One of 'expand' (re-encode the document with references expanded) or 'error'")
+	flag.StringVar(&onInvalid, "on-invalid", onInvalidSkip, "How input documents that are not Kubernetes resources (missing apiVersion or kind) are handled. One of 'error', 'warn' or 'skip'")
+	flag.StringVar(&acmFormat, "acm-format", acmFormatHierarchy, "The format of ACM repository written. One of 'hierarchy' (cluster/, namespaces/ and system/ directories) or 'unstructured' (a top level directory per namespace and cluster/, for ACM's unstructured mode)")
+	flag.BoolVar(&explodeWorkloads, "explode-workloads", false, "If true, workloads with more than one container are written as a kustomization in their own directory, with a patch file per container, so that changes to individual containers are easier to review. The output must then be built with kustomize")
+	flag.BoolVar(&acmValidate, "acm-validate", false, "If true, the output is checked against the structural constraints of a hierarchical ACM repository, such as each namespace directory containing exactly one Namespace, before it is written, and the run fails if any are violated")
+	flag.StringArrayVar(&postHooks, "post-hook", nil, "A command run using 'sh -c' within the output directory once it has been written, failing the run if it fails. May be given more than once. 'nomos-vet' runs nomos vet against the output directory")
+	flag.StringVar(&patchesDir, "patches-dir", "", "Path to a directory of local patches to input resources. Each resource in the directory is merged into the input resource with the same apiVersion group, kind, namespace and name before splitting, as a JSON merge patch")
+	flag.StringVar(&upgradeFrom, "upgrade-from", "", "Path to the previous version of the input manifests, as a file or directory. If set with --patches-dir, fields set by patches that have also changed upstream since this version are reported as conflicts, failing the run")
+	flag.IntVar(&writeConcurrency, "write-concurrency", 1, "Number of output files written, or uploaded to an object store, at once. Raising this speeds up writing to network filesystems and object stores, where each write has a high latency")
+	flag.IntVar(&streamThreshold, "stream-threshold", 1<<20, "Size in bytes above which documents in plain YAML and JSON input files, such as large ConfigMaps or CRDs, are held in memory by their header alone, and copied from their input file when written if unmodified. 0 disables this")
+	flag.BoolVar(&resume, "resume", false, "If true, output files that were written by a previous run with the same arguments and inputs that failed partway are not written again, continuing from the point of failure")
+	flag.BoolVar(&writeProvenanceFile, "provenance", false, "If true, a "+provenanceFilename+" file recording the digest of each input file, and the git repository and commit it was read from, is written to the output directory")
+	flag.BoolVar(&sign, "sign", false, "If true, a "+checksumsFilename+" file listing the SHA-256 checksum of every output file is written to the output directory")
+	flag.StringVar(&signKey, "sign-key", "", "If set with --sign, the checksums file is signed using 'cosign sign-blob' with this key reference, writing "+signatureFilename+". If \""+signKeyless+"\", Sigstore keyless signing is used and the certificate is also written to "+certificateFilename)
+	flag.StringVar(&dedupeDir, "dedupe-dir", "", "If set, namespaced resources that are identical apart from their namespace in more than one namespace are written once, without a namespace, into this directory within the output directory, and symlinked into each namespace's directory")
+	flag.BoolVar(&initACM, "init-acm", false, "If true, a system/repo.yaml ACM Repo resource is generated if one is not present in the input files")
+	flag.BoolVar(&initACMHierarchyConfig, "init-acm-hierarchy-config", false, "If true and --init-acm is set, a system/hierarchyconfig.yaml ACM HierarchyConfig resource is also generated if one is not present in the input files")
+	flag.BoolVar(&hnc, "hnc", false, "If true, namespace directories are nested beneath their parent namespace's directory, as declared by Hierarchical Namespace Controller SubnamespaceAnchor and HierarchyConfiguration resources and namespace tree labels")
+	flag.StringVar(&namespaceDirTmpl, "namespace-dir-template", "{{.Path}}", "Go template used to compute each namespace's directory within namespaces/. Available fields are .Namespace, .Path, .Labels and .Annotations, e.g. '{{index .Labels \"team\"}}/{{.Namespace}}'")
+	flag.BoolVar(&lint, "lint", true, "If true, input resources are checked for common mistakes in references between resources, such as bindings to ServiceAccounts in other namespaces, and warnings are reported")
+	flag.BoolVar(&generateQuotas, "generate-quotas", false, "If true, a template ResourceQuota and LimitRange are generated in each namespace that does not already have one, with quota values derived from the requests and limits of the namespace's workloads")
+	flag.IntVar(&quotaHeadroom, "quota-headroom", 25, "Percentage that quotas generated by --generate-quotas exceed the requests and limits of the namespace's workloads by, leaving room for the extra pods created during rolling updates. Defaults to the default maxSurge of a Deployment")
+	flag.BoolVar(&meshDirs, "mesh-dirs", false, "If true, Istio and Linkerd resources whose effects reach beyond their own namespace, such as policies in the Istio root namespace and Istio Gateways, are written to mesh/istio/<namespace> and mesh/linkerd/<namespace>, and a warning is reported for each")
+	flag.StringVar(&istioRootNamespace, "istio-root-namespace", "istio-system", "Root namespace of the Istio mesh, used by --mesh-dirs")
+	flag.StringVar(&linkerdNamespace, "linkerd-namespace", "linkerd", "Namespace of the Linkerd control plane, used by --mesh-dirs")
+	flag.BoolVar(&stripStatus, "strip-status", true, "If true, .status is removed from custom resources whose CustomResourceDefinition in the inputs declares a status subresource, as it is written by controllers rather than being desired state")
+	flag.BoolVar(&crdDocs, "crd-docs", false, "If true, the openAPIV3Schema of each version of each CRD in the inputs is written to docs/crds/<group>/<kind>_<version>.json in the output directory")
+	flag.BoolVar(&crdDocsMarkdown, "crd-docs-markdown", false, "If true, a markdown description of the fields of each CRD is also written to docs/crds/<group>/<kind>.md. Requires --crd-docs")
+	flag.StringVar(&ownersMapFile, "owners-map", "", "Path to a YAML file giving the owners of namespaces and cluster scoped resources. If set, a CODEOWNERS file is written assigning them the corresponding output directories")
+	flag.StringVar(&ownersLabel, "owners-label", "", "Label on Namespace resources whose value names the team that owns the namespace. If set, a CODEOWNERS file is written assigning each team its namespace directories")
+	flag.StringVar(&ownersLabelPrefix, "owners-label-prefix", "@", "Prefix added to the value of the --owners-label label to form an owner, e.g. '@my-org/', unless the --owners-map lists owners for the value under teams")
+	flag.StringVar(&codeownersPath, "codeowners-path", "CODEOWNERS", "Path of the CODEOWNERS file written by --owners-map or --owners-label, relative to the output directory, e.g. '.github/CODEOWNERS'")
+	flag.BoolVar(&generateNetpol, "generate-default-netpol", false, "If true, a default-deny NetworkPolicy and an allow-dns NetworkPolicy are generated in each namespace that does not already have them")
+	flag.BoolVar(&showProgress, "progress", false, "If true, progress is periodically reported while decoding, discovering and writing resources, and the time taken by each phase is printed once complete")
+	flag.StringVar(&serveAddress, "serve-address", ":8080", "Address that the 'serve' subcommand listens on")
+	flag.StringVar(&serveTLSCertFile, "serve-tls-cert-file", "", "Path to a PEM encoded certificate, including any intermediates, that the 'serve' subcommand serves HTTPS with. Requires --serve-tls-key-file")
+	flag.StringVar(&serveTLSKeyFile, "serve-tls-key-file", "", "Path to the PEM encoded private key of --serve-tls-cert-file")
+	flag.StringVar(&metricsFile, "metrics-file", "", "If set, statistics about the run are written to this file in the Prometheus textfile collector format")
+	flag.StringVar(&eventsOutput, "events-output", "", "If set to 'json', an event is written to stdout as a line of JSON for each resource decoded, classified, written or skipped, and for each warning")
+	flag.StringVar(&renderEngine, "render", "", "If set, input files are rendered before they are decoded. One of 'go' (Go templates, with values available as .Values) or 'envsubst' (${VAR} substitution from values and the environment). Defaults to 'go' if --values or --set are given")
+	flag.StringSliceVar(&valuesFiles, "values", nil, "YAML files containing values used to render input files, merged in order")
+	flag.StringArrayVar(&setValues, "set", nil, "A key=value pair used to render input files, taking precedence over --values. Keys may be dotted paths, e.g. image.tag=v1")
+	flag.StringSliceVar(&jsonnetPaths, "jpath", nil, "Library search paths used when evaluating .jsonnet and .libsonnet input files")
+	flag.StringArrayVar(&jsonnetExtStrs, "ext-str", nil, "A key=value external string variable used when evaluating Jsonnet input files")
+	flag.StringArrayVar(&jsonnetExtCodes, "ext-code", nil, "A key=<code> external code variable used when evaluating Jsonnet input files")
+	flag.StringVar(&composeNamespace, "compose-namespace", "default", "Namespace of the resources converted from Docker Compose input files")
+	flag.Var(fileModeFlag, "file-mode", "Permission mode, in octal, of output files. If not set, files are created with mode 0666 less the umask")
+	flag.Var(dirModeFlag, "dir-mode", "Permission mode, in octal, of created output directories. If not set, directories are created with mode 0777 less the umask")
+	flag.StringVar(&outputOwner, "owner", "", "If set, output files and created directories are owned by the given user[:group]")
+	flag.BoolVar(&atomicWrites, "atomic", false, "If true, output files are written to temporary files and only moved into place once all output has been written (and verified, if --verify-roundtrip is set), so that a failed run leaves the output directory unchanged")
+	flag.BoolVar(&gitCommit, "git-commit", false, "If true, changes within the output directory are staged and committed to the git repository containing it after a successful run")
+	flag.StringVar(&gitMessageTmpl, "git-message-template", "Update manifests from {{len .Inputs}} input files", "Go template used to render the --git-commit message. Available fields are .Inputs, .Files, .Namespaces and .Time")
+	flag.StringVar(&gitBranch, "git-branch", "", "If set with --git-commit, the given branch is checked out (and created if it does not exist) before output is written")
+	flag.StringVar(&gitNamespaceBranches, "git-namespace-branches", "", "If set with --git-commit, the output files of each namespace are also committed to a branch of their own, named by this Go template, e.g. 'tenants/{{.Namespace}}'. Branches that do not exist are created without history")
+	flag.StringVar(&pullRequestProvider, "pull-request", "", "If set with --git-commit and --git-branch, the branch is pushed to --git-remote and a pull request is opened using the given provider's API. One of 'github' (using $GITHUB_TOKEN) or 'gitlab' (using $GITLAB_TOKEN)")
+	flag.StringVar(&gitRemote, "git-remote", "origin", "The git remote that branches are pushed to with --pull-request")
+	flag.StringVar(&pullRequestBase, "pull-request-base", "", "The branch that pull requests opened with --pull-request target. Defaults to the branch checked out before --git-branch")
+	flag.StringVar(&pullRequestAPIURL, "pull-request-api-url", "", "The API URL used with --pull-request, for GitHub Enterprise or self-hosted GitLab. Defaults to the public API of the provider")
+	flag.StringSliceVar(&onlyFrom, "only-from", nil, "If set, only the output files of resources read from the given input files are written. All inputs are still read, so that references between them are resolved")
+	flag.StringVar(&changedSince, "changed-since", "", "If set, only the output files of resources read from input files that differ from the given git revision, or are untracked, are written")
+	flag.BoolVar(&useState, "state", false, "If true, the output files written are recorded with content hashes in "+stateFilename+" in the output directory. On subsequent runs, unchanged files are not rewritten and files whose resources were removed from the inputs are deleted")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "If set, a CPU profile is written to the given file")
+	flag.StringVar(&memProfile, "memprofile", "", "If set, a heap profile is written to the given file once the run completes")
+	flag.StringVar(&traceOutput, "trace", "", "If set, an execution trace is written to the given file")
+	flag.StringSliceVar(&stripAnnotations, "strip-annotations", defaultStripAnnotations, "Annotations removed from output resources. Patterns ending in '*' match all annotations with the given prefix. Set to an empty string to keep all annotations")
+	flag.BoolVar(&skipOwned, "skip-owned", false, "If true, resources with ownerReferences, such as ReplicaSets and Pods created by controllers, are excluded from the output. Useful when splitting an export of a cluster")
+	flag.StringSliceVar(&includeKinds, "include-kinds", nil, "If set, only resources of these types are included in the output. Types may be given as kinds, resource names, short names or categories, optionally qualified by group, as accepted by kubectl (e.g. deploy,cm,ingresses.networking.k8s.io)")
+	flag.StringSliceVar(&excludeKinds, "exclude-kinds", nil, "Resources of these types are excluded from the output. Types are given as for --include-kinds")
+	flag.StringSliceVar(&preferGroups, "prefer-group", nil, "API groups, in order of preference, used to resolve types given to --include-kinds and --exclude-kinds without a group that match kinds in more than one group. Use 'core' for the core group")
+	flag.BoolVar(&skipSystem, "skip-system", false, "If true, resources managed by the cluster itself, such as Events, EndpointSlices, Leases and Nodes, are excluded from the output. Useful when splitting an export of a cluster")
+	flag.StringSliceVar(&helmfileEnvs, "helmfile-environments", nil, "The environments that helmfile.yaml inputs are rendered for. Defaults to all environments declared in each Helmfile")
+	flag.BoolVar(&ytt, "ytt", false, "If true, input files are evaluated together as Carvel ytt templates before being split")
+	flag.StringSliceVar(&yttDataValues, "ytt-data-values", nil, "Data values files passed to ytt when --ytt is set")
+	flag.StringVar(&layout, "layout", layoutACM, "The layout of the output directory. One of 'acm', 'kapp' (which also annotates resources with kapp change groups and rules, and generates an app.yml kapp Config) or 'group' (a directory per API group within each namespace's directory)")
+	flag.BoolVar(&forbidCluster, "forbid-cluster-scoped", false, "If true, splitting fails if the inputs contain namespaced resources along with any cluster scoped resources other than Namespaces, e.g. when reviewing a single tenant's configuration")
+	flag.StringVar(&allNamespacesAnnotation, "all-namespaces-annotation", "manifest-splitter.io/all-namespaces", "Namespaced resources with this annotation set to \"true\" are written once into the namespaces/ abstract namespace directory, so that ACM applies them to every namespace, rather than into a single namespace's directory")
+	flag.StringSliceVar(&fanOutNamespaces, "fan-out-namespaces", nil, "Namespaces that resources annotated with "+fanOutAnnotation+"=true are replicated into")
+	flag.StringVar(&fanOutNamespacesFile, "fan-out-namespaces-file", "", "A file listing, one per line, namespaces that resources annotated with "+fanOutAnnotation+"=true are replicated into, in addition to --fan-out-namespaces")
+	flag.BoolVar(&injectConfigHash, "inject-config-hash", false, "If true, the pod template of each workload is annotated with "+configHashAnnotation+", a hash of the ConfigMaps and Secrets in the inputs that it uses, so that changes to them roll out the workload")
+	flag.StringVar(&configHashKeyFile, "config-hash-key-file", "", "Path to a file containing a secret key that --inject-config-hash computes hashes as an HMAC with. Secret data only contributes to config hashes if it is set, so that it cannot be guessed from the hashes written to the output")
+	flag.BoolVar(&standardLabels, "standard-labels", false, "If true, the recommended app.kubernetes.io/managed-by, part-of and instance labels are set on every resource that does not already set them")
+	flag.StringVar(&managedBy, "managed-by", "manifest-splitter", "Value of the app.kubernetes.io/managed-by label set by --standard-labels")
+	flag.StringVar(&partOf, "part-of", "", "Value of the app.kubernetes.io/part-of label set by --standard-labels. The label is not set if empty")
+	flag.StringVar(&instance, "instance", "", "Value of the app.kubernetes.io/instance label set by --standard-labels. The label is not set if empty")
+	flag.StringVar(&clusterLayout, "cluster-layout", clusterLayoutFlat, "How cluster scoped resources are organized within the cluster/ directory. One of 'flat', 'kind' or 'group'")
 }
 
 // manifest-splitter ingests Kubernetes manifest files and outputs a directory
@@ -47,95 +233,611 @@ func init() {
 func main() {
 	flag.Parse()
 
-	restcfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		log.Fatalf("Failed to build kubernetes REST client config: %v", err)
+	if err := loadConfigFile(); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := validateFlags(); err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	configureTransformers()
+	if showProgress || metricsFile != "" {
+		progress = &progressReporter{quiet: !showProgress}
+	}
+	if eventsOutput == eventsOutputJSON {
+		setEventsOutput(os.Stdout)
 	}
 
-	inspector, err := discovery.NewAPIServerResourceInspector(restcfg)
+	if err := startProfiling(); err != nil {
+		fatalf("Error starting profiling: %v", err)
+	}
+	defer stopProfiling()
+
+	if flag.NArg() > 0 {
+		if cmd, ok := subcommands[flag.Arg(0)]; ok {
+			if err := cmd(flag.Args()[1:]); err != nil {
+				fatalf("Error running %s: %v", flag.Arg(0), err)
+			}
+			return
+		}
+	}
+
+	inspector, err := newResourceInspector()
 	if err != nil {
-		log.Fatalf("Failed to construct APIServer backed resource inspector: %v", err)
+		fatalf("Error configuring discovery: %v", err)
+	}
+	if err := resolveKindFilters(inspector); err != nil {
+		fatalf("Error resolving kind filters: %v", err)
 	}
 
-	// accumulated map of input filename to sets of resources
-	files := make(map[string][]resource)
-	inputs := flag.Args()
-	for _, input := range inputs {
-		log.Printf("Reading input file %q", input)
-		// begin code that needs repeating
-		r, err := os.Open(input)
+	// Helmfile inputs are rendered and split once per environment, along
+	// with all other inputs
+	helmfiles, inputs := partitionHelmfiles(flag.Args())
+	var files map[string][]resource
+	if len(helmfiles) == 0 {
+		progress.begin("decode", len(inputs), "files")
+		files, err = readInputs(inputs)
 		if err != nil {
-			log.Fatalf("Failed to read input file: %v", err)
+			fatalf("Failed to read input files: %v", err)
+		}
+		if err := applyPatches(files); err != nil {
+			fatalf("Error applying patches: %v", err)
 		}
+	} else if patchesDir != "" {
+		fatalf("--patches-dir cannot be used with Helmfile inputs")
+	}
+
+	// output to an object store or cluster is split into memory and
+	// published once complete
+	var remoteOutputFS *outputfs.Mem
+	if objectStoreOutput != nil || configMapOutput != nil {
+		remoteOutputFS = outputfs.NewMem()
+		outputFS = remoteOutputFS
+	} else if err := checkOutputDir(outputDir); err != nil {
+		fatalf("Error checking output directory: %v", err)
+	}
+
+	if err := selectInputs(flag.Args()); err != nil {
+		fatalf("Error selecting input files: %v", err)
+	}
+
+	if gitCommit && gitBranch != "" {
+		if pullRequestProvider != "" && pullRequestBase == "" {
+			base, err := runGit(outputDir, "rev-parse", "--abbrev-ref", "HEAD")
+			if err != nil {
+				fatalf("Error determining pull request base branch: %v", err)
+			}
+			pullRequestBase = base
+		}
+		if err := checkoutGitBranch(outputDir, gitBranch); err != nil {
+			fatalf("Error checking out git branch: %v", err)
+		}
+	}
 
-		resources, err := decodeResourceManifest(input, r)
+	if useState {
+		state, err := loadState(outputDir)
 		if err != nil {
-			log.Fatalf("Failed to decode input file: %v", err)
+			fatalf("Error loading state: %v", err)
 		}
+		previousState = state
+	}
 
-		log.Printf("Found %d resources in file %q", len(resources), input)
-		files[input] = resources
+	if err := startJournal(outputDir, flag.Args()); err != nil {
+		fatalf("Error starting journal: %v", err)
 	}
 
-	if err := populateNamespacedField(inspector, files); err != nil {
-		log.Fatalf("Error discovering whether resources are namespaced: %v", err)
+	var outputs map[string][]resource
+	var written []outputFile
+	if len(helmfiles) > 0 {
+		outputs, written, err = splitHelmfiles(inspector, helmfiles, inputs)
+	} else {
+		outputs, written, err = splitResources(inspector, files, outputDir)
+	}
+	if err != nil {
+		fatalf("Error splitting resources: %v", err)
 	}
 
-	if err := validateResourceFiles(files); err != nil {
-		log.Fatalf("Error validating input files: %v", err)
+	if err := writeProvenance(outputDir, flag.Args()); err != nil {
+		fatalf("Error writing provenance: %v", err)
 	}
 
-	// gather output resources
-	// outputs maps namespace->resources
-	outputs := make(map[string][]resource)
-	for _, resources := range files {
-		for _, resource := range resources {
-			log.Printf("Processing resource %q", resource.obj.GetName())
-			ns := resource.obj.GetNamespace()
-			if resource.obj.IsList() {
-				log.Printf("Encountered list in file %q", resource.inputFilename)
-				ns = resource.listNamespaceName
-			}
-			if resource.obj.GetKind() == "Namespace" && resource.obj.GetAPIVersion() == "v1" {
-				ns = resource.obj.GetName()
+	if atomicWrites {
+		if err := commitStagedOutput(); err != nil {
+			fatalf("Error committing output files: %v", err)
+		}
+	}
+
+	if useState {
+		if err := pruneStaleOutputs(outputDir); err != nil {
+			fatalf("Error deleting stale output files: %v", err)
+		}
+		if !dryRun {
+			if err := saveState(outputDir); err != nil {
+				fatalf("Error saving state: %v", err)
 			}
-			list := outputs[ns]
-			list = append(list, resource)
-			outputs[ns] = list
 		}
 	}
 
-	// write output resources to directory
-	for ns, resources := range outputs {
-		log.Printf("Writing output namespace: %q", ns)
-		dirname := filepath.Join(outputDir, "namespaces", ns)
-		if ns == "" {
-			dirname = filepath.Join(outputDir, "cluster")
+	if err := writeChecksums(outputDir); err != nil {
+		fatalf("Error writing checksums: %v", err)
+	}
+
+	if err := runPostHooks(outputDir); err != nil {
+		fatalf("Error running post hooks: %v", err)
+	}
+
+	if objectStoreOutput != nil && !dryRun {
+		if err := publishOutput(remoteOutputFS, objectStoreOutput); err != nil {
+			fatalf("Error uploading output: %v", err)
 		}
-		if err := os.MkdirAll(dirname, 0755); err != nil {
-			log.Fatalf("Error creating output directory: %v", err)
+	}
+	if configMapOutput != nil && !dryRun {
+		if err := applyConfigMaps(remoteOutputFS, configMapOutput); err != nil {
+			fatalf("Error applying output to the cluster: %v", err)
 		}
+	}
+
+	if err := writeHTMLReport(flag.Args()); err != nil {
+		fatalf("Error writing HTML report: %v", err)
+	}
+
+	if err := finishJournal(); err != nil {
+		fatalf("Error deleting journal: %v", err)
+	}
 
-		log.Printf("Writing resources in directory: %q", dirname)
+	if gitCommit {
+		var namespaces []string
+		for ns := range outputs {
+			if ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		sort.Strings(namespaces)
+		msg, err := commitGitChanges(outputDir, gitMessageData{
+			Inputs:     flag.Args(),
+			Files:      len(written),
+			Namespaces: namespaces,
+			Time:       time.Now(),
+		})
+		if err != nil {
+			fatalf("Error committing changes to git: %v", err)
+		}
+		if pullRequestProvider != "" && msg != "" {
+			title, body := msg, ""
+			if parts := strings.SplitN(msg, "\n", 2); len(parts) == 2 {
+				title, body = parts[0], strings.TrimSpace(parts[1])
+			}
+			if err := pushAndOpenPullRequest(outputDir, pullRequestBase, title, body); err != nil {
+				fatalf("Error opening pull request: %v", err)
+			}
+		}
+		if gitNamespaceBranchTemplate != nil {
+			if err := commitNamespaceBranches(outputDir, written, gitMessageData{Inputs: flag.Args(), Time: time.Now()}); err != nil {
+				fatalf("Error committing namespace branches: %v", err)
+			}
+		}
+	}
+
+	progress.printTimings()
+	printSummary()
+	if len(failedInputs) > 0 {
+		fatalf("%d input files could not be read", len(failedInputs))
+	}
+	if err := writeMetricsFile(true); err != nil {
+		stopProfiling()
+		log.Fatalf("Error writing metrics file: %v", err)
+	}
+}
+
+// outputFile records a resource that has been written to the output directory.
+type outputFile struct {
+	// path is the path of the file, relative to the output root.
+	path     string
+	resource resource
+}
+
+// writeOutputs writes the given resources, keyed by namespace, into the root
+// directory. It returns details of all files written.
+func writeOutputs(root string, outputs map[string][]resource) ([]outputFile, error) {
+	var written []outputFile
+	// encoding and checking for unchanged files is done in order, and only
+	// writing the files that have changed is done concurrently
+	pool := newWritePool(writeConcurrency)
+	defer pool.wait()
+	for ns, resources := range outputs {
+		log.Printf("Writing output namespace: %q", ns)
 		for _, resource := range resources {
-			dir := dirname
-			if resource.obj.GetKind() == "Repo" && resource.obj.GetAPIVersion() == "configmanagement.gke.io/v1" {
-				dir = filepath.Join(outputDir, "system")
-				if err := os.MkdirAll(dir, 0755); err != nil {
-					log.Fatalf("Error creating output directory: %v", err)
+			dir := resourceDir(resource, ns)
+			if err := mkdirOutput(filepath.Join(root, dir)); err != nil {
+				return nil, fmt.Errorf("error creating output directory: %v", err)
+			}
+			path := filepath.Join(dir, resourceFilename(resource))
+			outputfile := filepath.Join(root, path)
+			key := stateKey(root, path)
+			if !isSelectedInput(resource.inputFilename) {
+				// the file is still recorded as part of the output, so
+				// that e.g. kustomizations list it, but left as is.
+				written = append(written, outputFile{path: path, resource: resource})
+				carryOverOutput(key)
+				stepOutput()
+				continue
+			}
+			// large unmodified resources are copied from their input
+			// file, using the hash of their raw data recorded when they
+			// were decoded
+			var data []byte
+			var hash string
+			copied := resource.source != nil && !resource.modified && resource.link == "" && !normalizeFileWhitespace
+			if copied {
+				hash = resource.source.hash
+			} else {
+				var err error
+				if data, err = resourceData(resource); err != nil {
+					return nil, fmt.Errorf("error encoding resource %q: %v", resource.obj.GetName(), err)
+				}
+				if normalizeFileWhitespace {
+					data = normalizeWhitespace(data)
 				}
+				hash = contentHash(data)
+			}
+			recordOutput(key, resource, hash)
+			if resource.link != "" {
+				target, err := filepath.Rel(dir, resource.link)
+				if err != nil {
+					return nil, err
+				}
+				if err := writeOutputSymlink(outputfile, target, data); err != nil {
+					return nil, fmt.Errorf("error writing output file %q: %v", outputfile, err)
+				}
+				written = append(written, outputFile{path: path, resource: resource})
+				stepOutput()
+				continue
+			}
+			if resumedOutput(outputfile, hash) {
+				written = append(written, outputFile{path: path, resource: resource})
+				stepOutput()
+				continue
+			}
+			if unchangedOutput(key, hash, outputfile) {
+				log.Printf("Output file for resource %q in namespace %q is unchanged: %s", resource.obj.GetName(), ns, outputfile)
+				written = append(written, outputFile{path: path, resource: resource})
+				stepOutput()
+				continue
 			}
-			filename := resourceFilename(resource)
-			outputfile := filepath.Join(dir, filename)
 			log.Printf("Writing resource %q in namespace %q to: %s", resource.obj.GetName(), ns, outputfile)
-			if err := ioutil.WriteFile(outputfile, resource.data, 0644); err != nil {
-				log.Fatalf("Error writing output file %q: %v", outputfile, err)
+			written = append(written, outputFile{path: path, resource: resource})
+			e := objectEvent(eventWritten, resource.inputFilename, resource.obj)
+			e.Path = path
+			source := resource.source
+			err := pool.do(func() error {
+				write := func() error { return writeOutputFile(outputfile, data) }
+				if copied {
+					write = func() error { return copyOutputFile(outputfile, source) }
+				}
+				if err := write(); err != nil {
+					return fmt.Errorf("error writing output file %q: %v", outputfile, err)
+				}
+				if err := journalOutput(outputfile, hash); err != nil {
+					return err
+				}
+				emitEvent(e)
+				stepOutput()
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
+	if err := pool.wait(); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// newResourceInspector returns the ResourceInspector used to discover whether
+// resources are namespaced, backed by the --scope-file if set, or otherwise
+// the apiserver given by --kubeconfig.
+func newResourceInspector() (discovery.ResourceInspector, error) {
+	if scopeFile != "" {
+		return discovery.LoadScopeFile(scopeFile)
+	}
+	restcfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	inspector, err := discovery.NewAPIServerResourceInspector(restcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct APIServer backed resource inspector: %v", err)
+	}
+	inspector.Warnf = warnf
+	return inspector, nil
+}
+
+// subcommands maps the names of subcommands to their implementation.
+// Subcommands are passed all non-flag arguments following their name.
+var subcommands = map[string]func(args []string) error{
+	"bench":         runBench,
+	"diff":          runDiff,
+	"dump-scopes":   runDumpScopes,
+	"export-tenant": runExportTenant,
+	"inspect":       runInspect,
+	"lint-layout":   runLintLayout,
+	"serve":         runServe,
+}
+
+// readInputFiles reads and decodes each of the given input files, returning a
+// map of input filename to the resources it contains.
+func readInputFiles(inputs []string) (map[string][]resource, error) {
+	files := make(map[string][]resource)
+	for _, input := range inputs {
+		log.Printf("Reading input file %q", input)
+		resources, streamed, err := decodeInputFile(input)
+		if err == nil && !streamed {
+			var data []byte
+			if data, err = readInput(input); err == nil {
+				if resources, err = decodeResourceManifest(input, bytes.NewReader(data)); err != nil {
+					err = fmt.Errorf("failed to decode input file %q: %v", input, err)
+				}
+			}
+		}
+		if err != nil {
+			if !keepGoing {
+				return nil, err
+			}
+			skipFailedInput(input, err)
+			progress.step(1)
+			continue
+		}
+
+		log.Printf("Found %d resources in file %q", len(resources), input)
+		files[input] = resources
+		for _, r := range resources {
+			emitEvent(objectEvent(eventDecoded, input, r.obj))
+		}
+		progress.step(1)
+	}
+	return files, nil
+}
+
+// readInput returns the contents of the given input file, evaluating or
+// rendering it first if required.
+func readInput(input string) ([]byte, error) {
+	if isKustomizeInput(input) {
+		data, err := buildKustomizeInput(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build input %q: %v", input, err)
+		}
+		return data, nil
+	}
+	if isJsonnetInput(input) {
+		data, err := evaluateJsonnet(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate input file %q: %v", input, err)
+		}
+		return data, nil
+	}
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	if isComposeInput(input) {
+		log.Printf("Converting Docker Compose file %q", input)
+		if data, err = convertCompose(input, data); err != nil {
+			return nil, fmt.Errorf("failed to convert input file %q: %v", input, err)
+		}
+		return data, nil
+	}
+	if isTerraformPlan(input, data) {
+		log.Printf("Extracting Kubernetes manifests from Terraform plan %q", input)
+		if data, err = extractTerraformManifests(data); err != nil {
+			return nil, fmt.Errorf("failed to extract manifests from input file %q: %v", input, err)
+		}
+		return data, nil
+	}
+	if renderEngine != "" {
+		if data, err = renderInput(input, data); err != nil {
+			return nil, fmt.Errorf("failed to render input file %q: %v", input, err)
+		}
+	}
+	return data, nil
+}
+
+// validateFlags checks that option flags have valid values before any work
+// is performed.
+func validateFlags() error {
+	if quotaHeadroom < 0 {
+		return fmt.Errorf("--quota-headroom must not be negative, got %d", quotaHeadroom)
+	}
+	if configHashKeyFile != "" && !injectConfigHash {
+		return fmt.Errorf("--config-hash-key-file requires --inject-config-hash")
+	}
+	if (serveTLSCertFile == "") != (serveTLSKeyFile == "") {
+		return fmt.Errorf("--serve-tls-cert-file and --serve-tls-key-file must be set together")
+	}
+	switch layout {
+	case layoutACM, layoutKapp, layoutGroup:
+	default:
+		return fmt.Errorf("--layout must be one of %q, %q or %q, got %q", layoutACM, layoutKapp, layoutGroup, layout)
+	}
+	switch clusterLayout {
+	case clusterLayoutFlat, clusterLayoutKind, clusterLayoutGroup:
+	default:
+		return fmt.Errorf("--cluster-layout must be one of %q, %q or %q, got %q", clusterLayoutFlat, clusterLayoutKind, clusterLayoutGroup, clusterLayout)
+	}
+	switch acmFormat {
+	case acmFormatHierarchy:
+	case acmFormatUnstructured:
+		if acmValidate {
+			return fmt.Errorf("--acm-validate cannot be used with --acm-format=%s, as unstructured repositories have no required structure", acmFormatUnstructured)
+		}
+		if initACM {
+			return fmt.Errorf("--init-acm cannot be used with --acm-format=%s, as unstructured repositories do not have a Repo", acmFormatUnstructured)
+		}
+		if layout == layoutKapp {
+			return fmt.Errorf("--acm-format=%s cannot be used with --layout=%s", acmFormatUnstructured, layoutKapp)
+		}
+	default:
+		return fmt.Errorf("--acm-format must be one of %q or %q, got %q", acmFormatHierarchy, acmFormatUnstructured, acmFormat)
+	}
+	if crdDocsMarkdown && !crdDocs {
+		return fmt.Errorf("--crd-docs-markdown requires --crd-docs")
+	}
+	if explodeWorkloads && acmValidate {
+		return fmt.Errorf("--explode-workloads cannot be used with --acm-validate, as exploded workloads must be built with kustomize")
+	}
+	if err := validateYAMLStyle(); err != nil {
+		return err
+	}
+	if writeConcurrency < 1 {
+		return fmt.Errorf("--write-concurrency must be at least 1, got %d", writeConcurrency)
+	}
+	tmpl, err := template.New("namespace-dir").Option("missingkey=error").Parse(namespaceDirTmpl)
+	if err != nil {
+		return fmt.Errorf("--namespace-dir-template is invalid: %v", err)
+	}
+	namespaceDirTemplate = tmpl
+	switch yamlAliases {
+	case yamlAliasesExpand, yamlAliasesError:
+	default:
+		return fmt.Errorf("--yaml-aliases must be one of %q or %q, got %q", yamlAliasesExpand, yamlAliasesError, yamlAliases)
+	}
+	if renderEngine == "" && (len(valuesFiles) > 0 || len(setValues) > 0) {
+		renderEngine = renderGoTemplate
+	}
+	switch renderEngine {
+	case "":
+	case renderGoTemplate, renderEnvsubst:
+		values, err := loadRenderValues(valuesFiles, setValues)
+		if err != nil {
+			return err
+		}
+		renderValues = values
+	default:
+		return fmt.Errorf("--render must be one of %q or %q if set, got %q", renderGoTemplate, renderEnvsubst, renderEngine)
+	}
+	if dryRun && (gitCommit || verifyRoundTrip) {
+		return fmt.Errorf("--dry-run cannot be used with --git-commit or --verify-roundtrip")
+	}
+	if outputOwner != "" {
+		uid, gid, err := parseOwner(outputOwner)
+		if err != nil {
+			return fmt.Errorf("--owner is invalid: %v", err)
+		}
+		outputUID, outputGID = uid, gid
+	}
+	if gitCommit {
+		tmpl, err := template.New("git-message").Option("missingkey=error").Parse(gitMessageTmpl)
+		if err != nil {
+			return fmt.Errorf("--git-message-template is invalid: %v", err)
+		}
+		gitMessageTemplate = tmpl
+	} else if gitBranch != "" || gitNamespaceBranches != "" {
+		return fmt.Errorf("--git-branch and --git-namespace-branches can only be used with --git-commit")
+	}
+	if gitNamespaceBranches != "" {
+		if len(environments) > 0 {
+			return fmt.Errorf("--git-namespace-branches cannot be used with --environments")
+		}
+		tmpl, err := template.New("git-namespace-branches").Option("missingkey=error").Parse(gitNamespaceBranches)
+		if err != nil {
+			return fmt.Errorf("--git-namespace-branches is invalid: %v", err)
+		}
+		gitNamespaceBranchTemplate = tmpl
+	}
+	if pullRequestProvider != "" {
+		if _, ok := pullRequestOpeners[pullRequestProvider]; !ok {
+			return fmt.Errorf("--pull-request must be one of %q or %q if set, got %q", pullRequestGitHub, pullRequestGitLab, pullRequestProvider)
+		}
+		if !gitCommit || gitBranch == "" {
+			return fmt.Errorf("--pull-request requires --git-commit and --git-branch to be set")
+		}
+	}
+	switch eventsOutput {
+	case "", eventsOutputJSON:
+	default:
+		return fmt.Errorf("--events-output must be %q if set, got %q", eventsOutputJSON, eventsOutput)
+	}
+	switch onInvalid {
+	case onInvalidError, onInvalidWarn, onInvalidSkip:
+	default:
+		return fmt.Errorf("--on-invalid must be one of %q, %q or %q, got %q", onInvalidError, onInvalidWarn, onInvalidSkip, onInvalid)
+	}
+	loc, ok, err := parseObjectStoreURL(outputDir)
+	if err != nil {
+		return fmt.Errorf("--output is invalid: %v", err)
+	}
+	if ok {
+		if gitCommit || useState || backupDir != "" || len(postHooks) > 0 {
+			return fmt.Errorf("--git-commit, --state, --backup-dir and --post-hook cannot be used when --output is an object store URL")
+		}
+		objectStoreOutput = loc
+		outputDir = "."
+	}
+	cmLoc, ok, err := parseConfigMapURL(outputDir)
+	if err != nil {
+		return fmt.Errorf("--output is invalid: %v", err)
+	}
+	if ok {
+		if gitCommit || useState || backupDir != "" || len(postHooks) > 0 {
+			return fmt.Errorf("--git-commit, --state, --backup-dir and --post-hook cannot be used when --output is a configmap:// or secret:// URL")
+		}
+		configMapOutput = cmLoc
+		outputDir = "."
+	}
+	if err := validateAuthFlags(); err != nil {
+		return err
+	}
+	if err := configureNetwork(); err != nil {
+		return err
+	}
+	if upgradeFrom != "" && patchesDir == "" {
+		return fmt.Errorf("--upgrade-from requires --patches-dir")
+	}
+	if signKey != "" && !sign {
+		return fmt.Errorf("--sign-key requires --sign")
+	}
+	if resume && (dryRun || atomicWrites || objectStoreOutput != nil || configMapOutput != nil) {
+		return fmt.Errorf("--resume cannot be used with --dry-run, --atomic or remote output, which do not write output files in place as the run progresses")
+	}
+	if dedupeDir != "" {
+		cleaned := filepath.Clean(filepath.FromSlash(dedupeDir))
+		if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("--dedupe-dir must be a directory within the output directory, got %q", dedupeDir)
+		}
+		dedupeDir = cleaned
+	}
+	for _, env := range environments {
+		if env == "" || env == "." || env == ".." || strings.ContainsAny(env, `/\`) {
+			return fmt.Errorf("--environments contains invalid environment name %q", env)
+		}
+	}
+	return nil
+}
+
+// configureTransformers builds the list of transformers to apply to resources
+// based on the provided flags.
+func configureTransformers() {
+	if layout == layoutKapp {
+		transformers = append(transformers, kappTransformer{})
+	}
+	if len(stripAnnotations) > 0 {
+		transformers = append(transformers, stripAnnotationsTransformer{patterns: stripAnnotations})
+	}
+	if standardLabels {
+		transformers = append(transformers, standardLabelsTransformer{managedBy: managedBy, partOf: partOf, instance: instance})
+	}
+	if stripStatus {
+		transformers = append(transformers, stripStatusTransformer{})
+	}
+	if pruneEmpty {
+		transformers = append(transformers, pruneEmptyTransformer{})
+	}
 }
 
 func resourceFilename(r resource) string {
+	if r.filename != "" {
+		return r.filename
+	}
 	if r.obj.IsList() {
 		inputFileName := filepath.Base(r.inputFilename)
 		inputFileNameStripped := strings.TrimSuffix(inputFileName, filepath.Ext(inputFileName))
@@ -145,49 +847,155 @@ func resourceFilename(r resource) string {
 		return fmt.Sprintf("namespace.%s", r.format)
 	}
 
-	return fmt.Sprintf("%s-%s.%s", r.obj.GetKind(), r.obj.GetName(), r.format)
+	return fmt.Sprintf("%s-%s.%s", qualifiedKind(r.obj), r.obj.GetName(), r.format)
 }
 
+// usedKnownScope records the GroupKinds whose built-in scope has been used,
+// so that it is only logged once.
+var usedKnownScope = make(map[schema.GroupKind]bool)
+
 func populateNamespacedField(inspector discovery.ResourceInspector, files map[string][]resource) error {
 	for inputFilename, resources := range files {
 		for i, resource := range resources {
 			gvk := resource.obj.GroupVersionKind()
+			if !resource.obj.IsList() {
+				var err error
+				if gvk, err = checkServedVersion(inspector, inputFilename, resource.obj); err != nil {
+					return fmt.Errorf("in input file %q: %v", inputFilename, err)
+				}
+			}
 			isNamespaced, err := inspector.IsNamespaced(gvk)
 			if err != nil {
-				return fmt.Errorf("in input file %q: %v", inputFilename, err)
+				known, ok := discovery.KnownScope(gvk.GroupKind())
+				if !ok {
+					return fmt.Errorf("in input file %q: %v", inputFilename, err)
+				}
+				if !usedKnownScope[gvk.GroupKind()] {
+					usedKnownScope[gvk.GroupKind()] = true
+					log.Printf("Using the built-in scope of %s, as it could not be discovered: %v", gvk.GroupKind(), err)
+				}
+				isNamespaced = known
 			}
 			resources[i].namespaced = isNamespaced
+			e := objectEvent(eventClassified, inputFilename, resource.obj)
+			e.Namespaced = &isNamespaced
+			emitEvent(e)
+			progress.step(1)
 		}
 	}
 	return nil
 }
 
-func validateResourceFiles(files map[string][]resource) error {
-	type namespacedName struct{ name, namespace string }
-	alreadyContains := func(list []namespacedName, toFind namespacedName) bool {
-		for _, e := range list {
-			if toFind == e {
-				return true
-			}
+// checkServedVersion warns if the given object uses an apiVersion that is not
+// served by the target cluster, so that version skew is caught before the
+// output is applied. It returns the GroupVersionKind to use when discovering
+// the object's scope, which is the cluster's preferred version if the
+// object's own version is not served.
+func checkServedVersion(inspector discovery.ResourceInspector, inputFilename string, obj *unstructured.Unstructured) (schema.GroupVersionKind, error) {
+	gvk := obj.GroupVersionKind()
+	versions, err := servedVersions(inspector, gvk.GroupKind())
+	if err != nil || len(versions) == 0 {
+		// unserved kinds are reported when discovering their scope
+		return gvk, err
+	}
+	for _, v := range versions {
+		if v == gvk.Version {
+			return gvk, nil
 		}
-		return false
 	}
+	warnf("%s %s in input file %q uses apiVersion %q, which is not served by the target cluster (served versions: %s)",
+		gvk.Kind, describeObject(obj), inputFilename, obj.GetAPIVersion(), strings.Join(versions, ", "))
+	gvk.Version = versions[0]
+	return gvk, nil
+}
 
-	existingResources := make(map[schema.GroupKind][]namespacedName)
-	for _, resources := range files {
+// servedVersionsCache caches the versions served for each GroupKind, as
+// inputs commonly contain many resources of the same kind.
+var servedVersionsCache = make(map[schema.GroupKind][]string)
+
+// servedVersions returns the versions of the given GroupKind served by the
+// target cluster, or nil if the inspector cannot report served versions.
+func servedVersions(inspector discovery.ResourceInspector, gk schema.GroupKind) ([]string, error) {
+	vi, ok := inspector.(discovery.VersionInspector)
+	if !ok {
+		return nil, nil
+	}
+	if versions, ok := servedVersionsCache[gk]; ok {
+		return versions, nil
+	}
+	versions, err := vi.ServedVersions(gk)
+	if err != nil {
+		return nil, err
+	}
+	servedVersionsCache[gk] = versions
+	return versions, nil
+}
+
+// validateResourceFiles validates every resource in the given files, and
+// ensures that no two input files define the same resource.
+// Identical definitions of a resource are tolerated and de-duplicated, whilst
+// conflicting definitions result in an error describing the fields that
+// differ.
+func validateResourceFiles(files map[string][]resource) error {
+	type resourceKey struct {
+		gk              schema.GroupKind
+		namespace, name string
+	}
+
+	inputFilenames := make([]string, 0, len(files))
+	for inputFilename := range files {
+		inputFilenames = append(inputFilenames, inputFilename)
+	}
+	sort.Strings(inputFilenames)
+
+	existingResources := make(map[resourceKey]resource)
+	for _, inputFilename := range inputFilenames {
+		resources := files[inputFilename]
 		if err := validateResources(resources); err != nil {
 			return err
 		}
 
+		var unique []resource
 		for _, resource := range resources {
-			gk := resource.obj.GroupVersionKind().GroupKind()
-			existingNamespacedNames := existingResources[gk]
-			nn := namespacedName{namespace: resource.obj.GetNamespace(), name: resource.obj.GetName()}
-			// find resources with duplicate names
-			if alreadyContains(existingNamespacedNames, nn) {
-				return fmt.Errorf("found duplicate resource %s/%s with group/kind %q", resource.obj.GetNamespace(), resource.obj.GetName(), gk.String())
+			// lists do not have names, so cannot be checked for duplicates
+			if resource.obj.IsList() {
+				unique = append(unique, resource)
+				continue
+			}
+
+			key := resourceKey{
+				gk:        resource.obj.GroupVersionKind().GroupKind(),
+				namespace: resource.obj.GetNamespace(),
+				name:      resource.obj.GetName(),
+			}
+			existing, ok := existingResources[key]
+			if !ok {
+				existingResources[key] = resource
+				unique = append(unique, resource)
+				continue
+			}
+
+			// resources holding only their header are compared whole
+			existingObj, err := existing.fullObject()
+			if err != nil {
+				return err
+			}
+			obj, err := resource.fullObject()
+			if err != nil {
+				return err
+			}
+			diffs := diffObjects(existingObj.Object, obj.Object)
+			if len(diffs) == 0 {
+				log.Printf("Ignoring duplicate identical definition of resource %s/%s with group/kind %q in file %q (first defined in %q)", key.namespace, key.name, key.gk.String(), resource.inputFilename, existing.inputFilename)
+				continue
+			}
+			msgs := make([]string, len(diffs))
+			for i, d := range diffs {
+				msgs[i] = "  " + d.String()
 			}
+			return fmt.Errorf("found conflicting definitions of resource %s/%s with group/kind %q in files %q and %q:\n%s", key.namespace, key.name, key.gk.String(), existing.inputFilename, resource.inputFilename, strings.Join(msgs, "\n"))
 		}
+		files[inputFilename] = unique
 	}
 
 	return nil
@@ -209,17 +1017,78 @@ func validateResource(r *resource) error {
 		return validateResourceList(r)
 	}
 
+	if _, _, err := annotatedPath(r.obj); err != nil {
+		return fmt.Errorf("in input file %q: %v", r.inputFilename, err)
+	}
+	if err := validateObjectNames(r.obj); err != nil {
+		return fmt.Errorf("in input file %q: %v", r.inputFilename, err)
+	}
+
+	if isAllNamespaces(r.obj) {
+		if acmFormat == acmFormatUnstructured {
+			return fmt.Errorf("in input file %q: %s %q is annotated with %s, but abstract namespaces are not supported by unstructured ACM repositories; use --fan-out-namespaces instead", r.inputFilename, r.obj.GetKind(), r.obj.GetName(), allNamespacesAnnotation)
+		}
+		if !r.namespaced {
+			return fmt.Errorf("in input file %q: %s %q is cluster scoped, but is annotated with %s", r.inputFilename, r.obj.GetKind(), r.obj.GetName(), allNamespacesAnnotation)
+		}
+		// resources in abstract namespace directories must not declare a
+		// namespace, as they apply to every namespace beneath them.
+		if r.obj.GetNamespace() != "" {
+			r.obj.SetNamespace("")
+			r.markModified()
+		}
+		return nil
+	}
 	if r.namespaced && r.obj.GetNamespace() == "" {
-		return fmt.Errorf("namespaced resource %q missing metadata.namespace field", r)
+		return fmt.Errorf("in input file %q: namespaced resource %s %q missing metadata.namespace field", r.inputFilename, r.obj.GetKind(), r.obj.GetName())
 	}
 	if !r.namespaced && r.obj.GetNamespace() != "" {
 		r.obj.SetNamespace("")
+		r.markModified()
 		//return fmt.Errorf("non-namespaced resource %q specifies metadata.namespace field", r)
 	}
 
 	return nil
 }
 
+// dns1123LabelKinds are kinds whose names must be DNS-1123 labels, rather
+// than the DNS-1123 subdomains accepted for most kinds.
+var dns1123LabelKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "Namespace"}: true,
+	{Group: "", Kind: "Service"}:   true,
+}
+
+// validateObjectNames checks that the name and namespace of the given object
+// are valid, so that invalid names are caught before the output is applied.
+// Objects without a name, e.g. those using generateName, are not checked.
+func validateObjectNames(obj *unstructured.Unstructured) error {
+	gk := obj.GroupVersionKind().GroupKind()
+	if ns := obj.GetNamespace(); ns != "" {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("%s %q has an invalid metadata.namespace %q: %s", gk.Kind, obj.GetName(), ns, strings.Join(errs, "; "))
+		}
+	}
+	name := obj.GetName()
+	var errs []string
+	switch {
+	case name == "":
+	case gk.Group == "rbac.authorization.k8s.io":
+		// RBAC names only need to be valid path segments, and commonly
+		// contain ':', e.g. 'system:controller:job-controller'
+		if name == "." || name == ".." || strings.ContainsAny(name, "/%") {
+			errs = []string{"must be a valid path segment, and may not be '.' or '..' or contain '/' or '%'"}
+		}
+	case dns1123LabelKinds[gk]:
+		errs = validation.IsDNS1123Label(name)
+	default:
+		errs = validation.IsDNS1123Subdomain(name)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s %q has an invalid metadata.name: %s", gk.Kind, name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // validateResourceList ensures that the given resource, which must be a 'List'
 // has valid list members.
 // This includes ensuring that all resources in the list share the same
@@ -238,6 +1107,7 @@ func validateResourceList(r *resource) error {
 			idx:               r.idx,
 			inputFilename:     r.inputFilename,
 			data:              r.data,
+			source:            r.source,
 			format:            r.format,
 			obj:               obj.(*unstructured.Unstructured),
 			namespaced:        r.namespaced,
@@ -250,7 +1120,13 @@ func validateResourceList(r *resource) error {
 		}
 
 		ns = inner.obj.GetNamespace()
-		return validateResource(inner)
+		if err := validateResource(inner); err != nil {
+			return err
+		}
+		if inner.modified {
+			r.markModified()
+		}
+		return nil
 	}); err != nil {
 		return err
 	}
@@ -260,6 +1136,12 @@ func validateResourceList(r *resource) error {
 	return nil
 }
 
+const (
+	onInvalidError = "error"
+	onInvalidWarn  = "warn"
+	onInvalidSkip  = "skip"
+)
+
 type format string
 
 const (
@@ -274,11 +1156,45 @@ type resource struct {
 	idx           int
 	inputFilename string
 
+	// data is the resource exactly as it was read from the input file, so
+	// that unmodified resources are written without being re-encoded. It is
+	// nil if the resource was not read verbatim (e.g. it is an item of an
+	// expanded list) or has been modified, in which case obj is encoded when
+	// the resource is written.
 	data       []byte
 	format     format
 	obj        *unstructured.Unstructured
 	namespaced bool
 
+	// source, if set, locates the raw data of a large resource in its input
+	// file, in place of data, so that it is copied from there when written.
+	source *inputRange
+	// partial is true if obj only holds the header of a large resource, whose
+	// other fields are read back from source when needed (see releaseBody).
+	partial bool
+
+	// filename, if set, overrides the name of the output file. It is used for
+	// resources generated by manifest-splitter.
+	filename string
+
+	// dir, if set, overrides the directory the resource is written to. It
+	// is set by routing rules.
+	dir string
+
+	// link, if set, is the path relative to the output directory of an
+	// identical resource, that the resource's output file is a symlink to.
+	link string
+
+	// modified is true if obj has been changed since it was decoded, in which
+	// case data has been released and the resource must be re-encoded when
+	// written.
+	modified bool
+
+	// scalarFixes are applied to obj when it is encoded, writing the scalar
+	// fields whose type does not match their schema as the type of their
+	// schema.
+	scalarFixes []scalarFix
+
 	// listNamespaceName is only used if obj.IsList() == true.
 	// It is the namespace of the items contained in the list.
 	listNamespaceName string
@@ -289,48 +1205,110 @@ type resource struct {
 type decoder func(r io.Reader, into interface{}) ([]byte, error)
 type encoder func(interface{}) ([]byte, error)
 
+// decoderFor returns the decoder used to read resources of the given format.
+func decoderFor(f format) decoder {
+	if f == jsonFormat {
+		return DecodeJSON
+	}
+	return DecodeYAML
+}
+
+// encoderFor returns the encoder used to write resources of the given format.
+func encoderFor(f format) encoder {
+	if f == jsonFormat {
+		return EncodeJSON
+	}
+	return EncodeYAML
+}
+
+// resourceData returns the bytes that should be written for the given
+// resource, encoding the object if it was not read verbatim or has been
+// modified.
+func resourceData(r resource) ([]byte, error) {
+	if !r.modified && r.data != nil {
+		return r.data, nil
+	}
+	if !r.modified && r.source != nil {
+		return r.source.read()
+	}
+	obj, err := r.fullObject()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.scalarFixes) > 0 {
+		applyScalarFixes(obj.Object, r.scalarFixes)
+	}
+	return encoderFor(r.format)(obj)
+}
+
+// markModified records that r.obj has been changed, releasing the now stale
+// raw data. The source of a resource holding only its header is kept, as the
+// rest of the object is still read from it.
+func (r *resource) markModified() {
+	r.modified = true
+	r.data = nil
+	if !r.partial {
+		r.source = nil
+	}
+}
+
 func decodeResourceManifest(input string, r io.Reader) ([]resource, error) {
+	return decodeResources(input, r, nil)
+}
+
+// decodeResources decodes the resources in r one document at a time. If file
+// is set, r is the input file as is, and documents larger than
+// --stream-threshold are streamed from it rather than held in memory.
+func decodeResources(input string, r io.Reader, file *inputRange) ([]resource, error) {
 	r, _, isJSON := utilyaml.GuessJSONStream(r, 4096)
-	var decode decoder
-	var encode encoder
-	var format format
-	if !isJSON {
-		decode = DecodeYAML
-		encode = EncodeYAML
-		format = yamlFormat
-	} else {
-		decode = DecodeJSON
-		encode = EncodeJSON
+	format := yamlFormat
+	if isJSON {
 		format = jsonFormat
 	}
+	docs := newDocumentReader(r, format)
 
 	idx := 0
+	invalid := 0
 	var resources []resource
-	for {
+	for doc := 0; ; doc++ {
 		u := unstructured.Unstructured{}
-		bytes, err := decode(r, &u)
+		bytes, err := docs.Read()
 		if err == io.EOF {
-			return resources, nil
+			break
+		}
+		if err == nil {
+			err = decodeDocument(format, bytes, &u)
 		}
 		if err != nil {
-			return nil, err
+			return nil, explainYAMLError(err)
+		}
+		// skip empty documents, e.g. those only containing comments
+		if len(u.Object) == 0 {
+			continue
 		}
-		// skip empty/invalid resources
 		if u.GetAPIVersion() == "" || u.GetKind() == "" {
+			invalid++
+			switch onInvalid {
+			case onInvalidError:
+				return nil, fmt.Errorf("document %d is not a Kubernetes resource as it is missing the apiVersion or kind field", doc)
+			case onInvalidWarn:
+				warnf("skipping document %d in file %q as it is missing the apiVersion or kind field", doc, input)
+			}
+			emitEvent(event{Type: eventSkipped, Input: input, Message: fmt.Sprintf("document %d is missing the apiVersion or kind field", doc)})
 			continue
 		}
 
 		if expandLists && u.IsList() {
 			u.EachListItem(func(obj runtime.Object) error {
 				u := obj.(*unstructured.Unstructured)
-				data, err := encode(u)
-				if err != nil {
-					return err
+				if skipResource(input, u) {
+					return nil
 				}
+				// list items are encoded individually when they are
+				// written, rather than holding an encoded copy of each.
 				resources = append(resources, resource{
 					idx:           idx,
 					inputFilename: input,
-					data:          data,
 					format:        format,
 					obj:           u,
 				})
@@ -340,16 +1318,42 @@ func decodeResourceManifest(input string, r io.Reader) ([]resource, error) {
 			continue
 		}
 
-		resources = append(resources, resource{
+		if skipResource(input, &u) {
+			continue
+		}
+
+		// documents using anchors or aliases are re-encoded so that the
+		// written file does not depend on YAML features that are easily
+		// broken by later edits.
+		res := resource{
 			idx:           idx,
 			inputFilename: input,
 			data:          bytes,
 			format:        format,
 			obj:           &u,
-		})
+		}
+		if format == yamlFormat {
+			aliased, err := usesYAMLAliases(bytes)
+			if err != nil {
+				return nil, err
+			}
+			if aliased && yamlAliases == yamlAliasesError {
+				return nil, fmt.Errorf("%s %q uses YAML anchors, aliases or merge keys, which are not permitted with --yaml-aliases=%s", u.GetKind(), u.GetName(), yamlAliasesError)
+			}
+			if aliased {
+				res.markModified()
+			}
+		}
+		if file != nil && !res.modified && !u.IsList() && docs.verbatim && len(bytes) >= streamThreshold {
+			res.stream(file, docs.offset, bytes)
+		}
+		resources = append(resources, res)
 		idx++
 	}
 
+	if invalid > 0 {
+		log.Printf("Skipped %d documents missing the apiVersion or kind field in file %q", invalid, input)
+	}
 	return resources, nil
 }
 
@@ -365,8 +1369,13 @@ func DecodeYAML(r io.Reader, into interface{}) ([]byte, error) {
 	}
 
 	if len(bytes) != 0 {
-		err := yaml.Unmarshal(bytes, into)
-		if err != nil {
+		if u, ok := into.(*unstructured.Unstructured); ok {
+			obj, err := decodeYAMLObject(bytes)
+			if err != nil {
+				return nil, err
+			}
+			u.Object = obj
+		} else if err := yaml.Unmarshal(bytes, into); err != nil {
 			return nil, err
 		}
 	}
@@ -374,10 +1383,6 @@ func DecodeYAML(r io.Reader, into interface{}) ([]byte, error) {
 	return bytes, err
 }
 
-func EncodeYAML(obj interface{}) ([]byte, error) {
-	return yaml.Marshal(obj)
-}
-
 func DecodeJSON(r io.Reader, into interface{}) ([]byte, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/munnerz/manifest-splitter/api/config/v1alpha1"
+)
+
+var (
+	// meshDirs is set by --mesh-dirs. When true, service mesh resources
+	// whose effects reach beyond their own namespace are routed to mesh/,
+	// with a warning describing their effects.
+	meshDirs bool
+	// istioRootNamespace is the root namespace of the Istio mesh, in which
+	// policies apply to every namespace.
+	istioRootNamespace string
+	// linkerdNamespace is the namespace of the Linkerd control plane, in
+	// which authentications may be referenced from every namespace.
+	linkerdNamespace string
+)
+
+// validateMeshFlags checks the namespaces given to the mesh flags, which are
+// used within route expressions.
+func validateMeshFlags() error {
+	for _, f := range []struct{ name, value string }{
+		{"istio-root-namespace", istioRootNamespace},
+		{"linkerd-namespace", linkerdNamespace},
+	} {
+		if errs := validation.IsDNS1123Label(f.value); len(errs) > 0 {
+			return fmt.Errorf("--%s must be a valid namespace name: %s", f.name, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// meshRoutes returns the knowledge base of service mesh resources with effects
+// beyond their own namespace, as routes to directories within mesh/ if
+// --mesh-dirs is set. They can be replaced or disabled by routes of the same
+// name in the config file, and further resources added in the same way.
+func meshRoutes() []v1alpha1.Route {
+	if !meshDirs {
+		return nil
+	}
+	return []v1alpha1.Route{
+		{
+			Name: "istio-root-namespace-policies",
+			Match: fmt.Sprintf("object.metadata.namespace == '%s' && ("+
+				"(object.apiVersion.startsWith('security.istio.io/') && object.kind in ['PeerAuthentication', 'RequestAuthentication', 'AuthorizationPolicy']) || "+
+				"(object.apiVersion.startsWith('networking.istio.io/') && object.kind in ['Sidecar', 'EnvoyFilter', 'ProxyConfig']) || "+
+				"(object.apiVersion.startsWith('telemetry.istio.io/') && object.kind == 'Telemetry') || "+
+				"(object.apiVersion.startsWith('extensions.istio.io/') && object.kind == 'WasmPlugin'))", istioRootNamespace),
+			Path:    "mesh/istio/{{.Namespace}}",
+			Warning: "Istio {{.Kind}} {{.Namespace}}/{{.Name}} is in the mesh root namespace, so it applies to workloads in every namespace",
+		},
+		{
+			Name:    "istio-gateways",
+			Match:   "object.apiVersion.startsWith('networking.istio.io/') && object.kind == 'Gateway'",
+			Path:    "mesh/istio/{{.Namespace}}",
+			Warning: "Istio Gateway {{.Namespace}}/{{.Name}} may be bound by VirtualServices in any namespace, and its selector may match gateway pods in other namespaces",
+		},
+		{
+			Name: "linkerd-global-authentications",
+			Match: fmt.Sprintf("object.metadata.namespace == '%s' && "+
+				"object.apiVersion.startsWith('policy.linkerd.io/') && object.kind in ['MeshTLSAuthentication', 'NetworkAuthentication']", linkerdNamespace),
+			Path:    "mesh/linkerd/{{.Namespace}}",
+			Warning: "Linkerd {{.Kind}} {{.Namespace}}/{{.Name}} is in the control plane namespace, so it may be referenced by authorization policies in every namespace",
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// metricsFile is the path that run statistics are written to, in the
+// Prometheus textfile collector format, if set.
+var metricsFile string
+
+// runStarted is the time that this run began.
+var runStarted = time.Now()
+
+// writeMetricsFile writes statistics about the run to --metrics-file, if set.
+// The file is replaced atomically, so that the textfile collector never reads
+// a partially written file.
+func writeMetricsFile(success bool) error {
+	if metricsFile == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP manifest_splitter_%s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE manifest_splitter_%s gauge\n", name)
+	}
+
+	successValue := 0
+	if success {
+		successValue = 1
+	}
+	gauge("last_run_success", "Whether the last run completed successfully.")
+	fmt.Fprintf(&buf, "manifest_splitter_last_run_success %d\n", successValue)
+	gauge("last_run_timestamp_seconds", "Time at which the last run completed, in seconds since the epoch.")
+	fmt.Fprintf(&buf, "manifest_splitter_last_run_timestamp_seconds %d\n", time.Now().Unix())
+	gauge("last_run_duration_seconds", "Time taken by the last run.")
+	fmt.Fprintf(&buf, "manifest_splitter_last_run_duration_seconds %g\n", time.Since(runStarted).Seconds())
+	gauge("last_run_warnings", "Number of warnings emitted by the last run.")
+	fmt.Fprintf(&buf, "manifest_splitter_last_run_warnings %d\n", len(warnings))
+
+	gauge("last_run_resources", "Number of resources decoded, classified, written or skipped by the last run.")
+	for _, typ := range []string{eventDecoded, eventClassified, eventWritten, eventSkipped} {
+		fmt.Fprintf(&buf, "manifest_splitter_last_run_resources{event=%q} %d\n", typ, eventCounts[typ])
+	}
+
+	if progress != nil {
+		progress.end()
+		// phases are repeated when splitting helmfiles once per
+		// environment, so durations are summed per phase
+		durations := make(map[string]time.Duration)
+		for _, t := range progress.timings {
+			durations[t.phase] += t.duration
+		}
+		phases := make([]string, 0, len(durations))
+		for phase := range durations {
+			phases = append(phases, phase)
+		}
+		sort.Strings(phases)
+		gauge("last_run_phase_duration_seconds", "Time taken by each phase of the last run.")
+		for _, phase := range phases {
+			fmt.Fprintf(&buf, "manifest_splitter_last_run_phase_duration_seconds{phase=%q} %g\n", phase, durations[phase].Seconds())
+		}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(metricsFile), "."+filepath.Base(metricsFile)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), metricsFile)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	defaultDenyNetworkPolicyName = "default-deny"
+	allowDNSNetworkPolicyName    = "allow-dns"
+)
+
+// generateNetworkPolicies returns a default-deny NetworkPolicy, plus a policy
+// allowing DNS lookups to kube-dns, for each namespace in outputs that does
+// not already contain policies with those names.
+// Namespaces reserved by Kubernetes (kube-*) are skipped, as a default-deny
+// policy there would break cluster components.
+func generateNetworkPolicies(outputs map[string][]resource) (map[string][]resource, error) {
+	var namespaces []string
+	for ns := range outputs {
+		if ns == "" || strings.HasPrefix(ns, "kube-") {
+			continue
+		}
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	generated := make(map[string][]resource)
+	for _, ns := range namespaces {
+		existing := make(map[string]bool)
+		for _, r := range outputs[ns] {
+			if r.obj.GetAPIVersion() == "networking.k8s.io/v1" && r.obj.GetKind() == "NetworkPolicy" {
+				existing[r.obj.GetName()] = true
+			}
+		}
+
+		if !existing[defaultDenyNetworkPolicyName] {
+			log.Printf("Generating default-deny NetworkPolicy for namespace %q", ns)
+			r, err := newGeneratedResource(newNetworkPolicy(ns, defaultDenyNetworkPolicyName, map[string]interface{}{
+				"podSelector": map[string]interface{}{},
+				"policyTypes": []interface{}{"Ingress", "Egress"},
+			}), true, "")
+			if err != nil {
+				return nil, err
+			}
+			generated[ns] = append(generated[ns], r)
+		}
+		if !existing[allowDNSNetworkPolicyName] {
+			log.Printf("Generating allow-dns NetworkPolicy for namespace %q", ns)
+			r, err := newGeneratedResource(newNetworkPolicy(ns, allowDNSNetworkPolicyName, map[string]interface{}{
+				"podSelector": map[string]interface{}{},
+				"policyTypes": []interface{}{"Egress"},
+				"egress": []interface{}{
+					map[string]interface{}{
+						"to": []interface{}{
+							map[string]interface{}{
+								"namespaceSelector": map[string]interface{}{
+									"matchLabels": map[string]interface{}{
+										"kubernetes.io/metadata.name": "kube-system",
+									},
+								},
+								"podSelector": map[string]interface{}{
+									"matchLabels": map[string]interface{}{
+										"k8s-app": "kube-dns",
+									},
+								},
+							},
+						},
+						"ports": []interface{}{
+							map[string]interface{}{"protocol": "UDP", "port": int64(53)},
+							map[string]interface{}{"protocol": "TCP", "port": int64(53)},
+						},
+					},
+				},
+			}), true, "")
+			if err != nil {
+				return nil, err
+			}
+			generated[ns] = append(generated[ns], r)
+		}
+	}
+	return generated, nil
+}
+
+func newNetworkPolicy(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": spec,
+	}}
+}
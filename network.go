@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// proxyURL is the proxy that requests to apiservers and other remote
+	// services are made through, overriding $HTTPS_PROXY and $HTTP_PROXY.
+	proxyURL string
+	// caBundle is a file of PEM encoded CA certificates trusted in addition
+	// to the system roots and any CA in the kubeconfig, e.g. for a TLS
+	// intercepting proxy.
+	caBundle string
+)
+
+// networkProxy, caBundleData and caBundlePool are parsed from --proxy-url and
+// --ca-bundle by configureNetwork. caBundlePool holds the system roots and the
+// CA bundle.
+var (
+	networkProxy = http.ProxyFromEnvironment
+	caBundleData []byte
+	caBundlePool *x509.CertPool
+)
+
+// configureNetwork applies --proxy-url and --ca-bundle to the HTTP clients
+// used to publish output and open pull requests. They are applied to
+// apiserver clients by restConfig.
+func configureNetwork() error {
+	if proxyURL == "" && caBundle == "" {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("--proxy-url must be a URL such as http://proxy:3128, got %q", proxyURL)
+		}
+		networkProxy = http.ProxyURL(u)
+		transport.Proxy = networkProxy
+	}
+	if caBundle != "" {
+		data, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return fmt.Errorf("reading --ca-bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("--ca-bundle %q does not contain any PEM encoded certificates", caBundle)
+		}
+		caBundleData, caBundlePool = data, pool
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	objectStoreClient.Transport = transport
+	pullRequestClient.Transport = transport
+	return nil
+}
+
+// applyNetworkConfig applies --proxy-url and --ca-bundle to an apiserver
+// client configuration. The CA bundle is trusted in addition to the CA in the
+// kubeconfig, or to the system roots if the kubeconfig has none.
+func applyNetworkConfig(restcfg *rest.Config) error {
+	if proxyURL != "" {
+		restcfg.Proxy = networkProxy
+	}
+	if caBundleData == nil || restcfg.Insecure {
+		return nil
+	}
+	ca := restcfg.CAData
+	if len(ca) == 0 && restcfg.CAFile != "" {
+		data, err := ioutil.ReadFile(restcfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("reading the kubeconfig's CA file: %v", err)
+		}
+		ca = data
+	}
+	if len(ca) == 0 {
+		// a CA given in the config replaces the system roots, so the pool
+		// of both is set on the transport instead
+		restcfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			t, ok := rt.(*http.Transport)
+			if !ok {
+				return rt
+			}
+			// the transport may be shared with other clients
+			t = t.Clone()
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			} else {
+				t.TLSClientConfig = t.TLSClientConfig.Clone()
+			}
+			t.TLSClientConfig.RootCAs = caBundlePool
+			return t
+		})
+		return nil
+	}
+	restcfg.CAData = append(append(append([]byte(nil), ca...), '\n'), caBundleData...)
+	restcfg.CAFile = ""
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestApplyNetworkConfigWithoutKubeconfigCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	defer func(data []byte, pool *x509.CertPool) { caBundleData, caBundlePool = data, pool }(caBundleData, caBundlePool)
+	caBundleData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pool.AddCert(srv.Certificate())
+	caBundlePool = pool
+
+	restcfg := &rest.Config{Host: srv.URL}
+	if err := applyNetworkConfig(restcfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(restcfg.CAData) > 0 || restcfg.CAFile != "" {
+		t.Errorf("the CA bundle was set as the config's CA, which replaces the system roots")
+	}
+	rt, err := rest.TransportFor(restcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: rt}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request to a server whose CA is in the bundle failed: %v", err)
+	}
+	resp.Body.Close()
+}
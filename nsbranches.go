@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+var (
+	// gitNamespaceBranches is set by --git-namespace-branches, a Go template
+	// rendering the name of the branch that each namespace's output files
+	// are committed to, e.g. 'tenants/{{.Namespace}}'.
+	gitNamespaceBranches string
+	// gitNamespaceBranchTemplate is the parsed gitNamespaceBranches, or nil
+	// if it is not set.
+	gitNamespaceBranchTemplate *template.Template
+)
+
+// namespaceBranchData is passed to --git-namespace-branches when rendering
+// the branch of a namespace.
+type namespaceBranchData struct {
+	Namespace string
+}
+
+// outputNamespace returns the namespace that the output file of the given
+// resource belongs to, which for a Namespace is the namespace itself, or ""
+// for other cluster scoped resources.
+func outputNamespace(r resource) string {
+	switch {
+	case r.obj.IsList():
+		return r.listNamespaceName
+	case r.obj.GetKind() == "Namespace" && r.obj.GetAPIVersion() == "v1":
+		return r.obj.GetName()
+	}
+	return r.obj.GetNamespace()
+}
+
+// commitNamespaceBranches commits the output files of each namespace, written
+// within dir, to the namespace's branch of the git repository containing dir,
+// at the same path within the repository. Branches that do not exist are
+// created without any history, so that they only contain the files of their
+// namespace. Each branch is checked out into a temporary worktree, so the
+// branch checked out in dir is left as it is.
+func commitNamespaceBranches(dir string, written []outputFile, data gitMessageData) error {
+	byNamespace := make(map[string][]string)
+	for _, f := range written {
+		if ns := outputNamespace(f.resource); ns != "" {
+			byNamespace[ns] = append(byNamespace[ns], f.path)
+		}
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	top, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		var name bytes.Buffer
+		if err := gitNamespaceBranchTemplate.Execute(&name, namespaceBranchData{Namespace: ns}); err != nil {
+			return fmt.Errorf("rendering branch of namespace %q: %v", ns, err)
+		}
+		branch := name.String()
+		if _, err := runGit(dir, "check-ref-format", "--branch", branch); err != nil {
+			return fmt.Errorf("branch %q of namespace %q is invalid: %v", branch, ns, err)
+		}
+		nsData := data
+		nsData.Files = len(byNamespace[ns])
+		nsData.Namespaces = []string{ns}
+		if err := commitNamespaceBranch(dir, rel, branch, byNamespace[ns], nsData); err != nil {
+			return fmt.Errorf("committing namespace %q to branch %q: %v", ns, branch, err)
+		}
+	}
+	return nil
+}
+
+// commitNamespaceBranch replaces the files at rel, the path of dir within its
+// repository, on the given branch with the given output files, and commits
+// them.
+func commitNamespaceBranch(dir, rel, branch string, paths []string, data gitMessageData) error {
+	worktree, err := ioutil.TempDir("", "manifest-splitter-branch-")
+	if err != nil {
+		return err
+	}
+	// git creates the worktree directory itself
+	if err := os.Remove(worktree); err != nil {
+		return err
+	}
+
+	replace := rel
+	if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+		if _, err := runGit(dir, "worktree", "add", "--quiet", worktree, branch); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Creating git branch %q", branch)
+		if _, err := runGit(dir, "worktree", "add", "--quiet", "--detach", worktree, "HEAD"); err != nil {
+			return err
+		}
+		if _, err := runGit(worktree, "checkout", "--quiet", "--orphan", branch); err != nil {
+			return err
+		}
+		replace = "."
+	}
+	defer func() {
+		if _, err := runGit(dir, "worktree", "remove", "--force", worktree); err != nil {
+			warnf("failed to remove git worktree %q: %v", worktree, err)
+		}
+	}()
+
+	if _, err := runGit(worktree, "rm", "-r", "-f", "--quiet", "--ignore-unmatch", "--", replace); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		// symlinks to identical resources may point into other
+		// namespaces, so the contents of every file are copied
+		contents, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(worktree, rel, path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, contents, 0644); err != nil {
+			return err
+		}
+	}
+	_, err = commitGitChanges(worktree, data)
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/munnerz/manifest-splitter/api/config/v1alpha1"
+	"github.com/munnerz/manifest-splitter/expr"
+)
+
+// namespaceLabelRule is a compiled namespace label propagation rule from the
+// config file.
+type namespaceLabelRule struct {
+	v1alpha1.NamespaceLabelRule
+	match *expr.Program
+}
+
+// namespaceLabelRules are applied in order by propagateNamespaceLabels.
+var namespaceLabelRules []namespaceLabelRule
+
+// compileNamespaceLabelRules compiles the namespace label rules in the config
+// file. The config file has already been validated.
+func compileNamespaceLabelRules(rules []v1alpha1.NamespaceLabelRule) ([]namespaceLabelRule, error) {
+	var compiled []namespaceLabelRule
+	for _, r := range rules {
+		c := namespaceLabelRule{NamespaceLabelRule: r}
+		if r.Match != "" {
+			var err error
+			if c.match, err = expr.Compile(r.Match, v1alpha1.RouteVariables...); err != nil {
+				return nil, fmt.Errorf("namespace label rule %q: %v", r.Name, err)
+			}
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// namespacedObject is a namespaced object in the inputs, which may be an item
+// of a List.
+type namespacedObject struct {
+	obj   *unstructured.Unstructured
+	owner *resource
+}
+
+// propagateNamespaceLabels applies each namespace label rule to the Namespaces
+// in the given files and the resources within them.
+func propagateNamespaceLabels(files map[string][]resource) error {
+	if len(namespaceLabelRules) == 0 {
+		return nil
+	}
+	namespaces := make(map[string]*resource)
+	objects := make(map[string][]namespacedObject)
+	for _, resources := range files {
+		for i := range resources {
+			r := &resources[i]
+			if r.obj.IsList() {
+				if err := r.obj.EachListItem(func(o runtime.Object) error {
+					item := o.(*unstructured.Unstructured)
+					if ns := item.GetNamespace(); ns != "" {
+						objects[ns] = append(objects[ns], namespacedObject{obj: item, owner: r})
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+			if r.obj.GetKind() == "Namespace" && r.obj.GetAPIVersion() == "v1" {
+				namespaces[r.obj.GetName()] = r
+				continue
+			}
+			if ns := r.obj.GetNamespace(); r.namespaced && ns != "" {
+				objects[ns] = append(objects[ns], namespacedObject{obj: r.obj, owner: r})
+			}
+		}
+	}
+
+	for _, rule := range namespaceLabelRules {
+		for name, nsResource := range namespaces {
+			var matched []namespacedObject
+			for _, o := range objects[name] {
+				ok := true
+				if rule.match != nil {
+					var err error
+					if ok, err = rule.match.Matches(map[string]interface{}{"object": o.obj.Object, "namespaced": true}); err != nil {
+						return fmt.Errorf("evaluating namespace label rule %q against %s %s: %v", rule.Name, o.obj.GetKind(), describeObject(o.obj), err)
+					}
+				}
+				if ok {
+					matched = append(matched, o)
+				}
+			}
+
+			if rule.Direction == v1alpha1.ToNamespace {
+				if setLabels(nsResource.obj, commonLabels(matched, rule.Labels), rule) {
+					nsResource.markModified()
+				}
+				continue
+			}
+			labels := make(map[string]string)
+			for _, key := range rule.Labels {
+				if value, ok := nsResource.obj.GetLabels()[key]; ok {
+					labels[key] = value
+				}
+			}
+			for _, o := range matched {
+				if setLabels(o.obj, labels, rule) {
+					o.owner.markModified()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// commonLabels returns those of the given label keys that every one of the
+// given objects sets to the same value.
+func commonLabels(objects []namespacedObject, keys []string) map[string]string {
+	common := make(map[string]string)
+	if len(objects) == 0 {
+		return common
+	}
+	for _, key := range keys {
+		value, ok := objects[0].obj.GetLabels()[key]
+		for _, o := range objects[1:] {
+			if !ok {
+				break
+			}
+			v, set := o.obj.GetLabels()[key]
+			ok = set && v == value
+		}
+		if ok {
+			common[key] = value
+		}
+	}
+	return common
+}
+
+// setLabels sets the given labels on obj, leaving labels that are already set
+// to a different value unless the rule overwrites them. It returns true if
+// obj was changed.
+func setLabels(obj *unstructured.Unstructured, labels map[string]string, rule namespaceLabelRule) bool {
+	existing := obj.GetLabels()
+	if existing == nil {
+		existing = make(map[string]string)
+	}
+	changed := false
+	for key, value := range labels {
+		old, ok := existing[key]
+		switch {
+		case ok && old == value:
+			continue
+		case ok && !rule.Overwrite:
+			log.Printf("Namespace label rule %q: not changing label %q of %s %s from %q to %q", rule.Name, key, obj.GetKind(), describeObject(obj), old, value)
+			continue
+		}
+		existing[key] = value
+		changed = true
+	}
+	if changed {
+		obj.SetLabels(existing)
+	}
+	return changed
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+const (
+	objectStoreS3  = "s3"
+	objectStoreGCS = "gs"
+)
+
+// objectStoreOutput is the object store location given to --output, if it is
+// an s3:// or gs:// URL. The output is split into memory, and then uploaded.
+var objectStoreOutput *objectStoreLocation
+
+type objectStoreLocation struct {
+	scheme, bucket, prefix string
+}
+
+func (l *objectStoreLocation) String() string {
+	return fmt.Sprintf("%s://%s/%s", l.scheme, l.bucket, l.prefix)
+}
+
+// parseObjectStoreURL parses an s3://bucket/prefix or gs://bucket/prefix URL.
+// ok is false if s is not an object store URL.
+func parseObjectStoreURL(s string) (loc *objectStoreLocation, ok bool, err error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != objectStoreS3 && u.Scheme != objectStoreGCS) {
+		return nil, false, nil
+	}
+	if u.Host == "" {
+		return nil, true, fmt.Errorf("%q does not name a bucket", s)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &objectStoreLocation{scheme: u.Scheme, bucket: u.Host, prefix: prefix}, true, nil
+}
+
+// objectStore uploads objects to a bucket.
+type objectStore interface {
+	put(key string, data []byte, contentType string) error
+}
+
+var objectStoreClient = &http.Client{Timeout: 60 * time.Second}
+
+// publishOutput uploads every file in the given in-memory output to the
+// object store location. Symlinks are uploaded as a copy of their target, as
+// object stores have no equivalent. Existing objects are overwritten, but
+// objects that are no longer part of the output are not deleted.
+func publishOutput(out *outputfs.Mem, loc *objectStoreLocation) error {
+	var store objectStore
+	var err error
+	switch loc.scheme {
+	case objectStoreS3:
+		store, err = newS3Store(loc.bucket)
+	case objectStoreGCS:
+		store, err = newGCSStore(loc.bucket)
+	}
+	if err != nil {
+		return err
+	}
+
+	uploaded := 0
+	pool := newWritePool(writeConcurrency)
+	for _, p := range out.Paths() {
+		if fi, err := out.Stat(p); err != nil || fi.IsDir() {
+			continue
+		}
+		data, err := out.ReadFile(p)
+		if err != nil {
+			pool.wait()
+			return err
+		}
+		contentType := "application/yaml"
+		if path.Ext(p) == ".json" {
+			contentType = "application/json"
+		}
+		p := p
+		err = pool.do(func() error {
+			if err := store.put(loc.prefix+p, data, contentType); err != nil {
+				return fmt.Errorf("uploading %s%s: %v", loc, p, err)
+			}
+			return nil
+		})
+		if err != nil {
+			pool.wait()
+			return err
+		}
+		uploaded++
+	}
+	if err := pool.wait(); err != nil {
+		return err
+	}
+	log.Printf("Uploaded %d output files to %s", uploaded, loc)
+	return nil
+}
+
+// s3Store uploads objects to Amazon S3, or a compatible store if
+// $AWS_ENDPOINT_URL is set, using credentials from the standard AWS
+// environment variables. Other sources in the AWS SDK's credential chain,
+// such as shared config files and instance roles, are not supported.
+type s3Store struct {
+	bucket, region              string
+	accessKey, secretKey, token string
+	// endpoint is set for S3 compatible stores, which are addressed using
+	// path style URLs.
+	endpoint string
+}
+
+func newS3Store(bucket string) (*s3Store, error) {
+	s := &s3Store{
+		bucket:    bucket,
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:  strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to write output to S3")
+	}
+	return s, nil
+}
+
+func (s *s3Store) put(key string, data []byte, contentType string) error {
+	u := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, escapeObjectKey(key))
+	if s.endpoint != "" {
+		u = fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, escapeObjectKey(key))
+	}
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data, time.Now().UTC())
+	return doObjectStoreRequest(objectStoreClient, req)
+}
+
+// sign signs the request using AWS Signature Version 4.
+func (s *s3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// gcsStore uploads objects to Google Cloud Storage using its XML API, with
+// Application Default Credentials: those of $GOOGLE_APPLICATION_CREDENTIALS,
+// gcloud's application default credentials, or the metadata server on GCP. An
+// access token in $GOOGLE_OAUTH_ACCESS_TOKEN is used instead if set.
+type gcsStore struct {
+	bucket string
+	client *http.Client
+}
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+func newGCSStore(bucket string) (*gcsStore, error) {
+	ctx := context.Background()
+	var ts oauth2.TokenSource
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	} else {
+		creds, err := google.FindDefaultCredentials(ctx, gcsScope)
+		if err != nil {
+			return nil, fmt.Errorf("finding Google Cloud credentials to write output to GCS: %v", err)
+		}
+		ts = creds.TokenSource
+	}
+	client := oauth2.NewClient(ctx, ts)
+	client.Timeout = objectStoreClient.Timeout
+	return &gcsStore{bucket: bucket, client: client}, nil
+}
+
+func (s *gcsStore) put(key string, data []byte, contentType string) error {
+	u := fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, escapeObjectKey(key))
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return doObjectStoreRequest(s.client, req)
+}
+
+// escapeObjectKey percent-encodes every byte of an object key other than
+// unreserved characters and slashes, as required when signing S3 requests.
+func escapeObjectKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func doObjectStoreRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
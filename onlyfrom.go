@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// selectedInputs is the set of cleaned input filenames whose output files are
+// written, as set by --only-from or --changed-since. It is nil if the output
+// of every input is written.
+var selectedInputs map[string]bool
+
+// selectInputs configures selectedInputs from the --only-from and
+// --changed-since flags.
+func selectInputs(inputs []string) error {
+	if len(onlyFrom) == 0 && changedSince == "" {
+		return nil
+	}
+	selectedInputs = make(map[string]bool)
+	for _, input := range onlyFrom {
+		selectedInputs[filepath.Clean(input)] = true
+	}
+	if changedSince != "" {
+		changed, err := changedInputs(changedSince, inputs)
+		if err != nil {
+			return fmt.Errorf("determining inputs changed since %q: %v", changedSince, err)
+		}
+		for _, input := range changed {
+			selectedInputs[input] = true
+		}
+	}
+	log.Printf("Only writing output files for resources from %d of %d input files", len(selectedInputs), len(inputs))
+	return nil
+}
+
+// changedInputs returns those of the given inputs that differ from the given
+// git revision, or are untracked. kustomize:// inputs are always considered
+// changed, as what they build may have changed without any local file
+// changing.
+func changedInputs(rev string, inputs []string) ([]string, error) {
+	var changed, files []string
+	for _, input := range inputs {
+		if isKustomizeInput(input) {
+			changed = append(changed, filepath.Clean(input))
+		} else {
+			files = append(files, input)
+		}
+	}
+	if len(files) == 0 {
+		return changed, nil
+	}
+	diff, err := runGit(".", append([]string{"diff", "--name-only", "--relative", rev, "--"}, files...)...)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit(".", append([]string{"ls-files", "--others", "--exclude-standard", "--"}, files...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(diff+"\n"+untracked, "\n") {
+		if name != "" {
+			changed = append(changed, filepath.Clean(name))
+		}
+	}
+	return changed, nil
+}
+
+// isSelectedInput returns true if the output files of resources read from the
+// given input should be written. Generated resources, which have no input,
+// are always written.
+func isSelectedInput(input string) bool {
+	return selectedInputs == nil || input == "" || selectedInputs[filepath.Clean(input)]
+}
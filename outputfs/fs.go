@@ -0,0 +1,123 @@
+// package outputfs abstracts the filesystem that split output is written to,
+// so that output can be written to the local filesystem, held in memory, or
+// copied elsewhere once complete.
+package outputfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is a filesystem that output files are written to. Its methods behave as
+// the functions of the same name in the os and ioutil packages, and return
+// errors that can be tested with os.IsNotExist and os.IsExist.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	// CreateExclusive writes a new file, failing if name already exists.
+	CreateExclusive(name string, data []byte, perm os.FileMode) error
+	// WriteFileFrom and CreateExclusiveFrom are WriteFile and
+	// CreateExclusive, writing the contents of r.
+	WriteFileFrom(name string, r io.Reader, perm os.FileMode) error
+	CreateExclusiveFrom(name string, r io.Reader, perm os.FileMode) error
+	Mkdir(name string, perm os.FileMode) error
+	// ReadDir returns the entries of the named directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+	Lchown(name string, uid, gid int) error
+}
+
+// OS is an FS backed by the local filesystem.
+type OS struct{}
+
+var _ FS = OS{}
+
+func (OS) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+
+func (OS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (fs OS) CreateExclusive(name string, data []byte, perm os.FileMode) error {
+	return fs.CreateExclusiveFrom(name, bytes.NewReader(data), perm)
+}
+
+func (OS) WriteFileFrom(name string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (OS) CreateExclusiveFrom(name string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+func (OS) Mkdir(name string, perm os.FileMode) error  { return os.Mkdir(name, perm) }
+func (OS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+func (OS) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (OS) Lstat(name string) (os.FileInfo, error)     { return os.Lstat(name) }
+func (OS) Remove(name string) error                   { return os.Remove(name) }
+func (OS) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+func (OS) Symlink(oldname, newname string) error      { return os.Symlink(oldname, newname) }
+func (OS) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (OS) Chmod(name string, mode os.FileMode) error  { return os.Chmod(name, mode) }
+func (OS) Lchown(name string, uid, gid int) error     { return os.Lchown(name, uid, gid) }
+
+// Walk walks the file tree rooted at root within fsys, calling fn for each file
+// or directory in lexical order, as filepath.Walk does. Symlinks are not
+// followed.
+func Walk(fsys FS, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(fsys FS, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	entries, err := fsys.ReadDir(path)
+	if err := fn(path, info, err); err != nil || entries == nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := walk(fsys, filepath.Join(path, entry.Name()), entry, fn)
+		if err != nil && (err != filepath.SkipDir || !entry.IsDir()) {
+			return err
+		}
+	}
+	return nil
+}
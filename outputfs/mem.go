@@ -0,0 +1,339 @@
+package outputfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mem is an FS held in memory, e.g. for splitting within a server, or when
+// embedding the splitter. All paths are cleaned, and relative paths are
+// relative to the root of the filesystem.
+type Mem struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+var _ FS = &Mem{}
+
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+	// link is the target of a symlink
+	link string
+}
+
+// NewMem returns an empty in-memory filesystem.
+func NewMem() *Mem {
+	return &Mem{files: map[string]*memFile{
+		".": {mode: os.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+func memPath(name string) string {
+	name = filepath.ToSlash(filepath.Clean(name))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func memDir(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return "."
+}
+
+// resolve follows symlinks in the final element of name.
+func (m *Mem) resolve(name string) (string, *memFile, error) {
+	for i := 0; i < 40; i++ {
+		f, ok := m.files[name]
+		if !ok {
+			return name, nil, os.ErrNotExist
+		}
+		if f.mode&os.ModeSymlink == 0 {
+			return name, f, nil
+		}
+		target := f.link
+		if !strings.HasPrefix(target, "/") {
+			target = memDir(name) + "/" + target
+		}
+		name = memPath(target)
+	}
+	return name, nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrInvalid}
+}
+
+// parentExists returns an error if the directory containing name does not
+// exist.
+func (m *Mem) parentExists(op, name string) error {
+	_, parent, err := m.resolve(memDir(name))
+	if err != nil || !parent.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *Mem) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, f, err := m.resolve(memPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if f.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrInvalid}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *Mem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.resolve(memPath(name))
+	if err == nil {
+		if f.mode.IsDir() {
+			return &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+		f.data, f.modTime = append([]byte(nil), data...), time.Now()
+		return nil
+	}
+	if err := m.parentExists("open", p); err != nil {
+		return err
+	}
+	m.files[p] = &memFile{data: append([]byte(nil), data...), mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *Mem) CreateExclusive(name string, data []byte, perm os.FileMode) error {
+	return m.CreateExclusiveFrom(name, bytes.NewReader(data), perm)
+}
+
+func (m *Mem) WriteFileFrom(name string, r io.Reader, perm os.FileMode) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.WriteFile(name, data, perm)
+}
+
+func (m *Mem) CreateExclusiveFrom(name string, r io.Reader, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	if _, ok := m.files[p]; ok {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	if err := m.parentExists("open", p); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.files[p] = &memFile{data: data, mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *Mem) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	if _, ok := m.files[p]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := m.parentExists("mkdir", p); err != nil {
+		return err
+	}
+	m.files[p] = &memFile{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *Mem) ReadDir(name string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.resolve(memPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !f.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: name, Err: os.ErrInvalid}
+	}
+	var entries []os.FileInfo
+	for other, f := range m.files {
+		if other != "." && memDir(other) == p {
+			entries = append(entries, memFileInfo{name: other, f: f})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *Mem) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.resolve(memPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return memFileInfo{name: p, f: f}, nil
+}
+
+func (m *Mem) Lstat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	f, ok := m.files[p]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	return memFileInfo{name: p, f: f}, nil
+}
+
+func (m *Mem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	f, ok := m.files[p]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if f.mode.IsDir() {
+		for other := range m.files {
+			if p == "." || strings.HasPrefix(other, p+"/") {
+				return &os.PathError{Op: "remove", Path: name, Err: os.ErrInvalid}
+			}
+		}
+	}
+	delete(m.files, p)
+	return nil
+}
+
+func (m *Mem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, n := memPath(oldpath), memPath(newpath)
+	f, ok := m.files[o]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if f.mode.IsDir() {
+		// directories are not renamed by the splitter
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrInvalid}
+	}
+	if err := m.parentExists("rename", n); err != nil {
+		return err
+	}
+	delete(m.files, o)
+	m.files[n] = f
+	return nil
+}
+
+func (m *Mem) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(newname)
+	if _, ok := m.files[p]; ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if err := m.parentExists("symlink", p); err != nil {
+		return err
+	}
+	m.files[p] = &memFile{mode: os.ModeSymlink | 0777, link: filepath.ToSlash(oldname), modTime: time.Now()}
+	return nil
+}
+
+func (m *Mem) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memPath(name)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	if f.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	return filepath.FromSlash(f.link), nil
+}
+
+func (m *Mem) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, f, err := m.resolve(memPath(name))
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	f.mode = f.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+// Lchown is a no-op, as files in memory have no owner.
+func (m *Mem) Lchown(name string, uid, gid int) error {
+	_, err := m.Lstat(name)
+	return err
+}
+
+// Paths returns the paths of all files, symlinks and directories in the
+// filesystem, other than the root, in lexical order.
+func (m *Mem) Paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for p := range m.files {
+		if p != "." {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WriteTar writes the contents of the filesystem to w as a tar archive.
+func (m *Mem) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, p := range m.Paths() {
+		m.mu.Lock()
+		f := m.files[p]
+		m.mu.Unlock()
+		hdr := &tar.Header{Name: p, Mode: int64(f.mode.Perm()), ModTime: f.modTime}
+		switch {
+		case f.mode.IsDir():
+			hdr.Typeflag, hdr.Name = tar.TypeDir, p+"/"
+		case f.mode&os.ModeSymlink != 0:
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, f.link
+		default:
+			hdr.Typeflag, hdr.Size = tar.TypeReg, int64(len(f.data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(f.data); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// memFileInfo implements os.FileInfo for a file in a Mem.
+type memFileInfo struct {
+	name string
+	f    *memFile
+}
+
+func (fi memFileInfo) Name() string       { return filepath.Base(fi.name) }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.f.data)) }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.f.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.f.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.f.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,181 @@
+package outputfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMem(t *testing.T) {
+	m := NewMem()
+	if err := m.WriteFile("missing/file", nil, 0644); !os.IsNotExist(err) {
+		t.Errorf("writing into a missing directory: got %v, want a not exist error", err)
+	}
+	if err := m.Mkdir("/dir/", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mkdir("dir", 0755); !os.IsExist(err) {
+		t.Errorf("creating an existing directory: got %v, want an exist error", err)
+	}
+	if err := m.WriteFile("dir/../dir/a.yaml", []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateExclusive("dir/a.yaml", []byte("b"), 0644); !os.IsExist(err) {
+		t.Errorf("exclusively creating an existing file: got %v, want an exist error", err)
+	}
+	if err := m.CreateExclusive("dir/b.yaml", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("dir", nil, 0644); err == nil {
+		t.Errorf("writing to a directory succeeded, want an error")
+	}
+
+	data, err := m.ReadFile("dir/a.yaml")
+	if err != nil || string(data) != "a" {
+		t.Errorf("ReadFile() = %q, %v, want %q", data, err, "a")
+	}
+	data[0] = 'x'
+	if data, _ := m.ReadFile("/dir/a.yaml"); string(data) != "a" {
+		t.Errorf("modifying the result of ReadFile changed the file to %q", data)
+	}
+	if _, err := m.ReadFile("dir/missing.yaml"); !os.IsNotExist(err) {
+		t.Errorf("reading a missing file: got %v, want a not exist error", err)
+	}
+	if fi, err := m.Stat("dir/a.yaml"); err != nil || fi.Mode() != 0600 || fi.Size() != 1 || fi.Name() != "a.yaml" {
+		t.Errorf("Stat() = %v, %v", fi, err)
+	}
+	if err := m.Chmod("dir/a.yaml", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := m.Stat("dir/a.yaml"); fi.Mode() != 0644 {
+		t.Errorf("got mode %v after Chmod, want %v", fi.Mode(), os.FileMode(0644))
+	}
+
+	if err := m.Symlink("dir/a.yaml", "link.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Symlink("a.yaml", "dir/relative.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"link.yaml", "dir/relative.yaml"} {
+		if data, err := m.ReadFile(link); err != nil || string(data) != "a" {
+			t.Errorf("reading through %s: got %q, %v, want %q", link, data, err, "a")
+		}
+		if fi, err := m.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Lstat(%s) = %v, %v, want a symlink", link, fi, err)
+		}
+	}
+	if target, err := m.Readlink("link.yaml"); err != nil || target != filepath.FromSlash("dir/a.yaml") {
+		t.Errorf("Readlink() = %q, %v", target, err)
+	}
+	if _, err := m.Readlink("dir/a.yaml"); err == nil {
+		t.Errorf("reading the target of a file that is not a symlink succeeded, want an error")
+	}
+	if err := m.Symlink("loop", "loop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("loop"); err == nil {
+		t.Errorf("following a symlink loop succeeded, want an error")
+	}
+	if err := m.Remove("loop"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := m.ReadDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"a.yaml", "b.yaml", "relative.yaml"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir() = %q, want %q", names, want)
+	}
+
+	if err := m.Rename("dir/b.yaml", "c.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rename("dir", "other"); err == nil {
+		t.Errorf("renaming a directory succeeded, want an error")
+	}
+	if err := m.Rename("missing", "other"); !os.IsNotExist(err) {
+		t.Errorf("renaming a missing file: got %v, want a not exist error", err)
+	}
+	if err := m.Remove("dir"); err == nil {
+		t.Errorf("removing a directory that is not empty succeeded, want an error")
+	}
+	if err := m.Remove("dir/relative.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c.yaml", "dir", "dir/a.yaml", "link.yaml"}; !reflect.DeepEqual(m.Paths(), want) {
+		t.Errorf("Paths() = %q, want %q", m.Paths(), want)
+	}
+
+	var visited []string
+	if err := Walk(m, ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, filepath.ToSlash(path))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".", "c.yaml", "dir", "dir/a.yaml", "link.yaml"}; !reflect.DeepEqual(visited, want) {
+		t.Errorf("Walk() visited %q, want %q", visited, want)
+	}
+}
+
+func TestMemWriteTar(t *testing.T) {
+	m := NewMem()
+	if err := m.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("dir/a.yaml", []byte("a: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Symlink("dir/a.yaml", "link.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	type entry struct {
+		name     string
+		typeflag byte
+		link     string
+		data     string
+	}
+	var got []entry
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry{name: hdr.Name, typeflag: hdr.Typeflag, link: hdr.Linkname, data: string(data)})
+	}
+	want := []entry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a.yaml", typeflag: tar.TypeReg, data: "a: 1\n"},
+		{name: "link.yaml", typeflag: tar.TypeSymlink, link: "dir/a.yaml"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
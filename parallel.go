@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+)
+
+// writeConcurrency is the number of output files written, or uploaded to an
+// object store, at once. Writing concurrently helps when each write has a
+// high latency, such as on a network filesystem, but makes the order in which
+// files are written, and so logged, vary between runs.
+var writeConcurrency int
+
+// outputMu guards the state updated as each output file is written, e.g. the
+// recorded changes, staged files and journal, when files are written
+// concurrently.
+var outputMu sync.Mutex
+
+// stepOutput records the progress of one output file having been written, or
+// left as it is. progress is not safe for concurrent use, so it is stepped
+// under outputMu, as files may be written by the goroutines of a writePool.
+func stepOutput() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	progress.step(1)
+}
+
+// writePool runs functions on at most a fixed number of goroutines at once.
+// Once a function returns an error no more are started, and the first error
+// is returned by wait.
+type writePool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// newWritePool returns a writePool running up to n functions at once. If n
+// is one or less, functions are run synchronously by do.
+func newWritePool(n int) *writePool {
+	p := &writePool{}
+	if n > 1 {
+		p.sem = make(chan struct{}, n)
+	}
+	return p
+}
+
+// do runs fn, waiting until fewer than the pool's limit are running. It
+// returns the first error returned by any function run so far, after which
+// no more functions should be passed to do.
+func (p *writePool) do(fn func() error) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if p.sem == nil {
+		p.setErr(fn())
+		return p.failed()
+	}
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		p.setErr(fn())
+	}()
+	return p.failed()
+}
+
+// wait waits for all running functions to return, and returns the first
+// error any of them returned.
+func (p *writePool) wait() error {
+	p.wg.Wait()
+	return p.failed()
+}
+
+func (p *writePool) setErr(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *writePool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
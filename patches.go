@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	// patchesDir is a directory of local patches applied to input resources
+	// before they are split, if set.
+	patchesDir string
+	// upgradeFrom is the previous version of the inputs, a file or
+	// directory, that local patches are checked against for conflicts with
+	// upstream changes.
+	upgradeFrom string
+)
+
+// manifestPatch is a JSON merge patch (RFC 7386) applied to a single input
+// resource.
+type manifestPatch struct {
+	filename string
+	key      objectKey
+	patch    map[string]interface{}
+	applied  bool
+}
+
+func (p *manifestPatch) String() string {
+	desc := p.key.kind + " " + p.key.name
+	if p.key.namespace != "" {
+		desc = p.key.kind + " " + p.key.namespace + "/" + p.key.name
+	}
+	return fmt.Sprintf("patch for %s in %q", desc, filepath.ToSlash(p.filename))
+}
+
+// loadPatches reads each resource in the manifests in dir as a patch. The
+// apiVersion, kind, name and namespace of each identify the resource it is
+// applied to, and all other fields are merged into that resource.
+func loadPatches(dir string) (map[objectKey]*manifestPatch, error) {
+	paths, err := layoutFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	patches := make(map[objectKey]*manifestPatch)
+	for _, path := range paths {
+		filename := filepath.Join(dir, path)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		resources, err := decodeResourceManifest(filename, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode patch file %q: %v", filename, err)
+		}
+		for _, r := range resources {
+			obj := r.obj
+			key := objectKey{group: obj.GroupVersionKind().Group, kind: obj.GetKind(), namespace: obj.GetNamespace(), name: obj.GetName()}
+			if key.name == "" {
+				return nil, fmt.Errorf("%s in patch file %q has no name", obj.GetKind(), filename)
+			}
+			if existing, ok := patches[key]; ok {
+				return nil, fmt.Errorf("%s %s is patched in both %q and %q", obj.GetKind(), describeObject(obj), existing.filename, filename)
+			}
+			patch := obj.DeepCopy().Object
+			delete(patch, "apiVersion")
+			delete(patch, "kind")
+			if metadata, ok := patch["metadata"].(map[string]interface{}); ok {
+				delete(metadata, "name")
+				delete(metadata, "namespace")
+				if len(metadata) == 0 {
+					delete(patch, "metadata")
+				}
+			}
+			patches[key] = &manifestPatch{filename: filename, key: key, patch: patch}
+		}
+	}
+	return patches, nil
+}
+
+// applyPatches applies the patches in --patches-dir to the matching input
+// resources. If --upgrade-from is set, the patches are first checked against
+// the changes made upstream since that version, failing if any field that
+// is patched has also changed upstream. Every patch must match an input
+// resource.
+func applyPatches(files map[string][]resource) error {
+	if patchesDir == "" {
+		return nil
+	}
+	patches, err := loadPatches(patchesDir)
+	if err != nil {
+		return err
+	}
+	if upgradeFrom != "" {
+		if err := checkUpgradeConflicts(patches, files); err != nil {
+			return err
+		}
+	}
+
+	for filename, resources := range files {
+		for i := range resources {
+			r := &resources[i]
+			if r.obj.IsList() {
+				continue
+			}
+			p, ok := patches[objectKeyOf(r)]
+			if !ok {
+				continue
+			}
+			r.obj.Object = mergePatch(r.obj.Object, p.patch).(map[string]interface{})
+			r.markModified()
+			p.applied = true
+			log.Printf("Applied %s to %q", p, filename)
+		}
+	}
+
+	var unapplied []string
+	for _, p := range patches {
+		if !p.applied {
+			unapplied = append(unapplied, p.String())
+		}
+	}
+	if len(unapplied) > 0 {
+		sort.Strings(unapplied)
+		return fmt.Errorf("%d patches do not match any input resource: %s", len(unapplied), strings.Join(unapplied, "; "))
+	}
+	return nil
+}
+
+func objectKeyOf(r *resource) objectKey {
+	return objectKey{group: r.obj.GroupVersionKind().Group, kind: r.obj.GetKind(), namespace: r.obj.GetNamespace(), name: r.obj.GetName()}
+}
+
+// checkUpgradeConflicts performs a three way comparison of each patch with
+// the resource it applies to in the --upgrade-from version and in the given
+// inputs. A field conflicts if it changed upstream between the two versions
+// and the patch sets it to anything other than the new upstream value, as
+// the local change may no longer be needed or correct. Patches for resources
+// removed upstream also conflict.
+func checkUpgradeConflicts(patches map[objectKey]*manifestPatch, files map[string][]resource) error {
+	previous, err := readDiffObjects(upgradeFrom)
+	if err != nil {
+		return fmt.Errorf("reading --upgrade-from: %v", err)
+	}
+	current := make(map[objectKey]map[string]interface{})
+	for _, resources := range files {
+		for i := range resources {
+			if !resources[i].obj.IsList() {
+				current[objectKeyOf(&resources[i])] = resources[i].obj.Object
+			}
+		}
+	}
+
+	var conflicts []string
+	for key, p := range patches {
+		old, inOld := previous[key]
+		updated, inNew := current[key]
+		switch {
+		case !inNew && inOld:
+			conflicts = append(conflicts, fmt.Sprintf("%s: the resource was removed upstream", p))
+			continue
+		case !inOld || !inNew:
+			// unapplied patches are reported by applyPatches
+			continue
+		}
+		for _, c := range patchConflicts("", old.Object, updated, p.patch) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", p, c))
+		}
+	}
+	if len(conflicts) == 0 {
+		log.Printf("No conflicts between %d patches and upstream changes since %q", len(patches), upgradeFrom)
+		return nil
+	}
+	sort.Strings(conflicts)
+	for _, c := range conflicts {
+		log.Printf("Conflict: %s", c)
+	}
+	return fmt.Errorf("found %d conflicts between patches in %q and upstream changes since %q", len(conflicts), patchesDir, upgradeFrom)
+}
+
+// patchConflicts returns a description of each field set by patch that
+// differs between old and updated, where the patch does not set it to the
+// updated value. old and updated may be nil if the field is unset.
+func patchConflicts(path string, old, updated interface{}, patch map[string]interface{}) []string {
+	oldMap, _ := old.(map[string]interface{})
+	updatedMap, _ := updated.(map[string]interface{})
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var conflicts []string
+	for _, k := range keys {
+		fieldPath := joinFieldPath(path, k)
+		oldValue, updatedValue := oldMap[k], updatedMap[k]
+		if nested, ok := patch[k].(map[string]interface{}); ok {
+			conflicts = append(conflicts, patchConflicts(fieldPath, oldValue, updatedValue, nested)...)
+			continue
+		}
+		if reflect.DeepEqual(oldValue, updatedValue) || reflect.DeepEqual(patch[k], updatedValue) {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s changed upstream from %s to %s, but is patched to %s", fieldPath, formatPatchValue(oldValue), formatPatchValue(updatedValue), formatPatchValue(patch[k])))
+	}
+	return conflicts
+}
+
+func formatPatchValue(v interface{}) string {
+	if v == nil {
+		return "<unset>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// mergePatch applies a JSON merge patch to target, returning the result.
+// Fields set to null in the patch are removed, maps are merged recursively,
+// and all other values, including lists, are replaced.
+func mergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for k, v := range patchMap {
+		if v == nil {
+			delete(targetMap, k)
+			continue
+		}
+		targetMap[k] = mergePatch(targetMap[k], v)
+	}
+	return targetMap
+}
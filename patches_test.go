@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target interface{}
+		patch  interface{}
+		want   interface{}
+	}{
+		{
+			name:   "merge maps",
+			target: map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e", "f": "g"}},
+			patch:  map[string]interface{}{"a": "z", "c": map[string]interface{}{"f": "y"}},
+			want:   map[string]interface{}{"a": "z", "c": map[string]interface{}{"d": "e", "f": "y"}},
+		},
+		{
+			name:   "remove fields",
+			target: map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e"}},
+			patch:  map[string]interface{}{"a": nil, "c": map[string]interface{}{"d": nil}, "missing": nil},
+			want:   map[string]interface{}{"c": map[string]interface{}{}},
+		},
+		{
+			name:   "replace lists",
+			target: map[string]interface{}{"args": []interface{}{"a", "b"}},
+			patch:  map[string]interface{}{"args": []interface{}{"c"}},
+			want:   map[string]interface{}{"args": []interface{}{"c"}},
+		},
+		{
+			name:   "replace scalar with map",
+			target: map[string]interface{}{"a": "b"},
+			patch:  map[string]interface{}{"a": map[string]interface{}{"c": "d", "e": nil}},
+			want:   map[string]interface{}{"a": map[string]interface{}{"c": "d"}},
+		},
+		{
+			name:   "add to missing target",
+			target: nil,
+			patch:  map[string]interface{}{"a": "b"},
+			want:   map[string]interface{}{"a": "b"},
+		},
+		{
+			name:   "replace target",
+			target: map[string]interface{}{"a": "b"},
+			patch:  "c",
+			want:   "c",
+		},
+	}
+	for _, test := range tests {
+		if got := mergePatch(test.target, test.patch); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPatchConflicts(t *testing.T) {
+	old := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"image":    "app:v1",
+			"paused":   false,
+			"args":     []interface{}{"--a"},
+		},
+	}
+	updated := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"image":    "app:v2",
+			"paused":   false,
+			"args":     []interface{}{"--b"},
+			"added":    "x",
+		},
+	}
+	tests := []struct {
+		name  string
+		patch map[string]interface{}
+		want  []string
+	}{
+		{
+			name:  "unchanged upstream",
+			patch: map[string]interface{}{"spec": map[string]interface{}{"paused": true}},
+		},
+		{
+			name:  "patched to the updated value",
+			patch: map[string]interface{}{"spec": map[string]interface{}{"image": "app:v2"}},
+		},
+		{
+			name: "changed upstream",
+			patch: map[string]interface{}{"spec": map[string]interface{}{
+				"replicas": int64(3),
+				"args":     []interface{}{"--c"},
+				"added":    nil,
+			}},
+			want: []string{
+				`spec.added changed upstream from <unset> to "x", but is patched to <unset>`,
+				`spec.args changed upstream from ["--a"] to ["--b"], but is patched to ["--c"]`,
+				`spec.replicas changed upstream from 1 to 2, but is patched to 3`,
+			},
+		},
+		{
+			name:  "unset upstream",
+			patch: map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "a"}}},
+		},
+	}
+	for _, test := range tests {
+		if got := patchConflicts("", old, updated, test.patch); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+// fileModeValue is a flag.Value holding a permission mode given in octal,
+// e.g. 0640.
+type fileModeValue struct {
+	mode *os.FileMode
+	// set is true if the mode was given explicitly, in which case it is
+	// applied exactly rather than being subject to the umask.
+	set bool
+}
+
+func newFileModeValue(def os.FileMode, p *os.FileMode) *fileModeValue {
+	*p = def
+	return &fileModeValue{mode: p}
+}
+
+func (v *fileModeValue) String() string {
+	return fmt.Sprintf("%#o", uint32(*v.mode))
+}
+
+func (v *fileModeValue) Set(s string) error {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("must be an octal permission mode, e.g. 0644")
+	}
+	if mode&^0777 != 0 {
+		return fmt.Errorf("must only contain permission bits (0000-0777)")
+	}
+	*v.mode = os.FileMode(mode)
+	v.set = true
+	return nil
+}
+
+func (v *fileModeValue) Type() string {
+	return "mode"
+}
+
+var (
+	fileMode, dirMode    os.FileMode
+	fileModeFlag         = newFileModeValue(0666, &fileMode)
+	dirModeFlag          = newFileModeValue(0777, &dirMode)
+	outputOwner          string
+	outputUID, outputGID = -1, -1
+)
+
+// parseOwner parses an owner given as user[:group], where both the user and
+// group may be a name or a numeric ID. If the group is omitted, the user's
+// primary group is used.
+func parseOwner(owner string) (uid, gid int, err error) {
+	parts := strings.SplitN(owner, ":", 2)
+	u, err := user.Lookup(parts[0])
+	if err != nil {
+		if u, err = user.LookupId(parts[0]); err != nil {
+			return -1, -1, fmt.Errorf("unknown user %q", parts[0])
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return -1, -1, fmt.Errorf("user %q does not have a numeric ID", parts[0])
+	}
+	gidStr := u.Gid
+	if len(parts) == 2 {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			if g, err = user.LookupGroupId(parts[1]); err != nil {
+				return -1, -1, fmt.Errorf("unknown group %q", parts[1])
+			}
+		}
+		gidStr = g.Gid
+	}
+	if gid, err = strconv.Atoi(gidStr); err != nil {
+		return -1, -1, fmt.Errorf("group %q does not have a numeric ID", gidStr)
+	}
+	return uid, gid, nil
+}
+
+// outputFS is the filesystem that output files are written to.
+var outputFS outputfs.FS = outputfs.OS{}
+
+// writeOutputFile writes data to the named output file. Unless --file-mode is
+// set, new files are created with mode 0666 less the process umask; if it is
+// set, the mode is applied exactly, including to existing files.
+//
+// If --atomic is set, the data is written to a staged temporary file which is
+// moved into place by commitStagedOutput. If --backup-dir is set, an existing
+// file with different contents is backed up first. If --dry-run is set, the
+// change is only recorded.
+func writeOutputFile(path string, data []byte) error {
+	if err := recordChange(path, data); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if err := backupOutputFile(path, data); err != nil {
+		return fmt.Errorf("backing up %q: %v", path, err)
+	}
+	if atomicWrites {
+		tmp, err := stageFile(path, data, fileMode)
+		if err != nil {
+			return err
+		}
+		return applyOutputPermissions(tmp, fileModeFlag)
+	}
+	if err := outputFS.WriteFile(path, data, fileMode); err != nil {
+		return err
+	}
+	return applyOutputPermissions(path, fileModeFlag)
+}
+
+// copyOutputFile writes the raw data of a large resource to the named output
+// file as writeOutputFile does, copying it from its input file rather than
+// holding it in memory. It is only held in memory if the existing file must
+// be compared with it, to record or back up the change.
+func copyOutputFile(path string, src *inputRange) error {
+	if recordingChanges() || backupDir != "" {
+		data, err := src.read()
+		if err != nil {
+			return err
+		}
+		return writeOutputFile(path, data)
+	}
+	if dryRun {
+		return nil
+	}
+	r, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if atomicWrites {
+		tmp, err := stageFileFrom(path, r, fileMode)
+		if err != nil {
+			return err
+		}
+		return applyOutputPermissions(tmp, fileModeFlag)
+	}
+	if err := outputFS.WriteFileFrom(path, r, fileMode); err != nil {
+		return err
+	}
+	return applyOutputPermissions(path, fileModeFlag)
+}
+
+// mkdirOutput creates the named output directory and any missing parents.
+// Directories that are created are given the mode set by --dir-mode (or 0777
+// less the umask) and the owner set by --owner. Existing directories are not
+// modified. Nothing is created if --dry-run is set.
+func mkdirOutput(path string) error {
+	if dryRun {
+		return nil
+	}
+	path = filepath.Clean(path)
+	if fi, err := outputFS.Stat(path); err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%q exists and is not a directory", path)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := mkdirOutput(parent); err != nil {
+			return err
+		}
+	}
+	if err := outputFS.Mkdir(path, dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if atomicWrites {
+		staging.dirs = append(staging.dirs, path)
+	}
+	return applyOutputPermissions(path, dirModeFlag)
+}
+
+func applyOutputPermissions(path string, mode *fileModeValue) error {
+	if mode.set {
+		if err := outputFS.Chmod(path, *mode.mode); err != nil {
+			return err
+		}
+	}
+	if outputUID >= 0 {
+		if err := outputFS.Lchown(path, outputUID, outputGID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+// Package splittertest runs manifest-splitter against fixture inputs and
+// compares the output with golden output trees, for regression testing of
+// inputs, flags and config files:
+//
+//	import "github.com/munnerz/manifest-splitter/pkg/splittertest"
+//
+//	func TestSplit(t *testing.T) {
+//		splittertest.RunDir(t, "testdata")
+//	}
+//
+// Setting $UPDATE_GOLDEN to "true" rewrites the golden trees from the
+// current output rather than comparing against them.
+package splittertest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	// inputsDir, argsFile and goldenDir are the names of the files within
+	// each case directory read by RunDir.
+	inputsDir = "inputs"
+	argsFile  = "args"
+	goldenDir = "golden"
+)
+
+// Case is a single golden test case.
+type Case struct {
+	// Name is the name of the subtest the case is run as.
+	Name string
+	// Inputs are the input files and directories passed to the splitter.
+	Inputs []string
+	// Args are additional flags passed to the splitter, e.g. --scope-file or
+	// --config. --output is set by Run.
+	Args []string
+	// Golden is the directory containing the expected output tree.
+	Golden string
+	// Command is the splitter command and any leading arguments. If empty,
+	// $MANIFEST_SPLITTER is used, or otherwise manifest-splitter on the
+	// PATH.
+	Command []string
+}
+
+// Run runs the splitter for the given case as a subtest of t, writing into a
+// temporary directory, and reports each file that is missing from, not
+// expected in, or differs from the golden tree. Hidden files such as the
+// state file are not compared.
+func Run(t *testing.T, c Case) {
+	t.Run(c.Name, func(t *testing.T) {
+		out, err := ioutil.TempDir("", "manifest-splitter-golden")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(out)
+
+		command := c.Command
+		if len(command) == 0 {
+			command = []string{"manifest-splitter"}
+			if env := os.Getenv("MANIFEST_SPLITTER"); env != "" {
+				command = strings.Fields(env)
+			}
+		}
+		args := append(append(append([]string(nil), command[1:]...), c.Args...), "--output", out)
+		var inputs []string
+		for _, input := range c.Inputs {
+			expanded, err := expandInput(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inputs = append(inputs, expanded...)
+		}
+		cmd := exec.Command(command[0], append(args, inputs...)...)
+		var logs bytes.Buffer
+		cmd.Stdout = &logs
+		cmd.Stderr = &logs
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("running %s: %v\n%s", strings.Join(cmd.Args, " "), err, logs.String())
+		}
+
+		if os.Getenv("UPDATE_GOLDEN") == "true" {
+			if err := updateGolden(out, c.Golden); err != nil {
+				t.Fatalf("updating golden output: %v", err)
+			}
+			return
+		}
+		if diffs := CompareTrees(c.Golden, out); len(diffs) > 0 {
+			t.Errorf("output differs from golden output in %q:\n%s\nsplitter output:\n%s", c.Golden, strings.Join(diffs, "\n"), logs.String())
+		}
+	})
+}
+
+// RunDir runs a case for each subdirectory of dir. Each case directory
+// contains an inputs/ directory of input files, an optional args file with
+// one additional flag per line, and a golden/ directory of expected output.
+// Relative paths in args are relative to the case directory.
+func RunDir(t *testing.T, dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		caseDir := filepath.Join(dir, entry.Name())
+		c := Case{
+			Name:   entry.Name(),
+			Inputs: []string{filepath.Join(caseDir, inputsDir)},
+			Golden: filepath.Join(caseDir, goldenDir),
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(caseDir, argsFile)); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					c.Args = append(c.Args, resolveArg(caseDir, line))
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		Run(t, c)
+	}
+}
+
+// resolveArg makes the path in a --flag=path argument relative to dir if it
+// refers to a file within dir.
+func resolveArg(dir, arg string) string {
+	i := strings.Index(arg, "=")
+	if !strings.HasPrefix(arg, "-") || i < 0 || filepath.IsAbs(arg[i+1:]) {
+		return arg
+	}
+	path := filepath.Join(dir, arg[i+1:])
+	if _, err := os.Stat(path); err != nil {
+		return arg
+	}
+	return arg[:i+1] + path
+}
+
+// expandInput returns the manifest files within input if it is a directory,
+// or input itself otherwise.
+func expandInput(input string) ([]string, error) {
+	fi, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{input}, nil
+	}
+	files, err := treeFiles(input)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = filepath.Join(input, f)
+	}
+	return paths, nil
+}
+
+// treeFiles returns the paths, relative to root, of all files within root,
+// other than hidden files and directories, in lexical order. Symlinks are
+// included as files.
+func treeFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// CompareTrees compares the files in the directories want and got, returning
+// a description of each difference. Symlinks are compared by their targets.
+func CompareTrees(want, got string) []string {
+	wantFiles, err := treeFiles(want)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	gotFiles, err := treeFiles(got)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	inGot := make(map[string]bool, len(gotFiles))
+	for _, f := range gotFiles {
+		inGot[f] = true
+	}
+	var diffs []string
+	for _, f := range wantFiles {
+		if !inGot[f] {
+			diffs = append(diffs, fmt.Sprintf("%s: missing from output", f))
+			continue
+		}
+		delete(inGot, f)
+		if d := compareFiles(filepath.Join(want, f), filepath.Join(got, f)); d != "" {
+			diffs = append(diffs, fmt.Sprintf("%s: %s", f, d))
+		}
+	}
+	for _, f := range gotFiles {
+		if inGot[f] {
+			diffs = append(diffs, fmt.Sprintf("%s: not expected in output", f))
+		}
+	}
+	return diffs
+}
+
+// compareFiles returns a description of the first difference between two
+// files, or an empty string if they are the same.
+func compareFiles(want, got string) string {
+	wantLink, wantErr := os.Readlink(want)
+	gotLink, gotErr := os.Readlink(got)
+	if wantErr == nil || gotErr == nil {
+		if wantLink != gotLink {
+			return fmt.Sprintf("symlink target %q, want %q", gotLink, wantLink)
+		}
+		return ""
+	}
+
+	wantData, err := ioutil.ReadFile(want)
+	if err != nil {
+		return err.Error()
+	}
+	gotData, err := ioutil.ReadFile(got)
+	if err != nil {
+		return err.Error()
+	}
+	if bytes.Equal(wantData, gotData) {
+		return ""
+	}
+	wantLines := strings.Split(string(wantData), "\n")
+	gotLines := strings.Split(string(gotData), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d is %q, want %q", i+1, g, w)
+		}
+	}
+	return "contents differ"
+}
+
+// updateGolden replaces the golden tree with the output tree.
+func updateGolden(out, golden string) error {
+	if err := os.RemoveAll(golden); err != nil {
+		return err
+	}
+	files, err := treeFiles(out)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		src, dst := filepath.Join(out, f), filepath.Join(golden, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if target, err := os.Readlink(src); err == nil {
+			if err := os.Symlink(target, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+// pathAnnotation may be set on an input resource to override the directory,
+// relative to the output directory, that the resource is written to.
+const pathAnnotation = "manifest-splitter.io/path"
+
+const (
+	// clusterLayoutFlat writes all cluster scoped resources into cluster/.
+	clusterLayoutFlat = "flat"
+	// clusterLayoutKind writes cluster scoped resources into a directory
+	// per kind, e.g. cluster/clusterrole/.
+	clusterLayoutKind = "kind"
+	// clusterLayoutGroup writes cluster scoped resources into a directory
+	// per API group, e.g. cluster/rbac/.
+	clusterLayoutGroup = "group"
+)
+
+const (
+	// layoutACM writes resources into the directory structure used by
+	// Anthos Config Management: cluster/, namespaces/ and system/.
+	layoutACM = "acm"
+	// layoutKapp writes resources into the same structure, annotated with
+	// kapp change groups and rules, along with a kapp Config in app.yml.
+	layoutKapp = "kapp"
+	// layoutGroup writes resources into a directory per API group within
+	// each namespace's directory, e.g. namespaces/<ns>/cert-manager.io/, and
+	// within cluster/.
+	layoutGroup = "group"
+)
+
+// clusterGroupDirs maps well-known API groups to the directory name used for
+// them when the 'group' cluster layout or output layout is used.
+// Groups not listed here use the full group name as the directory name.
+var clusterGroupDirs = map[string]string{
+	"":                             "core",
+	"admissionregistration.k8s.io": "webhooks",
+	"apiextensions.k8s.io":         "crds",
+	"apiregistration.k8s.io":       "apiservices",
+	"certificates.k8s.io":          "certificates",
+	"flowcontrol.apiserver.k8s.io": "flowcontrol",
+	"networking.k8s.io":            "networking",
+	"node.k8s.io":                  "node",
+	"policy":                       "policy",
+	"rbac.authorization.k8s.io":    "rbac",
+	"scheduling.k8s.io":            "scheduling",
+	"storage.k8s.io":               "storage",
+}
+
+// resourceDir returns the directory, relative to the output directory, that
+// the given resource should be written to.
+// ns is the namespace that the resource has been grouped into.
+func resourceDir(r resource, ns string) string {
+	if path, ok, _ := annotatedPath(r.obj); ok {
+		return path
+	}
+	if r.dir != "" {
+		return r.dir
+	}
+	if isAllNamespaces(r.obj) {
+		return "namespaces"
+	}
+	if layout == layoutKapp && isKappConfig(r.obj) {
+		return ""
+	}
+	if ns == "" {
+		return clusterDir(r)
+	}
+	if layout == layoutGroup {
+		return filepath.Join(namespacesDir(), namespaceDir(ns), groupDir(r.obj.GroupVersionKind().Group))
+	}
+	return filepath.Join(namespacesDir(), namespaceDir(ns))
+}
+
+// placeResources returns the path, relative to the output directory, that
+// each resource in the given files would be written to, sorted by path.
+// No output is written.
+func placeResources(inspector discovery.ResourceInspector, files map[string][]resource) ([]outputFile, error) {
+	if err := populateNamespacedField(inspector, files); err != nil {
+		return nil, fmt.Errorf("discovering whether resources are namespaced: %v", err)
+	}
+	if err := validateResourceFiles(files); err != nil {
+		return nil, fmt.Errorf("validating input files: %v", err)
+	}
+	if hnc {
+		parents, err := discoverNamespaceHierarchy(files)
+		if err != nil {
+			return nil, fmt.Errorf("discovering namespace hierarchy: %v", err)
+		}
+		namespaceParents = parents
+	}
+	detectAmbiguousKinds(files)
+	outputs := groupOutputs(files)
+	dirs, err := renderNamespaceDirs(outputs)
+	if err != nil {
+		return nil, fmt.Errorf("computing namespace directories: %v", err)
+	}
+	namespaceDirs = dirs
+	if err := routeResources(outputs); err != nil {
+		return nil, err
+	}
+
+	return plannedOutputs(outputs), nil
+}
+
+// plannedOutputs returns the path, relative to the output directory, that
+// each of the given resources, keyed by namespace, is written to, sorted by
+// path.
+func plannedOutputs(outputs map[string][]resource) []outputFile {
+	var placed []outputFile
+	for ns, resources := range outputs {
+		for _, r := range resources {
+			placed = append(placed, outputFile{path: filepath.Join(resourceDir(r, ns), resourceFilename(r)), resource: r})
+		}
+	}
+	sort.Slice(placed, func(i, j int) bool { return placed[i].path < placed[j].path })
+	return placed
+}
+
+// isAllNamespaces returns true if the given object is annotated to be applied
+// to all namespaces, in which case it is written into the namespaces/
+// abstract namespace directory.
+func isAllNamespaces(obj *unstructured.Unstructured) bool {
+	return allNamespacesAnnotation != "" && obj.GetAnnotations()[allNamespacesAnnotation] == "true"
+}
+
+// annotatedPath returns the output directory declared using the
+// pathAnnotation on the given object, if any.
+// An error is returned if the declared path is not a relative path contained
+// within the output directory.
+func annotatedPath(obj *unstructured.Unstructured) (string, bool, error) {
+	path, ok := obj.GetAnnotations()[pathAnnotation]
+	if !ok {
+		return "", false, nil
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	if path == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", false, fmt.Errorf("%s annotation on %s %q must be a relative path within the output directory, got %q", pathAnnotation, obj.GetKind(), obj.GetName(), path)
+	}
+	return cleaned, true, nil
+}
+
+// clusterDir returns the directory that the given cluster scoped resource
+// should be written to, according to the configured cluster layout.
+func clusterDir(r resource) string {
+	gvk := r.obj.GroupVersionKind()
+	if layout == layoutGroup {
+		return filepath.Join("cluster", groupDir(gvk.Group))
+	}
+	switch clusterLayout {
+	case clusterLayoutKind:
+		return filepath.Join("cluster", strings.ToLower(qualifiedKind(r.obj)))
+	case clusterLayoutGroup:
+		return filepath.Join("cluster", groupDir(gvk.Group))
+	}
+	return "cluster"
+}
+
+// ambiguousKinds is the set of kinds that resources in the inputs have in
+// more than one API group, e.g. Certificate in cert-manager.io and
+// networking.gke.io. Their file and directory names are qualified with the
+// group so that they do not collide.
+var ambiguousKinds map[string]bool
+
+// detectAmbiguousKinds sets ambiguousKinds from the given input files.
+func detectAmbiguousKinds(files map[string][]resource) {
+	groups := make(map[string]map[string]bool)
+	for _, resources := range files {
+		for _, r := range resources {
+			gvk := r.obj.GroupVersionKind()
+			if groups[gvk.Kind] == nil {
+				groups[gvk.Kind] = make(map[string]bool)
+			}
+			groups[gvk.Kind][gvk.Group] = true
+		}
+	}
+	ambiguousKinds = make(map[string]bool)
+	for kind, g := range groups {
+		if len(g) > 1 {
+			ambiguousKinds[kind] = true
+		}
+	}
+}
+
+// qualifiedKind returns the kind of the given object, qualified with its
+// group if the kind is ambiguous, e.g. 'Certificate.cert-manager.io'.
+func qualifiedKind(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	if !ambiguousKinds[gvk.Kind] || gvk.Group == "" {
+		return gvk.Kind
+	}
+	return gvk.Kind + "." + gvk.Group
+}
+
+// groupDir returns the directory name used for resources in the given API
+// group.
+func groupDir(group string) string {
+	if dir, ok := clusterGroupDirs[group]; ok {
+		return dir
+	}
+	return group
+}
+
+// namespaceDirTemplate is used to compute the directory for each namespace,
+// relative to the namespaces/ directory.
+var namespaceDirTemplate *template.Template
+
+// namespaceDirs maps namespace names to their directory relative to the
+// namespaces/ directory, as rendered using namespaceDirTemplate.
+var namespaceDirs map[string]string
+
+// namespaceDirData is the data passed to the namespace directory template.
+type namespaceDirData struct {
+	// Namespace is the name of the namespace.
+	Namespace string
+	// Path is the default directory for the namespace, which includes the
+	// names of any ancestor namespaces if --hnc is set.
+	Path string
+	// Labels and Annotations are those set on the Namespace resource in the
+	// input files, if any.
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// namespaceDir returns the directory for the given namespace, relative to the
+// namespaces/ directory.
+func namespaceDir(ns string) string {
+	if dir, ok := namespaceDirs[ns]; ok {
+		return dir
+	}
+	return namespacePath(ns)
+}
+
+// renderNamespaceDirs renders the namespace directory template for every
+// namespace in outputs. It returns an error if two namespaces are rendered to
+// the same directory, as their files would otherwise be mixed together.
+func renderNamespaceDirs(outputs map[string][]resource) (map[string]string, error) {
+	dirs := make(map[string]string)
+	if namespaceDirTemplate == nil {
+		return dirs, nil
+	}
+	namespaces := make([]string, 0, len(outputs))
+	for ns := range outputs {
+		if ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	// sorted so that collisions are always reported the same way
+	sort.Strings(namespaces)
+	owners := make(map[string]string)
+	for _, ns := range namespaces {
+		resources := outputs[ns]
+		data := namespaceDirData{Namespace: ns, Path: namespacePath(ns)}
+		for _, r := range resources {
+			if r.obj.GetKind() == "Namespace" && r.obj.GetAPIVersion() == "v1" && r.obj.GetName() == ns {
+				data.Labels = r.obj.GetLabels()
+				data.Annotations = r.obj.GetAnnotations()
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if err := namespaceDirTemplate.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("rendering directory for namespace %q: %v", ns, err)
+		}
+		dir := filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+		if dir == "." || filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("directory %q rendered for namespace %q must be a relative path within the namespaces directory", buf.String(), ns)
+		}
+		if acmFormat == acmFormatUnstructured && strings.SplitN(dir, string(filepath.Separator), 2)[0] == "cluster" {
+			return nil, fmt.Errorf("directory %q rendered for namespace %q conflicts with the cluster directory", buf.String(), ns)
+		}
+		if other, ok := owners[dir]; ok {
+			return nil, fmt.Errorf("namespaces %q and %q are both rendered to directory %q", other, ns, dir)
+		}
+		owners[dir] = ns
+		dirs[ns] = dir
+	}
+	return dirs, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// postHooks are commands run against the output directory once it has been
+// written. The run fails if any of them fail.
+var postHooks []string
+
+// postHookPresets are built in post hooks, given to --post-hook by name. Each
+// returns the command and arguments to run.
+var postHookPresets = map[string]func(dir string) []string{
+	// nomos vet checks that the output is a valid ACM repository
+	"nomos-vet": func(dir string) []string {
+		args := []string{"nomos", "vet", "--path", dir, "--no-api-server-check"}
+		if acmFormat == acmFormatUnstructured {
+			args = append(args, "--source-format", acmFormatUnstructured)
+		}
+		return args
+	},
+}
+
+// runPostHooks runs each --post-hook in order within the given output
+// directory, stopping at the first that fails. Commands that are not the name
+// of a preset are run using 'sh -c', with $MANIFEST_SPLITTER_OUTPUT set to
+// the output directory.
+func runPostHooks(dir string) error {
+	if dryRun && len(postHooks) > 0 {
+		log.Printf("Not running %d post hooks as --dry-run is set", len(postHooks))
+		return nil
+	}
+	for _, hook := range postHooks {
+		var cmd *exec.Cmd
+		if preset, ok := postHookPresets[hook]; ok {
+			args := preset(dir)
+			cmd = exec.Command(args[0], args[1:]...)
+		} else {
+			cmd = exec.Command("sh", "-c", hook)
+		}
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "MANIFEST_SPLITTER_OUTPUT="+dir)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		log.Printf("Running post hook %q", hook)
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.Error); ok {
+				return fmt.Errorf("running post hook %q: %v", hook, err)
+			}
+			return fmt.Errorf("post hook %q failed: %v", hook, err)
+		}
+	}
+	return nil
+}
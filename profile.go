@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+)
+
+// stopProfiling stops the profiling started by startProfiling, and writes the
+// heap profile requested by --memprofile. It is called by fatalf, as deferred
+// calls are not run when exiting with log.Fatalf, and only stops profiling
+// the first time it is called.
+var stopProfiling = func() {}
+
+// startProfiling starts the CPU profile and execution trace requested by
+// --cpuprofile and --trace, which are stopped by stopProfiling.
+func startProfiling() error {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("creating CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("starting CPU profile: %v", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+			log.Printf("Wrote CPU profile to %q", cpuProfile)
+		})
+	}
+	if traceOutput != "" {
+		f, err := os.Create(traceOutput)
+		if err != nil {
+			stop()
+			return fmt.Errorf("creating trace: %v", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return fmt.Errorf("starting trace: %v", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			f.Close()
+			log.Printf("Wrote execution trace to %q", traceOutput)
+		})
+	}
+	if memProfile != "" {
+		stops = append(stops, func() {
+			f, err := os.Create(memProfile)
+			if err != nil {
+				log.Printf("Failed to create memory profile: %v", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Printf("Failed to write memory profile: %v", err)
+				return
+			}
+			log.Printf("Wrote memory profile to %q", memProfile)
+		})
+	}
+	var once sync.Once
+	stopProfiling = func() { once.Do(stop) }
+	return nil
+}
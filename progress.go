@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// progressInterval is the minimum interval between progress reports within
+// a single phase.
+const progressInterval = time.Second
+
+// progress reports the progress of each phase of a run when --progress is
+// set. It is nil otherwise, and all of its methods are no-ops on a nil
+// receiver.
+var progress *progressReporter
+
+type phaseTiming struct {
+	phase    string
+	count    int
+	unit     string
+	duration time.Duration
+}
+
+// progressReporter periodically logs the number of items processed within the
+// current phase, and records the time taken by each phase.
+type progressReporter struct {
+	phase      string
+	unit       string
+	total      int
+	done       int
+	started    time.Time
+	lastReport time.Time
+
+	timings []phaseTiming
+	// quiet disables logging, so that timings are only recorded
+	quiet bool
+}
+
+// begin starts a new phase that will process total items, ending the current
+// phase if there is one. If total is zero, the phase is only timed.
+func (p *progressReporter) begin(phase string, total int, unit string) {
+	if p == nil {
+		return
+	}
+	if p.phase != "" {
+		p.end()
+	}
+	now := time.Now()
+	p.phase, p.unit, p.total, p.done = phase, unit, total, 0
+	p.started, p.lastReport = now, now
+}
+
+// step records that n items in the current phase have been processed.
+func (p *progressReporter) step(n int) {
+	if p == nil || p.phase == "" {
+		return
+	}
+	p.done += n
+	if now := time.Now(); !p.quiet && now.Sub(p.lastReport) >= progressInterval {
+		p.lastReport = now
+		if p.total > 0 {
+			log.Printf("Progress: %s %d/%d %s (%d%%)", p.phase, p.done, p.total, p.unit, p.done*100/p.total)
+		} else {
+			log.Printf("Progress: %s %d %s", p.phase, p.done, p.unit)
+		}
+	}
+}
+
+// end completes the current phase and logs the time it took.
+func (p *progressReporter) end() {
+	if p == nil || p.phase == "" {
+		return
+	}
+	t := phaseTiming{phase: p.phase, count: p.done, unit: p.unit, duration: time.Since(p.started)}
+	p.timings = append(p.timings, t)
+	if !p.quiet {
+		log.Printf("Progress: %s", t)
+	}
+	p.phase = ""
+}
+
+// printTimings logs the time taken by each completed phase.
+func (p *progressReporter) printTimings() {
+	if p == nil {
+		return
+	}
+	p.end()
+	if p.quiet {
+		return
+	}
+	var total time.Duration
+	log.Printf("Phase timings:")
+	for _, t := range p.timings {
+		log.Printf("  - %s", t)
+		total += t.duration
+	}
+	log.Printf("Total: %s", total.Round(time.Millisecond))
+}
+
+func (t phaseTiming) String() string {
+	if t.unit == "" {
+		return fmt.Sprintf("%s completed in %s", t.phase, t.duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s completed: %d %s in %s", t.phase, t.count, t.unit, t.duration.Round(time.Millisecond))
+}
+
+// countResources returns the total number of resources in the given map.
+func countResources(resources map[string][]resource) int {
+	n := 0
+	for _, list := range resources {
+		n += len(list)
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// provenanceFilename is the name of the file, within the output directory,
+// that --provenance writes.
+const provenanceFilename = "provenance.json"
+
+// writeProvenanceFile is whether a provenance file recording the inputs that
+// the output was generated from is written.
+var writeProvenanceFile bool
+
+// provenance documents the inputs that the output was generated from.
+type provenance struct {
+	Sources []provenanceSource `json:"sources"`
+}
+
+type provenanceSource struct {
+	// Path is the input file as given on the command line.
+	Path string `json:"path"`
+	// Digest is the SHA-256 digest of the input file as read, before it
+	// was rendered, or of the output built for a kustomize:// input.
+	Digest string `json:"digest"`
+	// Git is set if the input file is within a git repository.
+	Git *provenanceGit `json:"git,omitempty"`
+}
+
+type provenanceGit struct {
+	// Repository is the URL of the repository's origin remote, if it has
+	// one.
+	Repository string `json:"repository,omitempty"`
+	Commit     string `json:"commit"`
+	// Version describes the commit relative to the most recent tag.
+	Version string `json:"version,omitempty"`
+	// Path is the path of the input file within the repository.
+	Path string `json:"path"`
+	// Modified is true if the input file differs from the commit.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// writeProvenance writes a provenance file to the output directory recording
+// the digest of each of the given input files, and the repository and commit
+// each was read from if it is within a git repository.
+func writeProvenance(dir string, inputs []string) error {
+	if !writeProvenanceFile {
+		return nil
+	}
+	var p provenance
+	repos := make(map[string]*provenanceGit)
+	for _, input := range inputs {
+		if isKustomizeInput(input) {
+			p.Sources = append(p.Sources, provenanceSource{Path: input, Digest: kustomizeDigests[input]})
+			continue
+		}
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		source := provenanceSource{Path: filepath.ToSlash(input), Digest: contentHash(data)}
+		if source.Git, err = inputGitProvenance(input, repos); err != nil {
+			return fmt.Errorf("reading git provenance of %q: %v", input, err)
+		}
+		p.Sources = append(p.Sources, source)
+	}
+	sort.Slice(p.Sources, func(i, j int) bool { return p.Sources[i].Path < p.Sources[j].Path })
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(filepath.Join(dir, provenanceFilename), append(data, '\n'))
+}
+
+// inputGitProvenance returns the git provenance of the given input file, or
+// nil if it is not within a git repository. repos caches the commit of each
+// repository by its root directory.
+func inputGitProvenance(input string, repos map[string]*provenanceGit) (*provenanceGit, error) {
+	dir := filepath.Dir(input)
+	root, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		// not within a git repository
+		return nil, nil
+	}
+	repo, ok := repos[root]
+	if !ok {
+		repo = &provenanceGit{}
+		if repo.Commit, err = runGit(root, "rev-parse", "HEAD"); err != nil {
+			return nil, err
+		}
+		// a repository need not have a remote or any tags
+		repo.Repository, _ = runGit(root, "remote", "get-url", "origin")
+		repo.Version, _ = runGit(root, "describe", "--tags", "--always")
+		repos[root] = repo
+	}
+
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, err
+	}
+	status, err := runGit(root, "status", "--porcelain", "--", rel)
+	if err != nil {
+		return nil, err
+	}
+	source := *repo
+	source.Path = filepath.ToSlash(rel)
+	source.Modified = status != ""
+	return &source, nil
+}
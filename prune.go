@@ -0,0 +1,135 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// meaningfulEmptyFields lists fields whose value is meaningful even when
+// empty, such as an emptyDir volume source or a label selector that matches
+// everything, which schemas do not describe. These are never pruned.
+var meaningfulEmptyFields = map[string]bool{
+	"emptyDir":          true,
+	"namespaceSelector": true,
+	"podSelector":       true,
+	"selector":          true,
+}
+
+// unknownMapFields lists fields containing maps whose entries may
+// legitimately have empty values, such as ConfigMap data, used where the
+// schema of a field is not known. The entries within these maps are never
+// pruned.
+var unknownMapFields = map[string]bool{
+	"annotations": true,
+	"binaryData":  true,
+	"data":        true,
+	"labels":      true,
+	"stringData":  true,
+}
+
+// pruneEmptyTransformer removes null values, empty strings, and empty maps
+// and lists from resources.
+//
+// The schema of the resource's type, from --openapi-schema and the CRDs in
+// the inputs, decides which empty values are kept: those of fields that are
+// nullable, have a default (which an empty value would otherwise be replaced
+// by) or preserve unknown fields, and the entries of maps, whose keys are data
+// rather than optional fields. The schemas within CRDs are never pruned.
+// Where the schema of a field is not known, the entries of unknownMapFields
+// are kept instead.
+//
+// Items within lists are never removed, as doing so would change the meaning
+// of lists such as NetworkPolicy ingress rules, where an empty item matches
+// all traffic.
+type pruneEmptyTransformer struct{}
+
+func (pruneEmptyTransformer) Transform(r *resource) (bool, error) {
+	if !r.obj.IsList() {
+		return pruneEmptyMap(r.obj.Object, resourceSchema(r.obj.GroupVersionKind())), nil
+	}
+	changed := false
+	items, _ := r.obj.Object["items"].([]interface{})
+	for _, item := range items {
+		if item, ok := item.(map[string]interface{}); ok {
+			gvk := (&unstructured.Unstructured{Object: item}).GroupVersionKind()
+			changed = pruneEmptyMap(item, resourceSchema(gvk)) || changed
+		}
+	}
+	return changed, nil
+}
+
+// pruneEmptyMap prunes the empty fields of m, whose schema is s, or nil if it
+// is not known.
+func pruneEmptyMap(m map[string]interface{}, s map[string]interface{}) bool {
+	s = resolveSchema(s)
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, isMap := s["additionalProperties"].(map[string]interface{})
+	changed := false
+	for k, v := range m {
+		if k == "openAPIV3Schema" {
+			continue
+		}
+		var fieldSchema map[string]interface{}
+		if isMap {
+			fieldSchema = resolveSchema(additional)
+		} else if p, ok := properties[k].(map[string]interface{}); ok {
+			fieldSchema = resolveSchema(p)
+		}
+		if fieldSchema["x-kubernetes-preserve-unknown-fields"] == true {
+			continue
+		}
+		if fieldSchema != nil || !unknownMapFields[k] {
+			changed = pruneEmptyValue(v, fieldSchema) || changed
+		}
+		if isEmptyValue(v) && !isMap && !keepEmptyField(k, fieldSchema) {
+			delete(m, k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func pruneEmptyValue(v interface{}, s map[string]interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return pruneEmptyMap(t, s)
+	case []interface{}:
+		items, _ := s["items"].(map[string]interface{})
+		items = resolveSchema(items)
+		if items["x-kubernetes-preserve-unknown-fields"] == true {
+			return false
+		}
+		changed := false
+		for _, item := range t {
+			changed = pruneEmptyValue(item, items) || changed
+		}
+		return changed
+	}
+	return false
+}
+
+// keepEmptyField returns true if an empty value of the field k, whose schema
+// is s, or nil if it is not known, is meaningful.
+func keepEmptyField(k string, s map[string]interface{}) bool {
+	if meaningfulEmptyFields[k] {
+		return true
+	}
+	if s == nil {
+		return false
+	}
+	_, hasDefault := s["default"]
+	return hasDefault || s["nullable"] == true
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case map[string]interface{}:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	}
+	return false
+}
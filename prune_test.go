@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPruneEmptyMap(t *testing.T) {
+	str := map[string]interface{}{"type": "string"}
+	tests := []struct {
+		name   string
+		schema map[string]interface{}
+		in     map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name: "no schema",
+			in: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": nil,
+					"template": map[string]interface{}{"spec": map[string]interface{}{}},
+					"volumes":  []interface{}{map[string]interface{}{"name": "tmp", "emptyDir": map[string]interface{}{}}},
+					"selector": map[string]interface{}{},
+				},
+				"data": map[string]interface{}{"empty": ""},
+			},
+			want: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"volumes":  []interface{}{map[string]interface{}{"name": "tmp", "emptyDir": map[string]interface{}{}}},
+					"selector": map[string]interface{}{},
+				},
+				"data": map[string]interface{}{"empty": ""},
+			},
+		},
+		{
+			name: "nullable and default",
+			schema: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"nullable": map[string]interface{}{"type": "string", "nullable": true},
+							"default":  map[string]interface{}{"type": "string", "default": "x"},
+							"plain":    str,
+						},
+					},
+				},
+			},
+			in: map[string]interface{}{
+				"spec": map[string]interface{}{"nullable": nil, "default": "", "plain": ""},
+			},
+			want: map[string]interface{}{
+				"spec": map[string]interface{}{"nullable": nil, "default": ""},
+			},
+		},
+		{
+			name: "map entries",
+			schema: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"env": map[string]interface{}{"additionalProperties": str},
+						},
+					},
+				},
+			},
+			in: map[string]interface{}{
+				"spec": map[string]interface{}{"env": map[string]interface{}{"EMPTY": ""}},
+				"data": map[string]interface{}{"empty": ""},
+			},
+			want: map[string]interface{}{
+				"spec": map[string]interface{}{"env": map[string]interface{}{"EMPTY": ""}},
+				"data": map[string]interface{}{"empty": ""},
+			},
+		},
+		{
+			name: "preserve unknown fields",
+			schema: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"values": map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+						},
+					},
+				},
+			},
+			in: map[string]interface{}{
+				"spec": map[string]interface{}{"values": map[string]interface{}{"a": "", "b": map[string]interface{}{}}},
+			},
+			want: map[string]interface{}{
+				"spec": map[string]interface{}{"values": map[string]interface{}{"a": "", "b": map[string]interface{}{}}},
+			},
+		},
+		{
+			name: "CRD schemas",
+			in: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"versions": []interface{}{
+						map[string]interface{}{
+							"name":   "v1",
+							"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}},
+						},
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"versions": []interface{}{
+						map[string]interface{}{
+							"name":   "v1",
+							"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "list items are kept",
+			in: map[string]interface{}{
+				"ingress": []interface{}{map[string]interface{}{}, map[string]interface{}{"from": []interface{}{}}},
+			},
+			want: map[string]interface{}{
+				"ingress": []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+			},
+		},
+	}
+	for _, test := range tests {
+		pruneEmptyMap(test.in, test.schema)
+		if !reflect.DeepEqual(test.in, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.in, test.want)
+		}
+	}
+}
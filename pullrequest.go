@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	pullRequestGitHub = "github"
+	pullRequestGitLab = "gitlab"
+)
+
+// pullRequest describes a pull or merge request to be opened once the output
+// has been committed and pushed.
+type pullRequest struct {
+	// repo is the path of the repository on the provider, e.g. owner/repo.
+	repo        string
+	head, base  string
+	title, body string
+}
+
+// pullRequestOpener opens a pull request, returning its URL. created is false
+// if a pull request for the branch already exists.
+type pullRequestOpener func(apiURL, token string, pr pullRequest) (url string, created bool, err error)
+
+var pullRequestOpeners = map[string]pullRequestOpener{
+	pullRequestGitHub: openGitHubPullRequest,
+	pullRequestGitLab: openGitLabMergeRequest,
+}
+
+// pullRequestDefaults are the default API URLs and token environment
+// variables of each provider.
+var pullRequestDefaults = map[string]struct{ apiURL, tokenEnv string }{
+	pullRequestGitHub: {"https://api.github.com", "GITHUB_TOKEN"},
+	pullRequestGitLab: {"https://gitlab.com/api/v4", "GITLAB_TOKEN"},
+}
+
+var pullRequestClient = &http.Client{Timeout: 30 * time.Second}
+
+// pushAndOpenPullRequest pushes the current branch of the repository
+// containing dir to gitRemote, and opens a pull request for it against base
+// using the configured provider.
+func pushAndOpenPullRequest(dir, base, title, body string) error {
+	defaults := pullRequestDefaults[pullRequestProvider]
+	token := os.Getenv(defaults.tokenEnv)
+	if token == "" {
+		return fmt.Errorf("%s must be set to open a %s pull request", defaults.tokenEnv, pullRequestProvider)
+	}
+	apiURL := pullRequestAPIURL
+	if apiURL == "" {
+		apiURL = defaults.apiURL
+	}
+
+	remoteURL, err := runGit(dir, "remote", "get-url", gitRemote)
+	if err != nil {
+		return err
+	}
+	repo, err := remoteRepoPath(remoteURL)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Pushing branch %q to %q", gitBranch, gitRemote)
+	if _, err := runGit(dir, "push", "--set-upstream", gitRemote, gitBranch); err != nil {
+		return err
+	}
+
+	prURL, created, err := pullRequestOpeners[pullRequestProvider](strings.TrimSuffix(apiURL, "/"), token, pullRequest{
+		repo:  repo,
+		head:  gitBranch,
+		base:  base,
+		title: title,
+		body:  body,
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Opened pull request: %s", prURL)
+	} else {
+		log.Printf("A pull request for branch %q already exists and has been updated by the push", gitBranch)
+	}
+	return nil
+}
+
+// remoteRepoPath returns the repository path (e.g. owner/repo) of a git
+// remote URL in either URL or scp-like syntax.
+func remoteRepoPath(remote string) (string, error) {
+	var path string
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		path = u.Path
+	} else if i := strings.Index(remote, ":"); i >= 0 {
+		// scp-like syntax, e.g. git@github.com:owner/repo.git
+		path = remote[i+1:]
+	} else {
+		return "", fmt.Errorf("cannot determine repository from remote URL %q", remote)
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if !strings.Contains(path, "/") {
+		return "", fmt.Errorf("cannot determine repository from remote URL %q", remote)
+	}
+	return path, nil
+}
+
+func openGitHubPullRequest(apiURL, token string, pr pullRequest) (string, bool, error) {
+	var resp struct {
+		HTMLURL string `json:"html_url"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	status, err := postJSON(apiURL+"/repos/"+pr.repo+"/pulls", map[string]string{
+		"Authorization": "token " + token,
+		"Accept":        "application/vnd.github.v3+json",
+	}, map[string]interface{}{
+		"title": pr.title,
+		"head":  pr.head,
+		"base":  pr.base,
+		"body":  pr.body,
+	}, &resp)
+	if err != nil {
+		return "", false, err
+	}
+	if status == http.StatusCreated {
+		return resp.HTMLURL, true, nil
+	}
+	msgs := []string{resp.Message}
+	for _, e := range resp.Errors {
+		if strings.HasPrefix(e.Message, "A pull request already exists") {
+			return "", false, nil
+		}
+		msgs = append(msgs, e.Message)
+	}
+	return "", false, fmt.Errorf("creating GitHub pull request failed with status %d: %s", status, strings.Join(msgs, "; "))
+}
+
+func openGitLabMergeRequest(apiURL, token string, pr pullRequest) (string, bool, error) {
+	var resp struct {
+		WebURL  string      `json:"web_url"`
+		Message interface{} `json:"message"`
+	}
+	status, err := postJSON(apiURL+"/projects/"+url.PathEscape(pr.repo)+"/merge_requests", map[string]string{
+		"PRIVATE-TOKEN": token,
+	}, map[string]interface{}{
+		"title":         pr.title,
+		"source_branch": pr.head,
+		"target_branch": pr.base,
+		"description":   pr.body,
+	}, &resp)
+	if err != nil {
+		return "", false, err
+	}
+	switch status {
+	case http.StatusCreated:
+		return resp.WebURL, true, nil
+	case http.StatusConflict:
+		// returned if a merge request already exists for the branch
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("creating GitLab merge request failed with status %d: %v", status, resp.Message)
+}
+
+// postJSON POSTs body as JSON to the given URL, decoding the JSON response
+// into out and returning the response status code.
+func postJSON(url string, headers map[string]string, body interface{}, out interface{}) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := pullRequestClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(respData, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("decoding response with status %d: %v", resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Default values used for generated ResourceQuota and LimitRange resources
+// when the capacity of a namespace cannot be derived from its workloads.
+var (
+	defaultQuotaCPURequests    = apiresource.MustParse("4")
+	defaultQuotaMemoryRequests = apiresource.MustParse("8Gi")
+	defaultQuotaCPULimits      = apiresource.MustParse("8")
+	defaultQuotaMemoryLimits   = apiresource.MustParse("16Gi")
+
+	defaultContainerCPURequest    = "100m"
+	defaultContainerMemoryRequest = "128Mi"
+	defaultContainerCPULimit      = "500m"
+	defaultContainerMemoryLimit   = "512Mi"
+)
+
+// quotaHeadroom is set by --quota-headroom, the percentage that generated
+// quotas exceed the capacity of a namespace's workloads by, so that there is
+// room for the extra pods created during a rolling update. It defaults to 25,
+// the default maxSurge of a Deployment.
+var quotaHeadroom int
+
+// generateQuotaResources returns a template ResourceQuota and LimitRange for
+// each namespace in outputs that does not already contain one. Quota values
+// are derived from the capacity of the namespace's workloads, plus
+// --quota-headroom, falling back to defaults where no requests or limits are
+// set. Containers without requests or limits are counted with the defaults of
+// the namespace's LimitRange, or of the generated LimitRange.
+func generateQuotaResources(files map[string][]resource, outputs map[string][]resource) (map[string][]resource, error) {
+	capacity, err := capacityByNamespace(files, generatedLimitRangeDefaults())
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for ns := range outputs {
+		if ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+
+	generated := make(map[string][]resource)
+	for _, ns := range namespaces {
+		hasQuota, hasLimitRange := false, false
+		for _, r := range outputs[ns] {
+			if r.obj.GetAPIVersion() != "v1" {
+				continue
+			}
+			switch r.obj.GetKind() {
+			case "ResourceQuota":
+				hasQuota = true
+			case "LimitRange":
+				hasLimitRange = true
+			}
+		}
+
+		c := &namespaceCapacity{}
+		if workloads, ok := capacity[ns]; ok {
+			c = workloads.withHeadroom(quotaHeadroom)
+		}
+		if !hasQuota {
+			log.Printf("Generating ResourceQuota for namespace %q", ns)
+			quota, err := newGeneratedResource(&unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ResourceQuota",
+				"metadata": map[string]interface{}{
+					"name":      "default",
+					"namespace": ns,
+				},
+				"spec": map[string]interface{}{
+					"hard": map[string]interface{}{
+						"requests.cpu":    quotaValue(c.cpuRequestsQuantity(), defaultQuotaCPURequests),
+						"requests.memory": quotaValue(c.memoryRequestsQuantity(), defaultQuotaMemoryRequests),
+						"limits.cpu":      quotaValue(c.cpuLimitsQuantity(), defaultQuotaCPULimits),
+						"limits.memory":   quotaValue(c.memoryLimitsQuantity(), defaultQuotaMemoryLimits),
+					},
+				},
+			}}, true, "")
+			if err != nil {
+				return nil, err
+			}
+			generated[ns] = append(generated[ns], quota)
+		}
+		if !hasLimitRange {
+			log.Printf("Generating LimitRange for namespace %q", ns)
+			limitRange, err := newGeneratedResource(&unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "LimitRange",
+				"metadata": map[string]interface{}{
+					"name":      "default",
+					"namespace": ns,
+				},
+				"spec": map[string]interface{}{
+					"limits": []interface{}{
+						map[string]interface{}{
+							"type": "Container",
+							"defaultRequest": map[string]interface{}{
+								"cpu":    defaultContainerCPURequest,
+								"memory": defaultContainerMemoryRequest,
+							},
+							"default": map[string]interface{}{
+								"cpu":    defaultContainerCPULimit,
+								"memory": defaultContainerMemoryLimit,
+							},
+						},
+					},
+				},
+			}}, true, "")
+			if err != nil {
+				return nil, err
+			}
+			generated[ns] = append(generated[ns], limitRange)
+		}
+	}
+	return generated, nil
+}
+
+// generatedLimitRangeDefaults returns the container defaults of the generated
+// LimitRange.
+func generatedLimitRangeDefaults() *podResources {
+	quantity := func(s string) *apiresource.Quantity {
+		q := apiresource.MustParse(s)
+		return &q
+	}
+	return &podResources{
+		cpuRequests:    quantity(defaultContainerCPURequest).MilliValue(),
+		cpuLimits:      quantity(defaultContainerCPULimit).MilliValue(),
+		memoryRequests: quantity(defaultContainerMemoryRequest).Value(),
+		memoryLimits:   quantity(defaultContainerMemoryLimit).Value(),
+	}
+}
+
+// withHeadroom returns c with its requests and limits increased by percent,
+// rounded up.
+func (c *namespaceCapacity) withHeadroom(percent int) *namespaceCapacity {
+	add := func(v int64) int64 {
+		return v + (v*int64(percent)+99)/100
+	}
+	h := *c
+	h.cpuRequests, h.cpuLimits = add(c.cpuRequests), add(c.cpuLimits)
+	h.memoryRequests, h.memoryLimits = add(c.memoryRequests), add(c.memoryLimits)
+	return &h
+}
+
+// quotaValue returns the string form of q, or of def if q is zero.
+func quotaValue(q *apiresource.Quantity, def apiresource.Quantity) string {
+	if q.IsZero() {
+		return def.String()
+	}
+	return q.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// detectRenames returns the given stale output files whose resource has been
+// renamed, mapped to the output file written for the resource under its new
+// name. A resource is considered renamed if a file that was not written by
+// the previous run contains an identical resource apart from its name.
+// Stale files that have been modified since they were written are ignored.
+func detectRenames(dir string, stale []string) (map[string]string, error) {
+	added := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for key := range currentState.Outputs {
+		if _, ok := previousState.Outputs[key]; ok {
+			continue
+		}
+		id, err := renameIdentity(filepath.Join(dir, filepath.FromSlash(key)))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := added[id]; ok {
+			ambiguous[id] = true
+		}
+		added[id] = key
+	}
+
+	renames := make(map[string]string)
+	for _, key := range stale {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		data, err := outputFS.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if contentHash(data) != previousState.Outputs[key].Hash {
+			continue
+		}
+		id, err := renameIdentity(path)
+		if err != nil {
+			return nil, err
+		}
+		if newKey, ok := added[id]; ok && !ambiguous[id] {
+			renames[key] = newKey
+			// each new file is the target of at most one rename
+			delete(added, id)
+		}
+	}
+	return renames, nil
+}
+
+// renameIdentity returns a string identifying the resource in the given file
+// regardless of its name.
+func renameIdentity(path string) (string, error) {
+	data, err := outputFS.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		return "", fmt.Errorf("decoding %q: %v", path, err)
+	}
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "name")
+	}
+	// map keys are sorted when encoding, so the encoding is canonical
+	id, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// commitGitRenames moves each renamed output file to its new path using
+// 'git mv', and commits the moves on their own before the new contents are
+// written back. Committing pure renames separately means git always detects
+// them, so the history of each file is preserved.
+func commitGitRenames(dir string, renames map[string]string) error {
+	olds := make([]string, 0, len(renames))
+	for old := range renames {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+
+	contents := make(map[string][]byte)
+	var paths, lines []string
+	for _, old := range olds {
+		newKey := renames[old]
+		if _, err := runGit(dir, "ls-files", "--error-unmatch", "--", old); err != nil {
+			// the file is not tracked yet, so there is no history to
+			// preserve
+			continue
+		}
+		data, err := outputFS.ReadFile(filepath.Join(dir, filepath.FromSlash(newKey)))
+		if err != nil {
+			return err
+		}
+		contents[newKey] = data
+		if _, err := runGit(dir, "mv", "-f", "--", old, newKey); err != nil {
+			return err
+		}
+		log.Printf("Renamed output file %s to %s", old, newKey)
+		paths = append(paths, old, newKey)
+		lines = append(lines, fmt.Sprintf("- %s -> %s", old, newKey))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Move output files of %d renamed or re-scoped resources\n\n%s\n", len(lines), strings.Join(lines, "\n"))
+	if _, err := runGit(dir, append([]string{"commit", "--quiet", "--message", msg, "--"}, paths...)...); err != nil {
+		return err
+	}
+	for key, data := range contents {
+		if err := writeOutputFile(filepath.Join(dir, filepath.FromSlash(key)), data); err != nil {
+			return err
+		}
+	}
+	if atomicWrites {
+		return commitStagedOutput()
+	}
+	return nil
+}
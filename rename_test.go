@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+func TestRenameIdentity(t *testing.T) {
+	withStateFS(nil, nil, func(fs *outputfs.Mem) {
+		writeMemFile(t, fs, "a.yaml", "kind: ConfigMap\nmetadata:\n  name: a\n  namespace: app\ndata:\n  k: v\n")
+		writeMemFile(t, fs, "b.json", `{"data": {"k": "v"}, "metadata": {"namespace": "app", "name": "b"}, "kind": "ConfigMap"}`)
+		writeMemFile(t, fs, "c.yaml", "kind: ConfigMap\nmetadata:\n  name: a\n  namespace: other\ndata:\n  k: v\n")
+		a, err := renameIdentity("a.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := renameIdentity("b.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := renameIdentity("c.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != b {
+			t.Errorf("resources differing only in name have identities %s and %s", a, b)
+		}
+		if a == c {
+			t.Errorf("resources in different namespaces have the same identity %s", a)
+		}
+	})
+}
+
+func TestDetectRenames(t *testing.T) {
+	config := func(name, value string) string {
+		return "kind: ConfigMap\nmetadata:\n  name: " + name + "\ndata:\n  k: " + value + "\n"
+	}
+	previous := &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{
+		"ConfigMap-old.yaml":       {Hash: contentHash([]byte(config("old", "a")))},
+		"ConfigMap-modified.yaml":  {Hash: contentHash([]byte(config("modified", "b")))},
+		"ConfigMap-twin-1.yaml":    {Hash: contentHash([]byte(config("twin-1", "c")))},
+		"ConfigMap-unchanged.yaml": {Hash: contentHash([]byte(config("unchanged", "d")))},
+	}}
+	current := map[string]stateOutput{
+		"ConfigMap-new.yaml":       {},
+		"ConfigMap-renamed.yaml":   {},
+		"ConfigMap-twin-2.yaml":    {},
+		"ConfigMap-twin-3.yaml":    {},
+		"ConfigMap-unchanged.yaml": {},
+	}
+	withStateFS(previous, current, func(fs *outputfs.Mem) {
+		writeMemFile(t, fs, "ConfigMap-old.yaml", config("old", "a"))
+		writeMemFile(t, fs, "ConfigMap-new.yaml", config("new", "a"))
+		// modified since it was written, so not renamed
+		writeMemFile(t, fs, "ConfigMap-modified.yaml", config("modified", "changed"))
+		writeMemFile(t, fs, "ConfigMap-renamed.yaml", config("renamed", "changed"))
+		// two new files match, so which was renamed is ambiguous
+		writeMemFile(t, fs, "ConfigMap-twin-1.yaml", config("twin-1", "c"))
+		writeMemFile(t, fs, "ConfigMap-twin-2.yaml", config("twin-2", "c"))
+		writeMemFile(t, fs, "ConfigMap-twin-3.yaml", config("twin-3", "c"))
+		writeMemFile(t, fs, "ConfigMap-unchanged.yaml", config("unchanged", "d"))
+
+		got, err := detectRenames(".", []string{"ConfigMap-modified.yaml", "ConfigMap-old.yaml", "ConfigMap-twin-1.yaml"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"ConfigMap-old.yaml": "ConfigMap-new.yaml"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestCommitGitRenames(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "manifest-splitter-rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := runGit(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	git("init", "--quiet")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	old := "kind: ConfigMap\nmetadata:\n  name: old\ndata:\n  k: v\n"
+	updated := "kind: ConfigMap\nmetadata:\n  name: new\ndata:\n  k: v\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "ConfigMap-old.yaml"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "untracked.yaml"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "ConfigMap-old.yaml")
+	git("commit", "--quiet", "--message", "initial")
+	if err := ioutil.WriteFile(filepath.Join(dir, "ConfigMap-new.yaml"), []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	renames := map[string]string{
+		"ConfigMap-old.yaml": "ConfigMap-new.yaml",
+		"untracked.yaml":     "ConfigMap-other.yaml",
+	}
+	if err := commitGitRenames(dir, renames); err != nil {
+		t.Fatal(err)
+	}
+	if got := git("show", "--name-status", "--format=", "HEAD"); got != "R100\tConfigMap-old.yaml\tConfigMap-new.yaml" {
+		t.Errorf("got commit of %q, want a pure rename", got)
+	}
+	if msg := git("log", "-1", "--format=%B"); !strings.Contains(msg, "- ConfigMap-old.yaml -> ConfigMap-new.yaml") {
+		t.Errorf("got commit message %q", msg)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ConfigMap-new.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != updated {
+		t.Errorf("got contents %q after renaming, want %q", data, updated)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "untracked.yaml")); err != nil {
+		t.Errorf("untracked file was moved: %v", err)
+	}
+}
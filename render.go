@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	renderGoTemplate = "go"
+	renderEnvsubst   = "envsubst"
+)
+
+// renderValues holds the values passed to input templates, loaded from
+// --values files and --set flags. It is nil if input rendering is disabled.
+var renderValues map[string]interface{}
+
+// loadRenderValues merges the given values files, in order, and then applies
+// each key=value pair in sets on top. Keys in sets may be dotted paths into
+// nested maps, e.g. image.tag=v1.
+func loadRenderValues(files, sets []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading values file: %v", err)
+		}
+		var v map[string]interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("decoding values file %q: %v", f, err)
+		}
+		mergeValues(values, v)
+	}
+	for _, set := range sets {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --set value %q, must be of the form key=value", set)
+		}
+		m := values
+		path := strings.Split(parts[0], ".")
+		for _, key := range path[:len(path)-1] {
+			next, ok := m[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[key] = next
+			}
+			m = next
+		}
+		m[path[len(path)-1]] = parts[1]
+	}
+	return values, nil
+}
+
+// mergeValues deep merges src into dst, with values in src taking precedence.
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// renderInput renders the contents of an input file using the configured
+// --render engine.
+func renderInput(input string, data []byte) ([]byte, error) {
+	switch renderEngine {
+	case renderGoTemplate:
+		tmpl, err := template.New(input).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing template: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, map[string]interface{}{"Values": renderValues}); err != nil {
+			return nil, fmt.Errorf("rendering template: %v", err)
+		}
+		return buf.Bytes(), nil
+	case renderEnvsubst:
+		var missing []string
+		out := os.Expand(string(data), func(name string) string {
+			if v, ok := lookupValue(renderValues, name); ok {
+				return v
+			}
+			if v, ok := os.LookupEnv(name); ok {
+				return v
+			}
+			missing = append(missing, name)
+			return ""
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("undefined variables: %s", strings.Join(missing, ", "))
+		}
+		return []byte(out), nil
+	}
+	return data, nil
+}
+
+// lookupValue returns the value at the given dotted path in values.
+func lookupValue(values map[string]interface{}, path string) (string, bool) {
+	var v interface{} = values
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[key]; !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%v", v), true
+}
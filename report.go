@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// dryRun disables all changes to the output directory. Changes that
+	// would have been made are recorded so they can be reported.
+	dryRun bool
+	// reportHTML is the path that an HTML report of the changes made to the
+	// output directory is written to, if set.
+	reportHTML string
+)
+
+// Statuses of an output file in the report.
+const (
+	changeAdded    = "added"
+	changeModified = "modified"
+	changeDeleted  = "deleted"
+)
+
+// outputChange is a change made, or that would be made in a dry run, to a
+// single output file.
+type outputChange struct {
+	Path   string
+	Status string
+	Diff   []diffLine
+}
+
+// diffLine is a single line of a line-based diff. Op is ' ' for unchanged
+// lines, '+' for added lines and '-' for removed lines.
+type diffLine struct {
+	Op   string
+	Text string
+}
+
+// outputChanges records the changes made to output files, if --dry-run or
+// --report-html is set.
+var outputChanges []outputChange
+
+// recordingChanges returns true if changes to output files are recorded.
+func recordingChanges() bool {
+	return dryRun || reportHTML != ""
+}
+
+// recordChange records the change made by writing data to, or deleting if
+// data is nil, the output file at path.
+func recordChange(path string, data []byte) error {
+	if !recordingChanges() {
+		return nil
+	}
+	old, err := outputFS.ReadFile(path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	change := outputChange{Path: path}
+	switch {
+	case data == nil && !exists:
+		return nil
+	case data == nil:
+		change.Status = changeDeleted
+	case !exists:
+		change.Status = changeAdded
+	case bytes.Equal(old, data):
+		return nil
+	default:
+		change.Status = changeModified
+	}
+	if rel, err := filepath.Rel(outputDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		change.Path = filepath.ToSlash(rel)
+	}
+	change.Diff = diffLines(string(old), string(data))
+	outputMu.Lock()
+	outputChanges = append(outputChanges, change)
+	outputMu.Unlock()
+	return nil
+}
+
+// maxDiffCells bounds the size of the table used to compute a diff, above
+// which a file is shown as entirely removed and re-added.
+const maxDiffCells = 4 << 20
+
+// diffLines returns a line-based diff of a and b, computed from their longest
+// common subsequence of lines.
+func diffLines(a, b string) []diffLine {
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	}
+	al, bl := split(a), split(b)
+
+	var lines []diffLine
+	if len(al)*len(bl) > maxDiffCells {
+		for _, l := range al {
+			lines = append(lines, diffLine{Op: "-", Text: l})
+		}
+		for _, l := range bl {
+			lines = append(lines, diffLine{Op: "+", Text: l})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of al[i:]
+	// and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			lines = append(lines, diffLine{Op: " ", Text: al[i]})
+			i++
+			j++
+		case j < len(bl) && (i == len(al) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{Op: "+", Text: bl[j]})
+			j++
+		default:
+			lines = append(lines, diffLine{Op: "-", Text: al[i]})
+			i++
+		}
+	}
+	return lines
+}
+
+// reportData is passed to reportTemplate.
+type reportData struct {
+	Time     time.Time
+	DryRun   bool
+	Inputs   []string
+	Counts   map[string]int
+	Timings  []reportTiming
+	Changes  []outputChange
+	Warnings []string
+}
+
+type reportTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// writeHTMLReport writes a standalone HTML report of the changes made to the
+// output directory, along with warnings and statistics about the run, to
+// --report-html.
+func writeHTMLReport(inputs []string) error {
+	if reportHTML == "" {
+		return nil
+	}
+	changes := append([]outputChange(nil), outputChanges...)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	counts := map[string]int{
+		"resources": eventCounts[eventDecoded],
+		"skipped":   eventCounts[eventSkipped],
+	}
+	for _, c := range changes {
+		counts[c.Status]++
+	}
+	data := reportData{
+		Time:     time.Now(),
+		DryRun:   dryRun,
+		Inputs:   inputs,
+		Counts:   counts,
+		Changes:  changes,
+		Warnings: warnings,
+	}
+	if progress != nil {
+		progress.end()
+		for _, t := range progress.timings {
+			data.Timings = append(data.Timings, reportTiming{Phase: t.phase, Duration: t.duration.Round(time.Millisecond)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(reportHTML, buf.Bytes(), 0644)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>manifest-splitter report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+.added, .op-add { color: #116329; }
+.op-add { background: #dafbe1; display: block; }
+.deleted, .op-del { color: #82071e; }
+.op-del { background: #ffebe9; display: block; }
+.modified { color: #9a6700; }
+.warning { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>manifest-splitter report{{ if .DryRun }} (dry run){{ end }}</h1>
+<p>Generated at {{ .Time.Format "2006-01-02 15:04:05 MST" }} from {{ len .Inputs }} inputs.</p>
+
+<h2>Statistics</h2>
+<table>
+<tr><th>Resources decoded</th><td>{{ index .Counts "resources" }}</td></tr>
+<tr><th>Resources skipped</th><td>{{ index .Counts "skipped" }}</td></tr>
+<tr><th>Files added</th><td>{{ index .Counts "added" }}</td></tr>
+<tr><th>Files modified</th><td>{{ index .Counts "modified" }}</td></tr>
+<tr><th>Files deleted</th><td>{{ index .Counts "deleted" }}</td></tr>
+<tr><th>Warnings</th><td>{{ len .Warnings }}</td></tr>
+</table>
+{{ if .Timings }}
+<h3>Phase timings</h3>
+<table>
+{{ range .Timings }}<tr><th>{{ .Phase }}</th><td>{{ .Duration }}</td></tr>
+{{ end }}</table>
+{{ end }}
+
+{{ if .Warnings }}
+<h2>Warnings</h2>
+<ul>
+{{ range .Warnings }}<li class="warning">{{ . }}</li>
+{{ end }}</ul>
+{{ end }}
+
+<h2>Changes</h2>
+{{ if not .Changes }}<p>No output files changed.</p>{{ end }}
+<ul>
+{{ range .Changes }}<li><span class="{{ .Status }}">{{ .Status }}</span> <a href="#{{ .Path }}">{{ .Path }}</a></li>
+{{ end }}</ul>
+
+{{ range .Changes }}
+<details id="{{ .Path }}"{{ if ne .Status "deleted" }} open{{ end }}>
+<summary><span class="{{ .Status }}">{{ .Status }}</span> {{ .Path }}</summary>
+<pre>{{ range .Diff }}{{ if eq .Op "+" }}<span class="op-add">+{{ .Text }}</span>{{ else if eq .Op "-" }}<span class="op-del">-{{ .Text }}</span>{{ else }} {{ .Text }}
+{{ end }}{{ end }}</pre>
+</details>
+{{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/munnerz/manifest-splitter/api/config/v1alpha1"
+	"github.com/munnerz/manifest-splitter/expr"
+)
+
+// route is a compiled routing rule from the config file.
+type route struct {
+	name    string
+	match   *expr.Program
+	path    *template.Template
+	warning *template.Template
+}
+
+// defaultRoutes, and the mesh routes if enabled, are appended to the routes
+// in the config file. A route in the config file with the same name as a
+// default route replaces it.
+var defaultRoutes = []v1alpha1.Route{
+	{
+		// ACM requires its Repo and HierarchyConfig to be in system/
+		Name:  "acm-system",
+		Match: "object.apiVersion == '" + acmAPIVersion + "' && object.kind in ['Repo', 'HierarchyConfig']",
+		Path:  "system",
+	},
+}
+
+// withDefaultRoutes returns the given routes followed by the default routes,
+// omitting any default route that has the same name as one of the given
+// routes.
+func withDefaultRoutes(rules []v1alpha1.Route) []v1alpha1.Route {
+	names := make(map[string]bool)
+	for _, r := range rules {
+		names[r.Name] = true
+	}
+	all := append([]v1alpha1.Route(nil), rules...)
+	for _, r := range append(append([]v1alpha1.Route(nil), defaultRoutes...), meshRoutes()...) {
+		if !names[r.Name] {
+			all = append(all, r)
+		}
+	}
+	return all
+}
+
+// routes are evaluated in order against each resource, and the first that
+// matches chooses the directory the resource is written to.
+var routes []route
+
+// routeData is the data passed to a route's path template.
+type routeData struct {
+	Kind, Group, Version string
+	Namespace, Name      string
+	Labels, Annotations  map[string]string
+	// NamespaceDir is the directory of the resource's namespace within the
+	// namespaces/ directory, or empty for cluster scoped resources.
+	NamespaceDir string
+	// Default is the directory the resource would be written to if no
+	// route matched it.
+	Default string
+}
+
+// compileRoutes compiles the routes in the config file. The config file has
+// already been validated.
+func compileRoutes(rules []v1alpha1.Route) ([]route, error) {
+	var compiled []route
+	for _, r := range rules {
+		match, err := expr.Compile(r.Match, v1alpha1.RouteVariables...)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", r.Name, err)
+		}
+		path, err := template.New(r.Name).Option("missingkey=error").Parse(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", r.Name, err)
+		}
+		rt := route{name: r.Name, match: match, path: path}
+		if r.Warning != "" {
+			if rt.warning, err = template.New(r.Name).Option("missingkey=error").Parse(r.Warning); err != nil {
+				return nil, fmt.Errorf("route %q: %v", r.Name, err)
+			}
+		}
+		compiled = append(compiled, rt)
+	}
+	return compiled, nil
+}
+
+// routeResources sets the output directory of each resource matched by a
+// route. It must be called once namespace directories have been rendered.
+func routeResources(outputs map[string][]resource) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	for ns, resources := range outputs {
+		for i := range resources {
+			r := &resources[i]
+			dir, ok, err := routeDir(*r, ns)
+			if err != nil {
+				return fmt.Errorf("routing %s %s: %v", r.obj.GetKind(), describeObject(r.obj), err)
+			}
+			if ok {
+				r.dir = dir
+			}
+		}
+	}
+	return nil
+}
+
+// routeDir returns the directory chosen by the first route that matches the
+// given resource, if any.
+func routeDir(r resource, ns string) (string, bool, error) {
+	vars := map[string]interface{}{
+		"object":     r.obj.Object,
+		"namespaced": r.namespaced,
+	}
+	for _, rt := range routes {
+		ok, err := rt.match.Matches(vars)
+		if err != nil {
+			return "", false, fmt.Errorf("evaluating route %q: %v", rt.name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		gvk := r.obj.GroupVersionKind()
+		data := routeData{
+			Kind:        gvk.Kind,
+			Group:       gvk.Group,
+			Version:     gvk.Version,
+			Namespace:   r.obj.GetNamespace(),
+			Name:        r.obj.GetName(),
+			Labels:      r.obj.GetLabels(),
+			Annotations: r.obj.GetAnnotations(),
+			Default:     filepath.ToSlash(resourceDir(r, ns)),
+		}
+		if ns != "" {
+			data.NamespaceDir = filepath.ToSlash(namespaceDir(ns))
+		}
+		buf := &bytes.Buffer{}
+		if err := rt.path.Execute(buf, data); err != nil {
+			return "", false, fmt.Errorf("rendering path of route %q: %v", rt.name, err)
+		}
+		dir := filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+		if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return "", false, fmt.Errorf("path %q rendered by route %q must be a relative path within the output directory", buf.String(), rt.name)
+		}
+		log.Printf("Routing %s %s to %s using route %q", gvk.Kind, describeObject(r.obj), filepath.ToSlash(dir), rt.name)
+		if rt.warning != nil {
+			buf.Reset()
+			if err := rt.warning.Execute(buf, data); err != nil {
+				return "", false, fmt.Errorf("rendering warning of route %q: %v", rt.name, err)
+			}
+			warnf("%s", strings.TrimSpace(buf.String()))
+		}
+		return dir, true, nil
+	}
+	return "", false, nil
+}
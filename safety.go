@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkOutputDir guards against writing into the wrong directory, e.g. due to
+// a typo in --output, as output files may be overwritten and stale files
+// pruned. Unless --force is set, writing into the filesystem root, the root
+// of the user's home directory, or the root of a git repository (other than
+// with --git-commit) is refused. If --create-output-dir is false, the
+// directory must already exist.
+func checkOutputDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	switch {
+	case os.IsNotExist(err):
+		if !createOutputDir {
+			return fmt.Errorf("output directory %q does not exist, and --create-output-dir is false", dir)
+		}
+		return nil
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("output path %q is not a directory", dir)
+	}
+	if force {
+		return nil
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	if abs == filepath.Dir(abs) {
+		return fmt.Errorf("refusing to write into the filesystem root %q without --force", dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(home); err == nil {
+			home = resolved
+		}
+		if abs == filepath.Clean(home) {
+			return fmt.Errorf("refusing to write into the home directory %q without --force", dir)
+		}
+	}
+	if !gitCommit {
+		if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil {
+			return fmt.Errorf("refusing to write into the root of the git repository %q without --force or --git-commit", dir)
+		}
+	}
+	return nil
+}
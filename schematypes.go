@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// preserveScalarTypes is set by --preserve-scalar-types. When true,
+	// scalar fields whose decoded type does not match their schema, e.g. an
+	// annotation value of 'on' read as a YAML 1.1 boolean, or a port of
+	// "8080" given as a string, are written with the type of their schema
+	// when the resource is re-encoded.
+	preserveScalarTypes bool
+	// openAPISchemaFiles are paths to OpenAPI documents, as served by an
+	// apiserver at /openapi/v2 or /openapi/v3/<group-version>, giving the
+	// schemas of built-in resource types.
+	openAPISchemaFiles []string
+)
+
+// scalarSchemas holds the schema of each resource type known from
+// --openapi-schema files and the CRDs in the inputs.
+var scalarSchemas = make(map[schema.GroupVersionKind]map[string]interface{})
+
+// openAPIDefinitions holds every definition of the --openapi-schema files,
+// keyed by the $ref that refers to it.
+var openAPIDefinitions = make(map[string]interface{})
+
+// objectMetaSchema is the part of the schema of ObjectMeta used for every
+// resource, as the metadata of custom resources is not described by their
+// CRD.
+var objectMetaSchema = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"name":         map[string]interface{}{"type": "string"},
+		"namespace":    map[string]interface{}{"type": "string"},
+		"generateName": map[string]interface{}{"type": "string"},
+		"labels": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "string"},
+		},
+		"annotations": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "string"},
+		},
+	},
+}
+
+// integerString matches strings that are read as integers when the type of
+// their field is an integer. Port names cannot consist of only digits, so a
+// string of digits in an int-or-string field is also an integer.
+var integerString = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+// scalarFix replaces the scalar at path, if it is still from, by to.
+type scalarFix struct {
+	path     []interface{}
+	from, to interface{}
+}
+
+// loadOpenAPISchemas reads the --openapi-schema files, recording the schema of
+// each resource type they describe.
+func loadOpenAPISchemas() error {
+	for _, filename := range openAPISchemaFiles {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to decode OpenAPI schema %q: %v", filename, err)
+		}
+		definitions, _, _ := unstructured.NestedMap(doc, "definitions")
+		prefix := "#/definitions/"
+		if len(definitions) == 0 {
+			definitions, _, _ = unstructured.NestedMap(doc, "components", "schemas")
+			prefix = "#/components/schemas/"
+		}
+		if len(definitions) == 0 {
+			return fmt.Errorf("OpenAPI schema %q contains no definitions", filename)
+		}
+		for name, def := range definitions {
+			def, ok := def.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			openAPIDefinitions[prefix+name] = def
+			gvks, _, _ := unstructured.NestedSlice(def, "x-kubernetes-group-version-kind")
+			for _, gvk := range gvks {
+				gvk, ok := gvk.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				group, _ := gvk["group"].(string)
+				version, _ := gvk["version"].(string)
+				kind, _ := gvk["kind"].(string)
+				scalarSchemas[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = def
+			}
+		}
+	}
+	return nil
+}
+
+// loadResourceSchemas records the schemas of the resource types described by
+// the --openapi-schema files and the CRDs in files, used by
+// --preserve-scalar-types and --prune-empty.
+func loadResourceSchemas(files map[string][]resource) error {
+	if err := loadOpenAPISchemas(); err != nil {
+		return err
+	}
+	for _, resources := range files {
+		for _, r := range resources {
+			gvk := r.obj.GroupVersionKind()
+			if gvk.Group != "apiextensions.k8s.io" || gvk.Kind != "CustomResourceDefinition" {
+				continue
+			}
+			obj, err := r.fullObject()
+			if err != nil {
+				return err
+			}
+			for _, s := range crdSchemas(obj) {
+				scalarSchemas[schema.GroupVersionKind{Group: s.group, Version: s.version, Kind: s.kind}] = s.schema
+			}
+		}
+	}
+	return nil
+}
+
+// findScalarTypeFixes records, on each resource in files, the scalar fields
+// whose type does not match the schema of the resource's type, and the value
+// each is written as if the resource is re-encoded. Values read as another
+// type from YAML input are restored to the text they were written as. The
+// schemas must first be loaded by loadResourceSchemas.
+func findScalarTypeFixes(files map[string][]resource) {
+	found := 0
+	for _, resources := range files {
+		for i := range resources {
+			r := &resources[i]
+			// the body of a streamed resource is read back for as long as it
+			// is needed here; one that can no longer be read fails when it is
+			// written
+			obj, data, err := r.readBody()
+			if err != nil {
+				continue
+			}
+			var fixes []scalarFix
+			if obj.IsList() {
+				items, _, _ := unstructured.NestedSlice(obj.Object, "items")
+				for j, item := range items {
+					if item, ok := item.(map[string]interface{}); ok {
+						gvk := (&unstructured.Unstructured{Object: item}).GroupVersionKind()
+						findScalarFixes(&fixes, item, resourceSchema(gvk), []interface{}{"items", j})
+					}
+				}
+			} else {
+				findScalarFixes(&fixes, obj.Object, resourceSchema(obj.GroupVersionKind()), nil)
+			}
+			if len(fixes) == 0 {
+				continue
+			}
+			if r.format == yamlFormat && data != nil {
+				restoreScalarLiterals(fixes, data)
+			}
+			r.scalarFixes = fixes
+			found += len(fixes)
+		}
+	}
+	if found > 0 {
+		log.Printf("Found %d fields whose type does not match their schema, which are corrected in resources that are re-encoded", found)
+	}
+}
+
+// resourceSchema returns the schema used for resources of the given type:
+// that of the type, if known, with objectMetaSchema as its metadata.
+func resourceSchema(gvk schema.GroupVersionKind) map[string]interface{} {
+	properties := make(map[string]interface{})
+	if s := resolveSchema(scalarSchemas[gvk]); s != nil {
+		if p, ok := s["properties"].(map[string]interface{}); ok {
+			for k, v := range p {
+				properties[k] = v
+			}
+		}
+	}
+	properties["metadata"] = objectMetaSchema
+	return map[string]interface{}{"properties": properties}
+}
+
+// resolveSchema follows the $ref of s, or its single allOf schema, to the
+// schema that describes the field.
+func resolveSchema(s map[string]interface{}) map[string]interface{} {
+	// bound the number of references followed, in case they form a cycle
+	for i := 0; i < 32 && s != nil; i++ {
+		if ref, ok := s["$ref"].(string); ok {
+			s, _ = openAPIDefinitions[ref].(map[string]interface{})
+			continue
+		}
+		all, ok := s["allOf"].([]interface{})
+		if !ok || len(all) != 1 || s["type"] != nil || s["properties"] != nil {
+			return s
+		}
+		s, _ = all[0].(map[string]interface{})
+	}
+	return s
+}
+
+// findScalarFixes appends a fix for each scalar within value, at path, whose
+// type does not match its schema s.
+func findScalarFixes(fixes *[]scalarFix, value interface{}, s map[string]interface{}, path []interface{}) {
+	s = resolveSchema(s)
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := s["properties"].(map[string]interface{})
+		additional, _ := s["additionalProperties"].(map[string]interface{})
+		for k, child := range v {
+			childSchema, ok := properties[k].(map[string]interface{})
+			if !ok {
+				childSchema = additional
+			}
+			findScalarFixes(fixes, child, childSchema, appendPath(path, k))
+		}
+	case []interface{}:
+		items, _ := s["items"].(map[string]interface{})
+		for i, child := range v {
+			findScalarFixes(fixes, child, items, appendPath(path, i))
+		}
+	default:
+		if to, ok := scalarFixFor(v, s); ok {
+			*fixes = append(*fixes, scalarFix{path: path, from: v, to: to})
+		}
+	}
+}
+
+// scalarFixFor returns the value that v is written as to match its schema s,
+// and true if that differs from v.
+func scalarFixFor(v interface{}, s map[string]interface{}) (interface{}, bool) {
+	typ, _ := s["type"].(string)
+	intOrString := s["x-kubernetes-int-or-string"] == true || s["format"] == "int-or-string"
+	switch {
+	case intOrString || typ == "integer":
+		if str, ok := v.(string); ok && integerString.MatchString(str) {
+			if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+				return n, true
+			}
+		}
+	case typ == "string":
+		switch x := v.(type) {
+		case bool:
+			return strconv.FormatBool(x), true
+		case int64:
+			return strconv.FormatInt(x, 10), true
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), true
+		}
+	}
+	return nil, false
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(path)+1), path...), elem)
+}
+
+// restoreScalarLiterals replaces the string each fix converts a non-string to
+// with the text of the plain scalar it was decoded from in the YAML document
+// data, so that e.g. 'on' is not written as 'true', or '1.10' as '1.1'.
+func restoreScalarLiterals(fixes []scalarFix, data []byte) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+	for i, fix := range fixes {
+		if _, ok := fix.to.(string); !ok {
+			continue
+		}
+		if n := yamlNodeAt(doc.Content[0], fix.path); n != nil && n.Kind == yamlv3.ScalarNode && n.Style == 0 {
+			fixes[i].to = n.Value
+		}
+	}
+}
+
+// yamlNodeAt returns the node at path within n, or nil if there is none.
+func yamlNodeAt(n *yamlv3.Node, path []interface{}) *yamlv3.Node {
+	for _, elem := range path {
+		switch key := elem.(type) {
+		case string:
+			if n.Kind != yamlv3.MappingNode {
+				return nil
+			}
+			var next *yamlv3.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == key {
+					next = n.Content[i+1]
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+		case int:
+			if n.Kind != yamlv3.SequenceNode || key >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[key]
+		}
+	}
+	return n
+}
+
+// applyScalarFixes applies each fix to obj whose field has not since been
+// changed.
+func applyScalarFixes(obj map[string]interface{}, fixes []scalarFix) {
+	for _, fix := range fixes {
+		var parent interface{} = obj
+		for _, elem := range fix.path[:len(fix.path)-1] {
+			parent = pathElem(parent, elem)
+		}
+		last := fix.path[len(fix.path)-1]
+		if !reflect.DeepEqual(pathElem(parent, last), fix.from) {
+			continue
+		}
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[last.(string)] = fix.to
+		case []interface{}:
+			p[last.(int)] = fix.to
+		}
+	}
+}
+
+// pathElem returns the child of v at elem, a map key or list index, or nil if
+// there is none.
+func pathElem(v interface{}, elem interface{}) interface{} {
+	switch key := elem.(type) {
+	case string:
+		if m, ok := v.(map[string]interface{}); ok {
+			return m[key]
+		}
+	case int:
+		if l, ok := v.([]interface{}); ok && key < len(l) {
+			return l[key]
+		}
+	}
+	return nil
+}
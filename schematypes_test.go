@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScalarFixFor(t *testing.T) {
+	str := map[string]interface{}{"type": "string"}
+	integer := map[string]interface{}{"type": "integer"}
+	intOrString := map[string]interface{}{"x-kubernetes-int-or-string": true}
+	tests := []struct {
+		v      interface{}
+		schema map[string]interface{}
+		want   interface{}
+		fixed  bool
+	}{
+		{v: true, schema: str, want: "true", fixed: true},
+		{v: int64(8080), schema: str, want: "8080", fixed: true},
+		{v: 1.1, schema: str, want: "1.1", fixed: true},
+		{v: "on", schema: str},
+		{v: "8080", schema: integer, want: int64(8080), fixed: true},
+		{v: "-1", schema: integer, want: int64(-1), fixed: true},
+		{v: "08", schema: integer},
+		{v: "http", schema: integer},
+		{v: "8080", schema: intOrString, want: int64(8080), fixed: true},
+		{v: "8080", schema: map[string]interface{}{"format": "int-or-string"}, want: int64(8080), fixed: true},
+		{v: "http", schema: intOrString},
+		{v: int64(1), schema: map[string]interface{}{"type": "number"}},
+		{v: true, schema: nil},
+	}
+	for _, test := range tests {
+		got, fixed := scalarFixFor(test.v, test.schema)
+		if fixed != test.fixed || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("scalarFixFor(%#v, %v) = %#v, %v, want %#v, %v", test.v, test.schema, got, fixed, test.want, test.fixed)
+		}
+	}
+}
+
+func TestResolveSchema(t *testing.T) {
+	defer func(defs map[string]interface{}) { openAPIDefinitions = defs }(openAPIDefinitions)
+	port := map[string]interface{}{"type": "integer"}
+	openAPIDefinitions = map[string]interface{}{
+		"#/definitions/port":  port,
+		"#/definitions/alias": map[string]interface{}{"$ref": "#/definitions/port"},
+		"#/definitions/loop":  map[string]interface{}{"$ref": "#/definitions/loop"},
+	}
+	tests := []struct {
+		name string
+		in   map[string]interface{}
+		want map[string]interface{}
+	}{
+		{name: "nil", in: nil, want: nil},
+		{name: "plain", in: port, want: port},
+		{name: "ref", in: map[string]interface{}{"$ref": "#/definitions/alias"}, want: port},
+		{name: "missing ref", in: map[string]interface{}{"$ref": "#/definitions/missing"}, want: nil},
+		{name: "allOf", in: map[string]interface{}{"allOf": []interface{}{map[string]interface{}{"$ref": "#/definitions/port"}}}, want: port},
+		{
+			name: "allOf with type",
+			in:   map[string]interface{}{"type": "object", "allOf": []interface{}{port}},
+			want: map[string]interface{}{"type": "object", "allOf": []interface{}{port}},
+		},
+		{name: "cycle", in: map[string]interface{}{"$ref": "#/definitions/loop"}, want: openAPIDefinitions["#/definitions/loop"].(map[string]interface{})},
+	}
+	for _, test := range tests {
+		if got := resolveSchema(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScalarFixes(t *testing.T) {
+	s := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"metadata": objectMetaSchema,
+			"spec": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"ports": map[string]interface{}{
+						"items": map[string]interface{}{
+							"properties": map[string]interface{}{
+								"port":       map[string]interface{}{"type": "integer"},
+								"targetPort": map[string]interface{}{"x-kubernetes-int-or-string": true},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	data := []byte(`metadata:
+  annotations:
+    enabled: on
+    version: 1.10
+    quoted: "on"
+spec:
+  ports:
+  - port: "80"
+    targetPort: "8080"
+  - port: 443
+    targetPort: https
+`)
+	obj, err := decodeYAMLObject(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fixes []scalarFix
+	findScalarFixes(&fixes, obj, s, nil)
+	if len(fixes) != 4 {
+		t.Fatalf("got %d fixes, want 4: %v", len(fixes), fixes)
+	}
+	restoreScalarLiterals(fixes, data)
+
+	// a field changed since the fixes were found is not fixed
+	obj["spec"].(map[string]interface{})["ports"].([]interface{})[0].(map[string]interface{})["port"] = "81"
+	applyScalarFixes(obj, fixes)
+
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"enabled": "on", "version": "1.10", "quoted": "on"},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"port": "81", "targetPort": int64(8080)},
+				map[string]interface{}{"port": int64(443), "targetPort": "https"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(obj, want) {
+		t.Errorf("got %v, want %v", obj, want)
+	}
+}
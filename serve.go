@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+var (
+	// serveAddress is the address that the 'serve' subcommand listens on.
+	serveAddress string
+	// serveTLSCertFile and serveTLSKeyFile are the certificate and key that
+	// the 'serve' subcommand serves HTTPS with. If they are not set, plain
+	// HTTP is served, which admission webhooks cannot use.
+	serveTLSCertFile string
+	serveTLSKeyFile  string
+)
+
+// maxBundleSize is the largest manifest bundle accepted by the HTTP API.
+const maxBundleSize = 64 << 20
+
+// splitReport is the JSON response returned by the HTTP API when a report is
+// requested instead of a tar of the output tree.
+type splitReport struct {
+	Files    []splitReportFile `json:"files"`
+	Warnings []string          `json:"warnings"`
+}
+
+type splitReportFile struct {
+	Path       string `json:"path"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// splitServer serves the HTTP API. Discovery information is cached by the
+// inspector and reused between requests.
+type splitServer struct {
+	inspector discovery.ResourceInspector
+	// mu serialises splits, as a split uses package level state such as
+	// the warnings emitted and the namespace directory names.
+	mu sync.Mutex
+}
+
+// runServe implements the 'serve' subcommand, which exposes splitting over an
+// HTTP API so that other services can use the splitter without exec-ing it
+// for each request:
+//
+//	POST /split      splits the manifest bundle in the request body,
+//	                 returning a tar of the output tree, or a JSON report if
+//	                 the request has '?output=json'
+//	POST /placement  returns the path each resource in the request body
+//	                 would be written to, optionally as a validating webhook
+//	GET /healthz     returns 200 once the server is running
+func runServe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: manifest-splitter serve")
+	}
+	inspector, err := newResourceInspector()
+	if err != nil {
+		return err
+	}
+	if err := resolveKindFilters(inspector); err != nil {
+		return fmt.Errorf("resolving kind filters: %v", err)
+	}
+
+	s := &splitServer{inspector: inspector}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/split", s.handleSplit)
+	mux.HandleFunc("/placement", s.handlePlacement)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	if serveTLSCertFile != "" {
+		log.Printf("Listening on %s with TLS", serveAddress)
+		return http.ListenAndServeTLS(serveAddress, serveTLSCertFile, serveTLSKeyFile, mux)
+	}
+	log.Printf("Listening on %s", serveAddress)
+	return http.ListenAndServe(serveAddress, mux)
+}
+
+func (s *splitServer) handleSplit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	output := r.URL.Query().Get("output")
+	if output != "" && output != "tar" && output != "json" {
+		http.Error(w, fmt.Sprintf("output must be %q or %q, got %q", "tar", "json", output), http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "bundle.yaml"
+	}
+
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	resources, err := decodeResourceManifest(name, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode manifest bundle: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	out := outputfs.NewMem()
+	written, warnings, err := s.split(map[string][]resource{name: resources}, out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if output == "json" {
+		report := splitReport{Files: []splitReportFile{}, Warnings: warnings}
+		for _, f := range written {
+			report.Files = append(report.Files, splitReportFile{
+				Path:       filepath.ToSlash(f.path),
+				APIVersion: f.resource.obj.GetAPIVersion(),
+				Kind:       f.resource.obj.GetKind(),
+				Namespace:  f.resource.obj.GetNamespace(),
+				Name:       f.resource.obj.GetName(),
+			})
+		}
+		writeJSON(w, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err := out.WriteTar(w); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// readRequestBody reads the body of r, of at most maxBundleSize bytes. If it
+// could not be read, an error is written to w and ok is false, with a status
+// of 413 if the body is too large.
+func readRequestBody(w http.ResponseWriter, r *http.Request) (body []byte, ok bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+	switch {
+	case err != nil && len(body) >= maxBundleSize:
+		http.Error(w, fmt.Sprintf("request body is larger than the limit of %d bytes", maxBundleSize), http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// split splits the given resources into the root of the given filesystem,
+// returning the files written and any warnings emitted.
+func (s *splitServer) split(files map[string][]resource, out outputfs.FS) ([]outputFile, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	outputFS = out
+	defer func() { outputFS = outputfs.OS{} }()
+
+	warnings, namespaceParents, namespaceDirs = nil, nil, nil
+	currentState = &splitterState{Version: stateVersion, Outputs: make(map[string]stateOutput)}
+
+	_, written, err := splitResources(s.inspector, files, ".")
+	if err != nil {
+		discardStagedOutput()
+		return nil, nil, err
+	}
+	if atomicWrites {
+		if err := commitStagedOutput(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return written, warnings, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+const (
+	// checksumsFilename is the name of the file, within the output
+	// directory, listing the SHA-256 checksum of every output file.
+	checksumsFilename = "SHA256SUMS"
+	// signatureFilename and certificateFilename are the cosign signature of
+	// the checksums file, and the Sigstore certificate if signed keylessly.
+	signatureFilename   = checksumsFilename + ".sig"
+	certificateFilename = checksumsFilename + ".pem"
+
+	// signKeyless is given to --sign-key to sign using Sigstore keyless
+	// signing rather than a key.
+	signKeyless = "keyless"
+)
+
+var (
+	// sign is whether a checksums file is written for the output tree.
+	sign bool
+	// signKey is the cosign key reference used to sign the checksums file,
+	// or "keyless". The checksums file is not signed if empty.
+	signKey string
+)
+
+// writeChecksums writes a checksums file listing every file in the output
+// directory, in the format read by 'sha256sum --check', and signs it with
+// cosign if --sign-key is set. Hidden files and directories, such as .git and
+// the state file, are not included.
+func writeChecksums(dir string) error {
+	if !sign {
+		return nil
+	}
+	if dryRun {
+		log.Printf("Not writing %s as --dry-run is set", checksumsFilename)
+		return nil
+	}
+	sums, err := outputChecksums(dir)
+	if err != nil {
+		return fmt.Errorf("computing checksums: %v", err)
+	}
+	if err := writeSigningFile(dir, checksumsFilename, sums); err != nil {
+		return err
+	}
+	if signKey == "" {
+		return nil
+	}
+	return cosignChecksums(dir, sums)
+}
+
+// outputChecksums returns the contents of the checksums file for the output
+// directory. Symlinks are checksummed as the file they point to.
+func outputChecksums(dir string) ([]byte, error) {
+	var sums strings.Builder
+	err := outputfs.Walk(outputFS, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		switch rel {
+		case checksumsFilename, signatureFilename, certificateFilename:
+			return nil
+		}
+		data, err := outputFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(sum[:]), filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sums.String()), nil
+}
+
+// cosignChecksums signs the checksums file using 'cosign sign-blob', writing
+// the signature (and certificate, if signing keylessly) alongside it. cosign
+// is run against a temporary copy, as the output may not be on the local
+// filesystem.
+func cosignChecksums(dir string, sums []byte) error {
+	tmp, err := ioutil.TempDir("", "manifest-splitter-sign")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	sumsPath := filepath.Join(tmp, checksumsFilename)
+	if err := ioutil.WriteFile(sumsPath, sums, 0644); err != nil {
+		return err
+	}
+
+	outputs := []string{signatureFilename}
+	args := []string{"sign-blob", "--yes", "--output-signature", filepath.Join(tmp, signatureFilename)}
+	if signKey == signKeyless {
+		outputs = append(outputs, certificateFilename)
+		args = append(args, "--output-certificate", filepath.Join(tmp, certificateFilename))
+	} else {
+		args = append(args, "--key", signKey)
+	}
+	cmd := exec.Command("cosign", append(args, sumsPath)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	log.Printf("Signing %s using cosign", checksumsFilename)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signing %s with cosign: %v", checksumsFilename, err)
+	}
+
+	for _, name := range outputs {
+		data, err := ioutil.ReadFile(filepath.Join(tmp, name))
+		if err != nil {
+			return fmt.Errorf("reading cosign output: %v", err)
+		}
+		if err := writeSigningFile(dir, name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSigningFile writes a checksums or signature file into the output
+// directory. These are written once all other output has been committed, so
+// are not staged even if --atomic is set.
+func writeSigningFile(dir, name string, data []byte) error {
+	path := filepath.Join(dir, name)
+	if err := outputFS.WriteFile(path, data, fileMode); err != nil {
+		return fmt.Errorf("writing %s: %v", name, err)
+	}
+	return applyOutputPermissions(path, fileModeFlag)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/munnerz/manifest-splitter/discovery"
+)
+
+// splitResources discovers, validates and transforms the resources read from
+// the input files, and writes them into the given output directory. It
+// returns the output resources, keyed by namespace, and details of the files
+// written.
+func splitResources(inspector discovery.ResourceInspector, files map[string][]resource, dir string) (map[string][]resource, []outputFile, error) {
+	progress.begin("discovery", countResources(files), "resources")
+	if err := populateNamespacedField(inspector, files); err != nil {
+		return nil, nil, fmt.Errorf("discovering whether resources are namespaced: %v", err)
+	}
+
+	if len(fanOutNamespaces) > 0 || fanOutNamespacesFile != "" {
+		namespaces, err := loadFanOutNamespaces()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading fan-out namespaces: %v", err)
+		}
+		if err := fanOutResources(files, namespaces); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	progress.begin("validate", 0, "")
+	if err := validateResourceFiles(files); err != nil {
+		return nil, nil, fmt.Errorf("validating input files: %v", err)
+	}
+
+	if lint {
+		progress.begin("lint", 0, "")
+		lintResources(files)
+	}
+
+	if err := checkTenancy(files); err != nil {
+		return nil, nil, err
+	}
+
+	progress.begin("transform", 0, "")
+	if stripStatus {
+		findStatusSubresources(files)
+	}
+	if preserveScalarTypes || pruneEmpty {
+		if err := loadResourceSchemas(files); err != nil {
+			return nil, nil, fmt.Errorf("loading resource schemas: %v", err)
+		}
+	}
+	if preserveScalarTypes {
+		findScalarTypeFixes(files)
+	}
+	if err := transformResourceFiles(files); err != nil {
+		return nil, nil, fmt.Errorf("transforming resources: %v", err)
+	}
+	if err := propagateNamespaceLabels(files); err != nil {
+		return nil, nil, fmt.Errorf("propagating namespace labels: %v", err)
+	}
+
+	if injectConfigHash {
+		if err := injectConfigHashes(files); err != nil {
+			return nil, nil, fmt.Errorf("injecting config hashes: %v", err)
+		}
+	}
+
+	progress.begin("plan", 0, "")
+	if hnc {
+		parents, err := discoverNamespaceHierarchy(files)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discovering namespace hierarchy: %v", err)
+		}
+		namespaceParents = parents
+	}
+
+	detectAmbiguousKinds(files)
+	outputs := groupOutputs(files)
+
+	if initACM {
+		generated, err := generateACMResources(files)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating ACM resources: %v", err)
+		}
+		outputs[""] = append(outputs[""], generated...)
+	}
+
+	if layout == layoutKapp {
+		generated, err := generateKappConfig(files)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating kapp Config: %v", err)
+		}
+		outputs[""] = append(outputs[""], generated...)
+	}
+
+	if generateQuotas {
+		generated, err := generateQuotaResources(files, outputs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating quota resources: %v", err)
+		}
+		for ns, resources := range generated {
+			outputs[ns] = append(outputs[ns], resources...)
+		}
+	}
+
+	if generateNetpol {
+		generated, err := generateNetworkPolicies(outputs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating network policies: %v", err)
+		}
+		for ns, resources := range generated {
+			outputs[ns] = append(outputs[ns], resources...)
+		}
+	}
+
+	dirs, err := renderNamespaceDirs(outputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("computing namespace directories: %v", err)
+	}
+	namespaceDirs = dirs
+	if err := routeResources(outputs); err != nil {
+		return nil, nil, err
+	}
+	if explodeWorkloads {
+		if err := explodeWorkloadResources(outputs); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := dedupeResources(outputs); err != nil {
+		return nil, nil, fmt.Errorf("deduplicating resources: %v", err)
+	}
+	if acmValidate {
+		if err := validateACMRepo(plannedOutputs(outputs)); err != nil {
+			return nil, nil, fmt.Errorf("validating ACM repository: %v", err)
+		}
+	}
+
+	root := dir
+	if len(environments) > 0 {
+		root = filepath.Join(dir, "base")
+	}
+	progress.begin("write", countResources(outputs), "files")
+	written, err := writeOutputs(root, outputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("writing output files: %v", err)
+	}
+
+	if verifyRoundTrip {
+		progress.begin("verify", 0, "")
+		if err := verifyOutputs(root, written); err != nil {
+			return nil, nil, fmt.Errorf("verifying output files: %v", err)
+		}
+	}
+
+	if len(environments) > 0 {
+		if err := writeKustomizeEnvironments(dir, written); err != nil {
+			return nil, nil, fmt.Errorf("writing kustomize environments: %v", err)
+		}
+	}
+
+	if err := writeCodeowners(dir, root, outputs); err != nil {
+		return nil, nil, fmt.Errorf("writing CODEOWNERS: %v", err)
+	}
+	if err := writeCRDDocs(dir, outputs); err != nil {
+		return nil, nil, fmt.Errorf("writing CRD schemas: %v", err)
+	}
+
+	return outputs, written, nil
+}
+
+// groupOutputs groups the resources in the given input files by the namespace
+// whose directory they are written to. Cluster scoped resources are grouped
+// under the empty namespace.
+func groupOutputs(files map[string][]resource) map[string][]resource {
+	outputs := make(map[string][]resource)
+	for _, resources := range files {
+		for _, resource := range resources {
+			log.Printf("Processing resource %q", resource.obj.GetName())
+			ns := resource.obj.GetNamespace()
+			if resource.obj.IsList() {
+				log.Printf("Encountered list in file %q", resource.inputFilename)
+				ns = resource.listNamespaceName
+			}
+			if resource.obj.GetKind() == "Namespace" && resource.obj.GetAPIVersion() == "v1" {
+				ns = resource.obj.GetName()
+			}
+			outputs[ns] = append(outputs[ns], resource)
+		}
+	}
+	return outputs
+}
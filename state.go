@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// stateFilename is the name of the file, within the output directory, used
+// to record the output files written by the previous run when --state is
+// set.
+const stateFilename = ".manifest-splitter-state.json"
+
+const stateVersion = 1
+
+// splitterState records each output file written by a run, along with the
+// input it was read from and a hash of its contents.
+type splitterState struct {
+	Version int `json:"version"`
+	// Outputs is keyed by the path of each output file, relative to the
+	// output directory.
+	Outputs map[string]stateOutput `json:"outputs"`
+}
+
+type stateOutput struct {
+	// Input is the input file that the resource was read from, or empty if
+	// the resource was generated.
+	Input string `json:"input,omitempty"`
+	Hash  string `json:"hash"`
+	// Kind and Name identify the resource written to the file, and Scope
+	// is either 'Namespaced' or 'Cluster'. They are used to detect
+	// resources whose scope has changed between runs, and are not set by
+	// older versions.
+	Kind  string `json:"kind,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes recorded in the state.
+const (
+	scopeNamespaced = "Namespaced"
+	scopeCluster    = "Cluster"
+)
+
+var (
+	// previousState is the state recorded by the previous run, or nil if
+	// --state is not set.
+	previousState *splitterState
+	// currentState accumulates the state of the current run.
+	currentState = &splitterState{Version: stateVersion, Outputs: make(map[string]stateOutput)}
+)
+
+// loadState reads the state file from the given output directory. An empty
+// state is returned if the file does not exist.
+func loadState(dir string) (*splitterState, error) {
+	state := &splitterState{Version: stateVersion, Outputs: make(map[string]stateOutput)}
+	data, err := outputFS.ReadFile(filepath.Join(dir, stateFilename))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("decoding state file: %v", err)
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+	if state.Outputs == nil {
+		state.Outputs = make(map[string]stateOutput)
+	}
+	return state, nil
+}
+
+// saveState writes the state of the current run into the given output
+// directory. The file is replaced atomically, regardless of --atomic, so
+// that an interrupted run never leaves a corrupt state file.
+func saveState(dir string) error {
+	data, err := json.MarshalIndent(currentState, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, stateFilename)
+	tmp, err := stageFile(path, append(data, '\n'), fileMode)
+	if err != nil {
+		return err
+	}
+	delete(staging.files, path)
+	if err := applyOutputPermissions(tmp, fileModeFlag); err != nil {
+		outputFS.Remove(tmp)
+		return err
+	}
+	return outputFS.Rename(tmp, path)
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// stateKey returns the key of an output file in the state, given the output
+// root and the path of the file relative to it.
+func stateKey(root, path string) string {
+	rel, err := filepath.Rel(outputDir, filepath.Join(root, path))
+	if err != nil {
+		return filepath.Join(root, path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// recordOutput records an output file in the state of the current run.
+func recordOutput(key string, r resource, hash string) {
+	out := stateOutput{Input: r.inputFilename, Hash: hash}
+	if !r.obj.IsList() {
+		gk := r.obj.GroupVersionKind().GroupKind()
+		out.Kind, out.Name, out.Scope = gk.String(), r.obj.GetName(), scopeCluster
+		if r.namespaced {
+			out.Scope = scopeNamespaced
+		}
+	}
+	currentState.Outputs[key] = out
+}
+
+// addScopeMoves adds the output files of resources whose scope has changed
+// to renames, so that they are moved with git like renamed resources. Files
+// that have been modified since they were written, or whose new path is
+// already the target of another move, are left to be pruned.
+func addScopeMoves(dir string, renames, moves map[string]string) error {
+	targets := make(map[string]bool)
+	for _, newKey := range renames {
+		targets[newKey] = true
+	}
+	olds := make([]string, 0, len(moves))
+	for old := range moves {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		newKey := moves[old]
+		if _, ok := renames[old]; ok || targets[newKey] {
+			continue
+		}
+		data, err := outputFS.ReadFile(filepath.Join(dir, filepath.FromSlash(old)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if contentHash(data) != previousState.Outputs[old].Hash {
+			continue
+		}
+		renames[old] = newKey
+		targets[newKey] = true
+	}
+	return nil
+}
+
+// detectScopeChanges returns the given stale output files whose resource is
+// still written, but to a different file because its scope has changed, e.g.
+// because a CRD changed from Namespaced to Cluster scope, mapped to the file
+// it is now written to.
+func detectScopeChanges(stale []string) map[string]string {
+	type resourceID struct{ kind, name string }
+	current := make(map[resourceID][]string)
+	for key, out := range currentState.Outputs {
+		if out.Scope != "" {
+			id := resourceID{out.Kind, out.Name}
+			current[id] = append(current[id], key)
+		}
+	}
+
+	moves := make(map[string]string)
+	for _, key := range stale {
+		prev := previousState.Outputs[key]
+		if prev.Scope == "" {
+			continue
+		}
+		for _, newKey := range current[resourceID{prev.Kind, prev.Name}] {
+			if scope := currentState.Outputs[newKey].Scope; scope != prev.Scope {
+				log.Printf("Scope of %s %q changed from %s to %s, so its output file has moved from %s to %s", prev.Kind, prev.Name, prev.Scope, scope, key, newKey)
+				moves[key] = newKey
+				break
+			}
+		}
+	}
+	return moves
+}
+
+// unchangedOutput returns true if the previous run wrote the given output
+// file with identical contents, and the file still exists.
+func unchangedOutput(key, hash, path string) bool {
+	if previousState == nil {
+		return false
+	}
+	prev, ok := previousState.Outputs[key]
+	if !ok || prev.Hash != hash {
+		return false
+	}
+	_, err := outputFS.Stat(path)
+	return err == nil
+}
+
+// carryOverOutput copies the state of an output file that was not rewritten
+// by this run from the previous state, if present.
+func carryOverOutput(key string) {
+	if previousState == nil {
+		return
+	}
+	if prev, ok := previousState.Outputs[key]; ok {
+		currentState.Outputs[key] = prev
+	}
+}
+
+// pruneStaleOutputs deletes output files that were written by the previous
+// run but not by this one, e.g. because their resource was removed from the
+// inputs. Files that have been modified since they were written are kept,
+// with a warning. Directories left empty are removed.
+// Files whose resource is now written to a different path because its scope
+// changed are logged. If --git-commit is set, they and files whose resource
+// has been renamed are moved to their new path instead, see commitGitRenames.
+func pruneStaleOutputs(dir string) error {
+	if previousState == nil {
+		return nil
+	}
+	var stale []string
+	for key, prev := range previousState.Outputs {
+		if _, ok := currentState.Outputs[key]; ok {
+			continue
+		}
+		if _, failed := failedInputs[prev.Input]; failed {
+			// the resource may still exist in its input, which could
+			// not be read by this run
+			currentState.Outputs[key] = prev
+			continue
+		}
+		stale = append(stale, key)
+	}
+	sort.Strings(stale)
+
+	moves := detectScopeChanges(stale)
+	if gitCommit {
+		renames, err := detectRenames(dir, stale)
+		if err != nil {
+			return fmt.Errorf("detecting renamed resources: %v", err)
+		}
+		if err := addScopeMoves(dir, renames, moves); err != nil {
+			return err
+		}
+		// renamed files are moved rather than deleted below
+		if err := commitGitRenames(dir, renames); err != nil {
+			return fmt.Errorf("renaming output files: %v", err)
+		}
+	}
+
+	for _, key := range stale {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		data, err := outputFS.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if contentHash(data) != previousState.Outputs[key].Hash {
+			warnf("not deleting stale output file %q as it has been modified since it was written", path)
+			currentState.Outputs[key] = previousState.Outputs[key]
+			continue
+		}
+		if err := recordChange(path, nil); err != nil {
+			return err
+		}
+		if dryRun {
+			continue
+		}
+		if err := backupOutputFile(path, nil); err != nil {
+			return fmt.Errorf("backing up %q: %v", path, err)
+		}
+		log.Printf("Deleting stale output file: %s", path)
+		if err := outputFS.Remove(path); err != nil {
+			return err
+		}
+		for d := filepath.Dir(path); d != filepath.Clean(dir); d = filepath.Dir(d) {
+			if outputFS.Remove(d) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+// withStateFS runs f with an empty in-memory output filesystem, and the
+// previous and current states given, restoring them afterwards.
+func withStateFS(previous *splitterState, current map[string]stateOutput, f func(fs *outputfs.Mem)) {
+	defer func(fs outputfs.FS, prev, cur *splitterState) {
+		outputFS, previousState, currentState = fs, prev, cur
+	}(outputFS, previousState, currentState)
+	fs := outputfs.NewMem()
+	outputFS = fs
+	previousState = previous
+	currentState = &splitterState{Version: stateVersion, Outputs: current}
+	f(fs)
+}
+
+func writeMemFile(t *testing.T, fs *outputfs.Mem, path, data string) {
+	t.Helper()
+	elems := strings.Split(path, "/")
+	for i := 1; i < len(elems); i++ {
+		if err := fs.Mkdir(strings.Join(elems[:i], "/"), 0755); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *splitterState
+		wantErr bool
+	}{
+		{name: "missing", want: &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{}}},
+		{
+			name: "outputs",
+			data: `{"version": 1, "outputs": {"cluster/ClusterRole-viewer.yaml": {"input": "rbac.yaml", "hash": "sha256:00", "kind": "ClusterRole.rbac.authorization.k8s.io", "name": "viewer", "scope": "Cluster"}}}`,
+			want: &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{
+				"cluster/ClusterRole-viewer.yaml": {Input: "rbac.yaml", Hash: "sha256:00", Kind: "ClusterRole.rbac.authorization.k8s.io", Name: "viewer", Scope: scopeCluster},
+			}},
+		},
+		{name: "no outputs", data: `{"version": 1}`, want: &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{}}},
+		{name: "unsupported version", data: `{"version": 2}`, wantErr: true},
+		{name: "invalid", data: `{`, wantErr: true},
+	}
+	for _, test := range tests {
+		withStateFS(nil, nil, func(fs *outputfs.Mem) {
+			if test.data != "" {
+				writeMemFile(t, fs, stateFilename, test.data)
+			}
+			got, err := loadState(".")
+			if (err != nil) != test.wantErr {
+				t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	outputs := map[string]stateOutput{
+		"namespaces/app/ConfigMap-config.yaml": {Input: "app.yaml", Hash: contentHash([]byte("a")), Kind: "ConfigMap", Name: "config", Scope: scopeNamespaced},
+	}
+	withStateFS(nil, outputs, func(fs *outputfs.Mem) {
+		if err := saveState("."); err != nil {
+			t.Fatal(err)
+		}
+		if paths := fs.Paths(); !reflect.DeepEqual(paths, []string{stateFilename}) {
+			t.Errorf("got files %q, want only the state file", paths)
+		}
+		got, err := loadState(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, currentState) {
+			t.Errorf("got %+v, want %+v", got, currentState)
+		}
+	})
+}
+
+func TestDetectScopeChanges(t *testing.T) {
+	previous := &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{
+		"namespaces/app/Widget-a.yaml": {Kind: "Widget.example.com", Name: "a", Scope: scopeNamespaced},
+		"namespaces/app/Widget-b.yaml": {Kind: "Widget.example.com", Name: "b", Scope: scopeNamespaced},
+		"namespaces/app/Legacy-c.yaml": {},
+	}}
+	current := map[string]stateOutput{
+		"cluster/Widget-a.yaml":          {Kind: "Widget.example.com", Name: "a", Scope: scopeCluster},
+		"namespaces/other/Widget-b.yaml": {Kind: "Widget.example.com", Name: "b", Scope: scopeNamespaced},
+	}
+	withStateFS(previous, current, func(*outputfs.Mem) {
+		got := detectScopeChanges([]string{"namespaces/app/Legacy-c.yaml", "namespaces/app/Widget-a.yaml", "namespaces/app/Widget-b.yaml"})
+		want := map[string]string{"namespaces/app/Widget-a.yaml": "cluster/Widget-a.yaml"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAddScopeMoves(t *testing.T) {
+	previous := &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{
+		"namespaces/app/Widget-a.yaml": {Hash: contentHash([]byte("a"))},
+		"namespaces/app/Widget-b.yaml": {Hash: contentHash([]byte("b"))},
+		"namespaces/app/Widget-c.yaml": {Hash: contentHash([]byte("c"))},
+		"namespaces/app/Widget-d.yaml": {Hash: contentHash([]byte("d"))},
+		"namespaces/app/Widget-e.yaml": {Hash: contentHash([]byte("e"))},
+	}}
+	withStateFS(previous, nil, func(fs *outputfs.Mem) {
+		writeMemFile(t, fs, "namespaces/app/Widget-a.yaml", "a")
+		writeMemFile(t, fs, "namespaces/app/Widget-b.yaml", "modified")
+		writeMemFile(t, fs, "namespaces/app/Widget-c.yaml", "c")
+		writeMemFile(t, fs, "namespaces/app/Widget-d.yaml", "d")
+		renames := map[string]string{"namespaces/app/Widget-c.yaml": "namespaces/app/Renamed-c.yaml"}
+		moves := map[string]string{
+			"namespaces/app/Widget-a.yaml": "cluster/Widget-a.yaml",
+			// modified since it was written
+			"namespaces/app/Widget-b.yaml": "cluster/Widget-b.yaml",
+			// already renamed
+			"namespaces/app/Widget-c.yaml": "cluster/Widget-c.yaml",
+			// the target of another move
+			"namespaces/app/Widget-d.yaml": "cluster/Widget-a.yaml",
+			// deleted
+			"namespaces/app/Widget-e.yaml": "cluster/Widget-e.yaml",
+		}
+		if err := addScopeMoves(".", renames, moves); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"namespaces/app/Widget-a.yaml": "cluster/Widget-a.yaml",
+			"namespaces/app/Widget-c.yaml": "namespaces/app/Renamed-c.yaml",
+		}
+		if !reflect.DeepEqual(renames, want) {
+			t.Errorf("got %v, want %v", renames, want)
+		}
+	})
+}
+
+func TestPruneStaleOutputs(t *testing.T) {
+	defer func(failed map[string]error) { failedInputs = failed }(failedInputs)
+	failedInputs = map[string]error{"broken.yaml": nil}
+	previous := &splitterState{Version: stateVersion, Outputs: map[string]stateOutput{
+		"namespaces/app/ConfigMap-kept.yaml":     {Input: "app.yaml", Hash: contentHash([]byte("kept"))},
+		"namespaces/old/ConfigMap-stale.yaml":    {Input: "app.yaml", Hash: contentHash([]byte("stale"))},
+		"namespaces/app/ConfigMap-modified.yaml": {Input: "app.yaml", Hash: contentHash([]byte("original"))},
+		"namespaces/app/ConfigMap-failed.yaml":   {Input: "broken.yaml", Hash: contentHash([]byte("failed"))},
+		"namespaces/app/ConfigMap-deleted.yaml":  {Input: "app.yaml", Hash: contentHash([]byte("deleted"))},
+	}}
+	current := map[string]stateOutput{
+		"namespaces/app/ConfigMap-kept.yaml": previous.Outputs["namespaces/app/ConfigMap-kept.yaml"],
+	}
+	withStateFS(previous, current, func(fs *outputfs.Mem) {
+		writeMemFile(t, fs, "namespaces/app/ConfigMap-kept.yaml", "kept")
+		writeMemFile(t, fs, "namespaces/old/ConfigMap-stale.yaml", "stale")
+		writeMemFile(t, fs, "namespaces/app/ConfigMap-modified.yaml", "modified")
+		writeMemFile(t, fs, "namespaces/app/ConfigMap-failed.yaml", "failed")
+		if err := pruneStaleOutputs("."); err != nil {
+			t.Fatal(err)
+		}
+		wantPaths := []string{
+			"namespaces",
+			"namespaces/app",
+			"namespaces/app/ConfigMap-failed.yaml",
+			"namespaces/app/ConfigMap-kept.yaml",
+			"namespaces/app/ConfigMap-modified.yaml",
+		}
+		if paths := fs.Paths(); !reflect.DeepEqual(paths, wantPaths) {
+			t.Errorf("got files %q, want %q", paths, wantPaths)
+		}
+		var keys []string
+		for key := range currentState.Outputs {
+			keys = append(keys, key)
+		}
+		wantKeys := []string{
+			"namespaces/app/ConfigMap-failed.yaml",
+			"namespaces/app/ConfigMap-kept.yaml",
+			"namespaces/app/ConfigMap-modified.yaml",
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, wantKeys) {
+			t.Errorf("got state of %q, want %q", keys, wantKeys)
+		}
+	})
+}
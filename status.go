@@ -0,0 +1,63 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// stripStatus is set by --strip-status. When true, .status is removed from
+// custom resources whose CRD, in the inputs, declares a status subresource,
+// as it is written by controllers rather than being part of the desired
+// state.
+var stripStatus bool
+
+// statusSubresourceKinds is the set of custom resource types in the inputs
+// whose CRD declares a status subresource.
+var statusSubresourceKinds = make(map[schema.GroupVersionKind]bool)
+
+// findStatusSubresources records the custom resource types whose CRD in the
+// given files declares a status subresource. v1beta1 CRDs may declare
+// subresources for all versions at once.
+func findStatusSubresources(files map[string][]resource) {
+	for _, resources := range files {
+		for _, r := range resources {
+			gvk := r.obj.GroupVersionKind()
+			if gvk.Group != "apiextensions.k8s.io" || gvk.Kind != "CustomResourceDefinition" {
+				continue
+			}
+			// a CRD that can no longer be read fails when it is written
+			obj, err := r.fullObject()
+			if err != nil {
+				continue
+			}
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			_, shared, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "subresources", "status")
+			if version, _, _ := unstructured.NestedString(obj.Object, "spec", "version"); version != "" && shared {
+				statusSubresourceKinds[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = true
+			}
+			forEachMap(obj.Object, []string{"spec", "versions"}, func(v map[string]interface{}) {
+				name, _ := v["name"].(string)
+				_, ok, _ := unstructured.NestedFieldNoCopy(v, "subresources", "status")
+				if ok || shared {
+					statusSubresourceKinds[schema.GroupVersionKind{Group: group, Version: name, Kind: kind}] = true
+				}
+			})
+		}
+	}
+}
+
+// stripStatusTransformer removes .status from custom resources whose CRD
+// declares a status subresource.
+type stripStatusTransformer struct{}
+
+func (stripStatusTransformer) Transform(r *resource) (bool, error) {
+	if !statusSubresourceKinds[r.obj.GroupVersionKind()] {
+		return false, nil
+	}
+	if _, ok := r.obj.Object["status"]; !ok {
+		return false, nil
+	}
+	delete(r.obj.Object, "status")
+	return true, nil
+}
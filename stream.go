@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// streamThreshold is the size in bytes above which unmodified resources are
+// not held in memory. Plain input files are decoded one document at a time,
+// and only the location and header (apiVersion, kind and metadata) of each
+// larger document is kept. Its raw data is copied from the input file to its
+// output file when written. Zero disables this.
+var streamThreshold int
+
+// headerFields are the fields of a streamed resource's object that are held
+// in memory. They are all that is needed to place a resource, and changes to
+// them are merged into the rest of the resource when it is encoded.
+var headerFields = []string{"apiVersion", "kind", "metadata"}
+
+// inputRange locates the raw data of a resource within its input file.
+type inputRange struct {
+	filename       string
+	offset, length int64
+	// hash is the contentHash of the raw data.
+	hash string
+	// size and modTime are those of the input file when it was decoded, so
+	// that changes to it during the run are detected.
+	size    int64
+	modTime time.Time
+}
+
+// resourceBodiesRead returns true if a feature is enabled that reads fields
+// of resources other than their header outside of transformers, in which
+// case streamed resources keep their whole decoded object, and only their raw
+// data is read back from their input file. Linting, --strip-status and
+// --preserve-scalar-types read back the bodies they need instead.
+func resourceBodiesRead() bool {
+	return patchesDir != "" || len(fanOutNamespaces) > 0 || fanOutNamespacesFile != "" ||
+		len(namespaceLabelRules) > 0 || injectConfigHash || hnc || initACM || acmValidate || layout == layoutKapp ||
+		generateQuotas || generateNetpol || len(routes) > 0 || explodeWorkloads ||
+		dedupeDir != "" || verifyRoundTrip || crdDocs
+}
+
+// decodeInputFile decodes the given input file one document at a time, so
+// that documents larger than --stream-threshold are never held in memory
+// whole. ok is false if the file must be read in full first, as it is
+// evaluated, converted or rendered, or streaming is disabled.
+func decodeInputFile(input string) (resources []resource, ok bool, err error) {
+	if streamThreshold <= 0 || isKustomizeInput(input) || isJsonnetInput(input) || isComposeInput(input) || renderEngine != "" {
+		return nil, false, nil
+	}
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, true, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, true, err
+	}
+	r := bufio.NewReader(f)
+	// Terraform plans are only recognised by their content
+	head, err := r.Peek(1024)
+	if err != nil && err != io.EOF {
+		return nil, true, err
+	}
+	if isTerraformPlan(input, head) {
+		return nil, false, nil
+	}
+
+	file := &inputRange{filename: input, size: fi.Size(), modTime: fi.ModTime()}
+	if resources, err = decodeResources(input, r, file); err != nil {
+		return nil, true, fmt.Errorf("failed to decode input file %q: %v", input, err)
+	}
+	streamed := 0
+	for _, r := range resources {
+		if r.source != nil {
+			streamed++
+		}
+	}
+	if streamed > 0 {
+		log.Printf("Copying %d large resources from %q when they are written", streamed, input)
+	}
+	return resources, true, nil
+}
+
+// stream releases the raw data of r, which was decoded from data at the given
+// offset of file, recording its location instead.
+func (r *resource) stream(file *inputRange, offset int64, data []byte) {
+	src := *file
+	src.offset = offset
+	src.length = int64(len(data))
+	src.hash = contentHash(data)
+	r.source = &src
+	r.data = nil
+	r.releaseBody()
+}
+
+// releaseBody drops all but the header of the object of a streamed resource,
+// whose other fields are unchanged since it was decoded, unless other
+// features read them.
+func (r *resource) releaseBody() {
+	if r.source == nil || r.partial || resourceBodiesRead() {
+		return
+	}
+	header := make(map[string]interface{}, len(headerFields))
+	for _, field := range headerFields {
+		if v, ok := r.obj.Object[field]; ok {
+			header[field] = v
+		}
+	}
+	r.obj.Object = header
+	r.partial = true
+}
+
+// loadBody reads back the object of a resource whose body was released, with
+// any changes made to its header since.
+func (r *resource) loadBody() error {
+	obj, err := r.fullObject()
+	if err != nil {
+		return err
+	}
+	r.obj.Object = obj.Object
+	r.partial = false
+	return nil
+}
+
+// fullObject returns the whole object of the resource, reading it back from
+// its input file if its body was released. The resource is not changed.
+func (r resource) fullObject() (*unstructured.Unstructured, error) {
+	obj, _, err := r.readBody()
+	return obj, err
+}
+
+// readBody returns the whole object of the resource and its raw data, which
+// is read back from its input file if its body was released. The resource is
+// not changed.
+func (r resource) readBody() (*unstructured.Unstructured, []byte, error) {
+	if !r.partial {
+		return r.obj, r.data, nil
+	}
+	data, err := r.source.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := decodeDocument(r.format, data, obj); err != nil {
+		return nil, nil, fmt.Errorf("decoding %q: %v", r.source.filename, err)
+	}
+	for _, field := range headerFields {
+		if v, ok := r.obj.Object[field]; ok {
+			obj.Object[field] = v
+		} else {
+			delete(obj.Object, field)
+		}
+	}
+	return obj, data, nil
+}
+
+// open returns a reader of the raw data of the resource from its input file.
+func (s *inputRange) open() (io.ReadCloser, error) {
+	f, err := os.Open(s.filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() != s.size || !fi.ModTime().Equal(s.modTime) {
+		f.Close()
+		return nil, fmt.Errorf("input file %q changed while it was being split", s.filename)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, s.offset, s.length), f}, nil
+}
+
+// read returns the raw data of the resource from its input file.
+func (s *inputRange) read() ([]byte, error) {
+	r, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %v", s.filename, err)
+	}
+	return data, nil
+}
+
+// documentReader splits a stream into documents in the same way as
+// utilyaml.YAMLReader for YAML, which reads lines with their line endings
+// normalised to '\n'. A JSON stream is a single document. The offset of each
+// document in the stream is recorded.
+type documentReader struct {
+	r      *bufio.Reader
+	format format
+	// pos is the offset of the next unread byte of the stream.
+	pos int64
+
+	// offset is the offset in the stream of the last document read, and
+	// verbatim is true if that document is exactly as it appears there.
+	offset   int64
+	verbatim bool
+}
+
+func newDocumentReader(r io.Reader, f format) *documentReader {
+	return &documentReader{r: bufio.NewReader(r), format: f}
+}
+
+// Read returns the next document, or io.EOF if there are none left.
+func (d *documentReader) Read() ([]byte, error) {
+	d.offset, d.verbatim = d.pos, true
+	if d.format == jsonFormat {
+		data, err := ioutil.ReadAll(d.r)
+		if err != nil {
+			return nil, err
+		}
+		d.pos += int64(len(data))
+		if len(data) == 0 {
+			return nil, io.EOF
+		}
+		return data, nil
+	}
+
+	var buffer bytes.Buffer
+	for {
+		raw, err := d.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(raw) == 0 && err == io.EOF {
+			if buffer.Len() != 0 {
+				return buffer.Bytes(), nil
+			}
+			return nil, io.EOF
+		}
+		d.pos += int64(len(raw))
+		line := raw
+		if bytes.HasSuffix(line, []byte("\n")) {
+			line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+		}
+		if bytes.HasPrefix(line, []byte("---")) {
+			trimmed := strings.TrimSpace(string(line[3:]))
+			if len(trimmed) > 0 && trimmed[0] != '#' {
+				return nil, fmt.Errorf("invalid Yaml document separator: %s", trimmed)
+			}
+			if buffer.Len() != 0 {
+				return buffer.Bytes(), nil
+			}
+			// as with utilyaml.YAMLReader, a separator at the start of a
+			// document is part of it
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+		d.verbatim = d.verbatim && len(line)+1 == len(raw)
+	}
+}
+
+// decodeDocument decodes a single document of the given format into obj.
+func decodeDocument(f format, data []byte, obj *unstructured.Unstructured) error {
+	if f == jsonFormat {
+		return json.Unmarshal(data, obj)
+	}
+	o, err := decodeYAMLObject(data)
+	if err != nil {
+		return err
+	}
+	obj.Object = o
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/munnerz/manifest-splitter/outputfs"
+)
+
+func TestDocumentReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		verbatim []bool
+	}{
+		{name: "empty"},
+		{name: "single", data: "a: 1\n", verbatim: []bool{true}},
+		{name: "separators", data: "---\na: 1\n---\nb: 2\n--- # comment\nc: 3\n", verbatim: []bool{true, true, true}},
+		{name: "comment only", data: "# comment\n---\na: 1\n", verbatim: []bool{true, true}},
+		{name: "no final newline", data: "a: 1\n---\nb: 2", verbatim: []bool{true, false}},
+		{name: "CRLF", data: "a: 1\r\n---\r\nb: 2\n", verbatim: []bool{false, true}},
+		{name: "carriage return at EOF", data: "a: 1\r", verbatim: []bool{false}},
+	}
+	for _, test := range tests {
+		// documents must be split exactly as they are by kubectl
+		var want [][]byte
+		yr := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(test.data)))
+		for {
+			doc, err := yr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, append([]byte(nil), doc...))
+		}
+
+		dr := newDocumentReader(strings.NewReader(test.data), yamlFormat)
+		var got [][]byte
+		var verbatim []bool
+		for {
+			doc, err := dr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, append([]byte(nil), doc...))
+			verbatim = append(verbatim, dr.verbatim)
+			if end := dr.offset + int64(len(doc)); dr.verbatim && (end > int64(len(test.data)) || test.data[dr.offset:end] != string(doc)) {
+				t.Errorf("%s: document %q is not at offset %d", test.name, doc, dr.offset)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got documents %q, want %q", test.name, got, want)
+		}
+		if !reflect.DeepEqual(verbatim, test.verbatim) {
+			t.Errorf("%s: got verbatim %v, want %v", test.name, verbatim, test.verbatim)
+		}
+	}
+
+	if _, err := newDocumentReader(strings.NewReader("a: 1\n--- b\n"), yamlFormat).Read(); err == nil {
+		t.Errorf("got no error for an invalid separator")
+	}
+}
+
+func TestStreamLargeResources(t *testing.T) {
+	defer func(threshold int, tr []transformer, fs outputfs.FS) {
+		streamThreshold, transformers, outputFS = threshold, tr, fs
+	}(streamThreshold, transformers, outputFS)
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	large := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: large\n  namespace: default\ndata:\n  key: " + strings.Repeat("x", 200) + "\n"
+	annotated := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: annotated\n  namespace: default\n  annotations:\n    kubectl.kubernetes.io/last-applied-configuration: '{}'\ndata:\n  key: " + strings.Repeat("y", 200) + "\n"
+	small := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: small\n  namespace: default\n"
+	input := filepath.Join(dir, "in.yaml")
+	if err := ioutil.WriteFile(input, []byte(small+"---\n"+large+"---\n"+annotated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	streamThreshold = 100
+	resources, ok, err := decodeInputFile(input)
+	if err != nil || !ok {
+		t.Fatalf("got ok %v and error %v, want the file to be streamed", ok, err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("got %d resources, want 3", len(resources))
+	}
+	if r := resources[0]; r.partial || r.source != nil || string(r.data) != small {
+		t.Errorf("small resource was streamed")
+	}
+	for _, r := range resources[1:] {
+		if !r.partial || r.data != nil {
+			t.Errorf("%s: got partial %v with %d bytes of raw data, want only its header", r.obj.GetName(), r.partial, len(r.data))
+		}
+		if _, ok := r.obj.Object["data"]; ok {
+			t.Errorf("%s: body was kept", r.obj.GetName())
+		}
+	}
+
+	transformers = []transformer{stripAnnotationsTransformer{patterns: []string{"kubectl.kubernetes.io/*"}}}
+	for i := range resources {
+		if err := transformResource(&resources[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := resources[1]; !r.partial || r.modified {
+		t.Errorf("unchanged resource was not released after it was transformed")
+	}
+	if r := resources[2]; r.partial || !r.modified || len(r.obj.GetAnnotations()) > 0 {
+		t.Errorf("transformed resource was not read back and changed")
+	}
+
+	// a change to the header of a released resource is merged into the
+	// rest of it when it is encoded
+	header := resources[1]
+	header.obj = header.obj.DeepCopy()
+	header.obj.SetNamespace("")
+	header.markModified()
+	data, err := resourceData(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(strings.Repeat("x", 200))) || bytes.Contains(data, []byte("namespace")) {
+		t.Errorf("got %q, want the body of the resource without its namespace", data)
+	}
+
+	fs := outputfs.NewMem()
+	outputFS = fs
+	if err := copyOutputFile("large.yaml", resources[1].source); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fs.ReadFile("large.yaml"); string(got) != large {
+		t.Errorf("got output %q, want %q", got, large)
+	}
+	if resources[1].source.hash != contentHash([]byte(large)) {
+		t.Errorf("hash of the raw data is not that of the document")
+	}
+
+	if err := ioutil.WriteFile(input, []byte(small), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyOutputFile("large.yaml", resources[1].source); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("got error %v, want one as the input changed", err)
+	}
+}
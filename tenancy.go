@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// tenancyBoundaryKinds are cluster scoped kinds that affect every namespace
+// in a cluster, and so cross the boundary of a tenant that otherwise only
+// owns resources within its own namespaces.
+var tenancyBoundaryKinds = map[schema.GroupKind]bool{
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:                true,
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:               true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}: true,
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:   true,
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}:                           true,
+}
+
+// checkTenancy reports cluster scoped resources in inputs that otherwise only
+// contain resources within a set of namespaces, as is the case for a single
+// team's configuration.
+//
+// A warning is reported for each resource that crosses the tenancy boundary,
+// such as a ClusterRoleBinding or webhook. With --forbid-cluster-scoped, an
+// error is returned if the inputs contain any cluster scoped resource other
+// than the Namespaces themselves.
+func checkTenancy(files map[string][]resource) error {
+	namespaces := make(map[string]bool)
+	for _, resources := range files {
+		for _, r := range resources {
+			if r.namespaced && !r.obj.IsList() && r.obj.GetNamespace() != "" {
+				namespaces[r.obj.GetNamespace()] = true
+			}
+		}
+	}
+	if len(namespaces) == 0 {
+		// the inputs only contain cluster scoped resources, and so are not
+		// scoped to any tenant
+		return nil
+	}
+	tenant := strings.Join(sortedNames(namespaces), ", ")
+
+	var forbidden []string
+	for _, r := range sortedResources(files) {
+		if r.namespaced {
+			continue
+		}
+		gk := r.obj.GroupVersionKind().GroupKind()
+		if gk == (schema.GroupKind{Kind: "Namespace"}) {
+			continue
+		}
+		if tenancyBoundaryKinds[gk] {
+			warnf("%s %q is cluster scoped and affects all namespaces, but the other resources in the inputs are confined to namespaces: %s", gk.Kind, r.obj.GetName(), tenant)
+		}
+		forbidden = append(forbidden, fmt.Sprintf("%s %q in file %q", gk.Kind, r.obj.GetName(), r.inputFilename))
+	}
+	if forbidCluster && len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		return fmt.Errorf("found %d cluster scoped resources, which are forbidden by --forbid-cluster-scoped:\n%s", len(forbidden), strings.Join(forbidden, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testResource(apiVersion, kind, namespace, name string, namespaced bool) resource {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return resource{obj: obj, namespaced: namespaced, inputFilename: "in.yaml"}
+}
+
+func TestCheckTenancy(t *testing.T) {
+	defer func(w []string, forbid bool) { warnings, forbidCluster = w, forbid }(warnings, forbidCluster)
+	files := map[string][]resource{
+		"in.yaml": {
+			testResource("v1", "Namespace", "", "team-a", false),
+			testResource("v1", "ConfigMap", "team-a", "config", true),
+			testResource("rbac.authorization.k8s.io/v1", "ClusterRoleBinding", "", "team-a-admin", false),
+			testResource("rbac.authorization.k8s.io/v1", "ClusterRole", "", "team-a-view", false),
+		},
+	}
+
+	warnings, forbidCluster = nil, false
+	if err := checkTenancy(files); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `ClusterRoleBinding "team-a-admin"`) {
+		t.Errorf("got warnings %q, want one for the ClusterRoleBinding", warnings)
+	}
+
+	warnings, forbidCluster = nil, true
+	err := checkTenancy(files)
+	if err == nil || !strings.Contains(err.Error(), "found 2 cluster scoped resources") {
+		t.Errorf("got error %v, want one listing the ClusterRole and ClusterRoleBinding", err)
+	}
+
+	// inputs with only cluster scoped resources are not a tenant's
+	warnings, forbidCluster = nil, true
+	if err := checkTenancy(map[string][]resource{"in.yaml": files["in.yaml"][2:]}); err != nil || len(warnings) > 0 {
+		t.Errorf("got error %v and warnings %q for cluster scoped inputs, want none", err, warnings)
+	}
+}
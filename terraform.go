@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// terraformPlan is the subset of the output of 'terraform show -json' that
+// is used to extract Kubernetes manifests. Both plan and state files are
+// supported.
+type terraformPlan struct {
+	FormatVersion string           `json:"format_version"`
+	PlannedValues *terraformValues `json:"planned_values"`
+	Values        *terraformValues `json:"values"`
+}
+
+type terraformValues struct {
+	RootModule terraformModule `json:"root_module"`
+}
+
+type terraformModule struct {
+	Resources    []terraformResource `json:"resources"`
+	ChildModules []terraformModule   `json:"child_modules"`
+}
+
+type terraformResource struct {
+	Address string                 `json:"address"`
+	Mode    string                 `json:"mode"`
+	Type    string                 `json:"type"`
+	Values  map[string]interface{} `json:"values"`
+}
+
+// isTerraformPlan returns true if data looks like the JSON representation of
+// a Terraform plan or state file. Only the start of the document is
+// inspected, so that other JSON inputs are not decoded an extra time.
+func isTerraformPlan(input string, data []byte) bool {
+	if filepath.Ext(input) != ".json" {
+		return false
+	}
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("{")) && bytes.Contains(head, []byte(`"format_version"`)) && !bytes.Contains(head, []byte(`"apiVersion"`))
+}
+
+// extractTerraformManifests returns a v1 List containing the manifests of all
+// kubernetes_manifest and kubectl_manifest resources in the given Terraform
+// plan or state JSON.
+func extractTerraformManifests(data []byte) ([]byte, error) {
+	var plan terraformPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("decoding Terraform plan: %v", err)
+	}
+	values := plan.PlannedValues
+	if values == nil && plan.Values == nil {
+		return nil, fmt.Errorf("plan contains neither planned_values nor values")
+	}
+	if values == nil {
+		values = plan.Values
+	}
+
+	var items []interface{}
+	var walk func(m terraformModule) error
+	walk = func(m terraformModule) error {
+		for _, r := range m.Resources {
+			if r.Mode != "managed" {
+				continue
+			}
+			obj, err := terraformResourceManifest(r)
+			if err != nil {
+				return fmt.Errorf("%s: %v", r.Address, err)
+			}
+			if obj != nil {
+				items = append(items, obj)
+			}
+		}
+		for _, child := range m.ChildModules {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(values.RootModule); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
+
+// terraformResourceManifest returns the Kubernetes object embedded in the
+// given Terraform resource, or nil if it is not a manifest resource.
+func terraformResourceManifest(r terraformResource) (map[string]interface{}, error) {
+	switch r.Type {
+	case "kubernetes_manifest":
+		manifest, ok := r.Values["manifest"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("manifest is unknown or not an object")
+		}
+		return manifest, nil
+	case "kubectl_manifest":
+		body, ok := r.Values["yaml_body"].(string)
+		if !ok {
+			return nil, fmt.Errorf("yaml_body is unknown or not a string")
+		}
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return nil, fmt.Errorf("decoding yaml_body: %v", err)
+		}
+		return obj, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// transformer is implemented by types that modify resources after they have
+// been decoded and validated, but before they are written to the output
+// directory.
+type transformer interface {
+	// Transform may modify r.obj in place. It returns true if the object was
+	// modified, in which case the resource will be re-encoded when it is
+	// written.
+	Transform(r *resource) (bool, error)
+}
+
+// transformers is the ordered list of transformers applied to every resource.
+var transformers []transformer
+
+func transformResourceFiles(files map[string][]resource) error {
+	if len(transformers) == 0 {
+		return nil
+	}
+	for inputFilename, resources := range files {
+		for i := range resources {
+			if err := transformResource(&resources[i]); err != nil {
+				return fmt.Errorf("in input file %q: %v", inputFilename, err)
+			}
+		}
+	}
+	return nil
+}
+
+// transformResource runs all configured transformers against the given
+// resource, marking it as modified if any of them changed the object.
+// If the resource is a List, each item in the list is transformed in turn.
+// A resource holding only its header is read back in full while it is
+// transformed, and released again if it is unchanged.
+func transformResource(r *resource) error {
+	if r.partial {
+		if err := r.loadBody(); err != nil {
+			return err
+		}
+	}
+	changed := false
+	apply := func(r *resource) error {
+		for _, t := range transformers {
+			c, err := t.Transform(r)
+			if err != nil {
+				return fmt.Errorf("transforming resource %s %q: %v", r.obj.GetKind(), r.obj.GetName(), err)
+			}
+			changed = changed || c
+		}
+		return nil
+	}
+
+	if r.obj.IsList() {
+		if err := r.obj.EachListItem(func(obj runtime.Object) error {
+			inner := *r
+			inner.obj = obj.(*unstructured.Unstructured)
+			return apply(&inner)
+		}); err != nil {
+			return err
+		}
+	} else if err := apply(r); err != nil {
+		return err
+	}
+
+	if changed {
+		r.markModified()
+	} else {
+		r.releaseBody()
+	}
+	return nil
+}
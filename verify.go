@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// verifyOutputs re-reads every written output file and compares the decoded
+// contents with the resource it was generated from. An error describing every
+// mismatch is returned if any file does not round-trip cleanly.
+func verifyOutputs(root string, written []outputFile) error {
+	var failures []string
+	for _, f := range written {
+		path := filepath.Join(root, f.path)
+		if err := verifyOutputFile(stagedPath(path), f.resource); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d output files did not round-trip:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	log.Printf("Verified %d output files", len(written))
+	return nil
+}
+
+func verifyOutputFile(path string, r resource) error {
+	data, err := outputFS.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	u := unstructured.Unstructured{}
+	read, err := decoderFor(r.format)(bytes.NewReader(data), &u)
+	if err == io.EOF && len(read) == 0 {
+		return fmt.Errorf("file contains no resources")
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode: %v", err)
+	}
+
+	diffs := diffObjects(r.obj.Object, u.Object)
+	if len(diffs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(diffs))
+	for i, d := range diffs {
+		msgs[i] = d.String()
+	}
+	return fmt.Errorf("written resource differs from source (source != written): %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// warnings accumulates the warnings emitted during a run, so that they can be
+// summarised once all output has been written.
+var warnings []string
+
+// warningsMu guards warnings, as warnings may be emitted while output files
+// are written concurrently.
+var warningsMu sync.Mutex
+
+// warnf logs a warning and records it to be included in the summary. It is
+// safe for concurrent use.
+func warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", msg)
+	warningsMu.Lock()
+	warnings = append(warnings, msg)
+	warningsMu.Unlock()
+	emitEvent(event{Type: eventWarning, Message: msg})
+}
+
+// failedInputs records the input files skipped by --keep-going because they
+// could not be read, along with the error encountered.
+var failedInputs = make(map[string]error)
+
+// skipFailedInput records that the given input file could not be read, and
+// has been skipped.
+func skipFailedInput(input string, err error) {
+	log.Printf("Error: skipping input file %q: %v", input, err)
+	failedInputs[input] = err
+	emitEvent(event{Type: eventSkipped, Input: input, Message: err.Error()})
+}
+
+// printSummary logs all warnings emitted during the run, and any input files
+// that were skipped by --keep-going.
+func printSummary() {
+	if len(failedInputs) > 0 {
+		inputs := make([]string, 0, len(failedInputs))
+		for input := range failedInputs {
+			inputs = append(inputs, input)
+		}
+		sort.Strings(inputs)
+		log.Printf("Skipped %d input files that could not be read:", len(inputs))
+		for _, input := range inputs {
+			log.Printf("  - %s: %v", input, failedInputs[input])
+		}
+	}
+	if len(warnings) == 0 {
+		return
+	}
+	log.Printf("Completed with %d warnings:", len(warnings))
+	for _, w := range warnings {
+		log.Printf("  - %s", w)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// sourcePathAnnotation is set by kustomize and kpt to the path of the file
+// that a resource was read from. It is used by the placement webhook to check
+// that a resource is located where it would be written.
+const sourcePathAnnotation = "config.kubernetes.io/path"
+
+// placementResult is the placement of a single resource, as returned by the
+// placement endpoint.
+type placementResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Path is the path, relative to the output directory, that the
+	// resource would be written to.
+	Path string `json:"path"`
+}
+
+// placementReport is the response of the placement endpoint when it is not
+// called as an admission webhook.
+type placementReport struct {
+	Resources []placementResult `json:"resources"`
+	// Misplaced lists the resources not placed at the path given in the
+	// request, if any.
+	Misplaced []placementResult `json:"misplaced,omitempty"`
+}
+
+// admissionReview is the subset of an admission.k8s.io AdmissionReview used
+// by the placement webhook.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID    string          `json:"uid"`
+	Object json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Status   *admissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type admissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// handlePlacement returns the path that each resource in the request body
+// would be written to using the configured layout, so that CI and pre-receive
+// hooks can check files in a repository are where the splitter would put
+// them.
+//
+// If the body is an AdmissionReview, it is handled as a validating webhook:
+// the object is rejected if its config.kubernetes.io/path annotation does not
+// match the path it would be written to, and allowed with a warning giving
+// the expected path if the annotation is not set.
+// Otherwise the body is a manifest bundle, and if '?path=' is set, the
+// resources not placed at that path are reported as misplaced.
+func (s *splitServer) handlePlacement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	var review admissionReview
+	if err := json.Unmarshal(body, &review); err == nil && review.Kind == "AdmissionReview" {
+		if review.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
+		review.Response = s.reviewPlacement(review.Request)
+		review.Request = nil
+		writeJSON(w, review)
+		return
+	}
+
+	placed, err := s.place(body, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	report := placementReport{Resources: placed}
+	if path := r.URL.Query().Get("path"); path != "" {
+		for _, p := range placed {
+			if !samePath(p.Path, path) {
+				report.Misplaced = append(report.Misplaced, p)
+			}
+		}
+	}
+	writeJSON(w, report)
+}
+
+// reviewPlacement validates the placement of the object in an admission
+// request.
+func (s *splitServer) reviewPlacement(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	// admission requests contain JSON, but files in a repository are
+	// usually YAML
+	placed, err := s.place(req.Object, true)
+	if err != nil {
+		resp.Allowed = false
+		resp.Status = &admissionStatus{Code: http.StatusUnprocessableEntity, Message: err.Error()}
+		return resp
+	}
+	var obj struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	// the object has already been decoded successfully
+	json.Unmarshal(req.Object, &obj)
+	source, ok := obj.Metadata.Annotations[sourcePathAnnotation]
+	for _, p := range placed {
+		switch {
+		case !ok:
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("%s %q should be located at %s", p.Kind, p.Name, p.Path))
+		case !samePath(source, p.Path):
+			resp.Allowed = false
+			resp.Status = &admissionStatus{
+				Code:    http.StatusForbidden,
+				Message: fmt.Sprintf("%s %q is located at %s, but should be located at %s", p.Kind, p.Name, source, p.Path),
+			}
+		}
+	}
+	return resp
+}
+
+// place returns the placement of each resource in the given manifests. If
+// asYAML is set, resources are placed as though they were YAML regardless of
+// the format of data.
+func (s *splitServer) place(data []byte, asYAML bool) ([]placementResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resources, err := decodeResourceManifest("placement", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifests: %v", err)
+	}
+	if asYAML {
+		for i := range resources {
+			resources[i].format = yamlFormat
+		}
+	}
+
+	warnings, namespaceParents, namespaceDirs = nil, nil, nil
+	placed, err := placeResources(s.inspector, map[string][]resource{"placement": resources})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]placementResult, len(placed))
+	for i, p := range placed {
+		results[i] = placementResult{
+			APIVersion: p.resource.obj.GetAPIVersion(),
+			Kind:       p.resource.obj.GetKind(),
+			Namespace:  p.resource.obj.GetNamespace(),
+			Name:       p.resource.obj.GetName(),
+			Path:       filepath.ToSlash(p.path),
+		}
+	}
+	return results, nil
+}
+
+// samePath returns true if the given slash separated paths are equivalent.
+func samePath(a, b string) bool {
+	return filepath.Clean(filepath.FromSlash(a)) == filepath.Clean(filepath.FromSlash(b))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+)
+
+// normalizeWhitespace returns a copy of data with CRLF line endings converted
+// to LF, any leading '---' document separators removed, and exactly one
+// trailing newline.
+func normalizeWhitespace(data []byte) []byte {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	for {
+		trimmed := bytes.TrimLeft(data, "\n")
+		line := trimmed
+		if i := bytes.IndexByte(trimmed, '\n'); i >= 0 {
+			line = trimmed[:i]
+		}
+		if !isDocumentSeparator(line) {
+			data = trimmed
+			break
+		}
+		data = trimmed[len(line):]
+	}
+	data = bytes.TrimRight(data, " \t\n")
+	if len(data) == 0 {
+		return data
+	}
+	return append(data, '\n')
+}
+
+// isDocumentSeparator returns true if the given line is a YAML document
+// separator, optionally followed by a comment.
+func isDocumentSeparator(line []byte) bool {
+	if !bytes.HasPrefix(line, []byte("---")) {
+		return false
+	}
+	rest := bytes.TrimSpace(line[3:])
+	return len(rest) == 0 || rest[0] == '#'
+}
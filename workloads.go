@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// podTemplatePaths maps the group/kind of workload resources to the path of
+// the pod template within them. Pods themselves are handled separately, as
+// they do not contain a template.
+var podTemplatePaths = map[schema.GroupKind][]string{
+	{Group: "", Kind: "ReplicationController"}: {"spec", "template"},
+	{Group: "apps", Kind: "Deployment"}:        {"spec", "template"},
+	{Group: "apps", Kind: "DaemonSet"}:         {"spec", "template"},
+	{Group: "apps", Kind: "ReplicaSet"}:        {"spec", "template"},
+	{Group: "apps", Kind: "StatefulSet"}:       {"spec", "template"},
+	{Group: "extensions", Kind: "Deployment"}:  {"spec", "template"},
+	{Group: "extensions", Kind: "DaemonSet"}:   {"spec", "template"},
+	{Group: "extensions", Kind: "ReplicaSet"}:  {"spec", "template"},
+	{Group: "batch", Kind: "Job"}:              {"spec", "template"},
+	{Group: "batch", Kind: "CronJob"}:          {"spec", "jobTemplate", "spec", "template"},
+}
+
+// isPod returns true if the given object is a Pod.
+func isPod(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "" && gvk.Kind == "Pod"
+}
+
+// podSpec returns the pod spec of the given Pod or workload resource.
+func podSpec(obj *unstructured.Unstructured) (map[string]interface{}, bool) {
+	if isPod(obj) {
+		spec, ok, _ := unstructured.NestedMap(obj.Object, "spec")
+		return spec, ok
+	}
+	path, ok := podTemplatePaths[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		return nil, false
+	}
+	spec, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, append(path, "spec")...)
+	m, isMap := spec.(map[string]interface{})
+	return m, ok && isMap
+}
+
+// podContainers returns all containers declared in the given pod spec,
+// including init and ephemeral containers.
+func podContainers(spec map[string]interface{}) []map[string]interface{} {
+	var containers []map[string]interface{}
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		list, _, _ := unstructured.NestedSlice(spec, field)
+		for _, c := range list {
+			if container, ok := c.(map[string]interface{}); ok {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return containers
+}
+
+// podReferences are the names of the objects, in the same namespace,
+// referenced by a pod spec.
+type podReferences struct {
+	serviceAccount string
+	configMaps     []string
+	secrets        []string
+}
+
+// podSpecReferences returns the ServiceAccount, ConfigMaps and Secrets
+// referenced by the given pod spec.
+func podSpecReferences(spec map[string]interface{}) podReferences {
+	configMaps := make(map[string]bool)
+	secrets := make(map[string]bool)
+	nameAt := func(obj map[string]interface{}, fields ...string) string {
+		name, _, _ := unstructured.NestedString(obj, fields...)
+		return name
+	}
+
+	refs := podReferences{serviceAccount: nameAt(spec, "serviceAccountName")}
+	if refs.serviceAccount == "" {
+		refs.serviceAccount = nameAt(spec, "serviceAccount")
+	}
+	if refs.serviceAccount == "" {
+		refs.serviceAccount = "default"
+	}
+
+	forEachMap(spec, []string{"imagePullSecrets"}, func(m map[string]interface{}) {
+		secrets[nameAt(m, "name")] = true
+	})
+	forEachMap(spec, []string{"volumes"}, func(v map[string]interface{}) {
+		configMaps[nameAt(v, "configMap", "name")] = true
+		secrets[nameAt(v, "secret", "secretName")] = true
+		forEachMap(v, []string{"projected", "sources"}, func(source map[string]interface{}) {
+			configMaps[nameAt(source, "configMap", "name")] = true
+			secrets[nameAt(source, "secret", "name")] = true
+		})
+	})
+	for _, c := range podContainers(spec) {
+		forEachMap(c, []string{"env"}, func(env map[string]interface{}) {
+			configMaps[nameAt(env, "valueFrom", "configMapKeyRef", "name")] = true
+			secrets[nameAt(env, "valueFrom", "secretKeyRef", "name")] = true
+		})
+		forEachMap(c, []string{"envFrom"}, func(envFrom map[string]interface{}) {
+			configMaps[nameAt(envFrom, "configMapRef", "name")] = true
+			secrets[nameAt(envFrom, "secretRef", "name")] = true
+		})
+	}
+
+	refs.configMaps = sortedNames(configMaps)
+	refs.secrets = sortedNames(secrets)
+	return refs
+}
+
+// forEachMap calls fn for every map in the list found at the given path.
+func forEachMap(obj map[string]interface{}, path []string, fn func(map[string]interface{})) {
+	list, _, _ := unstructured.NestedSlice(obj, path...)
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			fn(m)
+		}
+	}
+}
+
+// sortedNames returns the non-empty keys of the given set in sorted order.
+func sortedNames(set map[string]bool) []string {
+	var names []string
+	for name := range set {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// decodeViaJSON decodes data as unstructured.Unstructured does, by converting
+// it to JSON and decoding numbers as int64 where possible.
+func decodeViaJSON(data []byte) (map[string]interface{}, error) {
+	j, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var obj map[string]interface{}
+	if err := d.Decode(&obj); err != nil {
+		return nil, err
+	}
+	var convert func(v interface{}) interface{}
+	convert = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, v := range t {
+				t[k] = convert(v)
+			}
+		case []interface{}:
+			for i, v := range t {
+				t[i] = convert(v)
+			}
+		case json.Number:
+			if i, err := t.Int64(); err == nil {
+				return i
+			}
+			f, _ := t.Float64()
+			return f
+		}
+		return v
+	}
+	convert(obj)
+	return obj, nil
+}
+
+func TestDecodeYAMLObject(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{name: "empty", in: "# only a comment\n", want: nil},
+		{
+			name: "scalars",
+			in: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  annotations:
+    enabled: on
+    version: 1.10
+    quoted: "on"
+    date: 2021-01-01
+spec:
+  int: 42
+  negative: -7
+  float: 1.5
+  integral: 3.0
+  exponent: 1e3
+  large: 18446744073709551615
+  octal: 0755
+  nothing: null
+  tilde: ~
+  list: [a, 1, true]
+`,
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						"enabled": true,
+						"version": 1.1,
+						"quoted":  "on",
+						"date":    "2021-01-01",
+					},
+				},
+				"spec": map[string]interface{}{
+					"int":      int64(42),
+					"negative": int64(-7),
+					"float":    1.5,
+					"integral": int64(3),
+					"exponent": int64(1000),
+					"large":    float64(18446744073709551615),
+					"octal":    int64(493),
+					"nothing":  nil,
+					"tilde":    nil,
+					"list":     []interface{}{"a", int64(1), true},
+				},
+			},
+		},
+		{
+			name: "keys",
+			in:   "1: a\n2.5: b\ntrue: c\non: d\n",
+			want: map[string]interface{}{"1": "a", "2.5": "b", "true": "d"},
+		},
+		{
+			name: "anchors",
+			in:   "base: &base\n  a: 1\nderived:\n  <<: *base\n  b: 2\n",
+			want: map[string]interface{}{
+				"base":    map[string]interface{}{"a": int64(1)},
+				"derived": map[string]interface{}{"a": int64(1), "b": int64(2)},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := decodeYAMLObject([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+		if test.want == nil {
+			continue
+		}
+		viaJSON, err := decodeViaJSON([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: decoding via JSON: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, viaJSON) {
+			t.Errorf("%s: got %#v, but decoding via JSON gives %#v", test.name, got, viaJSON)
+		}
+	}
+}
+
+func TestDecodeYAMLObjectErrors(t *testing.T) {
+	for _, in := range []string{
+		"- a\n- b\n",
+		"just a string\n",
+		"a: .nan\n",
+		"a: [\n",
+	} {
+		if _, err := decodeYAMLObject([]byte(in)); err == nil {
+			t.Errorf("decoding %q succeeded, want an error", in)
+		}
+	}
+}
+
+// benchmarkManifest is a Deployment with many containers, so that decoding it
+// is dominated by the cost of parsing rather than of setting up the decoder.
+func benchmarkManifest() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n  labels: {app: app, tier: backend}\nspec:\n  replicas: 3\n  selector:\n    matchLabels: {app: app}\n  template:\n    metadata:\n      labels: {app: app}\n    spec:\n      containers:\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, "      - name: container-%d\n        image: example.com/app:v1.%d\n        args: [--port=%d, --verbose]\n        ports:\n        - containerPort: %d\n          protocol: TCP\n        resources:\n          limits: {cpu: 500m, memory: 128Mi}\n        env:\n        - name: RATIO\n          value: \"0.%d\"\n", i, i, 8000+i, 8000+i, i)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeYAML(b *testing.B) {
+	data := benchmarkManifest()
+	b.Run("sigs.k8s.io/yaml", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			u := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal(data, u); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("single pass", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeYAMLObject(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// yamlQuoteRequired quotes only strings that would otherwise be read as
+	// another type, including the YAML 1.1 booleans such as 'on' and 'off'.
+	yamlQuoteRequired = "required"
+	// yamlQuoteAmbiguous additionally quotes strings that look like version
+	// numbers, such as '1.2.3' or 'v1.20', or like YAML 1.1 sexagesimal
+	// numbers, such as '1:30'.
+	yamlQuoteAmbiguous = "ambiguous"
+	// yamlQuoteAll quotes every string value. Mapping keys are only quoted
+	// as for yamlQuoteAmbiguous.
+	yamlQuoteAll = "all"
+
+	yamlQuoteDouble = "double"
+	yamlQuoteSingle = "single"
+)
+
+var (
+	// yamlIndent is the number of spaces each level of YAML output is
+	// indented by.
+	yamlIndent int
+	// yamlFlowLists is the maximum length of lists of scalars that are
+	// written in flow style, e.g. '[a, b]'. Zero writes every list in block
+	// style.
+	yamlFlowLists int
+	// yamlQuote is the policy deciding which strings are quoted, one of
+	// yamlQuoteRequired, yamlQuoteAmbiguous or yamlQuoteAll.
+	yamlQuote string
+	// yamlQuoteChar is the quote character used for quoted strings, one of
+	// yamlQuoteDouble or yamlQuoteSingle.
+	yamlQuoteChar string
+)
+
+// versionLike matches strings that read as version numbers, or as
+// sexagesimal numbers in YAML 1.1, and so are ambiguous to readers and older
+// parsers.
+var versionLike = regexp.MustCompile(`^([vV]?[0-9]+(\.[0-9]+)+([-+][0-9A-Za-z.-]+)?|[0-9]+(:[0-5]?[0-9])+)$`)
+
+// validateYAMLStyle returns an error if the YAML style flags are invalid.
+func validateYAMLStyle() error {
+	if yamlIndent < 2 || yamlIndent > 9 {
+		return fmt.Errorf("--yaml-indent must be between 2 and 9, got %d", yamlIndent)
+	}
+	if yamlFlowLists < 0 {
+		return fmt.Errorf("--yaml-flow-lists must not be negative, got %d", yamlFlowLists)
+	}
+	switch yamlQuote {
+	case yamlQuoteRequired, yamlQuoteAmbiguous, yamlQuoteAll:
+	default:
+		return fmt.Errorf("--yaml-quote must be one of %q, %q or %q, got %q", yamlQuoteRequired, yamlQuoteAmbiguous, yamlQuoteAll, yamlQuote)
+	}
+	switch yamlQuoteChar {
+	case yamlQuoteDouble, yamlQuoteSingle:
+	default:
+		return fmt.Errorf("--yaml-quote-char must be one of %q or %q, got %q", yamlQuoteDouble, yamlQuoteSingle, yamlQuoteChar)
+	}
+	return nil
+}
+
+// yamlStyled returns true if any YAML style flag differs from the style
+// written by default.
+func yamlStyled() bool {
+	return yamlIndent != 2 || yamlFlowLists > 0 || yamlQuote != yamlQuoteRequired || yamlQuoteChar != yamlQuoteDouble
+}
+
+// EncodeYAML encodes obj as YAML in the style configured by the YAML style
+// flags. Keys are written in sorted order, as they are by encoding/json.
+func EncodeYAML(obj interface{}) ([]byte, error) {
+	if !yamlStyled() {
+		return yaml.Marshal(obj)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, so decoding it gives a node tree in the order the
+	// fields were written, which can then be restyled
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	styleYAMLNode(&doc, false)
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// styleYAMLNode replaces the flow styles and double quotes of a node decoded
+// from JSON with those configured by the YAML style flags. isKey is true if n
+// is a mapping key.
+func styleYAMLNode(n *yamlv3.Node, isKey bool) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			styleYAMLNode(c, false)
+		}
+	case yamlv3.MappingNode:
+		n.Style = 0
+		for i, c := range n.Content {
+			styleYAMLNode(c, i%2 == 0)
+		}
+	case yamlv3.SequenceNode:
+		n.Style = 0
+		flow := len(n.Content) > 0 && len(n.Content) <= yamlFlowLists
+		for _, c := range n.Content {
+			styleYAMLNode(c, false)
+			if c.Kind != yamlv3.ScalarNode || c.Style&yamlv3.LiteralStyle != 0 {
+				flow = false
+			}
+		}
+		if flow {
+			n.Style = yamlv3.FlowStyle
+		}
+	case yamlv3.ScalarNode:
+		n.Style = 0
+		if n.ShortTag() != "!!str" {
+			return
+		}
+		// re-resolve the value as if it were written unquoted
+		plain := yamlv3.Node{Kind: yamlv3.ScalarNode, Value: n.Value}
+		switch {
+		case strings.Contains(n.Value, "\n"):
+			n.Style = yamlv3.LiteralStyle
+		case quoteYAMLString(n.Value, plain.ShortTag() != "!!str", isKey):
+			if yamlQuoteChar == yamlQuoteSingle {
+				n.Style = yamlv3.SingleQuotedStyle
+			} else {
+				n.Style = yamlv3.DoubleQuotedStyle
+			}
+		}
+	}
+}
+
+// quoteYAMLString returns true if the string s is quoted under the --yaml-quote
+// policy. resolvesOtherwise is true if s would be read as another type if
+// written unquoted.
+func quoteYAMLString(s string, resolvesOtherwise, isKey bool) bool {
+	if resolvesOtherwise || isOldYAMLBool(s) {
+		return true
+	}
+	switch {
+	case yamlQuote == yamlQuoteAll && !isKey:
+		return true
+	case yamlQuote != yamlQuoteRequired:
+		return versionLike.MatchString(s)
+	}
+	return false
+}
+
+// isOldYAMLBool returns true if s is read as a boolean by YAML 1.1 parsers.
+func isOldYAMLBool(s string) bool {
+	switch s {
+	case "y", "Y", "yes", "Yes", "YES", "on", "On", "ON",
+		"n", "N", "no", "No", "NO", "off", "Off", "OFF":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+)
+
+// setYAMLStyle sets the YAML style flags, returning a function that restores
+// their previous values.
+func setYAMLStyle(indent, flowLists int, quote, quoteChar string) func() {
+	prevIndent, prevFlowLists, prevQuote, prevQuoteChar := yamlIndent, yamlFlowLists, yamlQuote, yamlQuoteChar
+	yamlIndent, yamlFlowLists, yamlQuote, yamlQuoteChar = indent, flowLists, quote, quoteChar
+	return func() {
+		yamlIndent, yamlFlowLists, yamlQuote, yamlQuoteChar = prevIndent, prevFlowLists, prevQuote, prevQuoteChar
+	}
+}
+
+func TestValidateYAMLStyle(t *testing.T) {
+	tests := []struct {
+		indent, flowLists int
+		quote, quoteChar  string
+		wantErr           bool
+	}{
+		{indent: 2, quote: yamlQuoteRequired, quoteChar: yamlQuoteDouble},
+		{indent: 4, flowLists: 3, quote: yamlQuoteAll, quoteChar: yamlQuoteSingle},
+		{indent: 1, quote: yamlQuoteRequired, quoteChar: yamlQuoteDouble, wantErr: true},
+		{indent: 10, quote: yamlQuoteRequired, quoteChar: yamlQuoteDouble, wantErr: true},
+		{indent: 2, flowLists: -1, quote: yamlQuoteRequired, quoteChar: yamlQuoteDouble, wantErr: true},
+		{indent: 2, quote: "never", quoteChar: yamlQuoteDouble, wantErr: true},
+		{indent: 2, quote: yamlQuoteRequired, quoteChar: "backtick", wantErr: true},
+	}
+	for _, test := range tests {
+		restore := setYAMLStyle(test.indent, test.flowLists, test.quote, test.quoteChar)
+		err := validateYAMLStyle()
+		restore()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%+v: got error %v, want error %v", test, err, test.wantErr)
+		}
+	}
+}
+
+func TestQuoteYAMLString(t *testing.T) {
+	tests := []struct {
+		quote             string
+		s                 string
+		resolvesOtherwise bool
+		isKey             bool
+		want              bool
+	}{
+		{quote: yamlQuoteRequired, s: "true", resolvesOtherwise: true, want: true},
+		{quote: yamlQuoteRequired, s: "on", want: true},
+		{quote: yamlQuoteRequired, s: "No", isKey: true, want: true},
+		{quote: yamlQuoteRequired, s: "1.2.3"},
+		{quote: yamlQuoteRequired, s: "nginx"},
+		{quote: yamlQuoteAmbiguous, s: "1.2.3", want: true},
+		{quote: yamlQuoteAmbiguous, s: "v1.20", want: true},
+		{quote: yamlQuoteAmbiguous, s: "1.2.3-rc.1", want: true},
+		{quote: yamlQuoteAmbiguous, s: "1:30", want: true},
+		{quote: yamlQuoteAmbiguous, s: "nginx:1.21"},
+		{quote: yamlQuoteAmbiguous, s: "nginx"},
+		{quote: yamlQuoteAll, s: "nginx", want: true},
+		{quote: yamlQuoteAll, s: "name", isKey: true},
+		{quote: yamlQuoteAll, s: "1.2", isKey: true, want: true},
+	}
+	for _, test := range tests {
+		restore := setYAMLStyle(2, 0, test.quote, yamlQuoteDouble)
+		got := quoteYAMLString(test.s, test.resolvesOtherwise, test.isKey)
+		restore()
+		if got != test.want {
+			t.Errorf("%+v: got %v", test, got)
+		}
+	}
+}
+
+func TestEncodeYAML(t *testing.T) {
+	obj := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "web",
+			"labels": map[string]interface{}{"version": "1.2.3", "enabled": "on"},
+		},
+		"spec": map[string]interface{}{
+			"args":   []interface{}{"--a", "--b"},
+			"ports":  []interface{}{map[string]interface{}{"port": int64(80)}},
+			"script": "echo a\necho b\n",
+			"empty":  []interface{}{},
+		},
+	}
+	tests := []struct {
+		name              string
+		indent, flowLists int
+		quote, quoteChar  string
+		want              string
+	}{
+		{
+			name:      "default",
+			indent:    2,
+			quote:     yamlQuoteRequired,
+			quoteChar: yamlQuoteDouble,
+			want: `metadata:
+  labels:
+    enabled: "on"
+    version: 1.2.3
+  name: web
+spec:
+  args:
+  - --a
+  - --b
+  empty: []
+  ports:
+  - port: 80
+  script: |
+    echo a
+    echo b
+`,
+		},
+		{
+			name:      "styled",
+			indent:    4,
+			flowLists: 2,
+			quote:     yamlQuoteAmbiguous,
+			quoteChar: yamlQuoteSingle,
+			want: `metadata:
+    labels:
+        enabled: 'on'
+        version: '1.2.3'
+    name: web
+spec:
+    args: [--a, --b]
+    empty: []
+    ports:
+        - port: 80
+    script: |
+        echo a
+        echo b
+`,
+		},
+		{
+			name:      "quote all",
+			indent:    2,
+			quote:     yamlQuoteAll,
+			quoteChar: yamlQuoteDouble,
+			want: `metadata:
+  labels:
+    enabled: "on"
+    version: "1.2.3"
+  name: "web"
+spec:
+  args:
+    - "--a"
+    - "--b"
+  empty: []
+  ports:
+    - port: 80
+  script: |
+    echo a
+    echo b
+`,
+		},
+	}
+	for _, test := range tests {
+		restore := setYAMLStyle(test.indent, test.flowLists, test.quote, test.quoteChar)
+		got, err := EncodeYAML(obj)
+		restore()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+)
+
+// yttBinary is the name of the ytt executable used to evaluate inputs when
+// --ytt is set.
+const yttBinary = "ytt"
+
+// yttInputName is the input filename recorded against resources produced by
+// evaluating ytt templates, as they cannot be attributed to a single file.
+const yttInputName = "ytt"
+
+// readYttInputs evaluates the given input files together as Carvel ytt
+// templates, along with any --ytt-data-values files, and decodes the
+// resulting resources.
+func readYttInputs(inputs []string) (map[string][]resource, error) {
+	var args []string
+	for _, input := range inputs {
+		args = append(args, "--file", input)
+	}
+	for _, values := range yttDataValues {
+		args = append(args, "--data-values-file", values)
+	}
+
+	log.Printf("Evaluating %d input files with ytt", len(inputs))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(yttBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, fmt.Errorf("%s must be installed to evaluate inputs with --ytt: %v", yttBinary, err)
+		}
+		return nil, fmt.Errorf("evaluating ytt templates: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	resources, err := decodeResourceManifest(yttInputName, &stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ytt output: %v", err)
+	}
+	log.Printf("Found %d resources in ytt output", len(resources))
+	for _, r := range resources {
+		emitEvent(objectEvent(eventDecoded, yttInputName, r.obj))
+	}
+	return map[string][]resource{yttInputName: resources}, nil
+}
+
+// readInputs reads and decodes the given input files, evaluating them
+// together as ytt templates if --ytt is set.
+func readInputs(inputs []string) (map[string][]resource, error) {
+	if ytt {
+		return readYttInputs(inputs)
+	}
+	return readInputFiles(inputs)
+}